@@ -2,8 +2,8 @@ package main
 
 import (
 	"flag"
-	"os"
 
+	"github.com/launchdarkly/ld-find-code-refs/internal/container"
 	"github.com/launchdarkly/ld-find-code-refs/internal/log"
 	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
 	"github.com/launchdarkly/ld-find-code-refs/pkg/coderefs"
@@ -18,13 +18,7 @@ func main() {
 	}
 
 	log.Info.Printf("setting Bitbucket Pipelines env vars")
-	options := map[string]string{
-		"repoType":         "bitbucket",
-		"repoName":         os.Getenv("BITBUCKET_REPO_SLUG"),
-		"dir":              os.Getenv("BITBUCKET_CLONE_DIR"),
-		"repoUrl":          os.Getenv("BITBUCKET_GIT_HTTP_ORIGIN"),
-		"updateSequenceId": os.Getenv("BITBUCKET_BUILD_NUMBER"),
-	}
+	options := container.BitbucketPipelinesOptions()
 	ldOptions, err := o.GetLDOptionsFromEnv()
 	if err != nil {
 		log.Error.Fatalf("Error setting options %s", err)
@@ -40,6 +34,10 @@ func main() {
 			log.Error.Fatalf("error setting option %s: %s", k, err)
 		}
 	}
+	err = container.CheckWorkspace(options["dir"])
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
 	log.Info.Printf("starting repo parsing program with options:\n %+v\n", options)
 
 	coderefs.Scan()
@@ -1,21 +1,421 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/container"
+	doctorpkg "github.com/launchdarkly/ld-find-code-refs/internal/doctor"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
 	"github.com/launchdarkly/ld-find-code-refs/internal/log"
 	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+	"github.com/launchdarkly/ld-find-code-refs/internal/scaffold"
 	"github.com/launchdarkly/ld-find-code-refs/pkg/coderefs"
 )
 
+const (
+	backfillCmd      = "backfill"
+	browseCmd        = "browse"
+	compareCmd       = "compare"
+	doctorCmd        = "doctor"
+	entrypointCmd    = "entrypoint"
+	exportBundleCmd  = "export-bundle"
+	importCmd        = "import"
+	importBundleCmd  = "import-bundle"
+	initCmd          = "init"
+	migrateConfigCmd = "migrate-config"
+	replayCmd        = "replay"
+	resumeCmd        = "resume"
+	serveCmd         = "serve"
+	validateCmd      = "validate"
+)
+
 func main() {
-	err, cb := o.Init()
+	// Commands are provided as the first argument, e.g. `ld-find-code-refs import [options] refs.json`.
+	// If no command is provided, the repository will be scanned.
+	subcommand := ""
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		subcommand, args = args[0], args[1:]
+	}
+
+	// These commands don't interact with LaunchDarkly, so they don't require the scanner's options.
+	switch subcommand {
+	case browseCmd:
+		os.Exit(browse(args))
+	case compareCmd:
+		// Results files can be compared without the scanner's options, which are only needed to scan revisions
+		if code, ok := compareFiles(args); ok {
+			os.Exit(code)
+		}
+	case doctorCmd:
+		os.Exit(doctor(args))
+	case exportBundleCmd:
+		os.Exit(exportBundle(args))
+	case initCmd:
+		os.Exit(initConfig(args))
+	case migrateConfigCmd:
+		os.Exit(migrateConfig(args))
+	case replayCmd:
+		os.Exit(replay(args))
+	case serveCmd:
+		os.Exit(serve(args))
+	case validateCmd:
+		os.Exit(validate(args))
+	case entrypointCmd:
+		entrypoint()
+	}
+
+	err, cb := o.Init(args)
 	if err != nil {
 		log.Init(false)
 		log.Error.Printf("could not validate command line options: %s", err)
 		cb()
 		os.Exit(1)
 	}
+	log.NoColor = o.NoColor.Value()
+	log.LocalTime = o.LocalTime.Value()
+	log.Stderr = o.EmitJsonSummary.Value()
 	log.Init(o.Debug.Value())
-	coderefs.Scan()
+
+	switch subcommand {
+	case "":
+		coderefs.Scan()
+	case entrypointCmd:
+		err := container.CheckWorkspace(o.RepoDir())
+		if err != nil {
+			log.Error.Fatalf("%s", err)
+		}
+		coderefs.Scan()
+	case backfillCmd:
+		if flag.NArg() == 0 {
+			log.Error.Fatalf("usage: ld-find-code-refs %s [options] <revision>...", backfillCmd)
+		}
+		coderefs.Backfill(flag.Args())
+	case compareCmd:
+		switch flag.NArg() {
+		case 1:
+			coderefs.CompareCommit(flag.Arg(0))
+		case 2:
+			coderefs.Compare(flag.Arg(0), flag.Arg(1))
+		default:
+			log.Error.Fatalf("usage: ld-find-code-refs %s [options] <base> <head>, or <commit>", compareCmd)
+		}
+	case importCmd:
+		if flag.NArg() != 1 {
+			log.Error.Fatalf("usage: ld-find-code-refs %s [options] <file>", importCmd)
+		}
+		coderefs.Import(flag.Arg(0))
+	case resumeCmd:
+		os.Exit(resume())
+	case importBundleCmd:
+		if flag.NArg() != 1 {
+			log.Error.Fatalf("usage: ld-find-code-refs %s [options] <bundle>", importBundleCmd)
+		}
+		coderefs.ImportBundle(flag.Arg(0))
+	default:
+		log.Error.Fatalf("unknown command: %s", subcommand)
+	}
+}
+
+// doctor reports whether the tools required by the scanner are installed, and optionally installs them. It returns an exit code.
+func doctor(args []string) int {
+	log.Init(false)
+	fs := flag.NewFlagSet(doctorCmd, flag.ExitOnError)
+	install := fs.String("install", "", "Install a tool with the system package manager. Acceptable values: git|ag|rg.")
+	download := fs.Bool("download", false, fmt.Sprintf("Download ripgrep %s to the tool cache directory.", doctorpkg.RipgrepVersion))
+	toolCacheDir := fs.String("toolCacheDir", os.Getenv(o.EnvVarName(o.ToolCacheDir)), "Directory searched for tools not found in the system PATH, and that downloaded tools are installed in. Defaults to ld-find-code-refs/bin in the user cache directory.")
+	_ = fs.Parse(args)
+
+	switch *install {
+	case "":
+	case "git", command.SearchToolAg, command.SearchToolRg:
+		err := doctorpkg.Install(*install, os.Stdout)
+		if err != nil {
+			log.Error.Printf("could not install %s: %s", *install, err)
+			return 1
+		}
+	default:
+		log.Error.Printf("install must be \"git\", \"ag\", or \"rg\"")
+		return 1
+	}
+	if *download {
+		path, err := doctorpkg.DownloadRipgrep(*toolCacheDir, runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			log.Error.Printf("could not download ripgrep: %s", err)
+			return 1
+		}
+		fmt.Printf("downloaded ripgrep %s to %s\n", doctorpkg.RipgrepVersion, path)
+	}
+
+	if !doctorpkg.Report(os.Stdout, *toolCacheDir) {
+		return 1
+	}
+	return 0
+}
+
+// entrypoint sets options from the container environment the scanner is running in, so the scanner can be
+// used as a container entrypoint. Command line arguments and config files take precedence over these options.
+func entrypoint() {
+	debug, err := o.GetDebugOptionFromEnv()
+	log.Init(debug)
+	if err != nil {
+		log.Error.Fatalf("error parsing debug option: %s", err)
+	}
+
+	env := container.Detect()
+	if env == container.Unknown {
+		log.Warning.Printf("no container environment detected, options will be read from LD_ prefixed environment variables")
+	} else {
+		log.Info.Printf("detected %s environment", env)
+	}
+	opts, err := container.Options(env)
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	err = o.SetDefaults(opts)
+	if err != nil {
+		log.Error.Fatalf("could not set options from %s environment: %s", env, err)
+	}
+}
+
+// replay sends the requests in an HTTP capture file to LaunchDarkly again, and returns an exit code.
+func replay(args []string) int {
+	log.Init(false)
+	fs := flag.NewFlagSet(replayCmd, flag.ExitOnError)
+	accessToken := fs.String("accessToken", os.Getenv(o.EnvVarName(o.AccessToken)), "LaunchDarkly personal access token used to authorize replayed requests. Defaults to "+o.EnvVarName(o.AccessToken)+".")
+	entry := fs.Int("entry", 0, "The 1-based number of the captured request to replay. If 0, all requests will be replayed.")
+	baseUri := fs.String("baseUri", os.Getenv(o.EnvVarName(o.BaseUri)), "LaunchDarkly base URI. Requests captured for other hosts aren't replayed. Defaults to "+o.EnvVarName(o.BaseUri)+", or "+ld.DefaultBaseUri+".")
+	_ = fs.Parse(args)
+	if *baseUri == "" {
+		*baseUri = ld.DefaultBaseUri
+	}
+
+	if fs.NArg() != 1 || *accessToken == "" {
+		log.Error.Printf("usage: ld-find-code-refs %s -accessToken <token> [-baseUri uri] [-entry n] <file>", replayCmd)
+		return 1
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Error.Printf("could not read HTTP capture file: %s", err)
+		return 1
+	}
+	defer f.Close()
+	entries, err := ld.ReadCapture(f)
+	if err != nil {
+		log.Error.Printf("could not read HTTP capture file %s: %s", fs.Arg(0), err)
+		return 1
+	}
+	if *entry < 0 || *entry > len(entries) {
+		log.Error.Printf("entry must be between 1 and %d", len(entries))
+		return 1
+	}
+
+	ret := 0
+	for i, e := range entries {
+		if *entry != 0 && i+1 != *entry {
+			continue
+		}
+		status, body, err := ld.Replay(e, *baseUri, *accessToken)
+		if err != nil {
+			fmt.Printf("%d. %s %s: captured status %d, replay failed: %s\n", i+1, e.Method, e.Url, e.Status, err)
+			ret = 1
+			continue
+		}
+		fmt.Printf("%d. %s %s: captured status %d, replayed status %d\n", i+1, e.Method, e.Url, e.Status, status)
+		if body != "" {
+			fmt.Println(body)
+		}
+		if status >= 400 {
+			ret = 1
+		}
+	}
+	return ret
+}
+
+// compareFiles prints the change in flag references between two results files, and returns an exit code. ok is false
+// if either argument is not a file, so revisions are scanned with the scanner's options.
+func compareFiles(args []string) (code int, ok bool) {
+	if len(args) < 2 || !coderefs.IsComparedFile(args[len(args)-1]) || !coderefs.IsComparedFile(args[len(args)-2]) {
+		return 0, false
+	}
+	log.Init(false)
+	fs := flag.NewFlagSet(compareCmd, flag.ExitOnError)
+	outFile := fs.String("outFile", "", "If provided, the change in references to each flag is written to this path as JSON.")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		log.Error.Printf("usage: ld-find-code-refs %s [-outFile <file>] <base> <head>", compareCmd)
+		return 1, true
+	}
+	err := coderefs.CompareFiles(os.Stdout, fs.Arg(0), fs.Arg(1), *outFile)
+	if err != nil {
+		log.Error.Printf("%s", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+// resume sends code references saved to a spool directory by failed scans, and returns an exit code.
+func resume() int {
+	if flag.NArg() != 0 || o.SpoolDir.Value() == "" {
+		log.Error.Printf("usage: ld-find-code-refs %s [options] -spoolDir <dir>", resumeCmd)
+		return 1
+	}
+	pending, err := coderefs.Resume(o.SpoolDir.Value())
+	if err != nil {
+		log.Error.Printf("could not send spooled code references: %s", err)
+		return 1
+	}
+	if pending > 0 {
+		log.Error.Printf("%d uploads are still pending in %s", pending, o.SpoolDir.Value())
+		return 1
+	}
+	return 0
+}
+
+// serve serves the code references in a results file over HTTP until it is interrupted, and returns an exit code.
+func serve(args []string) int {
+	log.Init(false)
+	fs := flag.NewFlagSet(serveCmd, flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:7777", "The address to listen on. By default, only local clients can connect.")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		log.Error.Printf("usage: ld-find-code-refs %s [-addr host:port] <file>", serveCmd)
+		return 1
+	}
+	handler, err := coderefs.NewResultsHandler(fs.Arg(0))
+	if err != nil {
+		log.Error.Printf("could not read code references from %s: %s", fs.Arg(0), err)
+		return 1
+	}
+	log.Info.Printf("serving code references from %s on http://%s", fs.Arg(0), *addr)
+	err = http.ListenAndServe(*addr, handler)
+	log.Error.Printf("%s", err)
+	return 1
+}
+
+// validate checks that each file provided conforms to the code reference results schema, and returns an exit code.
+func validate(args []string) int {
+	log.Init(false)
+	fs := flag.NewFlagSet(validateCmd, flag.ExitOnError)
+	printSchema := fs.Bool("printSchema", false, "Print the JSON schema for code reference results files instead of validating files.")
+	_ = fs.Parse(args)
+
+	if *printSchema {
+		fmt.Print(coderefs.ResultsSchema())
+		return 0
+	}
+	if fs.NArg() == 0 {
+		log.Error.Printf("usage: ld-find-code-refs %s [-printSchema] <file>...", validateCmd)
+		return 1
+	}
+
+	exitCode := 0
+	for _, path := range fs.Args() {
+		validationErrs, err := coderefs.ValidateResultsFile(path)
+		if err != nil {
+			log.Error.Printf("could not validate %s: %s", path, err)
+			exitCode = 1
+			continue
+		}
+		if len(validationErrs) > 0 {
+			log.Error.Printf("%s is invalid:\n  %s", path, strings.Join(validationErrs, "\n  "))
+			exitCode = 1
+			continue
+		}
+		log.Info.Printf("%s is valid", path)
+	}
+	return exitCode
+}
+
+// exportBundle writes a results file, its signature, a manifest, and checksums to a single file, to be imported with
+// the import-bundle command. It returns an exit code.
+func exportBundle(args []string) int {
+	log.Init(false)
+	fs := flag.NewFlagSet(exportBundleCmd, flag.ExitOnError)
+	out := fs.String("out", "", "Path to write the bundle to. Defaults to the results file's path, with the .tar.gz extension.")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Error.Printf("usage: ld-find-code-refs %s [-out bundle.tar.gz] <file>", exportBundleCmd)
+		return 1
+	}
+	path := fs.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".tar.gz"
+	}
+	err := coderefs.ExportBundle(path, *out)
+	if err != nil {
+		log.Error.Printf("could not export %s: %s", path, err)
+		return 1
+	}
+	log.Info.Printf("wrote bundle to %s", *out)
+	return 0
+}
+
+// initConfig inspects a repository and writes a starter config file to it, and returns an exit code.
+func initConfig(args []string) int {
+	log.Init(false)
+	fs := flag.NewFlagSet(initCmd, flag.ExitOnError)
+	dir := fs.String("dir", ".", "Path to existing checkout of the git repo.")
+	_ = fs.Parse(args)
+
+	info, err := scaffold.Inspect(*dir)
+	if err != nil {
+		log.Error.Printf("could not inspect repository: %s", err)
+		return 1
+	}
+
+	path := filepath.Join(*dir, o.ConfigFileName)
+	err = scaffold.Write(path, info.Header(), info.Config())
+	if err != nil {
+		log.Error.Printf("could not write config file: %s", err)
+		return 1
+	}
+	log.Info.Printf("wrote config file to %s", path)
+	return 0
+}
+
+// migrateConfig writes the options provided as command line arguments and environment variables
+// to a config file, and returns an exit code.
+func migrateConfig(args []string) int {
+	log.Init(false)
+	o.Parse(args)
+	config := o.ConfigFileOptions()
+
+	path := o.ConfigFilePath()
+	header := "# ld-find-code-refs configuration, generated by `ld-find-code-refs migrate-config`.\n" +
+		"# Provide your LaunchDarkly access token with the -accessToken argument or the LD_ACCESS_TOKEN environment variable.\n"
+	err := scaffold.Write(path, header, config)
+	if err != nil {
+		log.Error.Printf("could not write config file: %s", err)
+		return 1
+	}
+	log.Info.Printf("wrote %d options to config file %s", len(config), path)
+	return 0
+}
+
+// browse presents an interactive terminal UI for exploring a results file, and returns an exit code.
+func browse(args []string) int {
+	log.Init(false)
+	if len(args) != 1 {
+		log.Error.Printf("usage: ld-find-code-refs %s <file>", browseCmd)
+		return 1
+	}
+
+	stat, err := os.Stdout.Stat()
+	isTerminal := err == nil && stat.Mode()&os.ModeCharDevice != 0
+	err = coderefs.Browse(args[0], os.Stdin, os.Stdout, isTerminal)
+	if err != nil {
+		log.Error.Printf("could not browse code references: %s", err)
+		return 1
+	}
+	return 0
 }
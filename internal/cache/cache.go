@@ -0,0 +1,143 @@
+// Package cache stores data reused between scans, such as flag lists and the code references last sent for a
+// branch, in a directory with a maximum size. The least recently used entries are evicted when it is exceeded.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache is a directory of entries, each of which is a file or a directory.
+type Cache struct {
+	dir      string
+	maxBytes int64
+}
+
+// Open creates the cache directory, if necessary. If maxBytes is > 0, least recently used entries are evicted
+// when the cache grows larger than maxBytes.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// Key returns the key of an entry identified by parts, such as a repository and branch name.
+func Key(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// touch marks an entry as recently used.
+func (c *Cache) touch(key string) {
+	now := time.Now()
+	os.Chtimes(c.path(key), now, now)
+}
+
+// Get returns the contents of a file entry. It returns false if there is no entry for key.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	c.touch(key)
+	return data, true
+}
+
+// Put stores a file entry, and evicts entries if the cache is too large.
+func (c *Cache) Put(key string, data []byte) error {
+	// Write to a temporary file first, so partially written entries are never read
+	tmp, err := ioutil.TempFile(c.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.Evict()
+}
+
+// Dir returns the path of a directory entry, such as a cloned repository, creating it if necessary. Entries
+// are only evicted by Put and Evict, so callers should call Evict after they have finished writing to it.
+func (c *Cache) Dir(key string) (string, error) {
+	path := c.path(key)
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return "", err
+	}
+	c.touch(key)
+	return path, nil
+}
+
+// Evict removes the least recently used entries until the cache is no larger than its maximum size.
+func (c *Cache) Evict() error {
+	if c.maxBytes <= 0 {
+		return nil
+	}
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	entries := []entry{}
+	total := int64(0)
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".tmp") {
+			continue
+		}
+		size := info.Size()
+		if info.IsDir() {
+			size = dirSize(filepath.Join(c.dir, info.Name()))
+		}
+		entries = append(entries, entry{info.Name(), size, info.ModTime()})
+		total += size
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		err = os.RemoveAll(filepath.Join(c.dir, e.name))
+		if err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+func dirSize(dir string) int64 {
+	size := int64(0)
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
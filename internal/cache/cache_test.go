@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := Open(filepath.Join(dir, "cache"), 10)
+	require.NoError(t, err)
+
+	_, ok := c.Get(Key("missing"))
+	require.False(t, ok)
+
+	require.NoError(t, c.Put(Key("a"), []byte("aaaa")))
+	require.NoError(t, c.Put(Key("b"), []byte("bbbb")))
+	data, ok := c.Get(Key("a"))
+	require.True(t, ok)
+	require.Equal(t, "aaaa", string(data))
+
+	// Make b the least recently used entry, regardless of filesystem timestamp resolution
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(c.path(Key("b")), old, old))
+
+	require.NoError(t, c.Put(Key("c"), []byte("cccc")))
+	_, ok = c.Get(Key("b"))
+	require.False(t, ok, "least recently used entry should be evicted")
+	_, ok = c.Get(Key("a"))
+	require.True(t, ok)
+	_, ok = c.Get(Key("c"))
+	require.True(t, ok)
+}
+
+func TestCacheDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := Open(dir, 10)
+	require.NoError(t, err)
+	clone, err := c.Dir(Key("repo"))
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(clone, "file"), make([]byte, 20), 0600))
+	require.NoError(t, c.Evict())
+	_, err = os.Stat(clone)
+	require.True(t, os.IsNotExist(err), "directories larger than the cache should be evicted")
+}
@@ -0,0 +1,163 @@
+package command
+
+import (
+	"sort"
+)
+
+// ahoCorasick is an Aho–Corasick automaton, which finds every occurrence of any of a set of literal patterns in a
+// single pass over the text, regardless of the number of patterns.
+type ahoCorasick struct {
+	patterns [][]byte
+	nodes    []acNode
+	// root holds the transitions from the root node for every byte, since most bytes of a text lead back to it
+	root [256]int32
+}
+
+type acNode struct {
+	// edges are the node's trie transitions, sorted by byte
+	edges []acEdge
+	// fail is the node for the longest proper suffix of this node's path that is also a path in the trie
+	fail int32
+	// output is the index of the pattern ending at this node, or -1
+	output int32
+	// dict is the nearest node reachable by fail links with an output, or -1
+	dict int32
+}
+
+type acEdge struct {
+	b    byte
+	node int32
+}
+
+// newAhoCorasick builds an automaton matching patterns. Empty and duplicate patterns are ignored.
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{nodes: []acNode{{fail: 0, output: -1, dict: -1}}}
+	ac.add(patterns)
+	ac.link()
+	return ac
+}
+
+// add inserts patterns into the trie, ignoring empty and duplicate patterns. The automaton must be linked again before
+// it's used.
+func (ac *ahoCorasick) add(patterns []string) {
+	for _, p := range patterns {
+		if p != "" {
+			ac.insert([]byte(p))
+		}
+	}
+}
+
+func (ac *ahoCorasick) insert(pattern []byte) {
+	node := int32(0)
+	for _, b := range pattern {
+		next := ac.edge(node, b)
+		if next < 0 {
+			next = int32(len(ac.nodes))
+			ac.nodes = append(ac.nodes, acNode{output: -1, dict: -1})
+			// Edges are kept sorted, since edge binary searches nodes with many edges while patterns are inserted
+			edges := ac.nodes[node].edges
+			i := sort.Search(len(edges), func(i int) bool { return edges[i].b >= b })
+			edges = append(edges, acEdge{})
+			copy(edges[i+1:], edges[i:])
+			edges[i] = acEdge{b, next}
+			ac.nodes[node].edges = edges
+		}
+		node = next
+	}
+	if ac.nodes[node].output >= 0 {
+		// Duplicate pattern
+		return
+	}
+	ac.nodes[node].output = int32(len(ac.patterns))
+	ac.patterns = append(ac.patterns, pattern)
+}
+
+// edge returns the node reached from node by b in the trie, or -1.
+func (ac *ahoCorasick) edge(node int32, b byte) int32 {
+	edges := ac.nodes[node].edges
+	if len(edges) <= 8 {
+		for _, e := range edges {
+			if e.b == b {
+				return e.node
+			}
+		}
+		return -1
+	}
+	i := sort.Search(len(edges), func(i int) bool { return edges[i].b >= b })
+	if i < len(edges) && edges[i].b == b {
+		return edges[i].node
+	}
+	return -1
+}
+
+// link computes the root transitions, and the fail and dictionary links of each node, in breadth first order.
+func (ac *ahoCorasick) link() {
+	ac.root = [256]int32{}
+	for _, e := range ac.nodes[0].edges {
+		ac.root[e.b] = e.node
+	}
+	queue := []int32{}
+	for _, e := range ac.nodes[0].edges {
+		queue = append(queue, e.node)
+	}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for _, e := range ac.nodes[u].edges {
+			v := e.node
+			queue = append(queue, v)
+
+			f := ac.nodes[u].fail
+			for f != 0 && ac.edge(f, e.b) < 0 {
+				f = ac.nodes[f].fail
+			}
+			fail := ac.edge(f, e.b)
+			if fail < 0 || fail == v {
+				fail = 0
+			}
+			ac.nodes[v].fail = fail
+			if ac.nodes[fail].output >= 0 {
+				ac.nodes[v].dict = fail
+			} else {
+				ac.nodes[v].dict = ac.nodes[fail].dict
+			}
+		}
+	}
+}
+
+// findAll calls fn with the start and end offsets and pattern index of each occurrence of a pattern in text, in
+// order of their end offsets, until fn returns false.
+func (ac *ahoCorasick) findAll(text []byte, fn func(start, end, pattern int) bool) {
+	state := int32(0)
+	for i, b := range text {
+		for state != 0 {
+			if next := ac.edge(state, b); next >= 0 {
+				state = next
+				break
+			}
+			state = ac.nodes[state].fail
+		}
+		if state == 0 {
+			state = ac.root[b]
+		}
+
+		for n := state; n >= 0; n = ac.nodes[n].dict {
+			if p := ac.nodes[n].output; p >= 0 {
+				end := i + 1
+				if !fn(end-len(ac.patterns[p]), end, int(p)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// match returns true if the line contains any pattern delimited by word boundaries.
+func (ac *ahoCorasick) match(line []byte) bool {
+	found := false
+	ac.findAll(line, func(start, end, _ int) bool {
+		found = isWordBounded(line, start, end)
+		return !found
+	})
+	return found
+}
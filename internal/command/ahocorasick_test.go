@@ -0,0 +1,117 @@
+package command
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type acMatch struct {
+	start, end, pattern int
+}
+
+func Test_ahoCorasick_findAll(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "she"}
+	ac := newAhoCorasick(patterns)
+	matches := []acMatch{}
+	ac.findAll([]byte("ushers"), func(start, end, pattern int) bool {
+		matches = append(matches, acMatch{start, end, pattern})
+		return true
+	})
+	// Duplicate patterns are ignored, so "hers" is the fourth pattern, but has index 3
+	require.Equal(t, []acMatch{{1, 4, 1}, {2, 4, 0}, {2, 6, 3}}, matches)
+}
+
+// Test_ahoCorasick_random compares the automaton against a naive search over random texts and patterns drawn from a
+// small alphabet, which produces many overlapping matches.
+func Test_ahoCorasick_random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	randomString := func(maxLen int) string {
+		b := make([]byte, 1+r.Intn(maxLen))
+		for i := range b {
+			b[i] = "ab-_"[r.Intn(4)]
+		}
+		return string(b)
+	}
+
+	for i := 0; i < 200; i++ {
+		patterns := []string{}
+		for j := 0; j < 1+r.Intn(20); j++ {
+			patterns = append(patterns, randomString(5))
+		}
+		text := []byte(randomString(50))
+		ac := newAhoCorasick(patterns)
+
+		expected := map[acMatch]bool{}
+		for _, p := range ac.patterns {
+			for start := 0; start+len(p) <= len(text); start++ {
+				if bytes.Equal(text[start:start+len(p)], p) {
+					expected[acMatch{start, start + len(p), 0}] = true
+				}
+			}
+		}
+		actual := map[acMatch]bool{}
+		ac.findAll(text, func(start, end, pattern int) bool {
+			require.Equal(t, string(ac.patterns[pattern]), string(text[start:end]))
+			actual[acMatch{start, end, 0}] = true
+			return true
+		})
+		require.Equal(t, expected, actual, "patterns %q in %q", patterns, text)
+	}
+}
+
+func Test_ahoCorasick_match(t *testing.T) {
+	ac := newAhoCorasick([]string{"flag", "flag-2"})
+	require.True(t, ac.match([]byte("if flag-2 {")))
+	require.True(t, ac.match([]byte("myflag flag")))
+	require.False(t, ac.match([]byte("myflag flag_2")))
+	require.False(t, ac.match([]byte("")))
+}
+
+// Test_ahoCorasick_sharedPrefix checks keys sharing a prefix, so the node following it has enough edges to be binary
+// searched while the keys are inserted.
+func Test_ahoCorasick_sharedPrefix(t *testing.T) {
+	patterns := []string{}
+	for _, c := range "zyxwvutsrqponm" {
+		patterns = append(patterns, "flag-"+string(c), "flag-"+string(c)+"-2")
+	}
+	ac := newAhoCorasick(patterns)
+	require.Len(t, ac.patterns, len(patterns))
+	for _, p := range patterns {
+		require.True(t, ac.match([]byte("if "+p+" {")), p)
+	}
+}
+
+func TestFlagMatcher_Add(t *testing.T) {
+	m := NewFlagMatcher([]string{"flag-2", "flag"})
+	require.True(t, m.automaton().match([]byte("if flag {")))
+	require.Equal(t, []string{"flag"}, m.automaton().keys([]byte("if flag-3 {")))
+
+	// Keys added after the matcher was used are matched, and duplicates are ignored
+	m.Add([]string{"flag-3", "flag", ""})
+	require.Equal(t, 3, m.Len())
+	require.Equal(t, []string{"flag", "flag-3"}, m.automaton().keys([]byte("if flag-3 {")))
+	// Keys are returned in the order they were added, whether or not they're delimited by word boundaries
+	require.Equal(t, []string{"flag-2", "flag", "flag-3"}, m.automaton().keys([]byte("flag-3 + flag-2")))
+	require.Empty(t, m.automaton().keys([]byte("nothing")))
+}
+
+func Benchmark_literalMatcher(b *testing.B) {
+	flags := make([]string, 5000)
+	r := rand.New(rand.NewSource(1))
+	for i := range flags {
+		k := make([]byte, 10+r.Intn(20))
+		for j := range k {
+			k[j] = "abcdefghijklmnopqrstuvwxyz-"[r.Intn(27)]
+		}
+		flags[i] = string(k)
+	}
+	line := []byte(`	if client.BoolVariation("some-feature-flag-that-does-not-exist", user, false) { return nil }`)
+	matcher := newLiteralMatcher(flags)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matcher.match(line)
+	}
+}
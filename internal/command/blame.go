@@ -0,0 +1,56 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BlameEmails returns the email address of the author of each of lines in the file at path, relative to the workspace,
+// keyed by line number, according to git blame.
+func (c Client) BlameEmails(path string, lines []int) (map[int]string, error) {
+	ret := map[int]string{}
+	if len(lines) == 0 {
+		return ret, nil
+	}
+	args := []string{"-C", c.Workspace, "blame", "--line-porcelain"}
+	for _, line := range lines {
+		args = append(args, "-L", fmt.Sprintf("%d,%d", line, line))
+	}
+	args = append(args, "--", path)
+	out, err := niceCommand(context.Background(), "git", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseBlameEmails(out), nil
+}
+
+// parseBlameEmails parses the author emails of each line from git blame --line-porcelain output, in which each line
+// starts with a header of "<sha> <original line> <final line>", and is followed by its commit's details.
+func parseBlameEmails(out []byte) map[int]string {
+	ret := map[int]string{}
+	lineNum := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), len(out)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			// The contents of the line, which ends its entry
+			lineNum = 0
+		case strings.HasPrefix(line, "author-mail "):
+			if lineNum > 0 {
+				ret[lineNum] = strings.Trim(strings.TrimPrefix(line, "author-mail "), "<>")
+			}
+		case lineNum == 0:
+			fields := strings.Fields(line)
+			if len(fields) >= 3 {
+				lineNum, _ = strconv.Atoi(fields[2])
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,24 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseBlameEmails(t *testing.T) {
+	out := `3f2a1b 1 3 1
+author Jane
+author-mail <jane@example.com>
+summary add flag
+filename a.go
+	if flag-1 {
+9c8d7e 4 7 1
+author John
+author-mail <john@example.com>
+previous 3f2a1b a.go
+filename a.go
+	author-mail <not-a-header>
+`
+	require.Equal(t, map[int]string{3: "jane@example.com", 7: "john@example.com"}, parseBlameEmails([]byte(out)))
+}
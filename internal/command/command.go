@@ -6,28 +6,116 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/launchdarkly/ld-find-code-refs/internal/log"
 )
 
-/*
-grepRegex splits resulting grep lines into groups
-Group 1: File path.
-Group 2: Seperator. A colon indicates a match, a hyphen indicates a context lines
-Group 3: Line number
-Group 4: Line contents
-*/
-var grepRegex, _ = regexp.Compile("([^:]+)(:|-)([0-9]+)[:-](.*)")
-
 type Client struct {
-	Workspace string
-	GitBranch string
-	GitSha    string
+	Workspace      string
+	GitBranch      string
+	GitSha         string
+	SearchTool     string
+	SearchStrategy string
+	// Vcs is the version control system the workspace was checked out from. If empty, it's a git repository.
+	Vcs string
+	// GitTimestamp is the commit time of GitSha, in seconds since the epoch, so it doesn't depend on the time zone of
+	// the committer or the host.
+	GitTimestamp int64
+	// MaxConcurrency limits the number of files searched concurrently. If 0, runtime.NumCPU is used.
+	MaxConcurrency int
+	// IncludeGlobs limits the files searched to those matching any of the patterns returned by IncludeGlobs.
+	// If empty, all files are searched.
+	IncludeGlobs []string
+	// Roots limits the search to these directories, relative to Workspace. If empty, the whole workspace is searched.
+	Roots []string
+	// Pathspecs limits the search to files matching these git pathspecs, relative to Workspace. Since search tools
+	// can't match pathspecs, the files are listed with git ls-files, and searched with the native search strategy.
+	Pathspecs []string
+	// TrackedOnly limits the search to files tracked by git, so untracked files aren't searched even if they aren't
+	// ignored. Like Pathspecs, the files are searched with the native search strategy.
+	TrackedOnly bool
+	// LfsGlobs are patterns, in the form returned by IncludeGlobs, matching Git LFS files which are retrieved and
+	// searched. Other Git LFS pointer files aren't searched.
+	LfsGlobs []string
+	// MaxDepth limits the search to files at most this many levels below each root, so files directly in a root
+	// are at depth 1. If 0, the depth isn't limited.
+	MaxDepth int
+	// OneFileSystem limits the search to files on the same file system as their root, so mounted volumes in the
+	// workspace aren't searched.
+	OneFileSystem bool
+	// MaxFiles limits the number of files searched. If there are more files, only the first MaxFiles are searched.
+	// If 0, all files are searched.
+	MaxFiles int
+	// Deadline is the time by which the search must finish. If it passes, the search is stopped. If zero, searches
+	// aren't limited.
+	Deadline time.Time
+	// Plugins are matcher plugins, which search the files matching their globs instead of SearchStrategy.
+	Plugins []Plugin
+	// PluginTimeout limits the time a plugin may take to search a file. If 0, plugins aren't limited.
+	PluginTimeout time.Duration
+	// FileErrors collects errors searching individual files, which are skipped rather than failing the search. If nil,
+	// the errors are logged as warnings.
+	FileErrors *FileErrors
+	// FlagMatcher, if set, is searched for instead of the flags passed to SearchForFlags, with the native search
+	// strategy, so the flag keys needn't be collected into a list. LfsGlobs and Plugins aren't supported.
+	FlagMatcher *FlagMatcher
+
+	searchToolPath string
+	// searchToolJson is true if the search tool's JSON output is parsed instead of its text output.
+	searchToolJson bool
 }
 
-func NewClient(path string) (Client, error) {
+// NewClient initializes a client for searching the repository at dirs, checked out from vcs, for flag references, using
+// searchTool and searchStrategy. If more than one directory is provided, they must be in the same repository, and only
+// those directories are searched. Paths are relative to the root of the repository, unless relativeToDir is true and
+// a single directory is provided. Search tools are looked up in the system PATH, and then in toolCacheDir. A search
+// tool isn't required by the native strategy, or by the auto strategy, which falls back to the native strategy.
+func NewClient(vcs string, dirs []string, relativeToDir bool, searchTool, searchStrategy, toolCacheDir string) (Client, error) {
+	path := ""
+	if len(dirs) > 0 {
+		path = dirs[0]
+	}
+	client, err := NewVcsClient(vcs, path)
+	if err != nil {
+		return client, err
+	}
+	if len(dirs) > 1 || !relativeToDir {
+		if len(dirs) == 0 {
+			dirs = []string{path}
+		}
+		err = client.setRoots(dirs)
+		if err != nil {
+			return client, err
+		}
+	}
+	client.SearchStrategy = searchStrategy
+	if searchStrategy == SearchStrategyNative {
+		return client, nil
+	}
+
+	name, toolPath, err := LookSearchTool(searchTool, toolCacheDir)
+	if err != nil {
+		if searchStrategy == SearchStrategyAuto || searchStrategy == "" {
+			log.Debug.Printf("%s", err)
+			log.Info.Printf("no search tool was found, using the native search strategy")
+			return client, nil
+		}
+		return client, err
+	}
+	log.Debug.Printf("using search tool: %s", toolPath)
+	client.SearchTool = name
+	client.searchToolPath = toolPath
+	client.searchToolJson = name == SearchToolRg && supportsJson(toolPath)
+	return client, nil
+}
+
+// NewGitClient initializes a client for reading git metadata from the repository at path. Unlike NewClient,
+// it does not require a search tool to be installed. GitBranch is empty if no branch is checked out.
+func NewGitClient(path string) (Client, error) {
 	client := Client{}
 
 	absPath, err := normalizeAndValidatePath(path)
@@ -40,25 +128,25 @@ func NewClient(path string) (Client, error) {
 	if err != nil {
 		return client, errors.New("git is a required dependency, but was not found in the system PATH")
 	}
-	_, err = exec.LookPath("ag")
-	if err != nil {
-		return client, errors.New("ag (The Silver Searcher) is a required dependency, but was not found in the system PATH")
-	}
-
 	currBranch, err := client.branchName()
 	if err != nil {
 		return client, fmt.Errorf("error parsing git branch name: %s", err)
-	} else if currBranch == "" {
-		return client, fmt.Errorf("error parsing git branch name: git repo at %s must be checked out to a valid branch", client.Workspace)
 	}
+	// The branch name is empty if HEAD is detached, e.g. when a tag is checked out
 	client.GitBranch = currBranch
 
-	headSha, err := client.revParse(currBranch)
+	headSha, err := client.revParse("HEAD")
 	if err != nil {
 		return client, fmt.Errorf("error parsing current commit sha: %s", err)
 	}
 	client.GitSha = headSha
 
+	commitTime, err := client.commitTime(headSha)
+	if err != nil {
+		return client, fmt.Errorf("error parsing commit timestamp: %s", err)
+	}
+	client.GitTimestamp = commitTime
+
 	return client, nil
 }
 
@@ -87,36 +175,126 @@ func (c Client) revParse(branch string) (string, error) {
 	return ret, nil
 }
 
-func (c Client) SearchForFlags(flags []string, ctxLines int) ([][]string, error) {
-	var sb strings.Builder
-
-	sb.WriteString(fmt.Sprintf("ag --nogroup --case-sensitive"))
-	if ctxLines > 0 {
-		sb.WriteString(fmt.Sprintf(" -C%d", ctxLines))
+// commitTime returns the commit time of sha, in seconds since the epoch.
+func (c Client) commitTime(sha string) (int64, error) {
+	cmd := exec.Command("git", "-C", c.Workspace, "show", "-s", "--format=%ct", sha)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
 	}
+	ret, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	log.Debug.Printf("identified commit time: %d", ret)
+	return ret, nil
+}
 
-	flagRegexes := []string{}
-	for _, v := range flags {
-		escapedFlag := regexp.QuoteMeta(v)
-		flagRegexes = append(flagRegexes, "\\b"+escapedFlag+"\\b")
+// setRoots limits the search to dirs, and sets the workspace to the root of their repository, so paths are
+// relative to the repository root, however deeply dirs are nested in it.
+func (c *Client) setRoots(dirs []string) error {
+	vcs := c.Vcs
+	if c.IsGit() {
+		vcs = VcsGit
 	}
-	sb.WriteString(" '" + strings.Join(flagRegexes, "|") + "' " + c.Workspace)
+	topLevel, err := c.topLevel(c.Workspace)
+	if err != nil {
+		return fmt.Errorf("could not find the root of the %s repository at %s: %s", vcs, c.Workspace, err)
+	}
+	roots := []string{}
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		absPath, err := normalizeAndValidatePath(dir)
+		if err != nil {
+			return fmt.Errorf("could not validate directory option: %s", err)
+		}
+		dirTopLevel, err := c.topLevel(absPath)
+		if err != nil || dirTopLevel != topLevel {
+			return fmt.Errorf("directory %s is not in the %s repository at %s", absPath, vcs, topLevel)
+		}
+		// Symlinks are resolved, since the real path of the repository is reported
+		realPath, err := filepath.EvalSymlinks(absPath)
+		if err != nil {
+			return fmt.Errorf("invalid directory: %s", err)
+		}
+		root, err := filepath.Rel(topLevel, realPath)
+		if err != nil {
+			return fmt.Errorf("invalid directory: %s", err)
+		}
+		root = filepath.ToSlash(root)
+		if !seen[root] {
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+	c.Workspace = topLevel
+	c.Roots = roots
+	if seen["."] {
+		// The whole repository is searched
+		c.Roots = nil
+	}
+	if len(c.Roots) > 0 {
+		log.Info.Printf("searching %s in the %s repository at %s", strings.Join(c.Roots, ", "), vcs, topLevel)
+	}
+	return nil
+}
 
-	cmd := sb.String()
-	sh := exec.Command("sh", "-c", cmd)
-	out, err := sh.Output()
+// gitTopLevel returns the real path of the root of the git repository containing dir.
+func gitTopLevel(dir string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
 	if err != nil {
-		if err.Error() == "exit status 1" {
-			return [][]string{}, nil
+		return "", err
+	}
+	topLevel := filepath.FromSlash(strings.TrimSpace(string(out)))
+	return filepath.EvalSymlinks(topLevel)
+}
+
+// searchPaths returns the absolute paths of the directories to search.
+func (c Client) searchPaths() []string {
+	if len(c.Roots) == 0 {
+		return []string{c.Workspace}
+	}
+	paths := make([]string, 0, len(c.Roots))
+	for _, root := range c.Roots {
+		paths = append(paths, filepath.Join(c.Workspace, filepath.FromSlash(root)))
+	}
+	return paths
+}
+
+// IsAncestor returns true if the commit sha is an ancestor of, or the same as, the checked out commit. An error
+// is returned if sha is not a commit in the local repository, e.g. in a shallow clone. Subversion and Perforce
+// revisions are numbered in order, so earlier revisions are treated as ancestors.
+func (c Client) IsAncestor(sha string) (bool, error) {
+	if !c.IsGit() {
+		prev, err := strconv.ParseInt(sha, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("%s is not a %s revision", sha, c.Vcs)
+		}
+		head, err := strconv.ParseInt(c.GitSha, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("%s is not a %s revision", c.GitSha, c.Vcs)
 		}
-		return nil, err
+		return prev <= head, nil
+	}
+	cmd := exec.Command("git", "-C", c.Workspace, "merge-base", "--is-ancestor", "--", sha, "HEAD")
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.Sys().(syscall.WaitStatus).ExitStatus() == 1 {
+		return false, nil
 	}
-	grepRegexWithFilteredPath, err := regexp.Compile("(?:" + regexp.QuoteMeta(c.Workspace) + "/)" + grepRegex.String())
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoteUrl returns the url of a git remote for the repository at dir.
+func RemoteUrl(dir, remote string) (string, error) {
+	cmd := exec.Command("git", "-C", dir, "remote", "get-url", remote)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
 	}
-	ret := grepRegexWithFilteredPath.FindAllStringSubmatch(string(out), -1)
-	return ret, err
+	return strings.TrimSpace(string(out)), nil
 }
 
 func normalizeAndValidatePath(path string) (string, error) {
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAncestor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ancestor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	repo := filepath.Join(dir, "repo")
+	require.NoError(t, os.Mkdir(repo, 0755))
+	git(repo, "init", "-q")
+	git(repo, "checkout", "-q", "-b", "main")
+	git(repo, "commit", "-q", "--allow-empty", "-m", "first")
+	first := git(repo, "rev-parse", "HEAD")
+	git(repo, "checkout", "-q", "-b", "other")
+	git(repo, "commit", "-q", "--allow-empty", "-m", "other")
+	other := git(repo, "rev-parse", "HEAD")
+	git(repo, "checkout", "-q", "main")
+	git(repo, "commit", "-q", "--allow-empty", "-m", "second")
+	second := git(repo, "rev-parse", "HEAD")
+
+	c := Client{Workspace: repo}
+	for sha, want := range map[string]bool{first: true, second: true, other: false} {
+		isAncestor, err := c.IsAncestor(sha)
+		require.NoError(t, err)
+		require.Equal(t, want, isAncestor, sha)
+	}
+	// Commits aren't interpreted as options
+	_, err = c.IsAncestor("--help")
+	require.Error(t, err)
+
+	// The first commit isn't fetched by a shallow clone
+	shallow := filepath.Join(dir, "shallow")
+	git(dir, "clone", "-q", "--depth=1", "--branch=main", "file://"+repo, shallow)
+	c = Client{Workspace: shallow}
+	isAncestor, err := c.IsAncestor(second)
+	require.NoError(t, err)
+	require.True(t, isAncestor)
+	_, err = c.IsAncestor(first)
+	require.Error(t, err)
+}
+
+func TestNewGitClient_commitTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+	commit := exec.Command("git", "-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "test")
+	// The commit time doesn't depend on the committer's time zone
+	commit.Env = append(os.Environ(), "GIT_COMMITTER_DATE=2019-05-01T09:00:00+09:00", "TZ=America/New_York")
+	require.NoError(t, commit.Run())
+
+	client, err := NewGitClient(dir)
+	require.NoError(t, err)
+	require.Equal(t, int64(1556668800), client.GitTimestamp)
+}
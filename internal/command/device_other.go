@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package command
+
+import (
+	"os"
+)
+
+// deviceId is not supported on this platform, so files on other file systems are always searched.
+func deviceId(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
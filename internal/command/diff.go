@@ -0,0 +1,47 @@
+package command
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Renames returns the new path of each file renamed between the commits base and head, keyed by its path in base,
+// using git's rename detection. Paths are relative to the workspace, in the same form as the paths of search results,
+// and renames outside of the workspace are omitted.
+func (c Client) Renames(base, head string) (map[string]string, error) {
+	out, err := exec.Command("git", "-C", c.Workspace, "diff", "-M", "--relative", "--name-status", "-z", "--diff-filter=R", base, head).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not find files renamed between %s and %s: %s", base, head, err)
+	}
+	return parseRenames(string(out)), nil
+}
+
+// parseRenames parses the output of git diff --name-status -z, which is a NUL separated list of statuses and paths,
+// where renames, e.g. R095, are followed by the old and new paths.
+func parseRenames(out string) map[string]string {
+	renames := map[string]string{}
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	for i := 0; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "R") || i+2 >= len(fields) {
+			continue
+		}
+		renames[fields[i+1]] = fields[i+2]
+		i += 2
+	}
+	return renames
+}
+
+// Parents returns the parents of the commit rev, in order, so the first parent is the branch a merge commit was made
+// on. Root commits have no parents.
+func (c Client) Parents(rev string) ([]string, error) {
+	out, err := exec.Command("git", "-C", c.Workspace, "rev-list", "--parents", "-n", "1", rev).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not find the parents of %s: %s", rev, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("could not find the parents of %s", rev)
+	}
+	return fields[1:], nil
+}
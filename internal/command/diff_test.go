@@ -0,0 +1,104 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renames")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...).Output()
+		require.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	content := []byte(strings.Repeat("flag-1 is referenced in this file\n", 10))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "a.go"), content, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.go"), content, 0644))
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+	base := git("rev-parse", "HEAD")
+	git("mv", "sub/a.go", "sub/moved a.go")
+	git("mv", "b.go", "c.go")
+	git("commit", "-q", "-m", "second")
+	head := git("rev-parse", "HEAD")
+
+	renames, err := Client{Workspace: dir}.Renames(base, head)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"sub/a.go": "sub/moved a.go", "b.go": "c.go"}, renames)
+
+	// Paths are relative to the workspace, and renames outside of it are omitted
+	renames, err = Client{Workspace: filepath.Join(dir, "sub")}.Renames(base, head)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a.go": "moved a.go"}, renames)
+
+	_, err = Client{Workspace: dir}.Renames(base, "missing")
+	require.Error(t, err)
+}
+
+func Test_parseRenames(t *testing.T) {
+	require.Equal(t, map[string]string{"a": "b", "c": "d"}, parseRenames("R100\x00a\x00b\x00R090\x00c\x00d\x00"))
+	require.Equal(t, map[string]string{}, parseRenames(""))
+}
+
+func TestParents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parents")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...).Output()
+		require.NoError(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(file string) string {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, file), []byte(file), 0644))
+		git("add", file)
+		git("commit", "-q", "-m", file)
+		return git("rev-parse", "HEAD")
+	}
+	git("init", "-q")
+	git("checkout", "-q", "-b", "main")
+	root := commit("root")
+	git("checkout", "-q", "-b", "a")
+	a := commit("a")
+	git("checkout", "-q", "-b", "b", root)
+	b := commit("b")
+	git("checkout", "-q", "main")
+	main := commit("main")
+	git("merge", "-q", "--no-edit", "a", "b")
+	octopus := git("rev-parse", "HEAD")
+	client := Client{Workspace: dir}
+
+	parents, err := client.Parents(root)
+	require.NoError(t, err)
+	require.Empty(t, parents)
+
+	parents, err = client.Parents(octopus)
+	require.NoError(t, err)
+	require.Equal(t, []string{main, a, b}, parents)
+
+	// Fast-forward merges don't create a merge commit, so the merged commit keeps its single parent
+	git("checkout", "-q", "-b", "c", octopus)
+	c := commit("c")
+	git("checkout", "-q", "main")
+	git("merge", "-q", "--ff-only", "c")
+	require.Equal(t, c, git("rev-parse", "HEAD"))
+	parents, err = client.Parents("HEAD")
+	require.NoError(t, err)
+	require.Equal(t, []string{octopus}, parents)
+
+	_, err = client.Parents("missing")
+	require.Error(t, err)
+}
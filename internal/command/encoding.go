@@ -0,0 +1,45 @@
+package command
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Byte order marks identifying the encoding of a file
+var (
+	bomUtf8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUtf16LE = []byte{0xFF, 0xFE}
+	bomUtf16BE = []byte{0xFE, 0xFF}
+)
+
+// decodeText detects the encoding of a file from its byte order mark, and returns its contents as UTF-8 without
+// the byte order mark, along with the name of the original encoding. Files without a byte order mark are
+// returned unchanged, with an empty encoding.
+func decodeText(data []byte) ([]byte, string) {
+	switch {
+	case bytes.HasPrefix(data, bomUtf8):
+		return data[len(bomUtf8):], "UTF-8 with BOM"
+	case bytes.HasPrefix(data, bomUtf16LE):
+		return decodeUtf16(data[len(bomUtf16LE):], binary.LittleEndian), "UTF-16LE"
+	case bytes.HasPrefix(data, bomUtf16BE):
+		return decodeUtf16(data[len(bomUtf16BE):], binary.BigEndian), "UTF-16BE"
+	}
+	return data, ""
+}
+
+func decodeUtf16(data []byte, order binary.ByteOrder) []byte {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	runes := utf16.Decode(units)
+	ret := make([]byte, 0, len(runes))
+	buf := make([]byte, utf8.UTFMax)
+	for _, r := range runes {
+		n := utf8.EncodeRune(buf, r)
+		ret = append(ret, buf[:n]...)
+	}
+	return ret
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// InitEnv configures the environment inherited by git and the search tool so they run cleanly in
+// locked down environments, such as CI containers with a read-only root filesystem and no home directory:
+//   - The system git config is ignored, since it's outside of the repository's control and may not be readable.
+//   - git never prompts for credentials, since there is no one to answer.
+//   - Temporary files are written to a directory created for this run in tmpDir, if provided, or the system temporary
+//     directory, so concurrent scans can't collide. It's removed by RemoveRunDir.
+//   - If there is no usable home directory, HOME is set to the temporary directory.
+func InitEnv(tmpDir string) error {
+	if tmpDir != "" {
+		err := checkWritable(tmpDir)
+		if err != nil {
+			return fmt.Errorf("tmpDir %s is not writable: %s", tmpDir, err)
+		}
+	}
+	base := tmpDir
+	if base == "" {
+		base = os.TempDir()
+	}
+	runDirLock.Lock()
+	tempBase = base
+	runDirLock.Unlock()
+	dir, f, err := createRunDir(base)
+	if err != nil && tmpDir != "" {
+		return fmt.Errorf("could not create a temporary directory in tmpDir %s: %s", tmpDir, err)
+	} else if err != nil {
+		// Scans which don't write temporary files still succeed with a read-only system temporary directory
+		log.Warning.Printf("could not create a temporary directory in %s, provide a writable tmpDir: %s", base, err)
+	} else {
+		runDirLock.Lock()
+		runDir, runDirFile = dir, f
+		runDirLock.Unlock()
+		log.AtExit(RemoveRunDir)
+		exitOnSignal()
+		err = os.Setenv("TMPDIR", dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	env := map[string]string{
+		"GIT_CONFIG_NOSYSTEM": "1",
+		"GIT_TERMINAL_PROMPT": "0",
+	}
+	if home := os.Getenv("HOME"); home == "" || !isDir(home) {
+		env["HOME"] = os.TempDir()
+	}
+	for k, v := range env {
+		err := os.Setenv(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func checkWritable(dir string) error {
+	if !isDir(dir) {
+		return fmt.Errorf("not a directory")
+	}
+	f, err := ioutil.TempFile(dir, ".ld-find-code-refs")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// restoreEnv returns a function which restores the environment variables keys to their current values.
+func restoreEnv(keys ...string) func() {
+	prev := map[string]*string{}
+	for _, k := range keys {
+		if v, ok := os.LookupEnv(k); ok {
+			prev[k] = &v
+		} else {
+			prev[k] = nil
+		}
+	}
+	return func() {
+		for k, v := range prev {
+			if v != nil {
+				os.Setenv(k, *v)
+			} else {
+				os.Unsetenv(k)
+			}
+		}
+	}
+}
+
+func TestInitEnv(t *testing.T) {
+	log.Init(false)
+	defer restoreEnv("TMPDIR", "HOME", "GIT_CONFIG_NOSYSTEM", "GIT_TERMINAL_PROMPT")()
+	base, err := ioutil.TempDir("", "env")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	file := filepath.Join(base, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	err = InitEnv(file)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "tmpDir "+file+" is not writable")
+
+	require.NoError(t, os.Setenv("HOME", filepath.Join(base, "missing")))
+	require.NoError(t, InitEnv(base))
+	defer RemoveRunDir()
+	dir := os.Getenv("TMPDIR")
+	require.Equal(t, base, filepath.Dir(dir))
+	require.True(t, strings.HasPrefix(filepath.Base(dir), runDirPrefix), dir)
+	require.DirExists(t, dir)
+	require.Equal(t, dir, os.Getenv("HOME"))
+	require.Equal(t, "1", os.Getenv("GIT_CONFIG_NOSYSTEM"))
+	require.Equal(t, "0", os.Getenv("GIT_TERMINAL_PROMPT"))
+
+	RemoveRunDir()
+	_, err = os.Stat(dir)
+	require.True(t, os.IsNotExist(err))
+}
+
+func Test_checkWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writable")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, checkWritable(dir))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files, "the file written to check the directory should be removed")
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	require.EqualError(t, checkWritable(file), "not a directory")
+	require.EqualError(t, checkWritable(filepath.Join(dir, "missing")), "not a directory")
+}
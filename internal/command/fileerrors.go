@@ -0,0 +1,109 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// FileError is an error searching a single file, which was skipped rather than failing the search.
+type FileError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// FileErrors collects errors searching individual files, so they can be reported once the search is finished. It's
+// safe for concurrent use. If a client's FileErrors is nil, errors are logged as warnings instead.
+type FileErrors struct {
+	mu     sync.Mutex
+	errors map[string]string
+}
+
+// add records an error searching the file at path, relative to the workspace. Only the first error for each file is
+// kept.
+func (e *FileErrors) add(path, reason string) {
+	if e == nil {
+		log.Warning.Printf("could not search %s, references in it will be missing: %s", path, reason)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.errors == nil {
+		e.errors = map[string]string{}
+	}
+	if _, ok := e.errors[path]; !ok {
+		e.errors[path] = reason
+	}
+}
+
+// List returns the errors collected, sorted by path.
+func (e *FileErrors) List() []FileError {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ret := make([]FileError, 0, len(e.errors))
+	for path, reason := range e.errors {
+		ret = append(ret, FileError{Path: path, Reason: reason})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Path < ret[j].Path
+	})
+	return ret
+}
+
+// searchIsolated returns the results of search for the file at path. A panic while searching the file is recovered
+// from and recorded as an error in the file, so a bug triggered by one file doesn't abort the whole search.
+func (c Client) searchIsolated(path string, search func() [][]string) (results [][]string) {
+	if !c.FileErrors.Isolate(path, func() { results = search() }) {
+		return nil
+	}
+	return results
+}
+
+// Isolate runs fn, which processes the file at path, and returns false if it panicked. The panic is recovered from and
+// recorded as an error in the file, so a bug triggered by one file doesn't abort the whole scan.
+func (e *FileErrors) Isolate(path string, fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debug.Printf("panic processing %s: %v\n%s", path, r, debug.Stack())
+			e.add(path, fmt.Sprintf("panic: %v", r))
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+// agSkippedPrefix prefixes the errors written to stderr by ag for files it couldn't read, e.g.
+// `ERR: Skipping a.go: Error opening file: Permission denied`.
+const agSkippedPrefix = "ERR: Skipping "
+
+// addToolErrors records the errors written to stderr by a search tool which couldn't read some files, e.g.
+// `rg: a.go: Permission denied (os error 13)`. It returns the remaining lines of stderr, which don't name a file.
+func (c Client) addToolErrors(stderr []byte) []byte {
+	var rest bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSpace(string(stderr)), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fileErr := strings.TrimPrefix(line, c.SearchTool+": ")
+		if c.SearchTool == SearchToolAg {
+			fileErr = strings.TrimPrefix(line, agSkippedPrefix)
+		}
+		idx := strings.Index(fileErr, ": ")
+		if idx <= 0 || (c.SearchTool == SearchToolAg && fileErr == line) {
+			rest.WriteString(line + "\n")
+			continue
+		}
+		c.FileErrors.add(relativePath(fileErr[:idx], c.Workspace), fileErr[idx+2:])
+	}
+	return rest.Bytes()
+}
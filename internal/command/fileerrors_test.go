@@ -0,0 +1,86 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileErrors(t *testing.T) {
+	var nilErrors *FileErrors
+	require.Nil(t, nilErrors.List())
+
+	errs := &FileErrors{}
+	errs.add("b.go", "permission denied")
+	errs.add("a.go", "input/output error")
+	errs.add("b.go", "is a directory")
+	require.Equal(t, []FileError{{Path: "a.go", Reason: "input/output error"}, {Path: "b.go", Reason: "permission denied"}}, errs.List())
+}
+
+func TestSearchIsolated(t *testing.T) {
+	c := Client{FileErrors: &FileErrors{}}
+	results := c.searchIsolated("a.go", func() [][]string {
+		return [][]string{resultLine("a.go", ":", 1, "flag-1")}
+	})
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "flag-1")}, results)
+
+	results = c.searchIsolated("b.go", func() [][]string {
+		var lines []string
+		return [][]string{{lines[1]}}
+	})
+	require.Nil(t, results)
+	errs := c.FileErrors.List()
+	require.Len(t, errs, 1)
+	require.Equal(t, "b.go", errs[0].Path)
+	require.Contains(t, errs[0].Reason, "panic: runtime error: index out of range")
+}
+
+func TestAddToolErrors(t *testing.T) {
+	c := Client{Workspace: "/work", SearchTool: SearchToolRg, FileErrors: &FileErrors{}}
+	rest := c.addToolErrors([]byte("rg: /work/a.go: Permission denied (os error 13)\n/work/sub/b.go: Input/output error (os error 5)\nsome other error\n"))
+	require.Equal(t, "some other error\n", string(rest))
+	require.Equal(t, []FileError{
+		{Path: "a.go", Reason: "Permission denied (os error 13)"},
+		{Path: "sub/b.go", Reason: "Input/output error (os error 5)"},
+	}, c.FileErrors.List())
+
+	c = Client{Workspace: "/work", SearchTool: SearchToolAg, FileErrors: &FileErrors{}}
+	rest = c.addToolErrors([]byte("ERR: Skipping /work/a.go: Error opening file: Permission denied\nERR: out of memory\n"))
+	require.Equal(t, "ERR: out of memory\n", string(rest))
+	require.Equal(t, []FileError{{Path: "a.go", Reason: "Error opening file: Permission denied"}}, c.FileErrors.List())
+}
+
+func TestSearchWithTool_fileErrorsWithoutMatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	// A search tool which couldn't read the only file, so it exits with status 2 without printing any matches
+	tool := filepath.Join(dir, "rg")
+	script := "#!/bin/sh\necho 'rg: " + filepath.Join(dir, "a.txt") + ": Permission denied (os error 13)' >&2\nexit 2\n"
+	require.NoError(t, ioutil.WriteFile(tool, []byte(script), 0755))
+
+	c := Client{Workspace: dir, SearchTool: SearchToolRg, searchToolPath: tool, FileErrors: &FileErrors{}}
+	results, err := c.searchWithTool([]string{"flag-1"}, 0)
+	require.NoError(t, err)
+	require.Empty(t, results)
+	require.Equal(t, []FileError{{Path: "a.txt", Reason: "Permission denied (os error 13)"}}, c.FileErrors.List())
+}
+
+func TestSearchFiles_fileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("flag-1\n"), 0644))
+
+	// a.txt isn't a directory, so a.txt/b.txt can't be opened, but a.txt is still searched
+	c := Client{Workspace: dir, FileErrors: &FileErrors{}}
+	results, err := c.searchFiles([]string{"a.txt", "a.txt/b.txt", "missing.txt"}, []string{"flag-1"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("a.txt", ":", 1, "flag-1")}, results)
+	errs := c.FileErrors.List()
+	require.Len(t, errs, 1)
+	require.Equal(t, "a.txt/b.txt", errs[0].Path)
+}
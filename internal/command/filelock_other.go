@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package command
+
+import (
+	"os"
+)
+
+// lockFile is not supported on this platform, so workspaces aren't locked.
+func lockFile(f *os.File) error {
+	return errLockUnsupported
+}
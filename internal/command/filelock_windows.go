@@ -0,0 +1,31 @@
+package command
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// lockFile locks f exclusively without waiting, returning errLocked if another open file holds the lock. The lock is
+// released when f is closed, or the process exits.
+func lockFile(f *os.File) error {
+	// Locked ranges can't be read by other processes, so a byte far past the end of the file is locked, and the
+	// process holding the lock can still be read from the file
+	ol := syscall.Overlapped{OffsetHigh: 0x40000000}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLocked
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// matchedFlagKeysField is the index of the flag keys found in a result line by a FlagMatcher. Results from search
+// tools and plugins don't have this field.
+const matchedFlagKeysField = 6
+
+// FlagMatcher finds occurrences of flag keys in text with a single pass over it, regardless of the number of keys.
+// Keys may be added in batches, e.g. as pages of flags are retrieved: they're inserted into the automaton's trie as
+// they're added, and its links are computed the next time it's used, so the automaton is never rebuilt from scratch.
+type FlagMatcher struct {
+	mu     sync.Mutex
+	ac     *ahoCorasick
+	linked bool
+}
+
+// NewFlagMatcher returns a matcher for keys. Empty and duplicate keys are ignored.
+func NewFlagMatcher(keys []string) *FlagMatcher {
+	m := &FlagMatcher{ac: &ahoCorasick{nodes: []acNode{{fail: 0, output: -1, dict: -1}}}}
+	m.Add(keys)
+	return m
+}
+
+// Add adds keys to the matcher. Empty and duplicate keys are ignored. It may not be called during a search.
+func (m *FlagMatcher) Add(keys []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ac.add(keys)
+	m.linked = false
+}
+
+// Len returns the number of distinct keys matched.
+func (m *FlagMatcher) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.ac.patterns)
+}
+
+// automaton returns the matcher's automaton, linking it if keys were added since it was last used.
+func (m *FlagMatcher) automaton() *ahoCorasick {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.linked {
+		m.ac.link()
+		m.linked = true
+	}
+	return m.ac
+}
+
+// keys returns the keys occurring in line, in the order they were added, including occurrences which aren't delimited
+// by word boundaries.
+func (ac *ahoCorasick) keys(line []byte) []string {
+	found := map[int]bool{}
+	ac.findAll(line, func(_, _, pattern int) bool {
+		found[pattern] = true
+		return true
+	})
+	patterns := make([]int, 0, len(found))
+	for p := range found {
+		patterns = append(patterns, p)
+	}
+	sort.Ints(patterns)
+	keys := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		keys = append(keys, string(ac.patterns[p]))
+	}
+	return keys
+}
+
+// searchMatcher searches the files in the workspace for the keys in FlagMatcher with the native search strategy, since
+// the keys are never collected into a pattern for a search tool. Each matched line lists the keys occurring in it,
+// which are returned by MatchedFlagKeys.
+func (c Client) searchMatcher(ctxLines int) ([][]string, error) {
+	files, err := c.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	var budgetErr error
+	if c.MaxFiles > 0 && len(files) > c.MaxFiles {
+		log.Debug.Printf("found %d files, searching the first %d", len(files), c.MaxFiles)
+		budgetErr = &BudgetExceededError{Reason: fmt.Sprintf("found %d files, only the first %d were searched", len(files), c.MaxFiles)}
+		files = files[:c.MaxFiles]
+	}
+
+	ac := c.FlagMatcher.automaton()
+	results, err := c.searchFilesWith(files, ac, ctxLines)
+	if _, partial := err.(*BudgetExceededError); err != nil && !partial {
+		return nil, err
+	}
+	for i, r := range results {
+		if r[2] == ":" {
+			results[i] = append(r[:pluginFlagKeysField:pluginFlagKeysField], "", strings.Join(ac.keys([]byte(r[4])), ","))
+		}
+	}
+	if err == nil {
+		err = budgetErr
+	}
+	return results, err
+}
+
+// MatchedFlagKeys returns the flag keys occurring in a result line found by a FlagMatcher, whether or not they're
+// delimited by word boundaries. Results found otherwise aren't annotated with their keys, so nil is returned.
+func MatchedFlagKeys(result []string) []string {
+	if len(result) <= matchedFlagKeysField || result[matchedFlagKeysField] == "" {
+		return nil
+	}
+	return strings.Split(result[matchedFlagKeysField], ",")
+}
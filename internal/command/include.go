@@ -0,0 +1,107 @@
+package command
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IncludeGlobs parses a comma separated list of file extensions or glob patterns, e.g. "go,ts,*.yaml,src/*.py",
+// into glob patterns. Extensions match files with that extension in any directory. Patterns without a slash
+// match file names in any directory, and patterns with a slash match paths relative to the repository root.
+func IncludeGlobs(list string) ([]string, error) {
+	globs := []string{}
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.ContainsAny(v, "*?[/") {
+			v = "*." + strings.TrimPrefix(v, ".")
+		}
+		// Patterns are matched the same way by every search strategy, so unsupported syntax is rejected up front
+		if _, err := path.Match(v, ""); err != nil || strings.Contains(v, "**") {
+			return nil, fmt.Errorf("invalid file pattern %q", v)
+		}
+		globs = append(globs, strings.TrimPrefix(v, "/"))
+	}
+	return globs, nil
+}
+
+// isIncluded returns true if the path, relative to the workspace, matches any of globs, or globs is empty.
+func isIncluded(p string, globs []string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, g := range globs {
+		name := p
+		if !strings.Contains(g, "/") {
+			name = path.Base(p)
+		}
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// includeArgs returns the search tool arguments limiting a search to files matching globs.
+func includeArgs(searchTool, workspace string, globs []string) []string {
+	if len(globs) == 0 {
+		return nil
+	}
+	if searchTool == SearchToolRg {
+		args := make([]string, 0, len(globs))
+		for _, g := range globs {
+			if strings.Contains(g, "/") {
+				// rg matches globs containing a slash against the path relative to the search root
+				g = "/" + g
+			}
+			args = append(args, "--glob="+g)
+		}
+		return args
+	}
+	// ag only accepts a single regular expression, matched against each file's path
+	return []string{"-G", globsRegex(workspace, globs)}
+}
+
+// globsRegex converts globs to a regular expression matching the same paths in workspace.
+func globsRegex(workspace string, globs []string) string {
+	patterns := make([]string, 0, len(globs))
+	for _, g := range globs {
+		var b strings.Builder
+		if strings.Contains(g, "/") {
+			b.WriteString("^" + regexp.QuoteMeta(workspace) + "/")
+		} else {
+			b.WriteString("(?:^|/)")
+		}
+		for i := 0; i < len(g); i++ {
+			switch c := g[i]; c {
+			case '*':
+				b.WriteString("[^/]*")
+			case '?':
+				b.WriteString("[^/]")
+			case '[':
+				end := strings.IndexByte(g[i+1:], ']')
+				if end < 0 {
+					b.WriteString(regexp.QuoteMeta(string(c)))
+					continue
+				}
+				// Character classes, including negated classes, have the same syntax
+				b.WriteString("[" + g[i+1:i+1+end] + "]")
+				i += end + 1
+			case '\\':
+				if i+1 < len(g) {
+					i++
+				}
+				b.WriteString(regexp.QuoteMeta(string(g[i])))
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		b.WriteString("$")
+		patterns = append(patterns, b.String())
+	}
+	return strings.Join(patterns, "|")
+}
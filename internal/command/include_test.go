@@ -0,0 +1,48 @@
+package command
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludeGlobs(t *testing.T) {
+	globs, err := IncludeGlobs("go, .ts,*.yaml,/src/*.js,")
+	require.NoError(t, err)
+	require.Equal(t, []string{"*.go", "*.ts", "*.yaml", "src/*.js"}, globs)
+
+	_, err = IncludeGlobs("src/**/*.go")
+	require.Error(t, err)
+	_, err = IncludeGlobs("[a-")
+	require.Error(t, err)
+}
+
+func Test_isIncluded(t *testing.T) {
+	globs := []string{"*.go", "src/*.js"}
+	specs := []struct {
+		path     string
+		expected bool
+	}{
+		{"main.go", true},
+		{"internal/command/command.go", true},
+		{"main.go.orig", false},
+		{"src/index.js", true},
+		{"lib/src/index.js", false},
+		{"src/lib/index.js", false},
+	}
+	for _, tt := range specs {
+		t.Run(tt.path, func(t *testing.T) {
+			require.Equal(t, tt.expected, isIncluded(tt.path, globs))
+			// ag is given a regular expression which should match the same files
+			require.Equal(t, tt.expected, regexp.MustCompile(globsRegex("/ws", globs)).MatchString("/ws/"+tt.path))
+		})
+	}
+	require.True(t, isIncluded("anything", nil))
+}
+
+func Test_includeArgs(t *testing.T) {
+	require.Nil(t, includeArgs(SearchToolRg, "/ws", nil))
+	require.Equal(t, []string{"--glob=*.go", "--glob=/src/*.js"}, includeArgs(SearchToolRg, "/ws", []string{"*.go", "src/*.js"}))
+	require.Equal(t, []string{"-G", `(?:^|/)[^/]*\.go$|^/ws/src/[^/]*\.js$`}, includeArgs(SearchToolAg, "/ws", []string{"*.go", "src/*.js"}))
+}
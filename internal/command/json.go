@@ -0,0 +1,89 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rgJsonMinVersion is the first version of rg supporting --json.
+var rgJsonMinVersion = [2]int{0, 10}
+
+var rgVersionRegex = regexp.MustCompile(`^ripgrep (\d+)\.(\d+)`)
+
+// supportsJson returns true if the rg at toolPath supports --json output.
+func supportsJson(toolPath string) bool {
+	out, err := exec.Command(toolPath, "--version").Output()
+	if err != nil {
+		return false
+	}
+	return versionSupportsJson(out)
+}
+
+// versionSupportsJson returns true if the output of rg --version is for a version supporting --json.
+func versionSupportsJson(out []byte) bool {
+	m := rgVersionRegex.FindSubmatch(out)
+	if m == nil {
+		return false
+	}
+	major, _ := strconv.Atoi(string(m[1]))
+	minor, _ := strconv.Atoi(string(m[2]))
+	return major > rgJsonMinVersion[0] || major == rgJsonMinVersion[0] && minor >= rgJsonMinVersion[1]
+}
+
+// rgData is text in rg's JSON output. Text which isn't valid UTF-8 is base64 encoded in Bytes instead.
+type rgData struct {
+	Text  *string `json:"text"`
+	Bytes string  `json:"bytes"`
+}
+
+func (d rgData) String() string {
+	if d.Text != nil {
+		return *d.Text
+	}
+	b, _ := base64.StdEncoding.DecodeString(d.Bytes)
+	return string(b)
+}
+
+// rgMessage is a line of rg's JSON output. Only match and context messages are used.
+type rgMessage struct {
+	Type string `json:"type"`
+	Data struct {
+		Path       rgData `json:"path"`
+		Lines      rgData `json:"lines"`
+		LineNumber int    `json:"line_number"`
+	} `json:"data"`
+}
+
+// parseJsonOutput parses the output of rg --json into the same results as parseSearchOutput. Structured output
+// doesn't depend on separators, so any path is parsed correctly.
+func parseJsonOutput(out []byte, workspace string) ([][]string, error) {
+	ret := [][]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	// Each message contains a whole line, which may be long
+	scanner.Buffer(make([]byte, 64*1024), len(out)+1)
+	for scanner.Scan() {
+		var msg rgMessage
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			return nil, err
+		}
+		sep := ""
+		switch msg.Type {
+		case "match":
+			sep = ":"
+		case "context":
+			sep = "-"
+		default:
+			continue
+		}
+		text := strings.TrimSuffix(msg.Data.Lines.String(), "\n")
+		ret = append(ret, resultLine(relativePath(msg.Data.Path.String(), workspace), sep, msg.Data.LineNumber, text))
+	}
+	return ret, scanner.Err()
+}
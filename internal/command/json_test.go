@@ -0,0 +1,34 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_versionSupportsJson(t *testing.T) {
+	require.True(t, versionSupportsJson([]byte("ripgrep 13.0.0\n-SIMD -AVX (compiled)\n")))
+	require.True(t, versionSupportsJson([]byte("ripgrep 0.10.0\n")))
+	require.False(t, versionSupportsJson([]byte("ripgrep 0.9.0\n")))
+	require.False(t, versionSupportsJson([]byte("unknown\n")))
+}
+
+func Test_parseJsonOutput(t *testing.T) {
+	out := `{"type":"begin","data":{"path":{"text":"/ws/dir:1/a-2.go"}}}
+{"type":"context","data":{"path":{"text":"/ws/dir:1/a-2.go"},"lines":{"text":"before\n"},"line_number":1,"absolute_offset":0,"submatches":[]}}
+{"type":"match","data":{"path":{"text":"/ws/dir:1/a-2.go"},"lines":{"text":"x := \"flag-1\"\r\n"},"line_number":2,"absolute_offset":7,"submatches":[{"match":{"text":"flag-1"},"start":6,"end":12}]}}
+{"type":"end","data":{"path":{"text":"/ws/dir:1/a-2.go"},"binary_offset":null,"stats":{}}}
+{"type":"match","data":{"path":{"bytes":"L3dzL2L/LmdvCg=="},"lines":{"text":"flag-1"},"line_number":9,"absolute_offset":0,"submatches":[]}}
+{"type":"summary","data":{"elapsed_total":{"secs":0,"nanos":1,"human":"0s"},"stats":{}}}
+`
+	results, err := parseJsonOutput([]byte(out), "/ws")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		resultLine("dir:1/a-2.go", "-", 1, "before"),
+		resultLine("dir:1/a-2.go", ":", 2, "x := \"flag-1\"\r"),
+		resultLine("b\xff.go\n", ":", 9, "flag-1"),
+	}, results)
+
+	_, err = parseJsonOutput([]byte("not json\n"), "/ws")
+	require.Error(t, err)
+}
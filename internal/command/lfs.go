@@ -0,0 +1,109 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+const (
+	// lfsPointerMaxBytes is the size of the largest Git LFS pointer file.
+	lfsPointerMaxBytes = 1024
+	lfsPointerVersion  = "version https://git-lfs.github.com/spec/v1\n"
+)
+
+// isLfsPointer returns true if data is the contents of a Git LFS pointer file, which is checked out in place of a
+// file stored with LFS when the file hasn't been fetched.
+func isLfsPointer(data []byte) bool {
+	return len(data) < lfsPointerMaxBytes && bytes.HasPrefix(data, []byte(lfsPointerVersion)) && bytes.Contains(data, []byte("\noid sha256:"))
+}
+
+// readLfsPointer returns the contents of the file at path, relative to the workspace, if it is a Git LFS pointer
+// file. Otherwise, it returns nil.
+func (c Client) readLfsPointer(path string) []byte {
+	f, err := os.Open(filepath.Join(c.Workspace, filepath.FromSlash(path)))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(f, lfsPointerMaxBytes))
+	if err != nil || !isLfsPointer(data) {
+		return nil
+	}
+	return data
+}
+
+// smudgeLfs returns the contents of the Git LFS file at path, relative to the workspace, by running git lfs
+// smudge on its pointer, which downloads the file if it hasn't been fetched.
+func (c Client) smudgeLfs(path string, pointer []byte) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := niceCommand(context.Background(), "git", "-C", c.Workspace, "lfs", "smudge", "--", path)
+	cmd.Stdin = bytes.NewReader(pointer)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// searchLfs removes results from Git LFS pointer files, since a pointer's contents aren't the file's contents.
+// If smudge is true, pointer files matching LfsGlobs are smudged, and their contents are searched instead.
+func (c Client) searchLfs(results [][]string, flags []string, ctxLines int, smudge bool) ([][]string, error) {
+	pointers := map[string]bool{}
+	ret := make([][]string, 0, len(results))
+	for _, r := range results {
+		path := r[1]
+		isPointer, ok := pointers[path]
+		if !ok {
+			isPointer = c.readLfsPointer(path) != nil
+			pointers[path] = isPointer
+			if isPointer {
+				log.Debug.Printf("skipping Git LFS pointer file: %s", path)
+			}
+		}
+		if !isPointer {
+			ret = append(ret, r)
+		}
+	}
+	if !smudge || len(c.LfsGlobs) == 0 {
+		return ret, nil
+	}
+
+	files, err := c.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	matcher := newLiteralMatcher(flags)
+	smudged := 0
+	for _, f := range files {
+		if !isIncluded(f, c.LfsGlobs) {
+			continue
+		}
+		pointer := c.readLfsPointer(f)
+		if pointer == nil {
+			continue
+		}
+		data, err := c.smudgeLfs(f, pointer)
+		if err != nil {
+			c.FileErrors.add(f, fmt.Sprintf("could not retrieve Git LFS file: %s", err))
+			continue
+		}
+		smudged++
+		ret = append(ret, c.searchIsolated(f, func() [][]string {
+			return searchFile(f, data, matcher, ctxLines)
+		})...)
+	}
+	log.Debug.Printf("searched %d Git LFS files", smudged)
+	return mergeResults(ret), nil
+}
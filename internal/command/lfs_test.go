@@ -0,0 +1,44 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testLfsPointer = "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n"
+
+func Test_isLfsPointer(t *testing.T) {
+	require.True(t, isLfsPointer([]byte(testLfsPointer)))
+	require.False(t, isLfsPointer([]byte("version https://git-lfs.github.com/spec/v1\n")))
+	require.False(t, isLfsPointer([]byte("some-flag\n"+testLfsPointer)))
+}
+
+func Test_searchLfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("sha256\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.psd"), []byte(testLfsPointer), 0644))
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	client := Client{Workspace: dir, SearchStrategy: SearchStrategyNative}
+	results, err := client.SearchForFlags([]string{"sha256"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "sha256")}, results)
+
+	// Results from search tools are removed
+	results, err = client.searchLfs([][]string{resultLine("a.go", ":", 1, "sha256"), resultLine("b.psd", ":", 2, "oid sha256:4d7a")}, []string{"sha256"}, 0, false)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "sha256")}, results)
+
+	// Files which can't be retrieved are skipped
+	client.LfsGlobs = []string{"*.psd"}
+	results, err = client.SearchForFlags([]string{"sha256"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "sha256")}, results)
+}
@@ -0,0 +1,202 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+const (
+	// lockFileName is the name of the lock file created in the workspace's git directory while it's being scanned.
+	lockFileName = "ld-find-code-refs.lock"
+	// lockPollInterval is how often a lock held by another scan is checked while waiting for it.
+	lockPollInterval = time.Second
+)
+
+var (
+	errLocked          = errors.New("locked by another process")
+	errLockUnsupported = errors.New("file locks are not supported on this platform")
+)
+
+// Lock prevents concurrent scans of a workspace, e.g. by a git hook and a manual run. It's held on an open lock
+// file, so it's released by the operating system when the process exits, however it exits.
+type Lock struct {
+	path string
+	f    *os.File
+	mu   sync.Mutex
+}
+
+// lockInfo identifies the process holding a lock, so it can be reported to scans waiting for it.
+type lockInfo struct {
+	Pid  int       `json:"pid"`
+	Host string    `json:"host"`
+	Time time.Time `json:"time"`
+}
+
+func (l lockInfo) String() string {
+	if l.Pid == 0 {
+		return "an unknown process"
+	}
+	return fmt.Sprintf("pid %d on %s since %s", l.Pid, l.Host, l.Time.Format(time.RFC3339))
+}
+
+// lockPath returns the path of the workspace's lock file, which is in its git directory, so it's shared by every
+// worktree of the repository. It also returns the path used instead if the lock file can't be created there, e.g.
+// because the workspace is mounted read-only, or "" if there is none.
+func (c Client) lockPath() (path string, fallback string, err error) {
+	if !c.IsGit() {
+		path, fallback = c.vcsLockPath()
+		return path, fallback, nil
+	}
+	out, err := exec.Command("git", "-C", c.Workspace, "rev-parse", "--absolute-git-dir").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("could not find the git directory of %s: %s", c.Workspace, err)
+	}
+	gitDir := filepath.FromSlash(strings.TrimSpace(string(out)))
+	return filepath.Join(gitDir, lockFileName), tempLockPath(gitDir), nil
+}
+
+// tempLockPath returns the path of a lock file named after key in the temporary directory shared by every run.
+func tempLockPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(baseTempDir(), hex.EncodeToString(sum[:8])+"-"+lockFileName)
+}
+
+// LockWorkspace locks the workspace, so it can't be scanned by another process until the lock is released, or the
+// process exits. If the workspace is locked by another process, an error is returned, unless wait is true, in which
+// case LockWorkspace waits for the lock to be released. The lock is also released if the process exits because of a
+// fatal error.
+func (c Client) LockWorkspace(wait bool) (*Lock, error) {
+	path, fallback, err := c.lockPath()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+
+	waiting := false
+	for {
+		f, err := acquireFileLock(path)
+		if err == errLockUnsupported {
+			log.Debug.Printf("not locking %s: %s", c.Workspace, err)
+			return &Lock{}, nil
+		}
+		if err == nil {
+			// The process holding the lock is only recorded for messages, so errors are ignored
+			_ = writeLockInfo(f, lockInfo{Pid: os.Getpid(), Host: host, Time: time.Now().UTC()})
+			lock := &Lock{path: path, f: f}
+			log.AtExit(func() { _ = lock.Release() })
+			return lock, nil
+		}
+		if err != errLocked && fallback != "" {
+			// Scans of read-only workspaces only exclude each other, since they can't use the workspace's lock file
+			log.Debug.Printf("could not lock %s, locking %s instead: %s", path, fallback, err)
+			path, fallback = fallback, ""
+			continue
+		}
+		if err != errLocked {
+			log.Warning.Printf("could not lock %s, so concurrent scans of %s aren't prevented: %s", path, c.Workspace, err)
+			return &Lock{}, nil
+		}
+
+		holder := readLockInfo(path)
+		if !wait {
+			return nil, fmt.Errorf("another scan of %s is in progress (%s). Enable waitForLock to wait for it to finish", c.Workspace, holder)
+		}
+		if !waiting {
+			log.Info.Printf("waiting for another scan of %s to finish (%s)", c.Workspace, holder)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Release removes the lock file, and releases the lock. Releasing a lock more than once has no effect.
+func (l *Lock) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := releaseFileLock(l.path, l.f)
+	l.f = nil
+	return err
+}
+
+// acquireFileLock opens the file at path, creating it if it doesn't exist, and locks it without waiting. errLocked is
+// returned if another process holds the lock.
+func acquireFileLock(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		// The process which released the lock may have removed the file after it was opened, in which case the
+		// lock is on a file other processes can no longer open
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		pathInfo, err := os.Stat(path)
+		if err == nil && os.SameFile(info, pathInfo) {
+			return f, nil
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// releaseFileLock removes the lock file at path, and releases the lock by closing f. The file is removed while it's
+// still locked, so it can't be removed after another process locks it. Windows doesn't allow open files to be removed,
+// so it's removed after it's closed instead, which fails if another process has opened it since.
+func releaseFileLock(path string, f *os.File) error {
+	removeErr := os.Remove(path)
+	err := f.Close()
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
+// writeLockInfo records the process holding the lock on f.
+func writeLockInfo(f *os.File, info lockInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(append(data, '\n'), 0)
+	return err
+}
+
+// readLockInfo returns the process holding the lock on the file at path. If the file can't be read, e.g. because it
+// hasn't been written yet, the process is unknown.
+func readLockInfo(path string) lockInfo {
+	var info lockInfo
+	data, err := ioutil.ReadFile(path)
+	if err == nil && json.Unmarshal(data, &info) != nil {
+		info = lockInfo{}
+	}
+	return info
+}
@@ -0,0 +1,133 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+func TestLockWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+	client := Client{Workspace: dir}
+	path := filepath.Join(dir, ".git", lockFileName)
+
+	lock, err := client.LockWorkspace(false)
+	require.NoError(t, err)
+	require.FileExists(t, path)
+	_, err = client.LockWorkspace(false)
+	require.Error(t, err, "the workspace should already be locked")
+
+	// Waiting scans acquire the lock when it's released
+	acquired := make(chan error)
+	go func() {
+		waiting, err := client.LockWorkspace(true)
+		if err == nil {
+			err = waiting.Release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(lockPollInterval / 2)
+	require.NoError(t, lock.Release())
+	require.NoError(t, <-acquired)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	// Lock files left behind by processes which have exited aren't locked
+	data, err := json.Marshal(lockInfo{Pid: 1 << 30, Host: "other", Time: time.Now()})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	lock, err = client.LockWorkspace(false)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), readLockInfo(path).Pid)
+	_, err = client.LockWorkspace(false)
+	require.Contains(t, err.Error(), fmt.Sprintf("pid %d", os.Getpid()))
+
+	// Locks are released by exit hooks, and only once
+	log.RunExitHooks()
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, lock.Release())
+}
+
+func Test_readLockInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, lockFileName)
+
+	require.Equal(t, "an unknown process", readLockInfo(path).String())
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0644))
+	require.Equal(t, "an unknown process", readLockInfo(path).String())
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"pid": 1234, "host": "ci", "time": "2020-01-02T03:04:05Z"}`), 0644))
+	require.Equal(t, "pid 1234 on ci since 2020-01-02T03:04:05Z", readLockInfo(path).String())
+}
+
+func TestLockWorkspace_perforce(t *testing.T) {
+	log.Init(false)
+	defer restoreEnv("TMPDIR", "HOME", "GIT_CONFIG_NOSYSTEM", "GIT_TERMINAL_PROMPT")()
+	base, err := ioutil.TempDir("", "lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+	require.NoError(t, InitEnv(base))
+	defer RemoveRunDir()
+	defer func() { tempBase = "" }()
+
+	first := Client{Workspace: "/work/main", Vcs: VcsPerforce}
+	lock, err := first.LockWorkspace(false)
+	require.NoError(t, err)
+	defer lock.Release()
+	path, _ := first.vcsLockPath()
+	require.Equal(t, base, filepath.Dir(path))
+
+	// Another run has its own TMPDIR, but shares the lock on the workspace
+	other, err := ioutil.TempDir(base, "other")
+	require.NoError(t, err)
+	require.NoError(t, os.Setenv("TMPDIR", other))
+	second := Client{Workspace: "/work/main", Vcs: VcsPerforce}
+	_, err = second.LockWorkspace(false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "another scan of /work/main is in progress")
+}
+
+func TestLockWorkspace_readOnly(t *testing.T) {
+	log.Init(false)
+	dir, err := ioutil.TempDir("", "lock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+	// The lock file can't be created in the git directory, as on a read-only mount. Tests may run as root, which can
+	// write to read-only directories, so the lock file is replaced by a directory, which can't be opened either.
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".git", lockFileName), 0755))
+	client := Client{Workspace: dir}
+
+	// Scans of the workspace are locked in the temporary directory instead
+	lock, err := client.LockWorkspace(false)
+	require.NoError(t, err)
+	_, fallback, err := client.lockPath()
+	require.NoError(t, err)
+	require.FileExists(t, fallback)
+	_, err = client.LockWorkspace(false)
+	require.Error(t, err, "the workspace should already be locked")
+	require.NoError(t, lock.Release())
+
+	// If no lock file can be created, the workspace isn't locked
+	require.NoError(t, os.Mkdir(fallback, 0755))
+	defer os.Remove(fallback)
+	lock, err = client.LockWorkspace(false)
+	require.NoError(t, err)
+	_, err = client.LockWorkspace(false)
+	require.NoError(t, err)
+	require.NoError(t, lock.Release())
+}
@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package command
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is not supported on this platform, so files are always read into memory.
+func mmapFile(f *os.File, size int) ([]byte, func(), error) {
+	return nil, nil, errors.New("mmap is not supported")
+}
@@ -0,0 +1,272 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+const (
+	// binarySniffBytes is the number of bytes checked for NUL characters to identify binary files, which aren't searched.
+	binarySniffBytes = 8000
+	// mmapMinBytes is the size of the smallest file that is memory mapped rather than read. Mapping small files
+	// costs more than copying them.
+	mmapMinBytes = 1 << 20
+)
+
+// ListFiles returns the paths of the files searched for flag references, relative to the workspace. Search tools
+// may skip some of them, e.g. files ignored by .ignore files.
+func (c Client) ListFiles() ([]string, error) {
+	return c.listFiles()
+}
+
+// listFiles returns the paths of the files in the workspace's roots that aren't ignored by git, or are tracked if
+// TrackedOnly is true, match Pathspecs and IncludeGlobs, and are within MaxDepth and OneFileSystem, relative to the
+// workspace.
+func (c Client) listFiles() ([]string, error) {
+	if !c.IsGit() {
+		return c.walkWorkspace()
+	}
+	args := []string{"-C", c.Workspace, "ls-files", "-z", "--cached"}
+	if !c.TrackedOnly {
+		args = append(args, "--others", "--exclude-standard")
+	}
+	args = append(args, "--")
+	if len(c.Pathspecs) > 0 {
+		args = append(args, c.Pathspecs...)
+	} else {
+		// Roots are directories, rather than pathspecs
+		args = append([]string{"--literal-pathspecs"}, append(args, c.Roots...)...)
+	}
+	var stderr bytes.Buffer
+	cmd := niceCommand(context.Background(), "git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("could not list files: %s", msg)
+		}
+		return nil, err
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for _, f := range bytes.Split(out, []byte{0}) {
+		// Files with merge conflicts are listed once per stage
+		if len(f) > 0 && !seen[string(f)] && inRoots(string(f), c.Roots) && isIncluded(string(f), c.IncludeGlobs) {
+			seen[string(f)] = true
+			files = append(files, string(f))
+		}
+	}
+	return c.walkFiles(files), nil
+}
+
+// searchNative searches the files in the workspace for flags without an external search tool. Results are in the
+// same form as those parsed from search tool output.
+func (c Client) searchNative(flags []string, ctxLines int) ([][]string, error) {
+	files, err := c.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	return c.searchFiles(files, flags, ctxLines)
+}
+
+// searchFiles searches files, relative to the workspace, for flags without an external search tool. If Deadline
+// passes, the remaining files are skipped, and the results found are returned with a BudgetExceededError.
+func (c Client) searchFiles(files []string, flags []string, ctxLines int) ([][]string, error) {
+	return c.searchFilesWith(files, newLiteralMatcher(flags), ctxLines)
+}
+
+// searchFilesWith is searchFiles, finding lines with matcher.
+func (c Client) searchFilesWith(files []string, matcher literalMatcher, ctxLines int) ([][]string, error) {
+	var skipped int32
+
+	// Files are searched concurrently, but results are kept in the order files were listed
+	fileResults := make([][][]string, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := c.MaxConcurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if c.pastDeadline() {
+					atomic.AddInt32(&skipped, 1)
+					continue
+				}
+				fileResults[i] = c.searchIsolated(files[i], func() [][]string {
+					results, err := searchPath(c.Workspace, files[i], matcher, ctxLines)
+					if err != nil {
+						c.FileErrors.add(files[i], err.Error())
+					}
+					return results
+				})
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	results := [][]string{}
+	for _, r := range fileResults {
+		results = append(results, r...)
+	}
+	if skipped > 0 {
+		return mergeResults(results), &BudgetExceededError{Reason: fmt.Sprintf("the time limit passed after searching %d of %d files", len(files)-int(skipped), len(files))}
+	}
+	return mergeResults(results), nil
+}
+
+// searchPath searches a file in the workspace. Large files are memory mapped, so their contents aren't copied. An
+// error is returned if the file exists, but can't be read.
+func searchPath(workspace, path string, matcher literalMatcher, ctxLines int) ([][]string, error) {
+	f, err := os.Open(filepath.Join(workspace, path))
+	if os.IsNotExist(err) {
+		// Files may be deleted during the search, or be broken symlinks
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	if info.Size() >= mmapMinBytes && int64(int(info.Size())) == info.Size() {
+		data, unmap, err := mmapFile(f, int(info.Size()))
+		if err == nil {
+			mapped, ok := searchMapped(path, data, matcher, ctxLines)
+			unmap()
+			if ok {
+				return mapped, nil
+			}
+			log.Debug.Printf("%s changed while it was being searched, reading it again", path)
+		}
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return searchFile(path, data, matcher, ctxLines), nil
+}
+
+// searchMapped searches a memory mapped file. Accessing a mapping after its file is truncated causes a fault, so
+// faults are recovered from and reported by returning false.
+func searchMapped(path string, data []byte, matcher literalMatcher, ctxLines int) (results [][]string, ok bool) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			results, ok = nil, false
+		}
+	}()
+	return searchFile(path, data, matcher, ctxLines), true
+}
+
+// searchFile returns the lines of a file which contain flag references, and ctxLines lines of context around them.
+// Lines are only copied from data when they are included in the results, unless the file must be transcoded.
+func searchFile(path string, data []byte, matcher literalMatcher, ctxLines int) [][]string {
+	// UTF-16 files contain NUL characters, so they must be decoded before checking for binary files
+	data, encoding := decodeText(data)
+	if encoding != "" {
+		log.Debug.Printf("%s is encoded as %s, searching it as UTF-8", path, encoding)
+	}
+	sniff := data
+	if len(sniff) > binarySniffBytes {
+		sniff = sniff[:binarySniffBytes]
+	}
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return nil
+	}
+	if isLfsPointer(data) {
+		return nil
+	}
+
+	ret := [][]string{}
+	// before holds up to ctxLines lines preceding the current line, which haven't been included in the results
+	before := [][]byte{}
+	// after is the number of lines following the last match to include as context
+	after := 0
+	lineNum := 0
+	for len(data) > 0 {
+		var line []byte
+		// A trailing newline doesn't start another line
+		if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+			line, data = data[:idx], data[idx+1:]
+		} else {
+			line, data = data, nil
+		}
+		lineNum++
+		// Lines ending with CRLF are matched and reported without the carriage return
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+
+		switch {
+		case matcher.match(line):
+			for i, b := range before {
+				ret = append(ret, resultLine(path, "-", lineNum-len(before)+i, string(b)))
+			}
+			before = before[:0]
+			ret = append(ret, resultLine(path, ":", lineNum, string(line)))
+			after = ctxLines
+		case after > 0:
+			ret = append(ret, resultLine(path, "-", lineNum, string(line)))
+			after--
+		case ctxLines > 0:
+			if len(before) == ctxLines {
+				copy(before, before[1:])
+				before = before[:ctxLines-1]
+			}
+			before = append(before, line)
+		}
+	}
+	return ret
+}
+
+func resultLine(path, sep string, lineNum int, text string) []string {
+	n := strconv.Itoa(lineNum)
+	return []string{path + sep + n + sep + text, path, sep, n, text}
+}
+
+// literalMatcher finds flag keys in lines of text.
+type literalMatcher interface {
+	// match returns true if the line contains any flag key delimited by word boundaries.
+	match(line []byte) bool
+}
+
+// newLiteralMatcher returns a matcher which finds all flag keys in a single pass over each line.
+func newLiteralMatcher(flags []string) literalMatcher {
+	return newAhoCorasick(flags)
+}
+
+// isWordBounded returns true if line[start:end] is delimited by word boundaries, with the same semantics as \b in
+// the regular expressions passed to search tools.
+func isWordBounded(line []byte, start, end int) bool {
+	before := start > 0 && isWordByte(line[start-1])
+	after := end < len(line) && isWordByte(line[end])
+	return before != isWordByte(line[start]) && after != isWordByte(line[end-1])
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
@@ -0,0 +1,376 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+const (
+	// pluginMaxOutputBytes limits the output read from a matcher plugin for a single file.
+	pluginMaxOutputBytes = 16 << 20
+	// pluginFlagKeysField is the index of the flag keys a plugin attributed a result line to. Results from
+	// search tools don't have this field.
+	pluginFlagKeysField = 5
+	// pluginWaitDelay is the time to wait for a plugin's output to close after it exits or is killed.
+	pluginWaitDelay = time.Second
+)
+
+// Plugin is an external matcher, which searches files matching its globs for flag references instead of the search
+// strategy, e.g. to find references in a proprietary DSL.
+//
+// For each file, Command is run in the workspace with a minimal environment. It receives a pluginRequest as JSON on
+// stdin, and must write a pluginResponse as JSON to stdout, and exit with status 0. If Command is a WASM module,
+// it is run by a WASI runtime instead, without access to the file system, and receives the file's contents.
+type Plugin struct {
+	// Globs are patterns, in the form returned by IncludeGlobs, matching the files searched by the plugin.
+	Globs []string
+	// Command is the path to the plugin executable, or a WASM module. Relative paths are relative to the workspace.
+	Command string
+}
+
+// pluginRequest is sent to a plugin for each file it searches.
+type pluginRequest struct {
+	// Path is relative to the workspace, with forward slashes.
+	Path  string   `json:"path"`
+	Flags []string `json:"flags"`
+	// Content is the file's contents, decoded as UTF-8. It is only sent to WASM modules, which can't read files.
+	Content *string `json:"content,omitempty"`
+}
+
+// pluginResponse is the flag references a plugin found in a file.
+type pluginResponse struct {
+	Matches []pluginMatch `json:"matches"`
+}
+
+// pluginMatch is a reference to a flag on a 1-based line.
+type pluginMatch struct {
+	Line    int    `json:"line"`
+	FlagKey string `json:"flagKey"`
+}
+
+// ParsePlugins parses matcherPlugin options, in the form patterns=command, where patterns is a comma separated list
+// of file extensions or glob patterns, in the same form as includeExtensions.
+func ParsePlugins(values []string) ([]Plugin, error) {
+	plugins := []Plugin{}
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("matcher plugin %q must be in the form patterns=command", v)
+		}
+		globs, err := IncludeGlobs(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("matcher plugin %q: %s", v, err)
+		}
+		if len(globs) == 0 {
+			return nil, fmt.Errorf("matcher plugin %q must match at least one file pattern", v)
+		}
+		plugins = append(plugins, Plugin{Globs: globs, Command: strings.TrimSpace(parts[1])})
+	}
+	return plugins, nil
+}
+
+// PluginFlagKeys returns the flag keys a matcher plugin attributed a result line to. Lines matched by a search
+// strategy are attributed to the flag keys they contain, so nil is returned.
+func PluginFlagKeys(result []string) []string {
+	if len(result) <= pluginFlagKeysField || result[pluginFlagKeysField] == "" {
+		return nil
+	}
+	return strings.Split(result[pluginFlagKeysField], ",")
+}
+
+// pluginFor returns the first plugin with a glob matching the path, if any.
+func (c Client) pluginFor(path string) (Plugin, bool) {
+	for _, p := range c.Plugins {
+		if isIncluded(path, p.Globs) {
+			return p, true
+		}
+	}
+	return Plugin{}, false
+}
+
+// searchPlugins replaces results from files searched by matcher plugins with the plugins' results. If run is false,
+// results from those files are removed, but plugins aren't run. A plugin which fails for a file is recorded in
+// FileErrors, and the file's references are skipped, so one broken plugin doesn't fail the scan.
+func (c Client) searchPlugins(results [][]string, flags []string, ctxLines int, run bool) ([][]string, error) {
+	if len(c.Plugins) == 0 {
+		return results, nil
+	}
+	ret := make([][]string, 0, len(results))
+	for _, r := range results {
+		if _, ok := c.pluginFor(r[1]); !ok {
+			ret = append(ret, r)
+		}
+	}
+	if !run {
+		return ret, nil
+	}
+
+	for _, p := range c.Plugins {
+		if err := checkWasmRuntime(p); err != nil {
+			return nil, err
+		}
+	}
+	files, err := c.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	pluginFiles := []string{}
+	for _, f := range files {
+		if _, ok := c.pluginFor(f); ok {
+			pluginFiles = append(pluginFiles, f)
+		}
+	}
+
+	// Files are searched concurrently, but results are kept in the order files were listed
+	fileResults := make([][][]string, len(pluginFiles))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := c.MaxConcurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				f := pluginFiles[i]
+				plugin, _ := c.pluginFor(f)
+				fileResults[i] = c.searchIsolated(f, func() [][]string {
+					matches, err := c.runPlugin(plugin, f, flags)
+					if err != nil {
+						c.FileErrors.add(f, fmt.Sprintf("matcher plugin %s failed: %s", plugin.Command, err))
+						return nil
+					}
+					return c.pluginResults(f, matches, flags, ctxLines)
+				})
+			}
+		}()
+	}
+	for i := range pluginFiles {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, r := range fileResults {
+		ret = append(ret, r...)
+	}
+	log.Debug.Printf("searched %d files with matcher plugins", len(pluginFiles))
+	return mergeResults(ret), nil
+}
+
+// runPlugin runs a plugin for the file at path, relative to the workspace, and returns its matches. The plugin is
+// killed if it runs longer than PluginTimeout, or writes more than pluginMaxOutputBytes. Only PATH, and the
+// variables Windows requires to start processes, are passed to it, so access tokens in the environment aren't
+// exposed.
+func (c Client) runPlugin(plugin Plugin, path string, flags []string) ([]pluginMatch, error) {
+	ctx := context.Background()
+	if c.PluginTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.PluginTimeout)
+		defer cancel()
+	}
+	request := pluginRequest{Path: path, Flags: flags}
+	if plugin.isWasm() {
+		content, err := c.readText(path)
+		if err != nil {
+			return nil, err
+		}
+		request.Content = &content
+	}
+	req, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	out := &limitedBuffer{buf: &stdout, limit: pluginMaxOutputBytes}
+	cmd := c.pluginCommand(ctx, plugin)
+	cmd.Dir = c.Workspace
+	cmd.Env = pluginEnv()
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = out
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: pluginMaxOutputBytes}
+	err = runWithWaitDelay(cmd, pluginWaitDelay)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("timed out after %s", c.PluginTimeout)
+	case out.exceeded:
+		return nil, fmt.Errorf("output exceeded %d bytes", pluginMaxOutputBytes)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	var res pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("invalid output: %s", err)
+	}
+	return res.Matches, nil
+}
+
+// runWithWaitDelay runs cmd like cmd.Run, but only reads its output for up to delay after it exits or is killed.
+// Processes started by cmd may keep its output open after it exits, which would otherwise block until they exit too.
+func runWithWaitDelay(cmd *exec.Cmd, delay time.Duration) error {
+	var readers, writers []*os.File
+	defer func() {
+		for _, f := range append(readers, writers...) {
+			f.Close()
+		}
+	}()
+	done := make(chan struct{}, 2)
+	for _, output := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		if *output == nil {
+			continue
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		readers = append(readers, r)
+		writers = append(writers, w)
+		go func(dst io.Writer) {
+			_, _ = io.Copy(dst, r)
+			done <- struct{}{}
+		}(*output)
+		*output = w
+	}
+
+	err := cmd.Start()
+	// The process has its own copies of the pipes' write ends
+	for _, w := range writers {
+		w.Close()
+	}
+	writers = nil
+	if err != nil {
+		return err
+	}
+	err = cmd.Wait()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for remaining := len(readers); remaining > 0; {
+		select {
+		case <-done:
+			remaining--
+		case <-timer.C:
+			// Closing the read ends stops the copies still waiting for output
+			for _, r := range readers {
+				r.Close()
+			}
+		}
+	}
+	return err
+}
+
+// pluginCommand returns the command which runs plugin.
+func (c Client) pluginCommand(ctx context.Context, plugin Plugin) *exec.Cmd {
+	if plugin.isWasm() {
+		return niceCommand(ctx, wasmRuntime, c.wasmArgs(plugin)...)
+	}
+	return niceCommand(ctx, plugin.Command)
+}
+
+// readText returns the contents of the file at path, relative to the workspace, decoded as UTF-8.
+func (c Client) readText(path string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.Workspace, filepath.FromSlash(path)))
+	if err != nil {
+		return "", err
+	}
+	data, _ = decodeText(data)
+	return string(data), nil
+}
+
+// pluginEnv returns the environment matcher plugins are run with.
+func pluginEnv() []string {
+	env := []string{}
+	for _, name := range []string{"PATH", "SYSTEMROOT", "TEMP", "TMP", "TMPDIR"} {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+// pluginResults converts a plugin's matches in the file at path to results, with ctxLines lines of context. Matches
+// of unknown flag keys, or lines outside of the file, are ignored.
+func (c Client) pluginResults(path string, matches []pluginMatch, flags []string, ctxLines int) [][]string {
+	if len(matches) == 0 {
+		return nil
+	}
+	text, err := c.readText(path)
+	if err != nil {
+		log.Warning.Printf("could not read %s: %s", path, err)
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+
+	known := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		known[f] = true
+	}
+	lineFlags := map[int][]string{}
+	for _, m := range matches {
+		if !known[m.FlagKey] || m.Line < 1 || m.Line > len(lines) {
+			log.Debug.Printf("ignoring invalid match of %q on line %d of %s", m.FlagKey, m.Line, path)
+			continue
+		}
+		lineFlags[m.Line] = append(lineFlags[m.Line], m.FlagKey)
+	}
+
+	ret := [][]string{}
+	for lineNum, keys := range lineFlags {
+		sort.Strings(keys)
+		keys = dedupeSorted(keys)
+		for n := lineNum - ctxLines; n <= lineNum+ctxLines; n++ {
+			if n < 1 || n > len(lines) || n == lineNum {
+				continue
+			}
+			ret = append(ret, resultLine(path, "-", n, strings.TrimSuffix(lines[n-1], "\r")))
+		}
+		ret = append(ret, append(resultLine(path, ":", lineNum, strings.TrimSuffix(lines[lineNum-1], "\r")), strings.Join(keys, ",")))
+	}
+	return ret
+}
+
+func dedupeSorted(s []string) []string {
+	ret := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// limitedBuffer writes to buf until limit bytes have been written, and then fails, so a misbehaving process is
+// stopped instead of exhausting memory.
+type limitedBuffer struct {
+	buf      *bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.limit {
+		b.exceeded = true
+		return 0, errors.New("output limit exceeded")
+	}
+	return b.buf.Write(p)
+}
@@ -0,0 +1,100 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlugins(t *testing.T) {
+	plugins, err := ParsePlugins([]string{"dsl,templates/*.tmpl=./bin/matcher", "rules=/usr/bin/env=x"})
+	require.NoError(t, err)
+	require.Equal(t, []Plugin{
+		{Globs: []string{"*.dsl", "templates/*.tmpl"}, Command: "./bin/matcher"},
+		{Globs: []string{"*.rules"}, Command: "/usr/bin/env=x"},
+	}, plugins)
+
+	for _, v := range []string{"./bin/matcher", "dsl=", "=./bin/matcher", "[=./bin/matcher"} {
+		_, err := ParsePlugins([]string{v})
+		require.Error(t, err, v)
+	}
+}
+
+func Test_searchPlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin scripts require a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "plugin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("my-flag\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.dsl"), []byte("header\nFLAG(MY_FLAG) my-flag\nfooter\nmy-flag\n"), 0644))
+	// Plugins are kept outside of the workspace, so they aren't searched
+	bin, err := ioutil.TempDir("", "plugin-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(bin)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "matcher"), []byte(`#!/bin/sh
+cat > /dev/null
+echo '{"matches": [{"line": 2, "flagKey": "my-flag"}, {"line": 2, "flagKey": "other-flag"}, {"line": 9, "flagKey": "my-flag"}, {"line": 1, "flagKey": "unknown-flag"}]}'
+`), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "slow"), []byte("#!/bin/sh\nsleep 5\n"), 0755))
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	flags := []string{"my-flag", "other-flag"}
+	client := Client{Workspace: dir, SearchStrategy: SearchStrategyNative, Plugins: []Plugin{{Globs: []string{"*.dsl"}, Command: filepath.Join(bin, "matcher")}}}
+	results, err := client.SearchForFlags(flags, 1)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		resultLine("a.go", ":", 1, "my-flag"),
+		resultLine("b.dsl", "-", 1, "header"),
+		append(resultLine("b.dsl", ":", 2, "FLAG(MY_FLAG) my-flag"), "my-flag,other-flag"),
+		resultLine("b.dsl", "-", 3, "footer"),
+	}, results)
+	require.Nil(t, PluginFlagKeys(results[0]))
+	require.Equal(t, []string{"my-flag", "other-flag"}, PluginFlagKeys(results[2]))
+
+	// Files are skipped if their plugin fails
+	client.Plugins = []Plugin{{Globs: []string{"*.dsl"}, Command: filepath.Join(bin, "slow")}}
+	client.PluginTimeout = 100 * time.Millisecond
+	results, err = client.SearchForFlags(flags, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "my-flag")}, results)
+
+	_, err = client.runPlugin(client.Plugins[0], "b.dsl", flags)
+	require.EqualError(t, err, "timed out after 100ms")
+}
+
+func Test_runWithWaitDelay(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	// The background process keeps stdout open after the shell exits
+	var stdout bytes.Buffer
+	cmd := exec.Command("sh", "-c", "echo out; sleep 5 &")
+	cmd.Stdout = &stdout
+	start := time.Now()
+	require.NoError(t, runWithWaitDelay(cmd, 100*time.Millisecond))
+	require.True(t, time.Since(start) < 5*time.Second)
+	require.Equal(t, "out\n", stdout.String())
+
+	cmd = exec.Command("sh", "-c", "echo err >&2; exit 3")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	require.EqualError(t, runWithWaitDelay(cmd, time.Second), "exit status 3")
+	require.Equal(t, "err\n", stderr.String())
+}
+
+func Test_pluginEnv(t *testing.T) {
+	os.Setenv("LD_ACCESS_TOKEN", "secret")
+	defer os.Unsetenv("LD_ACCESS_TOKEN")
+	for _, v := range pluginEnv() {
+		require.NotContains(t, v, "secret")
+	}
+}
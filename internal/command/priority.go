@@ -0,0 +1,22 @@
+package command
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+)
+
+// niceness is the niceness of processes started by the scanner, set by SetNiceness. 0 leaves their priority
+// unchanged.
+var niceness int
+
+// niceCommand returns a command which runs name with args. If a niceness has been set, name is run by nice, so the
+// priority of the process is lowered however it's started: on Linux, setpriority only applies to the calling thread,
+// which may not be the thread that starts the process.
+func niceCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if niceness > 0 {
+		args = append([]string{"-n", strconv.Itoa(niceness), name}, args...)
+		name = "nice"
+	}
+	return exec.CommandContext(ctx, name, args...)
+}
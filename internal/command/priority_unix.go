@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"os/exec"
+)
+
+// SetNiceness lowers the scheduling priority of processes started by the scanner, such as git and the search tool.
+func SetNiceness(n int) error {
+	if _, err := exec.LookPath("nice"); err != nil {
+		return err
+	}
+	niceness = n
+	return nil
+}
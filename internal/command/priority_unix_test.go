@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetNiceness(t *testing.T) {
+	defer func() { niceness = 0 }()
+	out, err := niceCommand(context.Background(), "nice").Output()
+	require.NoError(t, err)
+	base := strings.TrimSpace(string(out))
+
+	require.NoError(t, SetNiceness(5))
+	// nice prints the niceness it's run with
+	out, err = niceCommand(context.Background(), "nice").Output()
+	require.NoError(t, err)
+	require.NotEqual(t, base, strings.TrimSpace(string(out)))
+	require.Equal(t, []string{"nice", "-n", "5", "git", "status"}, niceCommand(context.Background(), "git", "status").Args)
+}
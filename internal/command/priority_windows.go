@@ -0,0 +1,10 @@
+package command
+
+import (
+	"errors"
+)
+
+// SetNiceness is not supported on Windows.
+func SetNiceness(niceness int) error {
+	return errors.New("niceness is not supported on Windows")
+}
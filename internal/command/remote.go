@@ -0,0 +1,141 @@
+package command
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	originRemote   = "origin"
+	upstreamRemote = "upstream"
+)
+
+var scpLikeRemote = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// RemoteRepo is the hosted repository a git remote points at.
+type RemoteRepo struct {
+	// Remote is the name of the git remote, e.g. origin.
+	Remote string
+	Host   string
+	// Path is the path of the repository on its host, e.g. launchdarkly/ld-find-code-refs.
+	Path string
+}
+
+// ParseRemote parses a git remote url, in either url or scp-like form, e.g. git@github.com:org/repo.git. It returns
+// false for remotes which aren't hosted, such as local paths.
+func ParseRemote(remote, remoteUrl string) (RemoteRepo, bool) {
+	remoteUrl = strings.TrimSpace(remoteUrl)
+	var host, repoPath string
+	if u, err := url.Parse(remoteUrl); err == nil && u.Host != "" {
+		host, repoPath = u.Hostname(), u.Path
+	} else if m := scpLikeRemote.FindStringSubmatch(remoteUrl); m != nil {
+		host, repoPath = m[1], m[2]
+	} else {
+		return RemoteRepo{}, false
+	}
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	if repoPath == "" {
+		return RemoteRepo{}, false
+	}
+	return RemoteRepo{Remote: remote, Host: host, Path: repoPath}, true
+}
+
+// Name returns the name of the repository, without its owner.
+func (r RemoteRepo) Name() string {
+	return path.Base(r.Path)
+}
+
+// Url returns the browsable url of the repository.
+func (r RemoteRepo) Url() string {
+	return "https://" + r.Host + "/" + r.Path
+}
+
+// Type returns the repoType of the repository's host.
+func (r RemoteRepo) Type() string {
+	switch r.Host {
+	case "github.com":
+		return "github"
+	case "bitbucket.org":
+		return "bitbucket"
+	}
+	return "custom"
+}
+
+// sameRepo returns true if both remotes point at the same repository, regardless of protocol or case.
+func (r RemoteRepo) sameRepo(other RemoteRepo) bool {
+	return strings.EqualFold(r.Host, other.Host) && strings.EqualFold(r.Path, other.Path)
+}
+
+// Remotes returns the url of each remote of the repository at dir, keyed by remote name.
+func Remotes(dir string) (map[string]string, error) {
+	out, err := exec.Command("git", "-C", dir, "config", "--get-regexp", `^remote\..*\.url$`).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.Sys().(syscall.WaitStatus).ExitStatus() == 1 {
+		// No remotes are configured
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	remotes := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(parts[0], "remote."), ".url")
+		remotes[name] = parts[1]
+	}
+	return remotes, nil
+}
+
+// CanonicalRemote chooses the remote identifying the repository. If remote is provided, that remote is used.
+// Otherwise, origin is used, unless there is also an upstream remote pointing at a different repository, in which
+// case origin is assumed to be a fork, and upstream is used. A repository with a single remote uses it, whatever its
+// name.
+func CanonicalRemote(remotes map[string]string, remote string) (RemoteRepo, error) {
+	parse := func(name string) (RemoteRepo, error) {
+		r, ok := ParseRemote(name, remotes[name])
+		if !ok {
+			return r, fmt.Errorf("remote %s (%s) is not a hosted repository", name, remotes[name])
+		}
+		return r, nil
+	}
+	if remote != "" {
+		if _, ok := remotes[remote]; !ok {
+			return RemoteRepo{}, fmt.Errorf("remote %s not found", remote)
+		}
+		return parse(remote)
+	}
+
+	if _, ok := remotes[originRemote]; ok {
+		origin, err := parse(originRemote)
+		if _, hasUpstream := remotes[upstreamRemote]; !hasUpstream {
+			return origin, err
+		}
+		upstream, upstreamErr := parse(upstreamRemote)
+		if upstreamErr != nil || (err == nil && origin.sameRepo(upstream)) {
+			return origin, err
+		}
+		return upstream, nil
+	}
+	if len(remotes) == 1 {
+		for name := range remotes {
+			return parse(name)
+		}
+	}
+	if len(remotes) == 0 {
+		return RemoteRepo{}, fmt.Errorf("the repository has no remotes")
+	}
+	names := make([]string, 0, len(remotes))
+	for name := range remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return RemoteRepo{}, fmt.Errorf("the repository has no origin remote, and several other remotes: %s", strings.Join(names, ", "))
+}
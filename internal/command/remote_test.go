@@ -0,0 +1,74 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRemote(t *testing.T) {
+	r, ok := ParseRemote("origin", "git@github.com:launchdarkly/ld-find-code-refs.git")
+	require.True(t, ok)
+	require.Equal(t, RemoteRepo{Remote: "origin", Host: "github.com", Path: "launchdarkly/ld-find-code-refs"}, r)
+	require.Equal(t, "ld-find-code-refs", r.Name())
+	require.Equal(t, "https://github.com/launchdarkly/ld-find-code-refs", r.Url())
+	require.Equal(t, "github", r.Type())
+
+	_, ok = ParseRemote("origin", "/local/path/repo.git")
+	require.False(t, ok)
+}
+
+func TestCanonicalRemote(t *testing.T) {
+	const (
+		canonical = "https://github.com/launchdarkly/ld-find-code-refs.git"
+		fork      = "git@github.com:someone/ld-find-code-refs-fork.git"
+	)
+	tests := []struct {
+		name     string
+		remotes  map[string]string
+		remote   string
+		expected string
+		err      string
+	}{
+		{"origin", map[string]string{"origin": canonical}, "", "launchdarkly/ld-find-code-refs", ""},
+		{"fork of upstream", map[string]string{"origin": fork, "upstream": canonical}, "", "launchdarkly/ld-find-code-refs", ""},
+		{"upstream is origin", map[string]string{"origin": "git@github.com:launchdarkly/ld-find-code-refs.git", "upstream": canonical}, "", "launchdarkly/ld-find-code-refs", ""},
+		{"chosen remote", map[string]string{"origin": fork, "upstream": canonical}, "origin", "someone/ld-find-code-refs-fork", ""},
+		{"single remote", map[string]string{"github": canonical}, "", "launchdarkly/ld-find-code-refs", ""},
+		{"missing remote", map[string]string{"origin": canonical}, "upstream", "", "remote upstream not found"},
+		{"no remotes", map[string]string{}, "", "", "the repository has no remotes"},
+		{"ambiguous", map[string]string{"a": canonical, "b": fork}, "", "", "the repository has no origin remote, and several other remotes: a, b"},
+		{"local", map[string]string{"origin": "/srv/repo.git"}, "", "", "remote origin (/srv/repo.git) is not a hosted repository"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := CanonicalRemote(tt.remotes, tt.remote)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, r.Path)
+		})
+	}
+}
+
+func TestRemotes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotes")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	remotes, err := Remotes(dir)
+	require.NoError(t, err)
+	require.Empty(t, remotes)
+
+	require.NoError(t, exec.Command("git", "-C", dir, "remote", "add", "origin", "git@github.com:someone/repo.git").Run())
+	require.NoError(t, exec.Command("git", "-C", dir, "remote", "add", "upstream", "https://github.com/org/repo.git").Run())
+	remotes, err = Remotes(dir)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"origin": "git@github.com:someone/repo.git", "upstream": "https://github.com/org/repo.git"}, remotes)
+}
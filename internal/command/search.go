@@ -0,0 +1,464 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// Strategies used to search for flag references
+const (
+	// SearchStrategyAuto chooses a strategy with PlanSearch
+	SearchStrategyAuto = "auto"
+	// SearchStrategyCombined searches for all flags with a single regular expression alternation
+	SearchStrategyCombined = "combined"
+	// SearchStrategyChunked searches for flags with several smaller alternations, one at a time
+	SearchStrategyChunked = "chunked"
+	// SearchStrategyNative searches for flags with the built in literal matcher, without a search tool
+	SearchStrategyNative = "native"
+)
+
+const (
+	// maxPatternBytes is the largest alternation passed to a search tool. Very large patterns are slow to
+	// compile, and may exceed the argument length limits of some systems.
+	maxPatternBytes = 32 * 1024
+	// maxCombinedFlags is the largest number of flags searched for with a single alternation. Backtracking regex
+	// engines slow down with the number of alternatives, even if the pattern is small.
+	maxCombinedFlags = 500
+	// maxChunkedSearchFiles is the largest number of files searched once per chunk. Larger repositories are
+	// searched in a single pass with the native matcher.
+	maxChunkedSearchFiles = 20000
+	// maxChunks is the largest number of chunks searched before the native matcher is preferred.
+	maxChunks = 8
+)
+
+// SearchPlan describes the inputs to the search planner, and the strategy it chose.
+type SearchPlan struct {
+	Strategy     string
+	FlagCount    int
+	PatternBytes int
+	FileCount    int
+	Chunks       int
+}
+
+// PlanSearch chooses a search strategy for the flags being searched for and the number of files in the repository.
+// If a search tool is not available, only the native strategy may be used.
+func PlanSearch(flags []string, fileCount int, toolAvailable bool) SearchPlan {
+	plan := SearchPlan{FlagCount: len(flags), PatternBytes: len(FlagPattern(flags)), FileCount: fileCount}
+	plan.Chunks = len(chunkFlags(flags, maxPatternBytes))
+	switch {
+	case !toolAvailable:
+		plan.Strategy = SearchStrategyNative
+	case plan.PatternBytes <= maxPatternBytes && plan.FlagCount <= maxCombinedFlags:
+		plan.Strategy = SearchStrategyCombined
+	case plan.Chunks <= maxChunks && fileCount <= maxChunkedSearchFiles:
+		plan.Strategy = SearchStrategyChunked
+	default:
+		plan.Strategy = SearchStrategyNative
+	}
+	return plan
+}
+
+// FlagPattern returns the regular expression passed to search tools, matching any of the flags delimited by word
+// boundaries.
+func FlagPattern(flags []string) string {
+	flagRegexes := make([]string, 0, len(flags))
+	for _, v := range flags {
+		flagRegexes = append(flagRegexes, "\\b"+regexp.QuoteMeta(v)+"\\b")
+	}
+	return strings.Join(flagRegexes, "|")
+}
+
+// chunkFlags splits flags into groups whose patterns are no larger than maxBytes, or contain a single flag.
+func chunkFlags(flags []string, maxBytes int) [][]string {
+	chunks := [][]string{}
+	chunk := []string{}
+	chunkBytes := 0
+	for _, flag := range flags {
+		flagBytes := len(FlagPattern([]string{flag})) + 1
+		if len(chunk) > 0 && (chunkBytes+flagBytes > maxBytes || len(chunk) >= maxCombinedFlags) {
+			chunks = append(chunks, chunk)
+			chunk = []string{}
+			chunkBytes = 0
+		}
+		chunk = append(chunk, flag)
+		chunkBytes += flagBytes
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// BudgetExceededError is returned by SearchForFlags, along with the results found, when the search was stopped
+// early because there were more than MaxFiles files, or Deadline passed.
+type BudgetExceededError struct {
+	Reason string
+}
+
+func (e *BudgetExceededError) Error() string {
+	return "scan budget exceeded: " + e.Reason
+}
+
+// filesListed returns true if the files searched must be listed with git, since search tools can't select them.
+func (c Client) filesListed() bool {
+	return len(c.Pathspecs) > 0 || c.TrackedOnly
+}
+
+// pastDeadline returns true if the client has a Deadline, and it has passed.
+func (c Client) pastDeadline() bool {
+	return !c.Deadline.IsZero() && !time.Now().Before(c.Deadline)
+}
+
+// SearchForFlags returns the lines in the workspace containing references to flags, and ctxLines lines of context
+// around them. Each result contains the full line, path, separator (: for matches, - for context), line number, and
+// line text, sorted by path and line number. If the search exceeds MaxFiles or Deadline, the results found are
+// returned with a BudgetExceededError. Git LFS pointer files aren't searched, unless they match LfsGlobs, in which
+// case the files they point to are searched.
+func (c Client) SearchForFlags(flags []string, ctxLines int) ([][]string, error) {
+	_, results, err := c.searchForFlags(flags, ctxLines)
+	return results, err
+}
+
+// SearchForFlagsWithMethod is SearchForFlags, but also returns the SearchMethod used, without listing the files in the
+// workspace again to choose it.
+func (c Client) SearchForFlagsWithMethod(flags []string, ctxLines int) (string, [][]string, error) {
+	strategy, results, err := c.searchForFlags(flags, ctxLines)
+	if strategy == "" {
+		return "", nil, err
+	}
+	return c.searchMethod(strategy, flags), results, err
+}
+
+// searchForFlags implements SearchForFlags, and also returns the strategy used, unless it couldn't be chosen.
+func (c Client) searchForFlags(flags []string, ctxLines int) (string, [][]string, error) {
+	if c.FlagMatcher != nil {
+		results, err := c.searchMatcher(ctxLines)
+		return SearchStrategyNative, results, err
+	}
+	strategy, files, err := c.chooseStrategy(flags)
+	if err != nil {
+		return "", nil, err
+	}
+	results, err := c.search(strategy, files, flags, ctxLines)
+	_, partial := err.(*BudgetExceededError)
+	if err != nil && !partial {
+		return strategy, nil, err
+	}
+	// LFS files aren't retrieved once the budget is exceeded
+	results, lfsErr := c.searchLfs(results, flags, ctxLines, !partial)
+	if lfsErr != nil {
+		return strategy, nil, lfsErr
+	}
+	// Nor are matcher plugins run
+	results, pluginErr := c.searchPlugins(results, flags, ctxLines, !partial)
+	if pluginErr != nil {
+		return strategy, nil, pluginErr
+	}
+	return strategy, results, err
+}
+
+// search searches files for flags with strategy, as chosen by chooseStrategy.
+func (c Client) search(strategy string, files []string, flags []string, ctxLines int) ([][]string, error) {
+	if c.MaxFiles > 0 && len(files) > c.MaxFiles {
+		// Search tools can't be limited to a list of files without exceeding argument length limits
+		log.Debug.Printf("found %d files, searching the first %d with the native search strategy", len(files), c.MaxFiles)
+		results, err := c.searchFiles(files[:c.MaxFiles], flags, ctxLines)
+		if err != nil {
+			return results, err
+		}
+		return results, &BudgetExceededError{Reason: fmt.Sprintf("found %d files, only the first %d were searched", len(files), c.MaxFiles)}
+	}
+	if c.filesListed() {
+		// Search tools can't be limited to a list of files without exceeding argument length limits
+		log.Debug.Printf("searching %d listed files with the native search strategy", len(files))
+		return c.searchFiles(files, flags, ctxLines)
+	}
+
+	switch strategy {
+	case SearchStrategyNative:
+		if files != nil {
+			return c.searchFiles(files, flags, ctxLines)
+		}
+		return c.searchNative(flags, ctxLines)
+	case SearchStrategyChunked:
+		chunks := chunkFlags(flags, maxPatternBytes)
+		results := [][]string{}
+		for i, chunk := range chunks {
+			if c.pastDeadline() {
+				return mergeResults(results), &BudgetExceededError{Reason: fmt.Sprintf("the time limit passed after searching for %d of %d chunks of flags", i, len(chunks))}
+			}
+			log.Debug.Printf("searching for %d flags in chunk %d of %d", len(chunk), i+1, len(chunks))
+			chunkResults, err := c.searchWithTool(chunk, ctxLines)
+			if _, ok := err.(*BudgetExceededError); ok {
+				return mergeResults(append(results, chunkResults...)), err
+			}
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, chunkResults...)
+		}
+		return mergeResults(results), nil
+	default:
+		return c.searchWithTool(flags, ctxLines)
+	}
+}
+
+// chooseStrategy returns the strategy used to search for flags, and the files in the workspace if they were listed to
+// choose it. Files are listed if they're searched with the native strategy to apply MaxFiles or Pathspecs.
+func (c Client) chooseStrategy(flags []string) (string, []string, error) {
+	strategy := c.SearchStrategy
+	auto := strategy == SearchStrategyAuto || strategy == ""
+	var files []string
+	// The files are only needed to choose between strategies that use a search tool, or to limit the search
+	if (auto && c.searchToolPath != "") || c.MaxFiles > 0 || c.filesListed() {
+		var err error
+		files, err = c.listFiles()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if (c.MaxFiles > 0 && len(files) > c.MaxFiles) || c.filesListed() {
+		return SearchStrategyNative, files, nil
+	}
+	if auto {
+		plan := PlanSearch(flags, len(files), c.searchToolPath != "")
+		log.Debug.Printf("search plan: %d flags, %d byte pattern in %d chunks, %d files: using %s search", plan.FlagCount, plan.PatternBytes, plan.Chunks, plan.FileCount, plan.Strategy)
+		strategy = plan.Strategy
+	}
+	return strategy, files, nil
+}
+
+// NativeSearchMethod is the SearchMethod of clients which search with the native matcher.
+const NativeSearchMethod = "native matcher"
+
+// SearchMethod describes how flags are searched for: the search tool and strategy, or the native matcher.
+func (c Client) SearchMethod(flags []string) (string, error) {
+	if c.FlagMatcher != nil {
+		return NativeSearchMethod, nil
+	}
+	strategy, _, err := c.chooseStrategy(flags)
+	if err != nil {
+		return "", err
+	}
+	return c.searchMethod(strategy, flags), nil
+}
+
+func (c Client) searchMethod(strategy string, flags []string) string {
+	switch strategy {
+	case SearchStrategyNative:
+		return NativeSearchMethod
+	case SearchStrategyChunked:
+		return fmt.Sprintf("%s, %s strategy in %d chunks", c.SearchTool, strategy, len(chunkFlags(flags, maxPatternBytes)))
+	default:
+		return fmt.Sprintf("%s, %s strategy", c.SearchTool, SearchStrategyCombined)
+	}
+}
+
+// searchWithTool searches for flags with a single alternation using the configured search tool.
+func (c Client) searchWithTool(flags []string, ctxLines int) ([][]string, error) {
+	if c.searchToolPath == "" {
+		return nil, fmt.Errorf("a search tool is required for the %s search strategy", c.SearchStrategy)
+	}
+
+	// Both search tools are configured to print each line as `path\0lineNumber:line` for matches, and
+	// `path\0lineNumber-line` for context lines, unless rg supports JSON output. Paths may contain any
+	// character except NUL and newline.
+	var args []string
+	switch {
+	case c.SearchTool == SearchToolRg && c.searchToolJson:
+		args = []string{"--json", "--case-sensitive", "--encoding=auto"}
+	case c.SearchTool == SearchToolRg:
+		args = []string{"--no-heading", "--with-filename", "--line-number", "--null", "--case-sensitive", "--encoding=auto"}
+	default:
+		args = []string{"--nogroup", "--null", "--case-sensitive"}
+	}
+	if c.MaxConcurrency > 0 {
+		if c.SearchTool == SearchToolRg {
+			args = append(args, fmt.Sprintf("--threads=%d", c.MaxConcurrency))
+		} else {
+			args = append(args, fmt.Sprintf("--workers=%d", c.MaxConcurrency))
+		}
+	}
+	args = append(args, includeArgs(c.SearchTool, c.Workspace, c.IncludeGlobs)...)
+	args = append(args, walkArgs(c.SearchTool, c.MaxDepth, c.OneFileSystem)...)
+	if ctxLines > 0 {
+		args = append(args, fmt.Sprintf("-C%d", ctxLines))
+	}
+	args = append(args, "--", FlagPattern(flags))
+	args = append(args, c.searchPaths()...)
+
+	ctx := context.Background()
+	if !c.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.Deadline)
+		defer cancel()
+	}
+	var stderr bytes.Buffer
+	cmd := niceCommand(ctx, c.searchToolPath, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		// The output may end with part of a line
+		out = out[:bytes.LastIndexByte(out, '\n')+1]
+		results, err := c.parseToolOutput(out)
+		if err != nil {
+			return nil, err
+		}
+		return results, &BudgetExceededError{Reason: fmt.Sprintf("the time limit passed before %s finished searching", c.SearchTool)}
+	}
+	// Files which couldn't be searched are recorded first, since the search tool may have found references in others
+	matched, err := checkSearchExit(c.SearchTool, out, c.addToolErrors(stderr.Bytes()), err)
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		log.Debug.Printf("%s found no references to %d flags", c.SearchTool, len(flags))
+		return [][]string{}, nil
+	}
+	return c.parseToolOutput(out)
+}
+
+func (c Client) parseToolOutput(out []byte) ([][]string, error) {
+	if c.searchToolJson {
+		return parseJsonOutput(out, c.Workspace)
+	}
+	return parseSearchOutput(out, c.Workspace), nil
+}
+
+// checkSearchExit interprets the result of running a search tool, returning whether its output should be parsed.
+// stderr is the search tool's stderr, without the errors for individual files, which the caller has already recorded.
+// Both search tools exit with status 1 when nothing matched, but ag also exits with status 1 on errors, so a status 1
+// with other errors written to stderr is only treated as no matches for rg, or for ag if it only wrote warnings. rg
+// exits with status 2 after errors, which are not fatal if they only affected some files, or if it still printed
+// matches. Errors include stderr.
+func checkSearchExit(tool string, stdout, stderr []byte, err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	msg := strings.TrimSpace(string(stderr))
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false, fmt.Errorf("failed to run %s: %s", tool, err)
+	}
+	switch code := exitErr.Sys().(syscall.WaitStatus).ExitStatus(); {
+	case code == 1 && (tool == SearchToolRg || msg == ""):
+		return false, nil
+	case code == 1 && tool == SearchToolAg && agWarnings(msg):
+		log.Warning.Printf("%s: %s", tool, msg)
+		return false, nil
+	case code == 2 && tool == SearchToolRg && (msg == "" || len(stdout) > 0):
+		if msg != "" {
+			log.Warning.Printf("%s: %s", tool, msg)
+		}
+		return len(stdout) > 0, nil
+	}
+	if msg == "" {
+		return false, fmt.Errorf("%s failed: %s", tool, err)
+	}
+	return false, fmt.Errorf("%s failed: %s: %s", tool, err, msg)
+}
+
+// agWarningPrefix prefixes the warnings ag writes to stderr, which don't stop it from searching.
+const agWarningPrefix = "WARN: "
+
+// agWarnings returns true if every line of ag's stderr is a warning.
+func agWarnings(stderr string) bool {
+	for _, line := range strings.Split(stderr, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, agWarningPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSearchOutput parses the output of a search tool run with --null into results containing the full line,
+// path relative to workspace, separator (: for matches, - for context), line number, and line text. Lines which
+// aren't results, such as the -- printed between groups of context lines, are skipped.
+func parseSearchOutput(out []byte, workspace string) [][]string {
+	ret := [][]string{}
+	for len(out) > 0 {
+		var line []byte
+		if idx := bytes.IndexByte(out, '\n'); idx >= 0 {
+			line, out = out[:idx], out[idx+1:]
+		} else {
+			line, out = out, nil
+		}
+
+		nul := bytes.IndexByte(line, 0)
+		if nul <= 0 {
+			continue
+		}
+		path, rest := string(line[:nul]), line[nul+1:]
+		digits := 0
+		for digits < len(rest) && '0' <= rest[digits] && rest[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || digits == len(rest) || (rest[digits] != ':' && rest[digits] != '-') {
+			continue
+		}
+		lineNum, err := strconv.Atoi(string(rest[:digits]))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, resultLine(relativePath(path, workspace), string(rest[digits]), lineNum, string(rest[digits+1:])))
+	}
+	return ret
+}
+
+// relativePath returns a path printed by a search tool relative to the workspace, with forward slashes.
+func relativePath(path, workspace string) string {
+	for _, prefix := range []string{workspace + "/", workspace + string(filepath.Separator)} {
+		if strings.HasPrefix(path, prefix) {
+			path = path[len(prefix):]
+			break
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// mergeResults combines the results of several searches, which may contain the same lines, sorted by path and
+// line number. Lines matched by any search are matches, even if they are context lines in another search.
+func mergeResults(results [][]string) [][]string {
+	type key struct {
+		path string
+		line int
+	}
+	lines := map[key][]string{}
+	for _, r := range results {
+		lineNum, err := strconv.Atoi(r[3])
+		if err != nil {
+			continue
+		}
+		k := key{r[1], lineNum}
+		if existing, ok := lines[k]; !ok || (existing[2] != ":" && r[2] == ":") {
+			lines[k] = r
+		}
+	}
+
+	keys := make([]key, 0, len(lines))
+	for k := range lines {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].line < keys[j].line
+	})
+	ret := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, lines[k])
+	}
+	return ret
+}
@@ -0,0 +1,386 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+func TestMain(m *testing.M) {
+	log.Init(false)
+	os.Exit(m.Run())
+}
+
+func Test_PlanSearch(t *testing.T) {
+	few := []string{"flag-1", "flag-2"}
+	many := make([]string, 2000)
+	for i := range many {
+		many[i] = "a-fairly-long-flag-key-" + strings.Repeat("x", i%10) + string(rune('a'+i%26))
+	}
+
+	require.Equal(t, SearchStrategyCombined, PlanSearch(few, 100, true).Strategy)
+	require.Equal(t, SearchStrategyNative, PlanSearch(few, 100, false).Strategy)
+	require.Equal(t, SearchStrategyChunked, PlanSearch(many, 100, true).Strategy)
+	require.Equal(t, SearchStrategyNative, PlanSearch(many, 100000, true).Strategy)
+}
+
+func TestSearchMethod(t *testing.T) {
+	flags := []string{"flag-1"}
+	method, err := Client{SearchStrategy: SearchStrategyAuto}.SearchMethod(flags)
+	require.NoError(t, err)
+	require.Equal(t, NativeSearchMethod, method)
+
+	client := Client{SearchTool: SearchToolRg, SearchStrategy: SearchStrategyCombined, searchToolPath: "rg"}
+	method, err = client.SearchMethod(flags)
+	require.NoError(t, err)
+	require.Equal(t, "rg, combined strategy", method)
+
+	client.SearchStrategy = SearchStrategyChunked
+	method, err = client.SearchMethod(flags)
+	require.NoError(t, err)
+	require.Equal(t, "rg, chunked strategy in 1 chunks", method)
+}
+
+func Test_chunkFlags(t *testing.T) {
+	flags := []string{"flag-1", "flag-2", "flag-3"}
+	// Each flag's pattern is \bflag-n\b, plus a separator
+	require.Equal(t, [][]string{{"flag-1", "flag-2"}, {"flag-3"}}, chunkFlags(flags, 30))
+	require.Equal(t, [][]string{{"flag-1"}, {"flag-2"}, {"flag-3"}}, chunkFlags(flags, 1))
+	require.Equal(t, [][]string{flags}, chunkFlags(flags, maxPatternBytes))
+}
+
+func Test_mergeResults(t *testing.T) {
+	results := [][]string{
+		resultLine("b", ":", 1, "flag-2"),
+		resultLine("a", "-", 2, "flag-1"),
+		resultLine("a", ":", 1, "flag-2"),
+		resultLine("a", ":", 2, "flag-1"),
+	}
+	require.Equal(t, [][]string{
+		resultLine("a", ":", 1, "flag-2"),
+		resultLine("a", ":", 2, "flag-1"),
+		resultLine("b", ":", 1, "flag-2"),
+	}, mergeResults(results))
+}
+
+func Test_parseSearchOutput(t *testing.T) {
+	specs := []struct {
+		name     string
+		output   string
+		expected [][]string
+	}{
+		{"match", "/ws/a.go\x0012:flag-1\n", [][]string{resultLine("a.go", ":", 12, "flag-1")}},
+		{"context", "/ws/a.go\x0011-before\n", [][]string{resultLine("a.go", "-", 11, "before")}},
+		{"colon in path", "/ws/dir:1/a:2.go\x003:x = flag-1\n", [][]string{resultLine("dir:1/a:2.go", ":", 3, "x = flag-1")}},
+		{"dash and digits in path", "/ws/a-1-b-2.go\x0012-context\n", [][]string{resultLine("a-1-b-2.go", "-", 12, "context")}},
+		{"separators in text", "/ws/a.go\x007:a:1:b-2-c\n", [][]string{resultLine("a.go", ":", 7, "a:1:b-2-c")}},
+		{"empty text", "/ws/a.go\x007-\n", [][]string{resultLine("a.go", "-", 7, "")}},
+		{"group separator", "/ws/a.go\x001:flag-1\n--\n/ws/b.go\x009:flag-1\n", [][]string{resultLine("a.go", ":", 1, "flag-1"), resultLine("b.go", ":", 9, "flag-1")}},
+		{"no trailing newline", "/ws/a.go\x001:flag-1", [][]string{resultLine("a.go", ":", 1, "flag-1")}},
+		{"malformed lines", "/ws/a.go\x00x:flag-1\n/ws/a.go\x001\n\x001:flag-1\nno nul\n", [][]string{}},
+		{"path outside workspace", "/other/a.go\x001:flag-1\n", [][]string{resultLine("/other/a.go", ":", 1, "flag-1")}},
+	}
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, parseSearchOutput([]byte(tt.output), "/ws"))
+		})
+	}
+}
+
+func Test_checkSearchExit(t *testing.T) {
+	exitErr := func(code int) error {
+		return exec.Command("sh", "-c", "exit "+strconv.Itoa(code)).Run()
+	}
+
+	matched, err := checkSearchExit(SearchToolRg, []byte("a.go\x001:someFlag"), nil, nil)
+	require.NoError(t, err)
+	require.True(t, matched)
+
+	for _, tool := range []string{SearchToolAg, SearchToolRg} {
+		matched, err = checkSearchExit(tool, nil, nil, exitErr(1))
+		require.NoError(t, err, tool)
+		require.False(t, matched, tool)
+
+		_, err = checkSearchExit(tool, nil, []byte("bad pattern\n"), exitErr(2))
+		require.EqualError(t, err, tool+" failed: exit status 2: bad pattern")
+	}
+
+	// ag also exits with 1 on errors
+	_, err = checkSearchExit(SearchToolAg, nil, []byte("ERR: out of memory\n"), exitErr(1))
+	require.EqualError(t, err, "ag failed: exit status 1: ERR: out of memory")
+	matched, err = checkSearchExit(SearchToolAg, nil, []byte("WARN: Path a.go is a symlink\n"), exitErr(1))
+	require.NoError(t, err)
+	require.False(t, matched)
+	_, err = checkSearchExit(SearchToolAg, nil, []byte("WARN: Path a.go is a symlink\nERR: out of memory\n"), exitErr(1))
+	require.Error(t, err)
+	matched, err = checkSearchExit(SearchToolRg, nil, []byte("a.go: Permission denied\n"), exitErr(1))
+	require.NoError(t, err)
+	require.False(t, matched)
+
+	// rg keeps matches printed before errors in other files
+	matched, err = checkSearchExit(SearchToolRg, []byte("a.go\x001:someFlag"), []byte("b.go: Permission denied"), exitErr(2))
+	require.NoError(t, err)
+	require.True(t, matched)
+	// Errors for individual files have already been recorded, so rg's exit status is ignored even without matches
+	matched, err = checkSearchExit(SearchToolRg, nil, nil, exitErr(2))
+	require.NoError(t, err)
+	require.False(t, matched)
+	_, err = checkSearchExit(SearchToolAg, []byte("a.go\x001:someFlag"), nil, exitErr(2))
+	require.EqualError(t, err, "ag failed: exit status 2")
+
+	_, err = checkSearchExit(SearchToolRg, nil, nil, exec.ErrNotFound)
+	require.Error(t, err)
+	require.True(t, strings.HasPrefix(err.Error(), "failed to run rg: "))
+}
+
+func Test_searchFile(t *testing.T) {
+	matcher := newLiteralMatcher([]string{"flag-1", "flag-2"})
+	data := []byte("a\nb\nflag-1\nc\nd\ne\nf\nflag-2\nmyflag-2x\n")
+	require.Equal(t, [][]string{
+		resultLine("f", "-", 2, "b"),
+		resultLine("f", ":", 3, "flag-1"),
+		resultLine("f", "-", 4, "c"),
+		resultLine("f", "-", 7, "f"),
+		resultLine("f", ":", 8, "flag-2"),
+		resultLine("f", "-", 9, "myflag-2x"),
+	}, searchFile("f", data, matcher, 1))
+
+	require.Equal(t, [][]string{resultLine("f", ":", 3, "flag-1"), resultLine("f", ":", 8, "flag-2")}, searchFile("f", data, matcher, 0))
+	require.Empty(t, searchFile("f", []byte("flag-1\x00"), matcher, 0), "binary files are skipped")
+}
+
+func Test_searchFile_encodings(t *testing.T) {
+	matcher := newLiteralMatcher([]string{"flag-1"})
+	expected := [][]string{resultLine("f", "-", 1, "a"), resultLine("f", ":", 2, "flag-1")}
+
+	require.Equal(t, expected, searchFile("f", []byte("a\r\nflag-1\r\n"), matcher, 1))
+	require.Equal(t, expected, searchFile("f", []byte("\xEF\xBB\xBFa\nflag-1\n"), matcher, 1))
+
+	utf16LE := []byte{0xFF, 0xFE}
+	utf16BE := []byte{0xFE, 0xFF}
+	for _, c := range "a\r\nflag-1\r\n" {
+		utf16LE = append(utf16LE, byte(c), 0)
+		utf16BE = append(utf16BE, 0, byte(c))
+	}
+	require.Equal(t, expected, searchFile("f", utf16LE, matcher, 1))
+	require.Equal(t, expected, searchFile("f", utf16BE, matcher, 1))
+}
+
+func Test_searchPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Large enough to be memory mapped
+	var contents strings.Builder
+	for contents.Len() < mmapMinBytes {
+		contents.WriteString("no references here\n")
+	}
+	contents.WriteString("flag-1\n")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "large.txt"), []byte(contents.String()), 0644))
+	lineNum := strings.Count(contents.String(), "\n")
+
+	matcher := newLiteralMatcher([]string{"flag-1"})
+	results, err := searchPath(dir, "large.txt", matcher, 1)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		resultLine("large.txt", "-", lineNum-1, "no references here"),
+		resultLine("large.txt", ":", lineNum, "flag-1"),
+	}, results)
+	results, err = searchPath(dir, "missing.txt", matcher, 1)
+	require.NoError(t, err)
+	require.Nil(t, results)
+}
+
+func Test_isWordBounded(t *testing.T) {
+	specs := []struct {
+		line     string
+		flag     string
+		expected bool
+	}{
+		{"flag", "flag", true},
+		{"(flag)", "flag", true},
+		{"myflag", "flag", false},
+		{"flag_2", "flag", false},
+		{"my-flag", "flag", true},
+		// \b before a non-word character requires a word character before it
+		{"a-flag", "-flag", true},
+		{" -flag", "-flag", false},
+	}
+	for _, tt := range specs {
+		start := strings.Index(tt.line, tt.flag)
+		require.Equal(t, tt.expected, isWordBounded([]byte(tt.line), start, start+len(tt.flag)), "%s in %s", tt.flag, tt.line)
+	}
+}
+
+func TestSearchNative(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.go":       "if flag-1 {\n}\n",
+		"sub/b.js":   "x = 'flag-2'\n",
+		"ignored.go": "flag-1\n",
+		".gitignore": "ignored.go\n",
+	}
+	for name, contents := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	client := Client{Workspace: dir, SearchStrategy: SearchStrategyNative}
+	results, err := client.SearchForFlags([]string{"flag-1", "flag-2"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		resultLine("a.go", ":", 1, "if flag-1 {"),
+		resultLine("sub/b.js", ":", 1, "x = 'flag-2'"),
+	}, results)
+
+	client.Roots = []string{"sub"}
+	results, err = client.SearchForFlags([]string{"flag-1", "flag-2"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("sub/b.js", ":", 1, "x = 'flag-2'")}, results)
+
+	client.Roots = nil
+	client.MaxFiles = 2
+	results, err = client.SearchForFlags([]string{"flag-1", "flag-2"}, 0)
+	require.EqualError(t, err, "scan budget exceeded: found 3 files, only the first 2 were searched")
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "if flag-1 {")}, results)
+
+	// The method is returned with partial results
+	method, results, err := client.SearchForFlagsWithMethod([]string{"flag-1", "flag-2"}, 0)
+	require.IsType(t, &BudgetExceededError{}, err)
+	require.Equal(t, NativeSearchMethod, method)
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "if flag-1 {")}, results)
+
+	client.MaxFiles = 0
+	client.Deadline = time.Now()
+	results, err = client.SearchForFlags([]string{"flag-1", "flag-2"}, 0)
+	require.EqualError(t, err, "scan budget exceeded: the time limit passed after searching 0 of 3 files")
+	require.Empty(t, results)
+}
+
+func TestSearchNative_flagMatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte("if flag-1 {\n}\nx := flag-2 + flag-1\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.go"), []byte("flag-2\n"), 0644))
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	// The matcher is searched for instead of the flags passed, even if a search tool is configured
+	client := Client{Workspace: dir, SearchStrategy: SearchStrategyCombined, FlagMatcher: NewFlagMatcher([]string{"flag-1"})}
+	client.FlagMatcher.Add([]string{"flag-2"})
+	method, results, err := client.SearchForFlagsWithMethod([]string{"unused"}, 1)
+	require.NoError(t, err)
+	require.Equal(t, NativeSearchMethod, method)
+	require.Equal(t, [][]string{
+		append(resultLine("a.go", ":", 1, "if flag-1 {"), "", "flag-1"),
+		resultLine("a.go", "-", 2, "}"),
+		append(resultLine("a.go", ":", 3, "x := flag-2 + flag-1"), "", "flag-1,flag-2"),
+		append(resultLine("b.go", ":", 1, "flag-2"), "", "flag-2"),
+	}, results)
+	require.Equal(t, []string{"flag-1", "flag-2"}, MatchedFlagKeys(results[2]))
+	require.Nil(t, MatchedFlagKeys(results[1]))
+	require.Nil(t, PluginFlagKeys(results[2]))
+
+	client.MaxFiles = 1
+	results, err = client.SearchForFlags(nil, 0)
+	require.EqualError(t, err, "scan budget exceeded: found 2 files, only the first 1 were searched")
+	require.Len(t, results, 2)
+}
+
+func TestSearchNative_pathspecs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pathspec")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"src/a.go", "src/testdata/b.go", "src/pkg/testdata/c.go", "lib/d.go"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("flag-1\n"), 0644))
+	}
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	client := Client{Workspace: dir, SearchStrategy: SearchStrategyAuto, Pathspecs: []string{"src/**", ":!**/testdata/**"}}
+	results, err := client.SearchForFlags([]string{"flag-1"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("src/a.go", ":", 1, "flag-1")}, results)
+
+	// Pathspecs are combined with roots
+	client.Pathspecs = []string{"*.go"}
+	client.Roots = []string{"lib"}
+	results, err = client.SearchForFlags([]string{"flag-1"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("lib/d.go", ":", 1, "flag-1")}, results)
+
+	client.Pathspecs = []string{":(unknown)src"}
+	_, err = client.SearchForFlags([]string{"flag-1"}, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "could not list files")
+}
+
+func TestSearchNative_trackedOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracked")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"tracked.go", "untracked.go", ".tracked.go.swp"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("flag-1\n"), 0644))
+	}
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+	require.NoError(t, exec.Command("git", "-C", dir, "add", "tracked.go").Run())
+
+	client := Client{Workspace: dir, SearchStrategy: SearchStrategyAuto}
+	results, err := client.SearchForFlags([]string{"flag-1"}, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	client.TrackedOnly = true
+	results, err = client.SearchForFlags([]string{"flag-1"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("tracked.go", ":", 1, "flag-1")}, results)
+}
+
+func TestSetRoots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roots")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"services/api", "services/web", "other"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0755))
+	}
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+	topLevel, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+
+	client := Client{Workspace: filepath.Join(dir, "services/api")}
+	require.NoError(t, client.setRoots([]string{filepath.Join(dir, "services/api"), filepath.Join(dir, "services/web"), filepath.Join(dir, "services/web")}))
+	require.Equal(t, topLevel, client.Workspace)
+	require.Equal(t, []string{"services/api", "services/web"}, client.Roots)
+
+	// Paths in a single nested directory are relative to the repository root
+	client = Client{Workspace: filepath.Join(dir, "services/web")}
+	require.NoError(t, client.setRoots([]string{filepath.Join(dir, "services/web")}))
+	require.Equal(t, topLevel, client.Workspace)
+	require.Equal(t, []string{"services/web"}, client.Roots)
+
+	client = Client{Workspace: filepath.Join(dir, "other")}
+	require.NoError(t, client.setRoots([]string{filepath.Join(dir, "other"), dir}))
+	require.Empty(t, client.Roots, "the whole repository should be searched")
+
+	outside, err := ioutil.TempDir("", "outside")
+	require.NoError(t, err)
+	defer os.RemoveAll(outside)
+	client = Client{Workspace: dir}
+	require.Error(t, client.setRoots([]string{dir, outside}))
+}
@@ -0,0 +1,32 @@
+package command
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// TagSha returns the sha of the commit tag points to. An error is returned if tag doesn't exist.
+func (c Client) TagSha(tag string) (string, error) {
+	sha, err := c.revParse("refs/tags/" + tag + "^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("could not find tag %s: %s", tag, err)
+	}
+	return sha, nil
+}
+
+// TagTime returns the time tag was created, in seconds since the epoch. This is the tagger date of annotated tags,
+// and the commit time of lightweight tags.
+func (c Client) TagTime(tag string) (int64, error) {
+	cmd := exec.Command("git", "-C", c.Workspace, "for-each-ref", "--format=%(creatordate:unix)", "refs/tags/"+tag)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(out))
+	if s == "" {
+		return 0, fmt.Errorf("could not find tag %s", tag)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
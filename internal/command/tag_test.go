@@ -0,0 +1,47 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tag")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(date string, args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_DATE="+date)
+		require.NoError(t, cmd.Run())
+	}
+	git("2019-05-01T00:00:00Z", "init", "-q")
+	git("2019-05-01T00:00:00Z", "commit", "-q", "--allow-empty", "-m", "release")
+	git("2019-05-01T00:00:00Z", "tag", "lightweight")
+	// The tagger date of annotated tags is the committer date when the tag is created
+	git("2019-06-01T00:00:00Z", "tag", "-a", "-m", "v1.2.3", "v1.2.3")
+	git("2019-05-01T00:00:00Z", "checkout", "-q", "--detach", "v1.2.3")
+
+	client, err := NewGitClient(dir)
+	require.NoError(t, err)
+	require.Empty(t, client.GitBranch, "HEAD is detached")
+
+	sha, err := client.TagSha("v1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, client.GitSha, sha)
+	tagTime, err := client.TagTime("v1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, int64(1559347200), tagTime)
+	tagTime, err = client.TagTime("lightweight")
+	require.NoError(t, err)
+	require.Equal(t, int64(1556668800), tagTime)
+
+	_, err = client.TagSha("missing")
+	require.Error(t, err)
+	_, err = client.TagTime("missing")
+	require.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// runDirPrefix is the prefix of the temporary directory created for each run, followed by a random suffix.
+const runDirPrefix = "ld-find-code-refs-run-"
+
+const (
+	// runDirLockName is the name of the file in a run directory which is locked while the run is in progress.
+	runDirLockName = ".lock"
+	// staleRunDirAge is the age after which a run directory without a lock file is assumed to have been left behind,
+	// e.g. by an earlier version, or on a platform without file locks. Directories are created before their lock
+	// files, so newer directories may still be in use.
+	staleRunDirAge = 24 * time.Hour
+)
+
+var (
+	// tempBase is the directory run directories are created in, which is shared by every run, unlike TMPDIR, which
+	// InitEnv sets to the run directory
+	tempBase   string
+	runDir     string
+	runDirLock sync.Mutex
+	// runDirFile holds the lock on the run directory's lock file
+	runDirFile *os.File
+
+	exitOnSignalOnce sync.Once
+)
+
+// baseTempDir returns the temporary directory shared by every run: tmpDir, if provided to InitEnv, or the system
+// temporary directory as it was before InitEnv replaced it with the run directory.
+func baseTempDir() string {
+	runDirLock.Lock()
+	defer runDirLock.Unlock()
+	if tempBase != "" {
+		return tempBase
+	}
+	return os.TempDir()
+}
+
+// createRunDir creates a temporary directory in base which is only used by this run, so concurrent scans sharing
+// base can't collide, and locks it until it's removed, or the process exits. Directories left behind by earlier runs
+// which have exited, e.g. because they were killed, are removed first.
+func createRunDir(base string) (string, *os.File, error) {
+	removeStaleRunDirs(base, time.Now())
+	dir, err := ioutil.TempDir(base, runDirPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	f, err := acquireFileLock(filepath.Join(dir, runDirLockName))
+	if err == errLockUnsupported {
+		return dir, nil, nil
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("could not lock temporary directory: %s", err)
+	}
+	return dir, f, nil
+}
+
+// removeStaleRunDirs removes the run directories in base which aren't locked by a run in progress.
+func removeStaleRunDirs(base string, now time.Time) {
+	entries, err := ioutil.ReadDir(base)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), runDirPrefix) {
+			continue
+		}
+		path := filepath.Join(base, e.Name())
+		if !runDirStale(path, e.ModTime(), now) {
+			continue
+		}
+		log.Debug.Printf("removing stale temporary directory %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			log.Warning.Printf("could not remove stale temporary directory %s: %s", path, err)
+		}
+	}
+}
+
+// runDirStale returns true if the run directory at path, last modified at modTime, was left behind by a run which has
+// exited, since its lock file isn't locked.
+func runDirStale(path string, modTime, now time.Time) bool {
+	f, err := os.OpenFile(filepath.Join(path, runDirLockName), os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return now.Sub(modTime) > staleRunDirAge
+	} else if err != nil {
+		return false
+	}
+	defer f.Close()
+	err = lockFile(f)
+	if err == errLockUnsupported {
+		return now.Sub(modTime) > staleRunDirAge
+	}
+	return err == nil
+}
+
+// RemoveRunDir removes the temporary directory created by InitEnv, if any. It's also run if the process exits because
+// of a fatal error or a signal, and directories left behind otherwise, e.g. if the process is killed, are removed by
+// later runs.
+func RemoveRunDir() {
+	runDirLock.Lock()
+	defer runDirLock.Unlock()
+	if runDir == "" {
+		return
+	}
+	if runDirFile != nil {
+		// The lock file is closed first, since Windows doesn't allow open files to be removed
+		runDirFile.Close()
+		runDirFile = nil
+	}
+	if err := os.RemoveAll(runDir); err != nil {
+		log.Warning.Printf("could not remove temporary directory %s: %s", runDir, err)
+	}
+	runDir = ""
+}
+
+// exitOnSignal exits if the process is interrupted or terminated, after running the exit hooks which release locks and
+// remove the run directory, since deferred calls don't run.
+func exitOnSignal() {
+	exitOnSignalOnce.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-c
+			log.Warning.Printf("received %s, exiting", sig)
+			log.Exit(1)
+		}()
+	})
+}
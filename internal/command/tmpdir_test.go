@@ -0,0 +1,60 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+func Test_createRunDir(t *testing.T) {
+	log.Init(false)
+	base, err := ioutil.TempDir("", "tmpdir")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	// Left behind by a run which has exited, so its lock file isn't locked
+	exited := filepath.Join(base, runDirPrefix+"1")
+	// Without lock files, e.g. because they're still being created
+	unlocked := filepath.Join(base, runDirPrefix+"2")
+	oldUnlocked := filepath.Join(base, runDirPrefix+"3")
+	unrelated := filepath.Join(base, "unrelated")
+	for _, dir := range []string{exited, unlocked, oldUnlocked, unrelated} {
+		require.NoError(t, os.Mkdir(dir, 0755))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(exited, runDirLockName), nil, 0644))
+	old := time.Now().Add(-staleRunDirAge - time.Hour)
+	require.NoError(t, os.Chtimes(oldUnlocked, old, old))
+
+	dir, f, err := createRunDir(base)
+	require.NoError(t, err)
+	defer f.Close()
+	require.DirExists(t, dir)
+	require.FileExists(t, filepath.Join(dir, runDirLockName))
+
+	_, err = os.Stat(exited)
+	require.True(t, os.IsNotExist(err))
+	require.DirExists(t, unlocked)
+	_, err = os.Stat(oldUnlocked)
+	require.True(t, os.IsNotExist(err))
+	require.DirExists(t, unrelated)
+
+	// Directories are kept while they're locked
+	second, secondFile, err := createRunDir(base)
+	require.NoError(t, err)
+	require.NotEqual(t, dir, second)
+	require.DirExists(t, dir)
+	secondFile.Close()
+
+	_, third, err := createRunDir(base)
+	require.NoError(t, err)
+	third.Close()
+	_, err = os.Stat(second)
+	require.True(t, os.IsNotExist(err))
+	require.DirExists(t, dir)
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Search tools which may be used to find flag references
+const (
+	SearchToolAuto = "auto"
+	SearchToolAg   = "ag"
+	SearchToolRg   = "rg"
+)
+
+// searchToolNames are the display names of search tools.
+var searchToolNames = map[string]string{
+	SearchToolAg: "The Silver Searcher",
+	SearchToolRg: "ripgrep",
+}
+
+// DefaultToolCacheDir returns the directory that downloaded tools are installed in by default.
+func DefaultToolCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ld-find-code-refs", "bin")
+}
+
+// LookTool returns the path of an executable, searching the system PATH and then the tool cache directory.
+func LookTool(name, toolCacheDir string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err == nil {
+		return path, nil
+	}
+	if toolCacheDir == "" {
+		toolCacheDir = DefaultToolCacheDir()
+	}
+	if toolCacheDir != "" {
+		exe := name
+		if runtime.GOOS == "windows" {
+			exe += ".exe"
+		}
+		path, err := exec.LookPath(filepath.Join(toolCacheDir, exe))
+		if err == nil {
+			return path, nil
+		}
+	}
+	return "", err
+}
+
+// LookSearchTool returns the name and path of the search tool to use. If searchTool is auto, ag is preferred over rg.
+func LookSearchTool(searchTool, toolCacheDir string) (string, string, error) {
+	candidates := []string{searchTool}
+	if searchTool == SearchToolAuto || searchTool == "" {
+		candidates = []string{SearchToolAg, SearchToolRg}
+	}
+	for _, name := range candidates {
+		if path, err := LookTool(name, toolCacheDir); err == nil {
+			return name, path, nil
+		}
+	}
+
+	if len(candidates) == 1 {
+		return "", "", fmt.Errorf("%s (%s) is required to search for flag references, but was not found in the system PATH or tool cache directory. Run `ld-find-code-refs doctor` for installation instructions", searchTool, searchToolNames[searchTool])
+	}
+	return "", "", fmt.Errorf("ag (The Silver Searcher) or rg (ripgrep) is required to search for flag references, but neither was found in the system PATH or tool cache directory. Run `ld-find-code-refs doctor` for installation instructions, or `ld-find-code-refs doctor -download` to download ripgrep")
+}
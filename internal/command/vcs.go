@@ -0,0 +1,370 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// Acceptable values for the vcs option
+const (
+	VcsGit        = "git"
+	VcsSubversion = "svn"
+	VcsPerforce   = "perforce"
+)
+
+// vcsRevision is the revision checked out in a workspace of a version control system other than git.
+type vcsRevision struct {
+	Revision string
+	// Branch is the branch, or Perforce stream, checked out. It's empty if it can't be identified.
+	Branch string
+	// Time is the commit time of Revision, in seconds since the epoch.
+	Time int64
+	// ChangedFiles are the paths of files with local changes, relative to the root of the workspace.
+	ChangedFiles []string
+}
+
+// metadataProvider reads the checked out revision of a workspace of a version control system other than git. Providers
+// are read-only, and only identify what was scanned: files are listed by walking the workspace, and features which
+// depend on git history aren't available.
+type metadataProvider interface {
+	// command is the name of the command line tool the provider runs.
+	command() string
+	// root returns the root of the workspace containing dir.
+	root(dir string) (string, error)
+	revision(root string) (vcsRevision, error)
+}
+
+var metadataProviders = map[string]metadataProvider{
+	VcsSubversion: svnProvider{},
+	VcsPerforce:   perforceProvider{},
+}
+
+// runVcs runs a version control command in dir, and returns its output. It's a variable so tests don't require the
+// command to be installed.
+var runVcs = func(dir, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := niceCommand(context.Background(), name, args...)
+	cmd.Dir = dir
+	// p4 finds its configuration relative to PWD, rather than the working directory
+	cmd.Env = append(os.Environ(), "PWD="+dir)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		return nil, fmt.Errorf("%s %s: %s", name, strings.Join(args, " "), msg)
+	}
+	return out, err
+}
+
+// NewVcsClient initializes a client for reading the metadata of the workspace at path, checked out from vcs. If vcs is
+// git, or empty, it's equivalent to NewGitClient.
+func NewVcsClient(vcs, path string) (Client, error) {
+	if vcs == "" || vcs == VcsGit {
+		return NewGitClient(path)
+	}
+	client := Client{Vcs: vcs}
+	provider, ok := metadataProviders[vcs]
+	if !ok {
+		return client, fmt.Errorf("unsupported version control system: %s", vcs)
+	}
+
+	absPath, err := normalizeAndValidatePath(path)
+	if err != nil {
+		return client, fmt.Errorf("could not validate directory option: %s", err)
+	}
+	client.Workspace = absPath
+
+	_, err = exec.LookPath(provider.command())
+	if err != nil {
+		return client, fmt.Errorf("%s is a required dependency when vcs is %s, but was not found in the system PATH", provider.command(), vcs)
+	}
+	root, err := provider.root(absPath)
+	if err != nil {
+		return client, fmt.Errorf("could not find the root of the %s workspace at %s: %s", vcs, absPath, err)
+	}
+	rev, err := provider.revision(root)
+	if err != nil {
+		return client, fmt.Errorf("error reading %s revision: %s", vcs, err)
+	}
+	client.GitBranch = rev.Branch
+	client.GitSha = rev.Revision
+	client.GitTimestamp = rev.Time
+	log.Debug.Printf("identified %s revision %s of branch %q, committed at %d", vcs, rev.Revision, rev.Branch, rev.Time)
+	if len(rev.ChangedFiles) > 0 {
+		log.Warning.Printf("%d files have local changes which aren't in %s revision %s, and are scanned as they are", len(rev.ChangedFiles), vcs, rev.Revision)
+	}
+	return client, nil
+}
+
+// CheckedOutRevision returns the revision currently checked out in the workspace, which differs from GitSha if it was
+// changed after the client was initialized, e.g. by a pull.
+func (c Client) CheckedOutRevision() (string, error) {
+	if c.IsGit() {
+		return c.revParse("HEAD")
+	}
+	provider, ok := metadataProviders[c.Vcs]
+	if !ok {
+		return "", fmt.Errorf("unsupported version control system: %s", c.Vcs)
+	}
+	root, err := provider.root(c.Workspace)
+	if err != nil {
+		return "", err
+	}
+	rev, err := provider.revision(root)
+	if err != nil {
+		return "", err
+	}
+	return rev.Revision, nil
+}
+
+// ReloadRevision reads the branch, revision, and commit time checked out in the workspace again, so the client
+// describes the checked out revision if it has changed.
+func (c *Client) ReloadRevision() error {
+	reloaded, err := NewVcsClient(c.Vcs, c.Workspace)
+	if err != nil {
+		return err
+	}
+	c.GitBranch = reloaded.GitBranch
+	c.GitSha = reloaded.GitSha
+	c.GitTimestamp = reloaded.GitTimestamp
+	return nil
+}
+
+// IsGit returns true if the workspace is a git repository, so features which depend on git history are available.
+func (c Client) IsGit() bool {
+	return c.Vcs == "" || c.Vcs == VcsGit
+}
+
+// topLevel returns the real path of the root of the repository or workspace containing dir.
+func (c Client) topLevel(dir string) (string, error) {
+	if c.IsGit() {
+		return gitTopLevel(dir)
+	}
+	provider, ok := metadataProviders[c.Vcs]
+	if !ok {
+		return "", fmt.Errorf("unsupported version control system: %s", c.Vcs)
+	}
+	root, err := provider.root(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// vcsLockPath returns the path of the lock file for a workspace which isn't a git repository, and the path used instead
+// if it can't be created, or "". Perforce workspaces have no metadata directory, so their lock files are kept in the
+// temporary directory shared by every run, named after the workspace.
+func (c Client) vcsLockPath() (string, string) {
+	if c.Vcs == VcsSubversion {
+		if root, err := c.topLevel(c.Workspace); err == nil {
+			return filepath.Join(root, ".svn", lockFileName), tempLockPath(root)
+		}
+	}
+	return tempLockPath(c.Workspace), ""
+}
+
+// vcsMetadataDirs are the names of version control metadata directories, which aren't searched when walking a
+// workspace.
+var vcsMetadataDirs = map[string]bool{".git": true, ".hg": true, ".svn": true}
+
+// walkWorkspace returns the paths of the files in the workspace's roots matching IncludeGlobs, relative to the
+// workspace, for workspaces which aren't git repositories. Unlike git, ignore files aren't respected, so generated
+// files should be excluded with the exclude option.
+func (c Client) walkWorkspace() ([]string, error) {
+	files := []string{}
+	for _, dir := range c.searchPaths() {
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if p != dir && vcsMetadataDirs[info.Name()] {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(c.Workspace, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if isIncluded(rel, c.IncludeGlobs) {
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list files: %s", err)
+		}
+	}
+	return c.walkFiles(files), nil
+}
+
+// svnProvider reads the metadata of Subversion working copies with svn info and svn status.
+type svnProvider struct{}
+
+type svnInfo struct {
+	Entries []struct {
+		Revision    string `xml:"revision,attr"`
+		RelativeUrl string `xml:"relative-url"`
+		WcRoot      string `xml:"wc-info>wcroot-abspath"`
+		CommitDate  string `xml:"commit>date"`
+	} `xml:"entry"`
+}
+
+type svnStatus struct {
+	Entries []struct {
+		Path   string `xml:"path,attr"`
+		Status struct {
+			Item string `xml:"item,attr"`
+		} `xml:"wc-status"`
+	} `xml:"target>entry"`
+}
+
+func (svnProvider) command() string {
+	return "svn"
+}
+
+func (p svnProvider) info(dir string) (svnInfo, error) {
+	var info svnInfo
+	out, err := runVcs(dir, "svn", "info", "--xml", "--non-interactive", ".")
+	if err != nil {
+		return info, err
+	}
+	err = xml.Unmarshal(out, &info)
+	if err != nil {
+		return info, fmt.Errorf("could not parse svn info: %s", err)
+	}
+	if len(info.Entries) == 0 {
+		return info, fmt.Errorf("svn info returned no entries")
+	}
+	return info, nil
+}
+
+func (p svnProvider) root(dir string) (string, error) {
+	info, err := p.info(dir)
+	if err != nil {
+		return "", err
+	}
+	if info.Entries[0].WcRoot == "" {
+		return "", fmt.Errorf("svn info didn't identify the working copy root")
+	}
+	return filepath.FromSlash(info.Entries[0].WcRoot), nil
+}
+
+func (p svnProvider) revision(root string) (vcsRevision, error) {
+	info, err := p.info(root)
+	if err != nil {
+		return vcsRevision{}, err
+	}
+	entry := info.Entries[0]
+	rev := vcsRevision{Revision: entry.Revision, Branch: svnBranch(entry.RelativeUrl)}
+	if entry.CommitDate != "" {
+		t, err := time.Parse(time.RFC3339Nano, entry.CommitDate)
+		if err != nil {
+			return rev, fmt.Errorf("could not parse commit date %q: %s", entry.CommitDate, err)
+		}
+		rev.Time = t.Unix()
+	}
+
+	out, err := runVcs(root, "svn", "status", "--xml", "--non-interactive", "--quiet", ".")
+	if err != nil {
+		return rev, err
+	}
+	var status svnStatus
+	err = xml.Unmarshal(out, &status)
+	if err != nil {
+		return rev, fmt.Errorf("could not parse svn status: %s", err)
+	}
+	for _, e := range status.Entries {
+		if e.Status.Item != "normal" && e.Status.Item != "unversioned" {
+			rev.ChangedFiles = append(rev.ChangedFiles, filepath.ToSlash(e.Path))
+		}
+	}
+	return rev, nil
+}
+
+// svnBranch returns the branch checked out in a working copy from its url relative to the repository root, following
+// the standard trunk, branches and tags layout, e.g. ^/branches/release-1.2 is release-1.2. Urls outside of the
+// standard layout are returned without the leading ^/.
+func svnBranch(relativeUrl string) string {
+	p := strings.Trim(strings.TrimPrefix(relativeUrl, "^"), "/")
+	elems := strings.Split(p, "/")
+	for i, elem := range elems {
+		switch {
+		case elem == "trunk":
+			return "trunk"
+		case (elem == "branches" || elem == "tags") && i+1 < len(elems):
+			return elems[i+1]
+		}
+	}
+	return p
+}
+
+// perforceProvider reads the metadata of Perforce client workspaces with p4.
+type perforceProvider struct{}
+
+func (perforceProvider) command() string {
+	return "p4"
+}
+
+func (perforceProvider) root(dir string) (string, error) {
+	out, err := runVcs(dir, "p4", "-ztag", "-F", "%clientRoot%", "info")
+	if err != nil {
+		return "", err
+	}
+	root := strings.TrimSpace(string(out))
+	if root == "" || root == "*unknown*" {
+		return "", fmt.Errorf("%s is not in a Perforce client workspace", dir)
+	}
+	return root, nil
+}
+
+func (perforceProvider) revision(root string) (vcsRevision, error) {
+	rev := vcsRevision{}
+	out, err := runVcs(root, "p4", "-ztag", "-F", "%change% %time%", "changes", "-m1", "...#have")
+	if err != nil {
+		return rev, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return rev, fmt.Errorf("no changelists have been synced to the workspace")
+	}
+	rev.Revision = fields[0]
+	rev.Time, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return rev, fmt.Errorf("could not parse changelist time %q: %s", fields[1], err)
+	}
+
+	out, err = runVcs(root, "p4", "-ztag", "-F", "%Stream%", "client", "-o")
+	if err != nil {
+		return rev, err
+	}
+	// Classic workspaces have no stream, so the branchName option must be provided
+	rev.Branch = strings.TrimPrefix(strings.TrimSpace(string(out)), "//")
+
+	out, err = runVcs(root, "p4", "-ztag", "-F", "%clientFile%", "opened", "...")
+	if err != nil {
+		return rev, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// Client paths are in the form //client/path
+		line = strings.TrimSpace(line)
+		if parts := strings.SplitN(strings.TrimPrefix(line, "//"), "/", 2); len(parts) == 2 {
+			rev.ChangedFiles = append(rev.ChangedFiles, parts[1])
+		}
+	}
+	return rev, nil
+}
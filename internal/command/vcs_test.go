@@ -0,0 +1,184 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVcs replaces runVcs with a function returning the output in outputs for each command, keyed by the command and
+// its arguments separated by spaces, and returns a function that restores runVcs.
+func fakeVcs(outputs map[string]string) func() {
+	run := runVcs
+	runVcs = func(dir, name string, args ...string) ([]byte, error) {
+		cmd := strings.Join(append([]string{name}, args...), " ")
+		out, ok := outputs[cmd]
+		if !ok {
+			return nil, fmt.Errorf("unexpected command: %s", cmd)
+		}
+		return []byte(out), nil
+	}
+	return func() { runVcs = run }
+}
+
+func TestSvnBranch(t *testing.T) {
+	specs := []struct {
+		relativeUrl string
+		want        string
+	}{
+		{"^/trunk", "trunk"},
+		{"^/trunk/src", "trunk"},
+		{"^/branches/release-1.2", "release-1.2"},
+		{"^/project/branches/feature/src", "feature"},
+		{"^/tags/v1.2.3", "v1.2.3"},
+		{"^/sandbox/alice", "sandbox/alice"},
+		{"^/", ""},
+	}
+	for _, tt := range specs {
+		assert.Equal(t, tt.want, svnBranch(tt.relativeUrl), tt.relativeUrl)
+	}
+}
+
+func TestSvnProvider(t *testing.T) {
+	defer fakeVcs(map[string]string{
+		"svn info --xml --non-interactive .": `<?xml version="1.0" encoding="UTF-8"?>
+<info>
+<entry kind="dir" path="." revision="1234">
+<url>https://svn.example.com/repo/branches/release-1.2</url>
+<relative-url>^/branches/release-1.2</relative-url>
+<wc-info>
+<wcroot-abspath>/work/release</wcroot-abspath>
+</wc-info>
+<commit revision="1230">
+<author>alice</author>
+<date>2019-05-01T00:00:00.000000Z</date>
+</commit>
+</entry>
+</info>`,
+		"svn status --xml --non-interactive --quiet .": `<?xml version="1.0" encoding="UTF-8"?>
+<status>
+<target path=".">
+<entry path="src/flags.go">
+<wc-status props="none" item="modified" revision="1234"></wc-status>
+</entry>
+<entry path="src/new.go">
+<wc-status props="none" item="added" revision="-1"></wc-status>
+</entry>
+</target>
+</status>`,
+	})()
+
+	root, err := svnProvider{}.root("/work/release/src")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash("/work/release"), root)
+
+	rev, err := svnProvider{}.revision(root)
+	require.NoError(t, err)
+	assert.Equal(t, vcsRevision{
+		Revision:     "1234",
+		Branch:       "release-1.2",
+		Time:         1556668800,
+		ChangedFiles: []string{"src/flags.go", "src/new.go"},
+	}, rev)
+}
+
+func TestPerforceProvider(t *testing.T) {
+	defer fakeVcs(map[string]string{
+		"p4 -ztag -F %clientRoot% info":                    "/work/main\n",
+		"p4 -ztag -F %change% %time% changes -m1 ...#have": "4321 1556668800\n",
+		"p4 -ztag -F %Stream% client -o":                   "//depot/main\n",
+		"p4 -ztag -F %clientFile% opened ...":              "//alice-main/src/flags.go\n",
+	})()
+
+	root, err := perforceProvider{}.root("/work/main/src")
+	require.NoError(t, err)
+	assert.Equal(t, "/work/main", root)
+
+	rev, err := perforceProvider{}.revision(root)
+	require.NoError(t, err)
+	assert.Equal(t, vcsRevision{
+		Revision:     "4321",
+		Branch:       "depot/main",
+		Time:         1556668800,
+		ChangedFiles: []string{"src/flags.go"},
+	}, rev)
+}
+
+func TestPerforceProvider_notSynced(t *testing.T) {
+	defer fakeVcs(map[string]string{
+		"p4 -ztag -F %change% %time% changes -m1 ...#have": "",
+	})()
+	_, err := perforceProvider{}.revision("/work/main")
+	require.EqualError(t, err, "no changelists have been synced to the workspace")
+}
+
+func TestIsAncestor_numberedRevisions(t *testing.T) {
+	c := Client{Vcs: VcsSubversion, GitSha: "1234"}
+	for sha, want := range map[string]bool{"1000": true, "1234": true, "1235": false} {
+		got, err := c.IsAncestor(sha)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, sha)
+	}
+	_, err := c.IsAncestor("a1b2c3")
+	require.Error(t, err)
+}
+
+func TestListFiles_walksWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, f := range []string{"a.go", "src/b.go", "src/c.txt", ".svn/wc.db", "src/.svn/entries"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0700))
+		require.NoError(t, ioutil.WriteFile(p, []byte("flag"), 0600))
+	}
+
+	c := Client{Vcs: VcsSubversion, Workspace: dir}
+	files, err := c.listFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.go", "src/b.go", "src/c.txt"}, files)
+
+	c.Roots = []string{"src"}
+	c.IncludeGlobs, err = IncludeGlobs("go")
+	require.NoError(t, err)
+	files, err = c.listFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"src/b.go"}, files)
+}
+
+func TestReloadRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		require.NoError(t, cmd.Run())
+	}
+	git("init", "-q")
+	git("checkout", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+
+	c, err := NewVcsClient(VcsGit, dir)
+	require.NoError(t, err)
+	head, err := c.CheckedOutRevision()
+	require.NoError(t, err)
+	assert.Equal(t, c.GitSha, head)
+
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	head, err = c.CheckedOutRevision()
+	require.NoError(t, err)
+	assert.NotEqual(t, c.GitSha, head)
+
+	require.NoError(t, c.ReloadRevision())
+	assert.Equal(t, head, c.GitSha)
+	assert.Equal(t, "main", c.GitBranch)
+}
@@ -0,0 +1,106 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// walkArgs returns the search tool arguments limiting the directories a search descends into to maxDepth levels
+// below each search path, and to the file system of each search path if oneFileSystem is true.
+func walkArgs(searchTool string, maxDepth int, oneFileSystem bool) []string {
+	args := []string{}
+	if searchTool == SearchToolRg {
+		if maxDepth > 0 {
+			args = append(args, fmt.Sprintf("--max-depth=%d", maxDepth))
+		}
+		if oneFileSystem {
+			args = append(args, "--one-file-system")
+		}
+		return args
+	}
+	// ag's depth is the number of directories descended into, so files in the search path are at depth 0
+	if maxDepth > 0 {
+		args = append(args, fmt.Sprintf("--depth=%d", maxDepth-1))
+	}
+	if oneFileSystem {
+		args = append(args, "--one-device")
+	}
+	return args
+}
+
+// fileRoot returns the longest of roots containing the path, relative to the workspace. If roots is empty, the
+// workspace is the only root, and "" is returned.
+func fileRoot(p string, roots []string) string {
+	root := ""
+	for _, r := range roots {
+		if (p == r || strings.HasPrefix(p, r+"/")) && len(r) > len(root) {
+			root = r
+		}
+	}
+	return root
+}
+
+// inRoots returns true if p is in any of roots, or roots is empty.
+func inRoots(p string, roots []string) bool {
+	if len(roots) == 0 {
+		return true
+	}
+	for _, r := range roots {
+		if p == r || strings.HasPrefix(p, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// fileDepth returns the number of path elements of p below root, so files directly in root are at depth 1.
+func fileDepth(p, root string) int {
+	if root != "" {
+		p = strings.TrimPrefix(p, root+"/")
+	}
+	return strings.Count(p, "/") + 1
+}
+
+// walkFiles removes the files, relative to the workspace, which are more than MaxDepth levels below their root, or
+// on a different file system than their root when OneFileSystem is true, e.g. in bind mounted volumes.
+func (c Client) walkFiles(files []string) []string {
+	if c.MaxDepth <= 0 && !c.OneFileSystem {
+		return files
+	}
+	// Devices are looked up once per directory
+	devices := map[string]uint64{}
+	device := func(dir string) (uint64, bool) {
+		if d, ok := devices[dir]; ok {
+			return d, true
+		}
+		info, err := os.Stat(filepath.Join(c.Workspace, filepath.FromSlash(dir)))
+		if err != nil {
+			return 0, false
+		}
+		d, ok := deviceId(info)
+		if ok {
+			devices[dir] = d
+		}
+		return d, ok
+	}
+
+	ret := make([]string, 0, len(files))
+	for _, f := range files {
+		root := fileRoot(f, c.Roots)
+		if c.MaxDepth > 0 && fileDepth(f, root) > c.MaxDepth {
+			continue
+		}
+		if c.OneFileSystem {
+			rootDevice, ok := device(root)
+			fileDevice, fileOk := device(path.Dir(f))
+			if ok && fileOk && rootDevice != fileDevice {
+				continue
+			}
+		}
+		ret = append(ret, f)
+	}
+	return ret
+}
@@ -0,0 +1,33 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_walkArgs(t *testing.T) {
+	require.Empty(t, walkArgs(SearchToolRg, 0, false))
+	require.Equal(t, []string{"--max-depth=2", "--one-file-system"}, walkArgs(SearchToolRg, 2, true))
+	require.Equal(t, []string{"--depth=1", "--one-device"}, walkArgs(SearchToolAg, 2, true))
+}
+
+func Test_fileDepth(t *testing.T) {
+	require.Equal(t, 1, fileDepth("a.go", fileRoot("a.go", nil)))
+	require.Equal(t, 3, fileDepth("services/api/a.go", fileRoot("services/api/a.go", nil)))
+	require.Equal(t, 1, fileDepth("services/api/a.go", fileRoot("services/api/a.go", []string{"services", "services/api"})))
+	require.Equal(t, 2, fileDepth("services/web/a.go", fileRoot("services/web/a.go", []string{"services", "services/api"})))
+	require.Equal(t, "", fileRoot("services/apiv2/a.go", []string{"services/api"}))
+}
+
+func Test_walkFiles(t *testing.T) {
+	files := []string{"a.go", "sub/b.go", "sub/deeper/c.go"}
+	client := Client{Workspace: "."}
+	require.Equal(t, files, client.walkFiles(files))
+
+	client.MaxDepth = 2
+	require.Equal(t, []string{"a.go", "sub/b.go"}, client.walkFiles(files))
+
+	client.Roots = []string{"sub"}
+	require.Equal(t, []string{"sub/b.go", "sub/deeper/c.go"}, client.walkFiles(files[1:]))
+}
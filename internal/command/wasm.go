@@ -0,0 +1,37 @@
+package command
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// wasmRuntime is the WASI runtime WASM matcher plugins are run with. It must be in the PATH.
+var wasmRuntime = "wasmtime"
+
+// isWasm returns true if the plugin is a WASM module, rather than an executable.
+func (p Plugin) isWasm() bool {
+	return strings.EqualFold(filepath.Ext(p.Command), ".wasm")
+}
+
+// wasmArgs returns the runtime arguments which run a WASM plugin. No directories or environment variables are
+// passed to the module, so it can only read the request on stdin, and write its matches to stdout and stderr.
+func (c Client) wasmArgs(plugin Plugin) []string {
+	module := plugin.Command
+	if !filepath.IsAbs(module) {
+		module = filepath.Join(c.Workspace, filepath.FromSlash(module))
+	}
+	return []string{"run", module}
+}
+
+// checkWasmRuntime returns an error if the plugin is a WASM module, and the runtime isn't installed.
+func checkWasmRuntime(plugin Plugin) error {
+	if !plugin.isWasm() {
+		return nil
+	}
+	if _, err := exec.LookPath(wasmRuntime); err != nil {
+		return fmt.Errorf("WASM matcher plugin %s requires %s, which was not found in the PATH: %s", plugin.Command, wasmRuntime, err)
+	}
+	return nil
+}
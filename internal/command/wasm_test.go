@@ -0,0 +1,46 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_searchPluginsWasm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake WASM runtime requires a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "wasm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.dsl"), []byte("FLAG(MY_FLAG)\n"), 0644))
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	// The fake runtime only reports a match if it's sent the file's contents
+	bin, err := ioutil.TempDir("", "wasm-bin")
+	require.NoError(t, err)
+	defer os.RemoveAll(bin)
+	fakeRuntime := filepath.Join(bin, "wasmtime")
+	require.NoError(t, ioutil.WriteFile(fakeRuntime, []byte(`#!/bin/sh
+[ "$1" = run ] || exit 1
+grep -q '"content":"FLAG(MY_FLAG)\\n"' || exit 2
+echo '{"matches": [{"line": 1, "flagKey": "my-flag"}]}'
+`), 0755))
+	defer func(r string) { wasmRuntime = r }(wasmRuntime)
+	wasmRuntime = fakeRuntime
+
+	client := Client{Workspace: dir, SearchStrategy: SearchStrategyNative, Plugins: []Plugin{{Globs: []string{"*.dsl"}, Command: "plugins/dsl.wasm"}}}
+	require.Equal(t, []string{"run", filepath.Join(dir, "plugins", "dsl.wasm")}, client.wasmArgs(client.Plugins[0]))
+	results, err := client.SearchForFlags([]string{"my-flag"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{append(resultLine("a.dsl", ":", 1, "FLAG(MY_FLAG)"), "my-flag")}, results)
+
+	wasmRuntime = filepath.Join(bin, "missing")
+	_, err = client.SearchForFlags([]string{"my-flag"}, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,48 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Worktree checks out rev, which may be any commit-ish, e.g. a tag or sha, in a new git worktree at dir, which must
+// not exist. It returns a client for searching the worktree, which searches the same directories as c.
+// RemoveWorktree must be called when the worktree is no longer needed.
+func (c Client) Worktree(rev, dir string) (Client, error) {
+	sha, err := c.revParse(rev + "^{commit}")
+	if err != nil {
+		return c, fmt.Errorf("could not find commit %s: %s", rev, err)
+	}
+	commitTime, err := c.commitTime(sha)
+	if err != nil {
+		return c, fmt.Errorf("error parsing commit timestamp: %s", err)
+	}
+	// The workspace may be a subdirectory of the repository, if paths are relative to it
+	prefix, err := exec.Command("git", "-C", c.Workspace, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return c, err
+	}
+	out, err := niceCommand(context.Background(), "git", "-C", c.Workspace, "worktree", "add", "--detach", dir, sha).CombinedOutput()
+	if err != nil {
+		return c, fmt.Errorf("could not check out %s: %s", rev, strings.TrimSpace(string(out)))
+	}
+
+	worktree := c
+	worktree.Workspace = filepath.Join(dir, filepath.FromSlash(strings.TrimSpace(string(prefix))))
+	worktree.GitBranch = rev
+	worktree.GitSha = sha
+	worktree.GitTimestamp = commitTime
+	return worktree, nil
+}
+
+// RemoveWorktree removes a worktree created by Worktree.
+func (c Client) RemoveWorktree(dir string) error {
+	out, err := exec.Command("git", "-C", c.Workspace, "worktree", "remove", "--force", dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not remove worktree %s: %s", dir, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
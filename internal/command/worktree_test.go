@@ -0,0 +1,50 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorktree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worktree")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_COMMITTER_DATE=2019-05-01T00:00:00Z")
+		require.NoError(t, cmd.Run())
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "a.go"), []byte("flag-1\n"), 0644))
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+	git("tag", "v1")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "a.go"), []byte("flag-2\n"), 0644))
+	git("commit", "-q", "-am", "second")
+
+	client := Client{Workspace: filepath.Join(dir, "sub"), SearchStrategy: SearchStrategyNative}
+	worktreeDir := filepath.Join(dir, "..", filepath.Base(dir)+"-v1")
+	defer os.RemoveAll(worktreeDir)
+	worktree, err := client.Worktree("v1", worktreeDir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(worktreeDir, "sub"), worktree.Workspace)
+	require.Equal(t, int64(1556668800), worktree.GitTimestamp)
+
+	results, err := worktree.SearchForFlags([]string{"flag-1", "flag-2"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{resultLine("a.go", ":", 1, "flag-1")}, results)
+
+	require.NoError(t, client.RemoveWorktree(worktreeDir))
+	_, err = os.Stat(worktreeDir)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = client.Worktree("missing", worktreeDir)
+	require.Error(t, err)
+}
@@ -0,0 +1,237 @@
+// Package container maps the environment of CI services and Docker containers to scanner options.
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// Environment is a CI service or container runtime the scanner may be running in.
+type Environment string
+
+const (
+	GitHubActions      Environment = "GitHub Actions"
+	BitbucketPipelines Environment = "Bitbucket Pipelines"
+	Docker             Environment = "Docker"
+	Unknown            Environment = ""
+)
+
+// workspaceMounts are the directories repositories are conventionally mounted at in containers, in order of preference.
+var workspaceMounts = []string{"/github/workspace", "/workspace", "/repo", "/src"}
+
+// containerMarkers are files created by container runtimes in the root of a container's filesystem.
+var containerMarkers = []string{"/.dockerenv", "/run/.containerenv"}
+
+// Detect returns the environment the scanner is running in.
+func Detect() Environment {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true" || os.Getenv("GITHUB_WORKSPACE") != "":
+		return GitHubActions
+	case os.Getenv("BITBUCKET_CLONE_DIR") != "":
+		return BitbucketPipelines
+	}
+	for _, path := range containerMarkers {
+		if _, err := os.Stat(path); err == nil {
+			return Docker
+		}
+	}
+	return Unknown
+}
+
+// Options returns option values for an environment. Options provided as LD_ prefixed environment variables
+// take precedence over values derived from the environment.
+func Options(env Environment) (map[string]string, error) {
+	var opts map[string]string
+	var err error
+	switch env {
+	case GitHubActions:
+		opts, err = GitHubActionsOptions()
+	case BitbucketPipelines:
+		opts = BitbucketPipelinesOptions()
+	default:
+		opts = map[string]string{}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range o.EnvOptions() {
+		opts[k] = v
+	}
+	if opts["dir"] == "" {
+		opts["dir"] = mountedWorkspace()
+		if opts["dir"] == "" {
+			return nil, fmt.Errorf("could not find a repository to scan: mount the repository at one of %s, or set %s", strings.Join(workspaceMounts, ", "), o.EnvVarName(o.Dir))
+		}
+	}
+
+	for k, v := range opts {
+		if v == "" {
+			delete(opts, k)
+		}
+	}
+	return opts, nil
+}
+
+func mountedWorkspace() string {
+	for _, path := range workspaceMounts {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// GitHubActionsOptions returns option values derived from the GitHub Actions environment and event payload.
+func GitHubActionsOptions() (map[string]string, error) {
+	ghRepo := strings.Split(os.Getenv("GITHUB_REPOSITORY"), "/")
+	if len(ghRepo) < 2 {
+		return nil, fmt.Errorf("unable to validate GitHub repository name: %s", ghRepo)
+	}
+	event, err := parseEvent(os.Getenv("GITHUB_EVENT_PATH"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing GitHub event payload at %s: %s", os.Getenv("GITHUB_EVENT_PATH"), err)
+	}
+
+	return map[string]string{
+		"repoType":         "github",
+		"repoName":         ghRepo[1],
+		"dir":              os.Getenv("GITHUB_WORKSPACE"),
+		"updateSequenceId": strconv.FormatInt(event.Repo.PushedAt*1000, 10), // seconds to milliseconds
+		"defaultBranch":    event.Repo.DefaultBranch,
+		"repoUrl":          event.Repo.Url,
+	}, nil
+}
+
+// BitbucketPipelinesOptions returns option values derived from the Bitbucket Pipelines environment.
+func BitbucketPipelinesOptions() map[string]string {
+	return map[string]string{
+		"repoType":         "bitbucket",
+		"repoName":         os.Getenv("BITBUCKET_REPO_SLUG"),
+		"dir":              os.Getenv("BITBUCKET_CLONE_DIR"),
+		"repoUrl":          os.Getenv("BITBUCKET_GIT_HTTP_ORIGIN"),
+		"updateSequenceId": os.Getenv("BITBUCKET_BUILD_NUMBER"),
+	}
+}
+
+// JobUrl returns the url of the CI job the scanner is running in, if it can be determined from the environment.
+func JobUrl() string {
+	if runId := os.Getenv("GITHUB_RUN_ID"); runId != "" {
+		server := os.Getenv("GITHUB_SERVER_URL")
+		if server == "" {
+			server = "https://github.com"
+		}
+		return fmt.Sprintf("%s/%s/actions/runs/%s", server, os.Getenv("GITHUB_REPOSITORY"), runId)
+	}
+	if origin, build := os.Getenv("BITBUCKET_GIT_HTTP_ORIGIN"), os.Getenv("BITBUCKET_BUILD_NUMBER"); origin != "" && build != "" {
+		return fmt.Sprintf("%s/addon/pipelines/home#!/results/%s", origin, build)
+	}
+	// GitLab CI, Jenkins, and CircleCI
+	for _, name := range []string{"CI_JOB_URL", "BUILD_URL", "CIRCLE_BUILD_URL"} {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Runner returns the name of the CI runner the scanner is running on, or the hostname if it can't be determined
+// from the environment.
+func Runner() string {
+	// GitHub Actions, GitLab CI, and Jenkins
+	for _, name := range []string{"RUNNER_NAME", "CI_RUNNER_DESCRIPTION", "NODE_NAME"} {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+type event struct {
+	Repo   repo   `json:"repository"`
+	Sender sender `json:"sender"`
+}
+
+type repo struct {
+	Url           string `json:"html_url"`
+	DefaultBranch string `json:"default_branch"`
+	PushedAt      int64  `json:"pushed_at"`
+}
+
+type sender struct {
+	Username string `json:"login"`
+}
+
+func parseEvent(path string) (*event, error) {
+	eventJsonBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var evt event
+	err = json.Unmarshal(eventJsonBytes, &evt)
+	if err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// CheckWorkspace validates that the mounted repository at dir can be scanned by the current user. Git refuses
+// to operate on repositories owned by other users, which is common when a container runs as a different uid
+// than the CI runner that checked out the repository, so dir is marked as a safe directory for git commands
+// run by this process.
+func CheckWorkspace(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("repository at %s is not accessible: %s", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("repository at %s is not a directory", dir)
+	}
+
+	f, err := os.Open(dir)
+	if err == nil {
+		_, err = f.Readdirnames(1)
+		f.Close()
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("repository at %s is not readable by uid %d, run the container as a user with read access to the repository: %s", dir, os.Getuid(), err)
+	}
+
+	if uid, ok := owner(info); ok && uid != os.Getuid() {
+		log.Debug.Printf("repository at %s is owned by uid %d, but the scanner is running as uid %d. marking it as a safe directory for git", dir, uid, os.Getuid())
+		return trustDirectory(dir)
+	}
+	return nil
+}
+
+// trustDirectory adds dir to git's safe.directory config for child processes, using git's GIT_CONFIG_* environment variables.
+func trustDirectory(dir string) error {
+	n := 0
+	if count := os.Getenv("GIT_CONFIG_COUNT"); count != "" {
+		var err error
+		n, err = strconv.Atoi(count)
+		if err != nil {
+			return fmt.Errorf("invalid GIT_CONFIG_COUNT: %s", err)
+		}
+	}
+	for k, v := range map[string]string{
+		fmt.Sprintf("GIT_CONFIG_KEY_%d", n):   "safe.directory",
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d", n): dir,
+		"GIT_CONFIG_COUNT":                    strconv.Itoa(n + 1),
+	} {
+		err := os.Setenv(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
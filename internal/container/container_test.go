@@ -0,0 +1,122 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+func TestMain(m *testing.M) {
+	log.Init(false)
+	os.Exit(m.Run())
+}
+
+// setenv sets environment variables, and returns a function that restores their previous values.
+func setenv(t *testing.T, env map[string]string) func() {
+	prev := map[string]*string{}
+	for k, v := range env {
+		if p, ok := os.LookupEnv(k); ok {
+			prev[k] = &p
+		} else {
+			prev[k] = nil
+		}
+		require.NoError(t, os.Setenv(k, v))
+	}
+	return func() {
+		for k, p := range prev {
+			if p != nil {
+				os.Setenv(k, *p)
+			} else {
+				os.Unsetenv(k)
+			}
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	eventPath := filepath.Join(dir, "event.json")
+	require.NoError(t, ioutil.WriteFile(eventPath, []byte(`{"repository": {"html_url": "https://github.com/org/repo", "default_branch": "main", "pushed_at": 1}}`), 0644))
+
+	t.Run("GitHub Actions", func(t *testing.T) {
+		defer setenv(t, map[string]string{
+			"GITHUB_REPOSITORY": "org/repo",
+			"GITHUB_EVENT_PATH": eventPath,
+			"GITHUB_WORKSPACE":  dir,
+			"LD_PROJ_KEY":       "proj",
+			"LD_REPO_NAME":      "override",
+		})()
+		opts, err := Options(GitHubActions)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{
+			"repoType":         "github",
+			"repoName":         "override",
+			"dir":              dir,
+			"updateSequenceId": "1000",
+			"defaultBranch":    "main",
+			"repoUrl":          "https://github.com/org/repo",
+			"projKey":          "proj",
+		}, opts)
+	})
+
+	t.Run("GitHub Actions with invalid repository", func(t *testing.T) {
+		defer setenv(t, map[string]string{"GITHUB_REPOSITORY": "repo"})()
+		_, err := Options(GitHubActions)
+		require.Error(t, err)
+	})
+
+	t.Run("Docker with dir", func(t *testing.T) {
+		defer setenv(t, map[string]string{"LD_DIR": dir})()
+		opts, err := Options(Docker)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"dir": dir}, opts)
+	})
+}
+
+func TestJobUrl(t *testing.T) {
+	unset := map[string]string{"GITHUB_RUN_ID": "", "GITHUB_SERVER_URL": "", "BITBUCKET_BUILD_NUMBER": "", "CI_JOB_URL": "", "BUILD_URL": "", "CIRCLE_BUILD_URL": ""}
+	defer setenv(t, unset)()
+	require.Equal(t, "", JobUrl())
+
+	restore := setenv(t, map[string]string{"GITHUB_RUN_ID": "42", "GITHUB_REPOSITORY": "org/repo"})
+	require.Equal(t, "https://github.com/org/repo/actions/runs/42", JobUrl())
+	restore()
+
+	restore = setenv(t, map[string]string{"BITBUCKET_BUILD_NUMBER": "7", "BITBUCKET_GIT_HTTP_ORIGIN": "https://bitbucket.org/org/repo"})
+	require.Equal(t, "https://bitbucket.org/org/repo/addon/pipelines/home#!/results/7", JobUrl())
+	restore()
+
+	restore = setenv(t, map[string]string{"BUILD_URL": "https://jenkins.example.com/job/scan/1/"})
+	require.Equal(t, "https://jenkins.example.com/job/scan/1/", JobUrl())
+	restore()
+}
+
+func TestCheckWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "container")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, CheckWorkspace(dir))
+	require.Error(t, CheckWorkspace(filepath.Join(dir, "missing")))
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0644))
+	require.Error(t, CheckWorkspace(file))
+}
+
+func TestTrustDirectory(t *testing.T) {
+	defer setenv(t, map[string]string{"GIT_CONFIG_COUNT": "1", "GIT_CONFIG_KEY_1": "", "GIT_CONFIG_VALUE_1": ""})()
+
+	require.NoError(t, trustDirectory("/workspace"))
+	require.Equal(t, "2", os.Getenv("GIT_CONFIG_COUNT"))
+	require.Equal(t, "safe.directory", os.Getenv("GIT_CONFIG_KEY_1"))
+	require.Equal(t, "/workspace", os.Getenv("GIT_CONFIG_VALUE_1"))
+}
@@ -0,0 +1,17 @@
+// +build !windows
+
+package container
+
+import (
+	"os"
+	"syscall"
+)
+
+// owner returns the uid of the owner of a file.
+func owner(info os.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
@@ -0,0 +1,8 @@
+package container
+
+import "os"
+
+// owner is not supported on Windows, where file ownership is not represented by a uid.
+func owner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,59 @@
+package container
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PullRequest is a pull or merge request being built by a CI service.
+type PullRequest struct {
+	// Number identifies the pull request, if known.
+	Number string
+	// SourceBranch is the name of the branch the pull request merges, if known.
+	SourceBranch string
+}
+
+// pullRequestRefRegex matches the refs CI services check out to build pull requests, e.g. refs/pull/42/merge on
+// GitHub, refs/merge-requests/42/head on GitLab, or refs/pull-requests/42/from on Bitbucket Server.
+var pullRequestRefRegex = regexp.MustCompile(`^(?:refs/)?(?:remotes/(?:[^/]+/)?)?(?:pull|pull-requests|merge-requests)/(\d+)/(?:merge|head|from)$`)
+
+// pullRequestEnvs are the environment variables identifying a pull request, and its source branch, set by CI services.
+// Where number may also be set when building a branch, reason must also start with reasonPrefix. CircleCI builds
+// branches, even when they have pull requests, so only its pull request refs are detected.
+var pullRequestEnvs = []struct{ number, sourceBranch, reason, reasonPrefix string }{
+	{"CI_MERGE_REQUEST_IID", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "", ""},                                    // GitLab CI
+	{"BITBUCKET_PR_ID", "BITBUCKET_BRANCH", "", ""},                                                            // Bitbucket Pipelines
+	{"CHANGE_ID", "CHANGE_BRANCH", "BRANCH_NAME", "PR-"},                                                       // Jenkins multibranch pipelines
+	{"CHANGE_ID", "CHANGE_BRANCH", "BRANCH_NAME", "MR-"},                                                       // Jenkins multibranch pipelines for GitLab
+	{"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER", "SYSTEM_PULLREQUEST_SOURCEBRANCH", "BUILD_REASON", "PullRequest"}, // Azure Pipelines
+}
+
+// IsPullRequestRef returns true if ref is a ref checked out to build a pull request, rather than a branch.
+func IsPullRequestRef(ref string) bool {
+	return pullRequestRefRegex.MatchString(ref)
+}
+
+// DetectPullRequest returns the pull request being built, if the CI environment indicates one, or ref, the name of
+// the scanned branch, is a pull request ref.
+func DetectPullRequest(ref string) (PullRequest, bool) {
+	if event := os.Getenv("GITHUB_EVENT_NAME"); event == "pull_request" || event == "pull_request_target" {
+		pr := PullRequest{SourceBranch: os.Getenv("GITHUB_HEAD_REF")}
+		if m := pullRequestRefRegex.FindStringSubmatch(os.Getenv("GITHUB_REF")); m != nil {
+			pr.Number = m[1]
+		}
+		return pr, true
+	}
+	for _, env := range pullRequestEnvs {
+		if env.reason != "" && !strings.HasPrefix(os.Getenv(env.reason), env.reasonPrefix) {
+			continue
+		}
+		if number := os.Getenv(env.number); number != "" {
+			return PullRequest{Number: number, SourceBranch: strings.TrimPrefix(os.Getenv(env.sourceBranch), "refs/heads/")}, true
+		}
+	}
+	if m := pullRequestRefRegex.FindStringSubmatch(ref); m != nil {
+		return PullRequest{Number: m[1]}, true
+	}
+	return PullRequest{}, false
+}
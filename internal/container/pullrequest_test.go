@@ -0,0 +1,69 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPullRequest(t *testing.T) {
+	unset := map[string]string{"GITHUB_EVENT_NAME": "", "GITHUB_REF": "", "GITHUB_HEAD_REF": ""}
+	for _, env := range pullRequestEnvs {
+		unset[env.number] = ""
+		unset[env.sourceBranch] = ""
+		if env.reason != "" {
+			unset[env.reason] = ""
+		}
+	}
+	defer setenv(t, unset)()
+
+	_, ok := DetectPullRequest("main")
+	require.False(t, ok)
+
+	pr, ok := DetectPullRequest("refs/pull/42/merge")
+	require.True(t, ok)
+	require.Equal(t, PullRequest{Number: "42"}, pr)
+
+	restore := setenv(t, map[string]string{"GITHUB_EVENT_NAME": "pull_request", "GITHUB_REF": "refs/pull/7/merge", "GITHUB_HEAD_REF": "feature"})
+	pr, ok = DetectPullRequest("")
+	require.True(t, ok)
+	require.Equal(t, PullRequest{Number: "7", SourceBranch: "feature"}, pr)
+	restore()
+
+	// Set when building a branch which has a pull request
+	restore = setenv(t, map[string]string{"CIRCLE_PULL_REQUEST": "https://github.com/org/repo/pull/9", "CIRCLE_BRANCH": "fix"})
+	_, ok = DetectPullRequest("fix")
+	require.False(t, ok)
+	restore()
+
+	restore = setenv(t, map[string]string{"CHANGE_ID": "5", "CHANGE_BRANCH": "fix", "BRANCH_NAME": "fix"})
+	_, ok = DetectPullRequest("fix")
+	require.False(t, ok)
+	restore()
+
+	restore = setenv(t, map[string]string{"CHANGE_ID": "5", "CHANGE_BRANCH": "fix", "BRANCH_NAME": "PR-5"})
+	pr, ok = DetectPullRequest("")
+	require.True(t, ok)
+	require.Equal(t, PullRequest{Number: "5", SourceBranch: "fix"}, pr)
+	restore()
+
+	restore = setenv(t, map[string]string{"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER": "3", "SYSTEM_PULLREQUEST_SOURCEBRANCH": "refs/heads/topic", "BUILD_REASON": "IndividualCI"})
+	_, ok = DetectPullRequest("topic")
+	require.False(t, ok)
+	restore()
+
+	restore = setenv(t, map[string]string{"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER": "3", "SYSTEM_PULLREQUEST_SOURCEBRANCH": "refs/heads/topic", "BUILD_REASON": "PullRequest"})
+	pr, ok = DetectPullRequest("")
+	require.True(t, ok)
+	require.Equal(t, PullRequest{Number: "3", SourceBranch: "topic"}, pr)
+	restore()
+}
+
+func TestIsPullRequestRef(t *testing.T) {
+	for _, ref := range []string{"refs/pull/1/merge", "pull/1/head", "refs/remotes/origin/pull/1/merge", "merge-requests/12/head", "refs/pull-requests/5/from"} {
+		require.True(t, IsPullRequestRef(ref), ref)
+	}
+	for _, ref := range []string{"main", "merge", "feature/pull/1", "pull/abc/merge"} {
+		require.False(t, IsPullRequestRef(ref), ref)
+	}
+}
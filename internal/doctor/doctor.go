@@ -0,0 +1,268 @@
+// Package doctor diagnoses and installs the external tools required by the scanner.
+package doctor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+)
+
+// RipgrepVersion is the version of ripgrep downloaded by DownloadRipgrep.
+const RipgrepVersion = "14.1.0"
+
+// ripgrepReleaseUrl is the url ripgrep release assets are downloaded from.
+var ripgrepReleaseUrl = "https://github.com/BurntSushi/ripgrep/releases/download/" + RipgrepVersion
+
+// ripgrepTargets maps GOOS/GOARCH to the target of the statically linked ripgrep release for that platform.
+var ripgrepTargets = map[string]string{
+	"linux/amd64":  "x86_64-unknown-linux-musl",
+	"linux/arm64":  "aarch64-unknown-linux-gnu",
+	"darwin/amd64": "x86_64-apple-darwin",
+	"darwin/arm64": "aarch64-apple-darwin",
+}
+
+// packageManagers are detected in order of preference for each GOOS.
+var packageManagers = map[string][]string{
+	"darwin":  {"brew", "port"},
+	"linux":   {"apt-get", "apk", "dnf", "yum", "pacman", "zypper"},
+	"windows": {"choco", "scoop"},
+}
+
+// packages maps package managers to the install command and package names for each tool.
+var packages = map[string]struct {
+	install []string
+	tools   map[string]string
+}{
+	"brew":    {[]string{"brew", "install"}, map[string]string{"git": "git", "ag": "the_silver_searcher", "rg": "ripgrep"}},
+	"port":    {[]string{"port", "install"}, map[string]string{"git": "git", "ag": "the_silver_searcher", "rg": "ripgrep"}},
+	"apt-get": {[]string{"apt-get", "install", "-y"}, map[string]string{"git": "git", "ag": "silversearcher-ag", "rg": "ripgrep"}},
+	"apk":     {[]string{"apk", "add", "--no-cache"}, map[string]string{"git": "git", "ag": "the_silver_searcher", "rg": "ripgrep"}},
+	"dnf":     {[]string{"dnf", "install", "-y"}, map[string]string{"git": "git", "ag": "the_silver_searcher", "rg": "ripgrep"}},
+	"yum":     {[]string{"yum", "install", "-y"}, map[string]string{"git": "git", "ag": "the_silver_searcher", "rg": "ripgrep"}},
+	"pacman":  {[]string{"pacman", "-S", "--noconfirm"}, map[string]string{"git": "git", "ag": "the_silver_searcher", "rg": "ripgrep"}},
+	"zypper":  {[]string{"zypper", "install", "-y"}, map[string]string{"git": "git", "ag": "the_silver_searcher", "rg": "ripgrep"}},
+	"choco":   {[]string{"choco", "install", "-y"}, map[string]string{"git": "git", "ag": "ag", "rg": "ripgrep"}},
+	"scoop":   {[]string{"scoop", "install"}, map[string]string{"git": "git", "ag": "ag", "rg": "ripgrep"}},
+}
+
+// ToolStatus describes whether a tool is installed.
+type ToolStatus struct {
+	Name        string
+	Description string
+	Path        string
+	Version     string
+}
+
+// Found returns true if the tool is installed.
+func (t ToolStatus) Found() bool {
+	return t.Path != ""
+}
+
+// Check returns the status of git and each supported search tool.
+func Check(toolCacheDir string) []ToolStatus {
+	tools := []ToolStatus{
+		{Name: "git", Description: "Git"},
+		{Name: command.SearchToolAg, Description: "The Silver Searcher"},
+		{Name: command.SearchToolRg, Description: "ripgrep"},
+	}
+	for i, t := range tools {
+		path, err := command.LookTool(t.Name, toolCacheDir)
+		if err != nil {
+			continue
+		}
+		tools[i].Path = path
+		out, err := exec.Command(path, "--version").Output()
+		if err == nil {
+			tools[i].Version = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+		}
+	}
+	return tools
+}
+
+// Report writes the status of each tool, and instructions for installing missing tools. It returns true if
+// git and at least one search tool are installed.
+func Report(w io.Writer, toolCacheDir string) bool {
+	tools := Check(toolCacheDir)
+	searchToolFound := false
+	for _, t := range tools {
+		status := "not found"
+		if t.Found() {
+			status = t.Path
+			if t.Version != "" {
+				status += " (" + t.Version + ")"
+			}
+			if t.Name != "git" {
+				searchToolFound = true
+			}
+		}
+		fmt.Fprintf(w, "%-4s %-22s %s\n", t.Name, "("+t.Description+")", status)
+	}
+	ok := tools[0].Found() && searchToolFound
+	if ok {
+		return true
+	}
+
+	fmt.Fprintln(w)
+	pm := DetectPackageManager(runtime.GOOS)
+	if pm == "" {
+		fmt.Fprintln(w, "No supported package manager was found.")
+	} else {
+		if !tools[0].Found() {
+			fmt.Fprintf(w, "To install git, run: %s\n", strings.Join(InstallCommand(pm, "git"), " "))
+		}
+		if !searchToolFound {
+			fmt.Fprintf(w, "To install a search tool, run: %s\n", strings.Join(InstallCommand(pm, command.SearchToolRg), " "))
+			fmt.Fprintln(w, "or run `ld-find-code-refs doctor -install rg`")
+		}
+	}
+	if !searchToolFound {
+		if _, ok := ripgrepTargets[runtime.GOOS+"/"+runtime.GOARCH]; ok {
+			fmt.Fprintf(w, "To download ripgrep %s without a package manager, run `ld-find-code-refs doctor -download`\n", RipgrepVersion)
+		}
+	}
+	return false
+}
+
+// DetectPackageManager returns the first supported package manager found in the system PATH for goos.
+func DetectPackageManager(goos string) string {
+	for _, pm := range packageManagers[goos] {
+		if _, err := exec.LookPath(pm); err == nil {
+			return pm
+		}
+	}
+	return ""
+}
+
+// InstallCommand returns the command used to install a tool with a package manager.
+func InstallCommand(pm, tool string) []string {
+	p, ok := packages[pm]
+	if !ok {
+		return nil
+	}
+	return append(append([]string{}, p.install...), p.tools[tool])
+}
+
+// Install installs a tool with the system package manager, writing the package manager's output to w.
+func Install(tool string, w io.Writer) error {
+	pm := DetectPackageManager(runtime.GOOS)
+	if pm == "" {
+		return fmt.Errorf("no supported package manager was found")
+	}
+	args := InstallCommand(pm, tool)
+	fmt.Fprintf(w, "running: %s\n", strings.Join(args, " "))
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+// DownloadRipgrep downloads the statically linked ripgrep release for goos/goarch, verifies its checksum,
+// and installs the rg binary in toolCacheDir. It returns the path of the installed binary.
+func DownloadRipgrep(toolCacheDir, goos, goarch string) (string, error) {
+	target, ok := ripgrepTargets[goos+"/"+goarch]
+	if !ok {
+		return "", fmt.Errorf("ripgrep downloads are not supported on %s/%s", goos, goarch)
+	}
+	if toolCacheDir == "" {
+		toolCacheDir = command.DefaultToolCacheDir()
+		if toolCacheDir == "" {
+			return "", fmt.Errorf("could not determine the tool cache directory, provide one with -toolCacheDir")
+		}
+	}
+
+	asset := fmt.Sprintf("ripgrep-%s-%s.tar.gz", RipgrepVersion, target)
+	checksum, err := download(ripgrepReleaseUrl + "/" + asset + ".sha256")
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(checksum))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("invalid checksum file for %s", asset)
+	}
+	archive, err := download(ripgrepReleaseUrl + "/" + asset)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(archive)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), fields[0]) {
+		return "", fmt.Errorf("checksum mismatch for %s", asset)
+	}
+
+	rg, err := extractFile(archive, "rg")
+	if err != nil {
+		return "", fmt.Errorf("could not extract rg from %s: %s", asset, err)
+	}
+
+	err = os.MkdirAll(toolCacheDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(toolCacheDir, "rg")
+	// Write to a temporary file first, so a partially written binary is never used
+	tmp, err := ioutil.TempFile(toolCacheDir, ".rg")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(rg)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0755)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return dest, nil
+}
+
+func download(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download %s: %s", url, res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// extractFile returns the contents of the first regular file named name in a gzipped tar archive.
+func extractFile(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Base(hdr.Name) == name {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package doctor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_InstallCommand(t *testing.T) {
+	require.Equal(t, []string{"apt-get", "install", "-y", "silversearcher-ag"}, InstallCommand("apt-get", "ag"))
+	require.Equal(t, []string{"brew", "install", "ripgrep"}, InstallCommand("brew", "rg"))
+	require.Nil(t, InstallCommand("unknown", "rg"))
+}
+
+func Test_DownloadRipgrep(t *testing.T) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	for name, contents := range map[string]string{"ripgrep/doc/rg.1": "man page", "ripgrep/rg": "binary"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	sum := sha256.Sum256(archive.Bytes())
+	checksum := hex.EncodeToString(sum[:])
+
+	asset := "/ripgrep-" + RipgrepVersion + "-x86_64-unknown-linux-musl.tar.gz"
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case asset:
+			res.Write(archive.Bytes())
+		case asset + ".sha256":
+			res.Write([]byte(checksum + "  " + asset[1:] + "\n"))
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+	defer func(url string) { ripgrepReleaseUrl = url }(ripgrepReleaseUrl)
+	ripgrepReleaseUrl = testServer.URL
+
+	dir, err := ioutil.TempDir("", "doctor")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path, err := DownloadRipgrep(dir, "linux", "amd64")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "rg"), path)
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "binary", string(contents))
+
+	_, err = DownloadRipgrep(dir, "plan9", "386")
+	require.Error(t, err)
+
+	checksum = "0000"
+	_, err = DownloadRipgrep(dir, "linux", "amd64")
+	require.EqualError(t, err, "checksum mismatch for "+asset[1:])
+}
@@ -0,0 +1,74 @@
+// Package lang detects the programming language of source files.
+package lang
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Identifiers are lowercase and compatible with markdown code fence language identifiers.
+var extensions = map[string]string{
+	".c":      "c",
+	".h":      "c",
+	".cc":     "cpp",
+	".cpp":    "cpp",
+	".cxx":    "cpp",
+	".hpp":    "cpp",
+	".cs":     "csharp",
+	".clj":    "clojure",
+	".cljs":   "clojure",
+	".css":    "css",
+	".dart":   "dart",
+	".erl":    "erlang",
+	".ex":     "elixir",
+	".exs":    "elixir",
+	".go":     "go",
+	".groovy": "groovy",
+	".hs":     "haskell",
+	".html":   "html",
+	".htm":    "html",
+	".java":   "java",
+	".js":     "javascript",
+	".jsx":    "javascript",
+	".mjs":    "javascript",
+	".json":   "json",
+	".kt":     "kotlin",
+	".kts":    "kotlin",
+	".lua":    "lua",
+	".m":      "objectivec",
+	".mm":     "objectivec",
+	".php":    "php",
+	".pl":     "perl",
+	".py":     "python",
+	".rb":     "ruby",
+	".rs":     "rust",
+	".scala":  "scala",
+	".scss":   "scss",
+	".sh":     "bash",
+	".bash":   "bash",
+	".sql":    "sql",
+	".swift":  "swift",
+	".ts":     "typescript",
+	".tsx":    "typescript",
+	".vue":    "vue",
+	".xml":    "xml",
+	".yaml":   "yaml",
+	".yml":    "yaml",
+}
+
+var filenames = map[string]string{
+	"Dockerfile":  "dockerfile",
+	"Gemfile":     "ruby",
+	"Jenkinsfile": "groovy",
+	"Makefile":    "makefile",
+	"Rakefile":    "ruby",
+}
+
+// Detect returns the language identifier for a file path, or an empty string if the language is unknown.
+func Detect(path string) string {
+	base := filepath.Base(path)
+	if l, ok := filenames[base]; ok {
+		return l
+	}
+	return extensions[strings.ToLower(filepath.Ext(base))]
+}
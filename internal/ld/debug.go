@@ -0,0 +1,253 @@
+package ld
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+const (
+	// maxLoggedBodyBytes is the maximum number of bytes of a request or response body that are logged. Capture
+	// files always contain the full body.
+	maxLoggedBodyBytes = 2048
+	redacted           = "<redacted>"
+)
+
+// requestIdHeaders are the response headers that may identify a request in LaunchDarkly's logs, in order of preference.
+var requestIdHeaders = []string{"X-Ld-Request-Id", "X-Request-Id", "X-Amzn-Trace-Id"}
+
+// redactedHeaders are not logged or captured. Authorization is provided again when captured requests are replayed.
+var redactedHeaders = map[string]bool{"Authorization": true, "Cookie": true, "Set-Cookie": true}
+
+var (
+	// secretFieldRegex matches JSON string fields which may contain secrets.
+	secretFieldRegex = regexp.MustCompile(`("(?i:[a-z]*(?:token|secret|password|apiKey))"\s*:\s*)"[^"]*"`)
+	// secretKeyRegex matches LaunchDarkly access tokens, SDK keys, and mobile keys.
+	secretKeyRegex = regexp.MustCompile(`\b(?:api|sdk|mob)-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+)
+
+// CaptureEntry is a single HTTP exchange written to a capture file. Capture files contain one JSON encoded
+// entry per line, and can be replayed with Replay.
+type CaptureEntry struct {
+	Time            time.Time         `json:"time"`
+	Method          string            `json:"method"`
+	Url             string            `json:"url"`
+	RequestHeaders  map[string]string `json:"requestHeaders,omitempty"`
+	RequestBody     string            `json:"requestBody,omitempty"`
+	Status          int               `json:"status,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	ResponseBody    string            `json:"responseBody,omitempty"`
+	LatencyMs       int64             `json:"latencyMs"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// debugTransport logs the metadata of each request and response, and writes them to a capture file, if provided.
+// Secrets are redacted from logs and captures.
+type debugTransport struct {
+	transport http.RoundTripper
+	apiKeys   []string
+	// secretHeaders are request headers provided by the user, which may contain credentials
+	secretHeaders http.Header
+	log           bool
+
+	mu      sync.Mutex
+	capture io.Writer
+}
+
+func newDebugTransport(transport http.RoundTripper, apiKeys []string, secretHeaders http.Header, logRequests bool, capture io.Writer) *debugTransport {
+	return &debugTransport{transport: transport, apiKeys: apiKeys, secretHeaders: secretHeaders, log: logRequests, capture: capture}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := CaptureEntry{Time: time.Now().UTC(), Method: req.Method, Url: t.redact(req.URL.String()), RequestHeaders: t.headers(req.Header)}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		entry.RequestBody = t.redact(string(body))
+	}
+
+	start := time.Now()
+	res, err := t.transport.RoundTrip(req)
+	entry.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		entry.Error = t.redact(err.Error())
+		t.record(entry, "")
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	entry.Status = res.StatusCode
+	entry.ResponseHeaders = t.headers(res.Header)
+	entry.ResponseBody = t.redact(string(body))
+
+	requestId := ""
+	for _, h := range requestIdHeaders {
+		if requestId = res.Header.Get(h); requestId != "" {
+			break
+		}
+	}
+	t.record(entry, requestId)
+	return res, nil
+}
+
+func (t *debugTransport) record(entry CaptureEntry, requestId string) {
+	if t.log {
+		var sb strings.Builder
+		if entry.Error != "" {
+			fmt.Fprintf(&sb, "HTTP %s %s failed after %dms: %s", entry.Method, entry.Url, entry.LatencyMs, entry.Error)
+		} else {
+			fmt.Fprintf(&sb, "HTTP %s %s: %d in %dms", entry.Method, entry.Url, entry.Status, entry.LatencyMs)
+			if requestId != "" {
+				fmt.Fprintf(&sb, " (request id %s)", requestId)
+			}
+		}
+		if entry.RequestBody != "" {
+			fmt.Fprintf(&sb, "\n  request body: %s", truncateBody(entry.RequestBody))
+		}
+		if entry.ResponseBody != "" {
+			fmt.Fprintf(&sb, "\n  response body: %s", truncateBody(entry.ResponseBody))
+		}
+		log.Info.Print(sb.String())
+	}
+
+	if t.capture != nil {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			log.Warning.Printf("could not capture HTTP request: %s", err)
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		// Entries are written immediately, so they're available even if the scanner exits due to an error
+		_, err = t.capture.Write(append(data, '\n'))
+		if err != nil {
+			log.Warning.Printf("could not capture HTTP request: %s", err)
+		}
+	}
+}
+
+func (t *debugTransport) headers(header http.Header) map[string]string {
+	ret := map[string]string{}
+	for k, v := range header {
+		if redactedHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		if _, ok := t.secretHeaders[http.CanonicalHeaderKey(k)]; ok {
+			ret[k] = redacted
+			continue
+		}
+		ret[k] = t.redact(strings.Join(v, ", "))
+	}
+	return ret
+}
+
+func (t *debugTransport) redact(s string) string {
+	for _, apiKey := range t.apiKeys {
+		if apiKey != "" {
+			s = strings.Replace(s, apiKey, redacted, -1)
+		}
+	}
+	s = secretFieldRegex.ReplaceAllString(s, `$1"`+redacted+`"`)
+	return secretKeyRegex.ReplaceAllString(s, redacted)
+}
+
+func truncateBody(body string) string {
+	if len(body) <= maxLoggedBodyBytes {
+		return body
+	}
+	return fmt.Sprintf("%s... (%d bytes truncated)", body[:maxLoggedBodyBytes], len(body)-maxLoggedBodyBytes)
+}
+
+// ReadCapture reads the entries of a capture file.
+func ReadCapture(r io.Reader) ([]CaptureEntry, error) {
+	entries := []CaptureEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry CaptureEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capture entry on line %d: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Replay sends a captured request again, authorized with apiKey, and returns the response status and body.
+// Headers which were redacted from the capture are not sent. Requests are only sent to baseUri's scheme and host, so
+// apiKey isn't sent elsewhere by edited or untrusted capture files.
+func Replay(entry CaptureEntry, baseUri, apiKey string) (int, string, error) {
+	base, err := url.Parse(baseUri)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid baseUri: %s", err)
+	}
+	req, err := http.NewRequest(entry.Method, entry.Url, strings.NewReader(entry.RequestBody))
+	if err != nil {
+		return 0, "", err
+	}
+	if !strings.EqualFold(req.URL.Scheme, base.Scheme) || !strings.EqualFold(req.URL.Host, base.Host) {
+		return 0, "", fmt.Errorf("the request isn't sent to %s://%s, the configured baseUri", base.Scheme, base.Host)
+	}
+	for k, v := range entry.RequestHeaders {
+		// Redacted headers must be provided again with the original request
+		if k == "Content-Length" || v == redacted {
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Authorization", apiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return res.StatusCode, string(body), nil
+}
+
+// headerTransport adds headers to each request, such as those required by proxies and gateways.
+type headerTransport struct {
+	transport http.RoundTripper
+	headers   http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the original request
+	clone := *req
+	clone.Header = make(http.Header, len(req.Header)+len(t.headers))
+	for k, v := range req.Header {
+		clone.Header[k] = v
+	}
+	for k, v := range t.headers {
+		clone.Header[k] = v
+	}
+	return t.transport.RoundTrip(&clone)
+}
@@ -0,0 +1,96 @@
+package ld
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugTransportCapture(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		require.Equal(t, `{"accessToken": "api-x", "name": "repo"}`, string(body))
+		res.Header().Set("X-Request-Id", "req-1")
+		res.WriteHeader(http.StatusBadRequest)
+		res.Write([]byte(`{"code": "invalid_request", "message": "bad"}`))
+	}))
+	defer testServer.Close()
+
+	var capture bytes.Buffer
+	retryMax := 0
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL, RetryMax: &retryMax, DebugHttp: true, HttpCapture: &capture})
+	req, err := http.NewRequest("POST", testServer.URL+reposPath, bytes.NewBufferString(`{"accessToken": "api-x", "name": "repo"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "api-x")
+	res, err := client.httpClient.HTTPClient.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"code": "invalid_request", "message": "bad"}`, string(body), "response body must still be readable")
+
+	entries, err := ReadCapture(&capture)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	require.Equal(t, "POST", entry.Method)
+	require.Equal(t, http.StatusBadRequest, entry.Status)
+	require.Equal(t, `{"accessToken": "<redacted>", "name": "repo"}`, entry.RequestBody)
+	require.Equal(t, `{"code": "invalid_request", "message": "bad"}`, entry.ResponseBody)
+	require.Equal(t, "req-1", entry.ResponseHeaders["X-Request-Id"])
+	require.NotContains(t, entry.RequestHeaders, "Authorization")
+}
+
+func TestApiHeaders(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "tenant-1", req.Header.Get("X-Tenant-Id"))
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	var capture bytes.Buffer
+	retryMax := 0
+	headers := http.Header{"X-Tenant-Id": {"tenant-1"}}
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL, RetryMax: &retryMax, HttpCapture: &capture, Headers: headers})
+	err := client.PutCodeReferenceBranch(BranchRep{Name: "master"}, "repo")
+	require.NoError(t, err)
+
+	entries, err := ReadCapture(&capture)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, redacted, entries[0].RequestHeaders["X-Tenant-Id"])
+}
+
+func TestRedact(t *testing.T) {
+	transport := newDebugTransport(http.DefaultTransport, []string{"secret-token"}, nil, false, nil)
+	require.Equal(t, `Authorization <redacted>, {"apiKey":"<redacted>", "sdkKey": "<redacted>", "key": "flag"}`,
+		transport.redact(`Authorization secret-token, {"apiKey":"x", "sdkKey": "sdk-01234567-89ab-cdef-0123-456789abcdef", "key": "flag"}`))
+}
+
+func TestReplay(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "api-y", req.Header.Get("Authorization"))
+		require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(req.Body)
+		res.WriteHeader(http.StatusOK)
+		res.Write(body)
+	}))
+	defer testServer.Close()
+
+	status, body, err := Replay(CaptureEntry{
+		Method:         "PUT",
+		Url:            testServer.URL,
+		RequestHeaders: map[string]string{"Content-Type": "application/json"},
+		RequestBody:    `{"name": "master"}`,
+	}, testServer.URL, "api-y")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, `{"name": "master"}`, body)
+
+	// The access token is never sent to other hosts
+	_, _, err = Replay(CaptureEntry{Method: "GET", Url: testServer.URL}, "https://app.launchdarkly.com", "api-y")
+	require.EqualError(t, err, "the request isn't sent to https://app.launchdarkly.com, the configured baseUri")
+}
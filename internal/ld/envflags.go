@@ -0,0 +1,68 @@
+package ld
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	h "github.com/hashicorp/go-retryablehttp"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+type flagStatusesPage struct {
+	Items []struct {
+		Links struct {
+			Self struct {
+				Href string `json:"href"`
+			} `json:"self"`
+		} `json:"_links"`
+	} `json:"items"`
+}
+
+// getEnvironmentFlagKeyList retrieves the flag keys of a project from the flag statuses of one of its environments,
+// for access tokens whose role only grants access to that environment. Flag statuses don't include flag metadata, such
+// as whether the flag is archived, so this is only used when the project's flags can't be listed.
+func (c ApiClient) getEnvironmentFlagKeyList(projKey, envKey string) ([]string, error) {
+	reqUrl := fmt.Sprintf("%s%s/flag-statuses/%s/%s", c.Options.BaseUri, v2ApiPath, url.PathEscape(projKey), url.PathEscape(envKey))
+	req, err := h.NewRequest("GET", reqUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var page flagStatusesPage
+	err = json.NewDecoder(res.Body).Decode(&page)
+	if err != nil {
+		return nil, err
+	}
+
+	flagKeys := []string{}
+	for _, s := range page.Items {
+		// Flag statuses only identify their flag by their url, e.g. /api/v2/flag-statuses/projKey/envKey/flagKey
+		if s.Links.Self.Href == "" {
+			continue
+		}
+		flagKeys = append(flagKeys, path.Base(s.Links.Self.Href))
+	}
+	if max := c.Options.MaxFlags; max > 0 && len(flagKeys) > max {
+		err := &TruncatedFlagListError{ProjKey: projKey, Retrieved: max, Total: len(flagKeys)}
+		if !c.Options.AllowPartialFlags {
+			return nil, err
+		}
+		log.Warning.Printf("retrieved %d of %d flags in project %s, references to other flags will not be found", err.Retrieved, err.Total, projKey)
+		flagKeys = flagKeys[:max]
+	}
+	return flagKeys, nil
+}
+
+// isForbidden returns true if res is a 403 response, e.g. because the access token's role doesn't grant access to
+// a project's flags.
+func isForbidden(res *http.Response) bool {
+	return res != nil && res.StatusCode == http.StatusForbidden
+}
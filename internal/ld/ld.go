@@ -3,17 +3,20 @@ package ld
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"reflect"
 	"sort"
 	"strconv"
+	"time"
 
+	"github.com/hashicorp/go-cleanhttp"
 	h "github.com/hashicorp/go-retryablehttp"
 	"github.com/olekukonko/tablewriter"
 
@@ -33,8 +36,50 @@ type ApiOptions struct {
 	ProjKey  string
 	BaseUri  string
 	RetryMax *int
+	// DebugHttp enables logging of each request and response
+	DebugHttp bool
+	// HttpCapture, if provided, receives each request and response as a replayable capture entry
+	HttpCapture io.Writer
+	// Headers are added to every request. Their values are redacted from logs and captures.
+	Headers http.Header
+	// ClientCertificate, if provided, is presented to the server for mutual TLS authentication
+	ClientCertificate *tls.Certificate
+	// ProjectApiKeys are used instead of ApiKey for requests for flags in the given projects, keyed by project key
+	ProjectApiKeys map[string]string
+	// SigningSecret, if provided, is used to sign the body of code reference uploads. See SignatureHeader.
+	SigningSecret string
+	// MaxFlags, if > 0, is the maximum number of flags retrieved from each project
+	MaxFlags int
+	// AllowPartialFlags allows scanning for the flags retrieved from a project, if not all of them could be.
+	// Otherwise, a TruncatedFlagListError is returned.
+	AllowPartialFlags bool
+	// RequestTimeout is how long to wait for a response after a request has been sent. It doesn't include the time
+	// spent sending the request, so large uploads over slow links aren't cancelled. If 0, DefaultRequestTimeout is
+	// used. If < 0, requests don't time out.
+	RequestTimeout time.Duration
+	// DisableKeepAlives closes the connection after each request, instead of reusing it for later requests
+	DisableKeepAlives bool
+	// MaxIdleConns is the maximum number of idle connections kept open for reuse. If 0, DefaultMaxIdleConns is used.
+	MaxIdleConns int
+	// EnvironmentKey, if provided, is the environment flag keys are retrieved from when the access token isn't
+	// allowed to list a project's flags, e.g. because its role only grants access to that environment.
+	EnvironmentKey string
 }
 
+// Defaults for ApiOptions, suited to CI jobs making a few requests to a single host
+const (
+	DefaultBaseUri        = "https://app.launchdarkly.com"
+	DefaultRequestTimeout = 60 * time.Second
+	DefaultMaxIdleConns   = 10
+)
+
+const (
+	// maxConcurrentRequests is the maximum number of requests made concurrently when retrieving data for many projects
+	maxConcurrentRequests = 5
+	// maxRequestsPerSecond is the maximum rate of concurrent requests
+	maxRequestsPerSecond = 10
+)
+
 const (
 	v2ApiPath = "/api/v2"
 	reposPath = v2ApiPath + "/code-refs/repositories"
@@ -55,36 +100,242 @@ var (
 
 func InitApiClient(options ApiOptions) ApiClient {
 	if options.BaseUri == "" {
-		options.BaseUri = "https://app.launchdarkly.com"
+		options.BaseUri = DefaultBaseUri
 	}
 	client := h.NewClient()
 	client.Logger = log.Debug
 	if options.RetryMax != nil && *options.RetryMax >= 0 {
 		client.RetryMax = *options.RetryMax
 	}
+	config := &ldapi.Configuration{
+		BasePath:  options.BaseUri + v2ApiPath,
+		UserAgent: "github-actor",
+	}
+	var transport http.RoundTripper = pooledTransport(options)
+	if options.ClientCertificate != nil {
+		if t, ok := transport.(*http.Transport); ok {
+			t.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{*options.ClientCertificate}}
+		}
+	}
+	if options.DebugHttp || options.HttpCapture != nil {
+		apiKeys := []string{options.ApiKey}
+		for _, key := range options.ProjectApiKeys {
+			apiKeys = append(apiKeys, key)
+		}
+		transport = newDebugTransport(transport, apiKeys, options.Headers, options.DebugHttp, options.HttpCapture)
+	}
+	if len(options.Headers) > 0 {
+		// Headers are added before requests are logged, so they can be redacted
+		transport = &headerTransport{transport: transport, headers: options.Headers}
+	}
+	client.HTTPClient.Transport = transport
+	config.HTTPClient = &http.Client{Transport: transport}
 	return ApiClient{
-		ldClient: ldapi.NewAPIClient(&ldapi.Configuration{
-			BasePath:  options.BaseUri + v2ApiPath,
-			UserAgent: "github-actor",
-		}),
+		ldClient:   ldapi.NewAPIClient(config),
 		httpClient: client,
 		Options:    options,
 	}
 }
 
+// pooledTransport returns a transport which reuses connections, and applies the timeout and connection limits of
+// options. The default transport's connections never time out once established, so requests to black holed
+// networks would otherwise hang forever.
+func pooledTransport(options ApiOptions) *http.Transport {
+	transport := cleanhttp.DefaultPooledTransport()
+	switch {
+	case options.RequestTimeout == 0:
+		transport.ResponseHeaderTimeout = DefaultRequestTimeout
+	case options.RequestTimeout > 0:
+		transport.ResponseHeaderTimeout = options.RequestTimeout
+	}
+	transport.DisableKeepAlives = options.DisableKeepAlives
+	maxIdleConns := options.MaxIdleConns
+	if maxIdleConns <= 0 {
+		maxIdleConns = DefaultMaxIdleConns
+	}
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	return transport
+}
+
 func (c ApiClient) GetFlagKeyList() ([]string, error) {
-	ctx := context.WithValue(context.Background(), ldapi.ContextAPIKey, ldapi.APIKey{Key: c.Options.ApiKey})
-	flags, _, err := c.ldClient.FeatureFlagsApi.GetFeatureFlags(ctx, c.Options.ProjKey, nil)
+	return c.getFlagKeyList(c.Options.ProjKey)
+}
+
+// projectContext returns a request context authorized with the api key for a project.
+func (c ApiClient) projectContext(projKey string) context.Context {
+	apiKey := c.Options.ApiKey
+	if key, ok := c.Options.ProjectApiKeys[projKey]; ok {
+		apiKey = key
+	}
+	return context.WithValue(context.Background(), ldapi.ContextAPIKey, ldapi.APIKey{Key: apiKey})
+}
+
+// flagsPageSize is the number of flags requested at a time.
+const flagsPageSize = 100
+
+type flagsPage struct {
+	Items []struct {
+		Key string `json:"key"`
+	} `json:"items"`
+	// TotalCount is the number of flags in the project. It is omitted by versions of the API which return every
+	// flag at once.
+	TotalCount *int `json:"totalCount"`
+}
+
+// TruncatedFlagListError is returned when fewer flags were retrieved than the project contains, e.g. because
+// the access token can't retrieve every flag, or MaxFlags was exceeded, unless AllowPartialFlags is enabled.
+type TruncatedFlagListError struct {
+	ProjKey   string
+	Retrieved int
+	Total     int
+}
+
+func (e *TruncatedFlagListError) Error() string {
+	return fmt.Sprintf("retrieved %d of %d flags in project %s. To scan for the retrieved flags only, enable allowPartialFlags", e.Retrieved, e.Total, e.ProjKey)
+}
+
+func (c ApiClient) getFlagKeyList(projKey string) ([]string, error) {
+	flagKeys := []string{}
+	err := c.streamFlagKeys(projKey, func(keys []string) {
+		flagKeys = append(flagKeys, keys...)
+	})
 	if err != nil {
 		return nil, err
 	}
-	flagKeys := make([]string, 0, len(flags.Items))
-	for _, flag := range flags.Items {
-		flagKeys = append(flagKeys, flag.Key)
-	}
 	return flagKeys, nil
 }
 
+// StreamFlagKeys calls fn with each page of flag keys in the project as it's retrieved, so the keys can be used
+// without collecting every page first. If fewer flags were retrieved than the project contains, a
+// TruncatedFlagListError is returned after the pages retrieved, unless AllowPartialFlags is enabled.
+func (c ApiClient) StreamFlagKeys(fn func(keys []string)) error {
+	return c.streamFlagKeys(c.Options.ProjKey, fn)
+}
+
+func (c ApiClient) streamFlagKeys(projKey string, fn func(keys []string)) error {
+	// Requests are authorized with the project's api key, if provided
+	pc := c
+	if key, ok := c.Options.ProjectApiKeys[projKey]; ok {
+		pc.Options.ApiKey = key
+	}
+
+	retrieved := 0
+	total := 0
+	for {
+		limit := flagsPageSize
+		if max := c.Options.MaxFlags; max > 0 && max-retrieved < limit {
+			limit = max - retrieved
+		}
+		reqUrl := fmt.Sprintf("%s%s/flags/%s?summary=true&limit=%d&offset=%d", c.Options.BaseUri, v2ApiPath, url.PathEscape(projKey), limit, retrieved)
+		req, err := h.NewRequest("GET", reqUrl, nil)
+		if err != nil {
+			return err
+		}
+		res, err := pc.do(req)
+		if isForbidden(res) && retrieved == 0 {
+			if c.Options.EnvironmentKey == "" {
+				return fmt.Errorf("%s. If the access token only has access to an environment, provide its key with the environmentKey option", err)
+			}
+			log.Warning.Printf("the access token isn't allowed to list the flags in project %s, retrieving flag keys from environment %s instead. Flags may be missing, and flag metadata is not available", projKey, c.Options.EnvironmentKey)
+			flagKeys, err := pc.getEnvironmentFlagKeyList(projKey, c.Options.EnvironmentKey)
+			if err != nil {
+				return err
+			}
+			fn(flagKeys)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var page flagsPage
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(page.Items))
+		for _, flag := range page.Items {
+			keys = append(keys, flag.Key)
+		}
+		// Versions of the API without pagination may return more than MaxFlags
+		if max := c.Options.MaxFlags; max > 0 && retrieved+len(keys) > max {
+			keys = keys[:max-retrieved]
+		}
+		retrieved += len(keys)
+		fn(keys)
+		if page.TotalCount == nil {
+			// Every flag was returned at once
+			total = retrieved
+			break
+		}
+		total = *page.TotalCount
+		if len(page.Items) == 0 || retrieved >= total || (c.Options.MaxFlags > 0 && retrieved >= c.Options.MaxFlags) {
+			break
+		}
+	}
+
+	if retrieved < total {
+		err := &TruncatedFlagListError{ProjKey: projKey, Retrieved: retrieved, Total: total}
+		if !c.Options.AllowPartialFlags {
+			return err
+		}
+		log.Warning.Printf("retrieved %d of %d flags in project %s, references to other flags will not be found", err.Retrieved, err.Total, projKey)
+	}
+	return nil
+}
+
+// GetFlagKeyLists retrieves the flag keys of each project concurrently, keyed by project key. Requests are
+// made by a bounded number of workers, and share a rate limit, so scanning for many projects doesn't
+// exceed LaunchDarkly's API rate limits.
+func (c ApiClient) GetFlagKeyLists(projKeys []string) (map[string][]string, error) {
+	type result struct {
+		projKey string
+		flags   []string
+		err     error
+	}
+
+	jobs := make(chan string)
+	results := make(chan result, len(projKeys))
+	limiter := newRateLimiter(maxRequestsPerSecond)
+	workers := maxConcurrentRequests
+	if len(projKeys) < workers {
+		workers = len(projKeys)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for projKey := range jobs {
+				limiter.wait()
+				flags, err := c.getFlagKeyList(projKey)
+				results <- result{projKey, flags, err}
+			}
+		}()
+	}
+	go func() {
+		for _, projKey := range projKeys {
+			jobs <- projKey
+		}
+		close(jobs)
+	}()
+
+	ret := make(map[string][]string, len(projKeys))
+	var err error
+	for range projKeys {
+		r := <-results
+		if _, ok := r.err.(*TruncatedFlagListError); ok && err == nil {
+			// Truncation errors already identify the project
+			err = r.err
+		} else if r.err != nil && err == nil {
+			err = fmt.Errorf("project %s: %s", r.projKey, r.err)
+		}
+		ret[r.projKey] = r.flags
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 func (c ApiClient) repoUrl() string {
 	return fmt.Sprintf("%s%s", c.Options.BaseUri, reposPath)
 }
@@ -211,11 +462,11 @@ func (c ApiClient) PutCodeReferenceBranch(branch BranchRep, repoName string) err
 	if err != nil {
 		return err
 	}
-	putUrl := fmt.Sprintf("%s%s/%s/branches/%s", c.Options.BaseUri, reposPath, repoName, url.PathEscape(branch.Name))
-	req, err := h.NewRequest("PUT", putUrl, bytes.NewBuffer(branchBytes))
+	req, err := h.NewRequest("PUT", c.branchUrl(repoName, branch.Name), bytes.NewBuffer(branchBytes))
 	if err != nil {
 		return err
 	}
+	c.sign(req, branchBytes)
 
 	_, err = c.do(req)
 	if err != nil {
@@ -225,6 +476,54 @@ func (c ApiClient) PutCodeReferenceBranch(branch BranchRep, repoName string) err
 	return nil
 }
 
+func (c ApiClient) branchUrl(repoName, branchName string) string {
+	return fmt.Sprintf("%s%s/%s/branches/%s", c.Options.BaseUri, reposPath, repoName, url.PathEscape(branchName))
+}
+
+// GetCodeReferenceBranch returns the code references previously sent for a branch. NotFoundErr is returned
+// if the branch has not been sent.
+func (c ApiClient) GetCodeReferenceBranch(repoName, branchName string) (*BranchRep, error) {
+	req, err := h.NewRequest("GET", c.branchUrl(repoName, branchName), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var branch BranchRep
+	err = json.NewDecoder(res.Body).Decode(&branch)
+	if err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+// PatchOperation is a JSON patch (RFC 6902) operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// PatchCodeReferenceBranch applies a JSON patch to the code references previously sent for a branch.
+func (c ApiClient) PatchCodeReferenceBranch(repoName, branchName string, patch []PatchOperation) error {
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	req, err := h.NewRequest("PATCH", c.branchUrl(repoName, branchName), bytes.NewBuffer(patchBytes))
+	if err != nil {
+		return err
+	}
+	c.sign(req, patchBytes)
+
+	_, err = c.do(req)
+	return err
+}
+
 type ldErrorResponse struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
@@ -309,8 +608,46 @@ type BranchRep struct {
 	Head             string              `json:"head"`
 	UpdateSequenceId *int64              `json:"updateSequenceId,omitempty"`
 	SyncTime         int64               `json:"syncTime"`
+	CommitTime       int64               `json:"commitTime,omitempty"`
 	IsDefault        bool                `json:"isDefault"`
+	Metadata         *UploadMetadata     `json:"metadata,omitempty"`
 	References       []ReferenceHunksRep `json:"references,omitempty"`
+	// Samples are the true number of hunks and references to each flag which had only a sample of its hunks sent
+	Samples []SampledFlagRep `json:"samples,omitempty"`
+}
+
+// SampledFlagRep is the number of hunks and references to a flag found by a scan, when only a sample of its hunks
+// was sent, so metrics remain accurate.
+type SampledFlagRep struct {
+	ProjKey          string `json:"projKey"`
+	FlagKey          string `json:"flagKey"`
+	HunkCount        int    `json:"hunkCount"`
+	ReferenceCount   int    `json:"referenceCount"`
+	SampledHunkCount int    `json:"sampledHunkCount"`
+}
+
+// UploadMetadata describes where code references were sent from, so uploads can be audited.
+type UploadMetadata struct {
+	// CiJobUrl is the url of the CI job which ran the scanner
+	CiJobUrl string `json:"ciJobUrl,omitempty"`
+	// Runner identifies the CI runner or host which ran the scanner
+	Runner string `json:"runner,omitempty"`
+	// ConfigHash is a hash of the options the scanner was run with, excluding secrets
+	ConfigHash string `json:"configHash,omitempty"`
+	// Languages are the number of files and references in each language
+	Languages []LanguageStatsRep `json:"languages,omitempty"`
+	// BranchName is the original name of the branch, if code references were sent under an encoded name because
+	// LaunchDarkly doesn't accept it
+	BranchName string `json:"branchName,omitempty"`
+}
+
+// LanguageStatsRep is the number of files scanned in a language, and the number of files and references to flags in
+// them.
+type LanguageStatsRep struct {
+	Language            string `json:"language"`
+	FileCount           int    `json:"fileCount"`
+	ReferencedFileCount int    `json:"referencedFileCount"`
+	ReferenceCount      int    `json:"referenceCount"`
 }
 
 func (b BranchRep) TotalHunkCount() int {
@@ -321,16 +658,66 @@ func (b BranchRep) TotalHunkCount() int {
 	return count
 }
 
+// TotalReferenceCount returns the number of flag key occurrences across all hunks. A line may contain
+// several occurrences of a flag key, so this may be larger than TotalHunkCount.
+func (b BranchRep) TotalReferenceCount() int {
+	count := 0
+	for _, r := range b.References {
+		for _, h := range r.Hunks {
+			count += h.ReferenceCount()
+		}
+	}
+	return count
+}
+
 type ReferenceHunksRep struct {
 	Path  string    `json:"path"`
 	Hunks []HunkRep `json:"hunks"`
 }
 
 type HunkRep struct {
-	StartingLineNumber int    `json:"startingLineNumber"`
-	Lines              string `json:"lines,omitempty"`
-	ProjKey            string `json:"projKey"`
-	FlagKey            string `json:"flagKey"`
+	StartingLineNumber int         `json:"startingLineNumber"`
+	Lines              string      `json:"lines,omitempty"`
+	ProjKey            string      `json:"projKey"`
+	FlagKey            string      `json:"flagKey"`
+	Offsets            []OffsetRep `json:"offsets,omitempty"`
+	// ContentHash identifies the hunk across commits, even if its line numbers change.
+	ContentHash string `json:"contentHash,omitempty"`
+	// TestCode is true if the hunk was found in a test file.
+	TestCode bool `json:"testCode,omitempty"`
+	// ConfigReference is true if the hunk references the flag by an environment variable name in a configuration file.
+	ConfigReference bool `json:"configReference,omitempty"`
+	// Language is the code fence identifier of the language of the hunk's file, e.g. go, so its lines can be
+	// highlighted. It is not set if the language is unknown, or the plainHunks option is enabled.
+	Language string `json:"language,omitempty"`
+	// Metadata are the fields attached to the hunk by the hunkMetadata option, e.g. the team owning its file. They
+	// aren't set if the plainHunks option is enabled.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Url links to the hunk's source code at the scanned commit. It is only written to local outputs.
+	Url string `json:"url,omitempty"`
+	// Score is the relevance of the hunk's flag references, from evaluations of the flag to references in tests.
+	// It is only written to local outputs.
+	Score int `json:"score,omitempty"`
+	// SharedFlags are the keys of the other flags referenced on the same lines, which have hunks of their own. It is
+	// only written to local outputs.
+	SharedFlags []string `json:"sharedFlags,omitempty"`
+}
+
+// ReferenceCount returns the number of occurrences of the flag key in the hunk. Hunks without offsets
+// are counted as a single reference.
+func (h HunkRep) ReferenceCount() int {
+	if len(h.Offsets) == 0 {
+		return 1
+	}
+	return len(h.Offsets)
+}
+
+// OffsetRep is the position of a flag key reference within a hunk. Columns are 0-based byte offsets
+// into the line, and EndColumn is exclusive.
+type OffsetRep struct {
+	LineNumber  int `json:"lineNumber"`
+	StartColumn int `json:"startColumn"`
+	EndColumn   int `json:"endColumn"`
 }
 
 type tableData [][]string
@@ -354,31 +741,40 @@ const maxFlagKeysDisplayed = 50
 func (b BranchRep) PrintReferenceCountTable() {
 	data := tableData{}
 	refCountByFlag := map[string]int64{}
+	hunkCountByFlag := map[string]int64{}
 	for _, ref := range b.References {
 		for _, hunk := range ref.Hunks {
-			refCountByFlag[hunk.FlagKey]++
+			refCountByFlag[hunk.FlagKey] += int64(hunk.ReferenceCount())
+			hunkCountByFlag[hunk.FlagKey]++
 		}
 	}
 	for k, v := range refCountByFlag {
-		data = append(data, []string{k, strconv.FormatInt(v, 10)})
+		data = append(data, []string{k, strconv.FormatInt(v, 10), strconv.FormatInt(hunkCountByFlag[k], 10)})
 	}
 	sort.Sort(data)
 
 	truncatedData := data
 	var additionalRefCount int64 = 0
+	var additionalHunkCount int64 = 0
 	if len(truncatedData) > maxFlagKeysDisplayed {
 		truncatedData = data[0:maxFlagKeysDisplayed]
 
 		for _, v := range data[maxFlagKeysDisplayed:] {
 			i, _ := strconv.ParseInt(v[1], 10, 64)
 			additionalRefCount += i
+			i, _ = strconv.ParseInt(v[2], 10, 64)
+			additionalHunkCount += i
 		}
 	}
-	truncatedData = append(truncatedData, []string{"Other flags", strconv.FormatInt(additionalRefCount, 10)})
+	truncatedData = append(truncatedData, []string{"Other flags", strconv.FormatInt(additionalRefCount, 10), strconv.FormatInt(additionalHunkCount, 10)})
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Flag", "# References"})
+	out := log.Output()
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"Flag", "# References", "# Hunks"})
 	table.SetBorder(false)
+	if log.Colors(out) {
+		table.SetHeaderColor(tablewriter.Colors{tablewriter.Bold}, tablewriter.Colors{tablewriter.Bold}, tablewriter.Colors{tablewriter.Bold})
+	}
 	table.AppendBulk(truncatedData)
 	table.Render()
 }
@@ -1,13 +1,20 @@
 package ld
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
+	ldapi "github.com/launchdarkly/api-client-go"
 	"github.com/launchdarkly/ld-find-code-refs/internal/log"
 )
 
@@ -67,6 +74,20 @@ func TestGetCodeReferenceRepository(t *testing.T) {
 	}
 }
 
+func TestMaybeUpsertCodeReferenceRepository_disabled(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "GET", req.Method)
+		res.WriteHeader(http.StatusOK)
+		res.Write([]byte(`{"name":"test","type":"custom","enabled":false}`))
+	}))
+	defer testServer.Close()
+
+	retryMax := 0
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL, RetryMax: &retryMax})
+	err := client.MaybeUpsertCodeReferenceRepository(RepoParams{Name: "test", Type: "custom"})
+	require.Equal(t, RepositoryDisabledErr, err)
+}
+
 func TestPatchCodeReferenceRepository(t *testing.T) {
 	specs := []struct {
 		name           string
@@ -116,3 +137,380 @@ func TestPutCodeReferenceBranch(t *testing.T) {
 		})
 	}
 }
+
+func TestPutCodeReferenceBranch_signed(t *testing.T) {
+	var body []byte
+	var signature string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ = ioutil.ReadAll(req.Body)
+		signature = req.Header.Get(SignatureHeader)
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL, SigningSecret: "secret"})
+	require.NoError(t, client.PutCodeReferenceBranch(BranchRep{Name: "main"}, "test"))
+	require.NotEmpty(t, body)
+	require.Equal(t, Signature(body, "secret"), signature)
+	require.NotEqual(t, Signature(body, "other"), signature)
+
+	client = InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL})
+	require.NoError(t, client.PutCodeReferenceBranch(BranchRep{Name: "main"}, "test"))
+	require.Empty(t, signature, "requests are only signed when a secret is provided")
+}
+
+func TestGetCodeReferenceBranch(t *testing.T) {
+	specs := []struct {
+		name           string
+		responseStatus int
+		responseBody   string
+		expected       *BranchRep
+		expectedErr    error
+	}{
+		{"succeeds", 200, `{"name":"master","head":"abc","references":[{"path":"a.go"}]}`, &BranchRep{Name: "master", Head: "abc", References: []ReferenceHunksRep{{Path: "a.go"}}}, nil},
+		{"not found", 404, `{"code":"not_found"}`, nil, NotFoundErr},
+	}
+
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				require.Equal(t, "GET", req.Method)
+				require.Equal(t, "/api/v2/code-refs/repositories/test/branches/master", req.URL.Path)
+				res.WriteHeader(tt.responseStatus)
+				res.Write([]byte(tt.responseBody))
+			}))
+			defer testServer.Close()
+
+			retryMax := 0
+			client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL, RetryMax: &retryMax})
+			branch, err := client.GetCodeReferenceBranch("test", "master")
+			require.Equal(t, tt.expectedErr, err)
+			require.Equal(t, tt.expected, branch)
+		})
+	}
+}
+
+func TestPatchCodeReferenceBranch(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "PATCH", req.Method)
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, `[{"op":"test","path":"/head","value":"abc"},{"op":"remove","path":"/references/0"}]`, string(body))
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	retryMax := 0
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL, RetryMax: &retryMax})
+	err := client.PatchCodeReferenceBranch("test", "master", []PatchOperation{{Op: "test", Path: "/head", Value: "abc"}, {Op: "remove", Path: "/references/0"}})
+	require.NoError(t, err)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		<-done
+	}))
+	defer testServer.Close()
+	defer close(done)
+
+	retryMax := 0
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL, RetryMax: &retryMax, RequestTimeout: 50 * time.Millisecond})
+	start := time.Now()
+	_, err := client.GetCodeReferenceBranch("test", "master")
+	require.Error(t, err)
+	require.True(t, time.Since(start) < 5*time.Second, "the request should time out")
+}
+
+func Test_pooledTransport(t *testing.T) {
+	transport := pooledTransport(ApiOptions{})
+	require.Equal(t, DefaultRequestTimeout, transport.ResponseHeaderTimeout)
+	require.Equal(t, DefaultMaxIdleConns, transport.MaxIdleConnsPerHost)
+	require.False(t, transport.DisableKeepAlives)
+
+	transport = pooledTransport(ApiOptions{RequestTimeout: -1, MaxIdleConns: 2, DisableKeepAlives: true})
+	require.Zero(t, transport.ResponseHeaderTimeout)
+	require.Equal(t, 2, transport.MaxIdleConns)
+	require.True(t, transport.DisableKeepAlives)
+}
+
+func TestTotalReferenceCount(t *testing.T) {
+	b := BranchRep{References: []ReferenceHunksRep{
+		{Path: "a", Hunks: []HunkRep{
+			{FlagKey: "flag-1", Offsets: []OffsetRep{{LineNumber: 1, StartColumn: 0, EndColumn: 6}, {LineNumber: 1, StartColumn: 10, EndColumn: 16}}},
+			{FlagKey: "flag-2"},
+		}},
+		{Path: "b", Hunks: []HunkRep{{FlagKey: "flag-1", Offsets: []OffsetRep{{LineNumber: 3, StartColumn: 0, EndColumn: 6}}}}},
+	}}
+	require.Equal(t, 3, b.TotalHunkCount())
+	require.Equal(t, 4, b.TotalReferenceCount())
+}
+
+func TestGetFlagStatuses(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v2/flags/default":
+			res.Write([]byte(`{"items": [{"key": "flag-1", "environments": {"production": {"on": true, "lastModified": 1556668800000}}}]}`))
+		case "/api/v2/flag-statuses/default/production":
+			res.Write([]byte(`{"items": [{"name": "active", "lastRequested": "2019-05-02T00:00:00Z", "_links": {"self": {"href": "/api/v2/flag-statuses/default/production/flag-1"}}}]}`))
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL})
+	flags, err := client.GetProjectFlags()
+	require.NoError(t, err)
+	statuses, err := client.GetFlagStatuses(flags)
+	require.NoError(t, err)
+	lastModified := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	lastRequested := time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, map[string]FlagStatus{
+		"flag-1": {"production": {On: true, LastModified: &lastModified, Status: "active", LastRequested: &lastRequested}},
+	}, statuses)
+}
+
+func TestGetFlagMaintainers(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v2/flags/default" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.Write([]byte(`{"items": [
+			{"key": "flag-1", "maintainerId": "m1", "_maintainer": {"_id": "m1", "email": "jane@example.com"}},
+			{"key": "flag-2", "maintainerId": "m2"},
+			{"key": "flag-3"}
+		]}`))
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "default", BaseUri: testServer.URL})
+	flags, err := client.GetProjectFlags()
+	require.NoError(t, err)
+	require.Equal(t, map[string]FlagMaintainer{
+		"flag-1": {Id: "m1", Email: "jane@example.com"},
+		"flag-2": {Id: "m2"},
+	}, flags.Maintainers())
+}
+
+func TestGetProjectFlags(t *testing.T) {
+	offsets := []string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/api/v2/flags/proj", req.URL.Path)
+		require.Equal(t, "api-y", req.Header.Get("Authorization"))
+		offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+		offsets = append(offsets, req.URL.Query().Get("offset"))
+		total := flagsPageSize + 1
+		page := projectFlagsPage{TotalCount: &total}
+		for i := offset; i < total && i < offset+flagsPageSize; i++ {
+			page.Items = append(page.Items, ldapi.FeatureFlag{Key: fmt.Sprintf("flag-%d", i), MaintainerId: "m1"})
+		}
+		json.NewEncoder(res).Encode(page)
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "proj", BaseUri: testServer.URL, ProjectApiKeys: map[string]string{"proj": "api-y"}})
+	flags, err := client.GetProjectFlags()
+	require.NoError(t, err)
+	require.Len(t, flags.Maintainers(), flagsPageSize+1)
+	require.Equal(t, []string{"0", "100"}, offsets)
+}
+
+func TestGetFlagKeyLists(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v2/flags/proj-1":
+			res.Write([]byte(`{"items": [{"key": "flag-1"}, {"key": "shared-flag"}]}`))
+		case "/api/v2/flags/proj-2":
+			res.Write([]byte(`{"items": [{"key": "shared-flag"}]}`))
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	retryMax := 0
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "proj-1", BaseUri: testServer.URL, RetryMax: &retryMax})
+	flags, err := client.GetFlagKeyLists([]string{"proj-1", "proj-2"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"proj-1": {"flag-1", "shared-flag"}, "proj-2": {"shared-flag"}}, flags)
+
+	_, err = client.GetFlagKeyLists([]string{"proj-1", "missing"})
+	require.Error(t, err)
+}
+
+func TestGetFlagKeyList_pages(t *testing.T) {
+	total := flagsPageSize + 5
+	available := total
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+		page := flagsPage{TotalCount: &total}
+		for i := offset; i < offset+limit && i < available; i++ {
+			page.Items = append(page.Items, struct {
+				Key string `json:"key"`
+			}{fmt.Sprintf("flag-%d", i)})
+		}
+		json.NewEncoder(res).Encode(page)
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "proj", BaseUri: testServer.URL})
+	flags, err := client.GetFlagKeyList()
+	require.NoError(t, err)
+	require.Len(t, flags, total)
+	require.Equal(t, "flag-104", flags[total-1])
+
+	// Fewer flags are available than the project contains, e.g. because of the access token's permissions
+	available = flagsPageSize
+	_, err = client.GetFlagKeyList()
+	require.Equal(t, &TruncatedFlagListError{ProjKey: "proj", Retrieved: flagsPageSize, Total: total}, err)
+	_, err = client.GetFlagKeyLists([]string{"proj"})
+	require.IsType(t, &TruncatedFlagListError{}, err)
+
+	client.Options.AllowPartialFlags = true
+	flags, err = client.GetFlagKeyList()
+	require.NoError(t, err)
+	require.Len(t, flags, flagsPageSize)
+
+	available = total
+	client.Options.AllowPartialFlags = false
+	client.Options.MaxFlags = 10
+	_, err = client.GetFlagKeyList()
+	require.Equal(t, &TruncatedFlagListError{ProjKey: "proj", Retrieved: 10, Total: total}, err)
+	client.Options.MaxFlags = total
+	flags, err = client.GetFlagKeyList()
+	require.NoError(t, err)
+	require.Len(t, flags, total)
+}
+
+func TestStreamFlagKeys(t *testing.T) {
+	total := flagsPageSize + 5
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+		page := flagsPage{TotalCount: &total}
+		for i := offset; i < offset+limit && i < total; i++ {
+			page.Items = append(page.Items, struct {
+				Key string `json:"key"`
+			}{fmt.Sprintf("flag-%d", i)})
+		}
+		json.NewEncoder(res).Encode(page)
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "proj", BaseUri: testServer.URL})
+	pages := [][]string{}
+	err := client.StreamFlagKeys(func(keys []string) {
+		pages = append(pages, keys)
+	})
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+	require.Len(t, pages[0], flagsPageSize)
+	require.Equal(t, []string{"flag-100", "flag-101", "flag-102", "flag-103", "flag-104"}, pages[1])
+
+	// Pages retrieved before the limit are still passed to fn
+	client.Options.MaxFlags = 10
+	pages = [][]string{}
+	err = client.StreamFlagKeys(func(keys []string) {
+		pages = append(pages, keys)
+	})
+	require.Equal(t, &TruncatedFlagListError{ProjKey: "proj", Retrieved: 10, Total: total}, err)
+	require.Len(t, pages, 1)
+	require.Len(t, pages[0], 10)
+}
+
+func TestGetFlagKeyList_environmentFallback(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v2/flags/proj":
+			res.WriteHeader(http.StatusForbidden)
+			res.Write([]byte(`{"code": "forbidden", "message": "Access to the requested resource was denied"}`))
+		case "/api/v2/flag-statuses/proj/production":
+			res.Write([]byte(`{"items": [{"name": "active", "_links": {"self": {"href": "/api/v2/flag-statuses/proj/production/flag-1"}}}, {"name": "new", "_links": {"self": {"href": "/api/v2/flag-statuses/proj/production/flag-2"}}}]}`))
+		default:
+			res.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "proj", BaseUri: testServer.URL})
+	_, err := client.GetFlagKeyList()
+	require.EqualError(t, err, "forbidden, Access to the requested resource was denied. If the access token only has access to an environment, provide its key with the environmentKey option")
+
+	client.Options.EnvironmentKey = "production"
+	flags, err := client.GetFlagKeyList()
+	require.NoError(t, err)
+	require.Equal(t, []string{"flag-1", "flag-2"}, flags)
+
+	client.Options.MaxFlags = 1
+	_, err = client.GetFlagKeyList()
+	require.Equal(t, &TruncatedFlagListError{ProjKey: "proj", Retrieved: 1, Total: 2}, err)
+
+	// The environment must be accessible too
+	client.Options.EnvironmentKey = "staging"
+	_, err = client.GetFlagKeyList()
+	require.Error(t, err)
+}
+
+func TestGetFlagKeyList_maxFlagsWithoutPagination(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		// Versions of the API without pagination return every flag, without a totalCount
+		res.Write([]byte(`{"items": [{"key": "flag-1"}, {"key": "flag-2"}, {"key": "flag-3"}]}`))
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "proj", BaseUri: testServer.URL, MaxFlags: 2, AllowPartialFlags: true})
+	flags, err := client.GetFlagKeyList()
+	require.NoError(t, err)
+	require.Equal(t, []string{"flag-1", "flag-2"}, flags)
+}
+
+func TestGetFlagKeyLists_projectApiKeys(t *testing.T) {
+	var mu sync.Mutex
+	tokens := map[string]string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tokens[req.URL.Path] = req.Header.Get("Authorization")
+		res.Write([]byte(`{"items": []}`))
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", ProjKey: "proj-1", BaseUri: testServer.URL, ProjectApiKeys: map[string]string{"proj-2": "api-y"}})
+	_, err := client.GetFlagKeyLists([]string{"proj-1", "proj-2"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/api/v2/flags/proj-1": "api-x", "/api/v2/flags/proj-2": "api-y"}, tokens)
+}
+
+func TestGetMembers(t *testing.T) {
+	offsets := []string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/api/v2/members", req.URL.Path)
+		offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+		offsets = append(offsets, req.URL.Query().Get("offset"))
+		page := membersPage{TotalCount: membersPageSize + 1}
+		for i := offset; i < membersPageSize+1 && i < offset+membersPageSize; i++ {
+			page.Items = append(page.Items, Member{Id: strconv.Itoa(i), Email: fmt.Sprintf("member-%d@example.com", i)})
+		}
+		json.NewEncoder(res).Encode(page)
+	}))
+	defer testServer.Close()
+
+	client := InitApiClient(ApiOptions{ApiKey: "api-x", BaseUri: testServer.URL})
+	members, err := client.GetMembers()
+	require.NoError(t, err)
+	require.Len(t, members, membersPageSize+1)
+	require.Equal(t, "member-100@example.com", members[membersPageSize].Email)
+	require.Equal(t, []string{"0", "100"}, offsets)
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.wait()
+	}
+	// The first request isn't delayed
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+}
@@ -0,0 +1,26 @@
+package ld
+
+// FlagMaintainer is the LaunchDarkly member responsible for a flag.
+type FlagMaintainer struct {
+	Id string
+	// Email is empty if the flag only identifies its maintainer by id.
+	Email string
+}
+
+// Maintainers returns the maintainer of each flag which has one, keyed by flag key.
+func (f ProjectFlags) Maintainers() map[string]FlagMaintainer {
+	maintainers := map[string]FlagMaintainer{}
+	for _, flag := range f.items {
+		m := FlagMaintainer{Id: flag.MaintainerId}
+		if flag.Maintainer != nil {
+			if m.Id == "" {
+				m.Id = flag.Maintainer.Id
+			}
+			m.Email = flag.Maintainer.Email
+		}
+		if m.Id != "" || m.Email != "" {
+			maintainers[flag.Key] = m
+		}
+	}
+	return maintainers
+}
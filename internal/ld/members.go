@@ -0,0 +1,50 @@
+package ld
+
+import (
+	"encoding/json"
+	"fmt"
+
+	h "github.com/hashicorp/go-retryablehttp"
+)
+
+// Member is a member of a LaunchDarkly account.
+type Member struct {
+	Id        string `json:"_id"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+}
+
+type membersPage struct {
+	Items      []Member `json:"items"`
+	TotalCount int      `json:"totalCount"`
+}
+
+// membersPageSize is the number of members requested at a time.
+const membersPageSize = 100
+
+// GetMembers returns every member of the LaunchDarkly account, retrieving them a page at a time.
+func (c ApiClient) GetMembers() ([]Member, error) {
+	members := []Member{}
+	for {
+		url := fmt.Sprintf("%s%s/members?limit=%d&offset=%d", c.Options.BaseUri, v2ApiPath, membersPageSize, len(members))
+		req, err := h.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page membersPage
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page.Items...)
+		if len(page.Items) < membersPageSize || len(members) >= page.TotalCount {
+			return members, nil
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package ld
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	h "github.com/hashicorp/go-retryablehttp"
+)
+
+// SignatureHeader contains the signature of the body of code reference uploads, when a signing secret is provided,
+// e.g. X-Code-Refs-Signature: sha256=<hex encoded HMAC>. Gateways in front of LaunchDarkly can verify it to check
+// that uploads were sent by a pipeline which knows the secret.
+const SignatureHeader = "X-Code-Refs-Signature"
+
+// Signature returns the value of SignatureHeader for a request body: the hex encoded HMAC-SHA256 of body, keyed by secret.
+func Signature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sign adds a signature of body to req, if a signing secret was provided.
+func (c ApiClient) sign(req *h.Request, body []byte) {
+	if c.Options.SigningSecret != "" {
+		req.Header.Set(SignatureHeader, Signature(body, c.Options.SigningSecret))
+	}
+}
@@ -0,0 +1,158 @@
+package ld
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+
+	h "github.com/hashicorp/go-retryablehttp"
+
+	ldapi "github.com/launchdarkly/api-client-go"
+)
+
+// FlagStatus is the status of a flag in each of a project's environments, keyed by environment key.
+type FlagStatus map[string]FlagEnvironmentStatus
+
+// FlagEnvironmentStatus is the status of a flag in a single environment.
+type FlagEnvironmentStatus struct {
+	On           bool       `json:"on"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	// Status is one of new, active, inactive, or launched
+	Status        string     `json:"status,omitempty"`
+	LastRequested *time.Time `json:"lastRequested,omitempty"`
+}
+
+// ProjectFlags are the flags in a project, with their environment configurations and maintainers. They're retrieved
+// once by GetProjectFlags, and shared by GetFlagStatuses and Maintainers.
+type ProjectFlags struct {
+	items []ldapi.FeatureFlag
+}
+
+type projectFlagsPage struct {
+	Items []ldapi.FeatureFlag `json:"items"`
+	// TotalCount is omitted by versions of the API which return every flag at once.
+	TotalCount *int `json:"totalCount"`
+}
+
+// GetProjectFlags returns every flag in the project, retrieving them a page at a time.
+func (c ApiClient) GetProjectFlags() (ProjectFlags, error) {
+	// Requests are authorized with the project's api key, if provided
+	pc := c
+	if key, ok := c.Options.ProjectApiKeys[c.Options.ProjKey]; ok {
+		pc.Options.ApiKey = key
+	}
+
+	flags := ProjectFlags{items: []ldapi.FeatureFlag{}}
+	for {
+		reqUrl := fmt.Sprintf("%s%s/flags/%s?limit=%d&offset=%d", c.Options.BaseUri, v2ApiPath, url.PathEscape(c.Options.ProjKey), flagsPageSize, len(flags.items))
+		req, err := h.NewRequest("GET", reqUrl, nil)
+		if err != nil {
+			return ProjectFlags{}, err
+		}
+		res, err := pc.do(req)
+		if err != nil {
+			return ProjectFlags{}, err
+		}
+		var page projectFlagsPage
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return ProjectFlags{}, err
+		}
+		flags.items = append(flags.items, page.Items...)
+		if page.TotalCount == nil || len(page.Items) == 0 || len(flags.items) >= *page.TotalCount {
+			return flags, nil
+		}
+	}
+}
+
+// GetFlagStatuses returns the status of each of the project's flags in each of its environments, keyed by flag key.
+func (c ApiClient) GetFlagStatuses(flags ProjectFlags) (map[string]FlagStatus, error) {
+	ctx := c.projectContext(c.Options.ProjKey)
+	statuses := map[string]FlagStatus{}
+	envKeys := map[string]bool{}
+	for _, flag := range flags.items {
+		status := FlagStatus{}
+		for envKey, config := range flag.Environments {
+			envStatus := FlagEnvironmentStatus{On: config.On}
+			if config.LastModified > 0 {
+				t := time.Unix(0, config.LastModified*int64(time.Millisecond)).UTC()
+				envStatus.LastModified = &t
+			}
+			status[envKey] = envStatus
+			envKeys[envKey] = true
+		}
+		statuses[flag.Key] = status
+	}
+
+	for envKey := range envKeys {
+		envStatuses, _, err := c.ldClient.FeatureFlagsApi.GetFeatureFlagStatuses(ctx, c.Options.ProjKey, envKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range envStatuses.Items {
+			if s.Links == nil || s.Links.Self == nil {
+				continue
+			}
+			// Flag statuses only identify their flag by their url, e.g. /api/v2/flag-statuses/projKey/envKey/flagKey
+			flagKey := path.Base(s.Links.Self.Href)
+			envStatus, ok := statuses[flagKey][envKey]
+			if !ok {
+				continue
+			}
+			envStatus.Status = s.Name
+			if t, err := time.Parse(time.RFC3339, s.LastRequested); err == nil {
+				t = t.UTC()
+				envStatus.LastRequested = &t
+			}
+			statuses[flagKey][envKey] = envStatus
+		}
+	}
+	return statuses, nil
+}
+
+// EnvironmentKeys returns the keys of the environments the flag has a status in, sorted.
+func (s FlagStatus) EnvironmentKeys() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// OnCount returns the number of environments the flag is on in.
+func (s FlagStatus) OnCount() int {
+	count := 0
+	for _, env := range s {
+		if env.On {
+			count++
+		}
+	}
+	return count
+}
+
+// LastModified returns the most recent time the flag was modified in any environment.
+func (s FlagStatus) LastModified() *time.Time {
+	var ret *time.Time
+	for _, env := range s {
+		if env.LastModified != nil && (ret == nil || env.LastModified.After(*ret)) {
+			ret = env.LastModified
+		}
+	}
+	return ret
+}
+
+// LastRequested returns the most recent time the flag was requested in any environment.
+func (s FlagStatus) LastRequested() *time.Time {
+	var ret *time.Time
+	for _, env := range s {
+		if env.LastRequested != nil && (ret == nil || env.LastRequested.After(*ret)) {
+			ret = env.LastRequested
+		}
+	}
+	return ret
+}
@@ -0,0 +1,65 @@
+package ld
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// LoadClientCertificate loads a PEM encoded client certificate and private key for mutual TLS. Each of cert
+// and key may be either a path to a PEM file, or the PEM encoded contents themselves, e.g. when provided by an
+// environment variable. Certificates are read each time the scanner runs, so rotated certificates are picked
+// up without any other changes.
+func LoadClientCertificate(cert, key string) (*tls.Certificate, error) {
+	certPEM, err := readPEM(cert)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client certificate: %s", err)
+	}
+	keyPEM, err := readPEM(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not read client key: %s", err)
+	}
+	err = checkPEMBlocks(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate: %s", err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate: %s", err)
+	}
+	// Only the first certificate is parsed by X509KeyPair, so invalid certificates in the rest of the chain would
+	// otherwise be sent to LaunchDarkly
+	for i, der := range pair.Certificate {
+		if _, err := x509.ParseCertificate(der); err != nil {
+			return nil, fmt.Errorf("invalid client certificate %d in chain: %s", i+1, err)
+		}
+	}
+	return &pair, nil
+}
+
+// checkPEMBlocks returns an error if any PEM block in data can't be decoded, since pem.Decode skips them.
+func checkPEMBlocks(data []byte) error {
+	blocks := 0
+	for rest := data; ; blocks++ {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+	}
+	if begun := bytes.Count(data, []byte("-----BEGIN")); blocks < begun {
+		return fmt.Errorf("%d of %d PEM blocks could not be decoded", begun-blocks, begun)
+	}
+	return nil
+}
+
+func readPEM(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return ioutil.ReadFile(value)
+}
@@ -0,0 +1,64 @@
+package ld
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testCertificate(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ld-find-code-refs"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	certPEM, keyPEM := testCertificate(t)
+
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPath := filepath.Join(dir, "cert.pem")
+	require.NoError(t, ioutil.WriteFile(certPath, certPEM, 0600))
+
+	// A path and PEM contents may be mixed
+	cert, err := LoadClientCertificate(certPath, string(keyPEM))
+	require.NoError(t, err)
+	require.Len(t, cert.Certificate, 1)
+
+	_, err = LoadClientCertificate(filepath.Join(dir, "missing.pem"), string(keyPEM))
+	require.Error(t, err)
+
+	_, otherKeyPEM := testCertificate(t)
+	_, err = LoadClientCertificate(string(certPEM), string(otherKeyPEM))
+	require.Error(t, err)
+	// Certificates in the chain which can't be decoded or parsed aren't skipped
+	_, err = LoadClientCertificate(string(certPEM)+"-----BEGIN CERTIFICATE-----\nnot base64\n-----END CERTIFICATE-----\n", string(keyPEM))
+	require.EqualError(t, err, "invalid client certificate: 1 of 2 PEM blocks could not be decoded")
+	invalid := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("not a certificate")})
+	_, err = LoadClientCertificate(string(certPEM)+string(invalid), string(keyPEM))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid client certificate 2 in chain")
+}
@@ -0,0 +1,39 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// NoColor disables colored output, even when writing to a terminal. It must be set before calling Init.
+var NoColor = false
+
+// ANSI escape codes for log levels
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// Colors returns true if output written to w should be colored: w is a terminal, and colors haven't been disabled
+// with NoColor or the NO_COLOR environment variable (https://no-color.org). Output redirected to files or pipes,
+// e.g. CI logs, is never colored.
+func Colors(w io.Writer) bool {
+	if NoColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// prefix returns the prefix of log lines for a level. In terminals, the level is colored and padded, so messages of
+// every level are aligned.
+func prefix(w io.Writer, level, color string) string {
+	if !Colors(w) {
+		return level + ": "
+	}
+	return fmt.Sprintf("%s%-8s%s ", color, level+":", ansiReset)
+}
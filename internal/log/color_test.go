@@ -0,0 +1,22 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestColors(t *testing.T) {
+	require.False(t, Colors(&bytes.Buffer{}), "only terminals are colored")
+
+	f, err := ioutil.TempFile("", "log")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	require.False(t, Colors(f), "files are not colored")
+
+	require.Equal(t, "WARNING: ", prefix(f, "WARNING", ansiYellow))
+}
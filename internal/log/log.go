@@ -1,9 +1,13 @@
 package log
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"sync"
+	"time"
 )
 
 // Global package level loggers
@@ -11,29 +15,131 @@ var (
 	Debug   *log.Logger
 	Info    *log.Logger
 	Warning *log.Logger
-	Error   *log.Logger
+	Error   *ErrorLogger
 )
 
-// Init overrides the default loggers that write to stdout
+// ErrorLogger is a logger whose Fatalf method calls FatalHook, if it is set, before exiting.
+type ErrorLogger struct {
+	*log.Logger
+}
+
+// FatalHook is called with the message of a fatal error after it is logged, and returns the exit code of the process.
+// If it is not set, the process exits with 1.
+var FatalHook func(msg string) int
+
+// Fatalf logs an error, and exits the process after running the exit hooks.
+func (l *ErrorLogger) Fatalf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	_ = l.Output(2, msg)
+	Exit(fatalExitCode(msg))
+}
+
+var (
+	exitHooks     []func()
+	exitHooksLock sync.Mutex
+)
+
+// AtExit registers fn to be run before the process exits because of a fatal error, or by Exit, e.g. to release locks
+// or remove temporary files. Deferred calls don't run in those cases.
+func AtExit(fn func()) {
+	exitHooksLock.Lock()
+	defer exitHooksLock.Unlock()
+	exitHooks = append(exitHooks, fn)
+}
+
+// RunExitHooks runs the hooks registered with AtExit which haven't run yet, in the reverse order they were registered.
+func RunExitHooks() {
+	exitHooksLock.Lock()
+	hooks := exitHooks
+	exitHooks = nil
+	exitHooksLock.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+// Exit runs the exit hooks, and exits the process with code. It should be used instead of os.Exit once hooks may have
+// been registered.
+func Exit(code int) {
+	RunExitHooks()
+	os.Exit(code)
+}
+
+func fatalExitCode(msg string) int {
+	if FatalHook == nil {
+		return 1
+	}
+	return FatalHook(msg)
+}
+
+// LocalTime displays log timestamps in the local time zone, rather than UTC. It must be set before calling Init.
+var LocalTime = false
+
+// Stderr writes logs and tables to stderr rather than stdout, so stdout only contains output meant to be parsed. It
+// must be set before calling Init.
+var Stderr = false
+
+// Output returns the writer for tables and other console output which isn't meant to be parsed.
+func Output() io.Writer {
+	if Stderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Init overrides the default loggers that write to stdout, or stderr if Stderr is set. Log levels are colored when
+// writing to a terminal, unless NoColor is set.
 func Init(debug bool) {
+	out := Output()
 	debugHandle := ioutil.Discard
 	if debug {
-		debugHandle = os.Stdout
+		debugHandle = out
 	}
 
-	Debug = log.New(debugHandle,
-		"DEBUG: ",
-		log.Ldate|log.Ltime|log.Lshortfile)
+	Debug = log.New(timestampWriter{debugHandle, prefix(debugHandle, "DEBUG", ansiDim)},
+		"",
+		log.Lshortfile)
 
-	Info = log.New(os.Stdout,
-		"INFO: ",
-		log.Ldate|log.Ltime|log.Lshortfile)
+	Info = log.New(timestampWriter{out, prefix(out, "INFO", ansiCyan)},
+		"",
+		log.Lshortfile)
 
-	Warning = log.New(os.Stdout,
-		"WARNING: ",
-		log.Ldate|log.Ltime|log.Lshortfile)
+	Warning = log.New(timestampWriter{out, prefix(out, "WARNING", ansiYellow)},
+		"",
+		log.Lshortfile)
 
-	Error = log.New(os.Stderr,
-		"ERROR: ",
-		log.Ldate|log.Ltime|log.Lshortfile)
+	Error = &ErrorLogger{log.New(timestampWriter{os.Stderr, prefix(os.Stderr, "ERROR", ansiRed)},
+		"",
+		log.Lshortfile)}
+}
+
+// Quiet runs fn with Debug, Info, and Warning logs discarded, e.g. to repeat work whose logs were already written.
+// Errors are still logged.
+func Quiet(fn func()) {
+	debug, info, warning := Debug, Info, Warning
+	discard := log.New(ioutil.Discard, "", 0)
+	Debug, Info, Warning = discard, discard, discard
+	defer func() {
+		Debug, Info, Warning = debug, info, warning
+	}()
+	fn()
+}
+
+// timestampWriter writes log lines with a level prefix and an RFC3339 timestamp. Unlike the standard logger's date
+// and time flags, the timestamp includes its time zone, so logs from hosts in different zones can be compared.
+type timestampWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+func (t timestampWriter) Write(p []byte) (int, error) {
+	now := time.Now()
+	if !LocalTime {
+		now = now.UTC()
+	}
+	_, err := fmt.Fprintf(t.w, "%s%s %s", t.prefix, now.Format(time.RFC3339), p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
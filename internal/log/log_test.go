@@ -0,0 +1,60 @@
+package log
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := timestampWriter{&buf, "INFO: "}
+	n, err := w.Write([]byte("log.go:1: message\n"))
+	require.NoError(t, err)
+	require.Equal(t, len("log.go:1: message\n"), n)
+	require.Regexp(t, regexp.MustCompile(`^INFO: \d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ log.go:1: message\n$`), buf.String())
+}
+
+func TestOutput(t *testing.T) {
+	require.Equal(t, os.Stdout, Output())
+	Stderr = true
+	defer func() { Stderr = false }()
+	require.Equal(t, os.Stderr, Output())
+}
+
+func TestFatalExitCode(t *testing.T) {
+	require.Equal(t, 1, fatalExitCode("error"))
+	var got string
+	FatalHook = func(msg string) int {
+		got = msg
+		return 0
+	}
+	defer func() { FatalHook = nil }()
+	require.Equal(t, 0, fatalExitCode("error"))
+	require.Equal(t, "error", got)
+}
+
+func TestRunExitHooks(t *testing.T) {
+	ran := []int{}
+	AtExit(func() { ran = append(ran, 1) })
+	AtExit(func() { ran = append(ran, 2) })
+	RunExitHooks()
+	require.Equal(t, []int{2, 1}, ran)
+
+	// Hooks only run once
+	RunExitHooks()
+	require.Equal(t, []int{2, 1}, ran)
+}
+
+func TestQuiet(t *testing.T) {
+	Init(false)
+	info := Info
+	Quiet(func() {
+		require.NotEqual(t, info, Info)
+		Info.Printf("discarded")
+	})
+	require.Equal(t, info, Info)
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package log
+
+import "os"
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
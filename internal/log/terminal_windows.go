@@ -0,0 +1,9 @@
+package log
+
+import "os"
+
+// isTerminal always returns false on Windows, where consoles don't interpret ANSI escape codes unless
+// virtual terminal processing is enabled, so output is never colored.
+func isTerminal(f *os.File) bool {
+	return false
+}
@@ -0,0 +1,313 @@
+package options
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ConfigFileName is the name of the config file read from the scanned directory, if present.
+const ConfigFileName = "coderefs.yaml"
+
+// Options which are not written to generated config files. Secrets are omitted since config files are
+// typically checked in to source control, and the location of the config file depends on dir.
+var omittedConfigFileOptions = map[string]bool{
+	string(AccessToken):     true,
+	string(ApiHeader):       true,
+	string(ClientKey):       true,
+	string(ConfigFile):      true,
+	string(Dir):             true,
+	string(Profile):         true,
+	string(ProjAccessToken): true,
+	string(SigningSecret):   true,
+}
+
+// configProfilesKey is the config file key containing named sets of options, which are selected with the profile
+// option.
+const configProfilesKey = "profiles"
+
+// ConfigFilePath returns the path of the config file: the configFile option, or coderefs.yaml in dir.
+func ConfigFilePath() string {
+	if path := ConfigFile.Value(); path != "" {
+		return path
+	}
+	return filepath.Join(RepoDir(), ConfigFileName)
+}
+
+// SetConfigFileValue sets a top level option in the config file at path, creating the file if it doesn't exist. The
+// rest of the file, including comments, is kept as it is. Options with values spanning several lines, and options in
+// profiles, aren't changed, and an error is returned if the profile selected by the profile option sets the option,
+// since it would override the top level value.
+func SetConfigFileValue(path, name string, value interface{}) error {
+	line, err := yaml.Marshal(yaml.MapSlice{{Key: name, Value: value}})
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read config file: %s", err)
+	}
+	if profile := Profile.Value(); profile != "" {
+		config := map[string]interface{}{}
+		err = yaml.Unmarshal(data, &config)
+		if err != nil {
+			return fmt.Errorf("could not parse config file %s: %s", path, err)
+		}
+		profiles, err := configProfiles(config[configProfilesKey])
+		if err != nil {
+			return fmt.Errorf("could not parse profiles in config file %s: %s", path, err)
+		}
+		if _, ok := profiles[profile][name]; ok {
+			return fmt.Errorf("%s is set by profile %q in config file %s, and must be changed by hand", name, profile, path)
+		}
+	}
+
+	content := string(data)
+	lines := strings.SplitAfter(content, "\n")
+	set := false
+	for i, l := range lines {
+		if !strings.HasPrefix(l, name+":") {
+			continue
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(l, name+":"))
+		if rest == "" || strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">") {
+			return fmt.Errorf("%s spans several lines in config file %s, and must be changed by hand", name, path)
+		}
+		lines[i] = string(line)
+		set = true
+		break
+	}
+	if set {
+		content = strings.Join(lines, "")
+	} else {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += string(line)
+	}
+	return ioutil.WriteFile(path, []byte(content), 0644)
+}
+
+// loadConfigFile sets options from a YAML config file, and the config file's profile selected by the profile option.
+// Options which were explicitly provided as command line arguments take precedence over the config file.
+func loadConfigFile() error {
+	path := ConfigFilePath()
+	explicit := ConfigFile.Value() != ""
+
+	profile := Profile.Value()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit && profile == "" {
+			return nil
+		}
+		return fmt.Errorf("could not read config file: %s", err)
+	}
+
+	config := map[string]interface{}{}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return fmt.Errorf("could not parse config file %s: %s", path, err)
+	}
+	profiles, err := configProfiles(config[configProfilesKey])
+	if err != nil {
+		return fmt.Errorf("could not parse profiles in config file %s: %s", path, err)
+	}
+	delete(config, configProfilesKey)
+	if profile != "" {
+		values, ok := profiles[profile]
+		if !ok {
+			names := make([]string, 0, len(profiles))
+			for name := range profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("profile %q not found in config file %s, available profiles: %s", profile, path, strings.Join(names, ", "))
+		}
+		// Options in the profile replace options at the top level of the config file
+		for name, value := range values {
+			if options.find(name) == nil || name == string(ConfigFile) || name == string(Profile) {
+				return fmt.Errorf("unknown option %q in profile %q of config file %s", name, profile, path)
+			}
+			config[name] = value
+		}
+	}
+
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	for name, value := range config {
+		if options.find(name) == nil || name == string(ConfigFile) || name == string(Profile) {
+			return fmt.Errorf("unknown option %q in config file %s", name, path)
+		}
+		if setFlags[name] {
+			continue
+		}
+		values := []interface{}{value}
+		if list, ok := value.([]interface{}); ok {
+			values = list
+		}
+		var err error
+		for _, v := range values {
+			s := fmt.Sprint(v)
+			if name == string(Dir) && !filepath.IsAbs(s) {
+				// Directories in the config file are relative to the config file
+				s = filepath.Join(filepath.Dir(path), s)
+			}
+			err = flag.Set(name, s)
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for option %q in config file %s: %s", name, path, err)
+		}
+	}
+	return nil
+}
+
+// configProfiles parses the profiles of a config file, a map of profile names to maps of option values.
+func configProfiles(value interface{}) (map[string]map[string]interface{}, error) {
+	profiles := map[string]map[string]interface{}{}
+	if value == nil {
+		return profiles, nil
+	}
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("profiles must be a map of profile names to options")
+	}
+	for name, options := range m {
+		profile := map[string]interface{}{}
+		if options != nil {
+			values, ok := options.(map[interface{}]interface{})
+			if !ok {
+				return nil, fmt.Errorf("profile %q must be a map of option names to values", fmt.Sprint(name))
+			}
+			for k, v := range values {
+				profile[fmt.Sprint(k)] = v
+			}
+		}
+		profiles[fmt.Sprint(name)] = profile
+	}
+	return profiles, nil
+}
+
+// ConfigFileOptions returns the options that have been set to non-default values, either as command line
+// arguments or LD_ prefixed environment variables, in a form that can be written to a config file. Command line
+// arguments must already have been parsed by Parse or Init.
+func ConfigFileOptions() yaml.MapSlice {
+	if !populated {
+		Populate()
+	}
+
+	values := map[string]interface{}{}
+	for name, v := range EnvOptions() {
+		values[name] = v
+		if _, ok := options.find(name).defaultValue.([]string); ok {
+			// An environment variable provides a single value of an option which may be provided multiple times
+			values[name] = []string{v}
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+		if s, ok := f.Value.(*stringSlice); ok {
+			values[f.Name] = s.Get()
+		}
+	})
+
+	names := []string{}
+	for name, v := range values {
+		o := options.find(name)
+		if o == nil || fmt.Sprint(v) == fmt.Sprint(o.defaultValue) || omittedConfigFileOptions[name] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	config := yaml.MapSlice{}
+	for _, name := range names {
+		value := values[name]
+		// Options which may be provided multiple times are written as sequences, which are read as one value each
+		if s, ok := value.(string); ok {
+			value = typedValue(name, s)
+		}
+		config = append(config, yaml.MapItem{Key: name, Value: value})
+	}
+	return config
+}
+
+// typedValue converts a string option value to the option's type, so it is written to a config file without quotes.
+func typedValue(name, value string) interface{} {
+	var err error
+	var v interface{}
+	switch options.find(name).defaultValue.(type) {
+	case int64:
+		v, err = strconv.ParseInt(value, 10, 64)
+	case int:
+		v, err = strconv.Atoi(value)
+	case bool:
+		v, err = strconv.ParseBool(value)
+	default:
+		return value
+	}
+	if err != nil {
+		return value
+	}
+	return v
+}
+
+// EnvOptions returns the values of options provided as LD_ prefixed environment variables.
+func EnvOptions() map[string]string {
+	values := map[string]string{}
+	for n := range options {
+		if v, ok := os.LookupEnv(EnvVarName(n)); ok {
+			values[n.name()] = v
+		}
+	}
+	return values
+}
+
+// SetDefaults sets option values which may still be overridden by command line arguments or a config file.
+func SetDefaults(values map[string]string) error {
+	if !populated {
+		Populate()
+	}
+	for name, value := range values {
+		f := flag.Lookup(name)
+		if f == nil || options.find(name) == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		// Setting the value directly, rather than with flag.Set, doesn't mark the flag as provided
+		err := f.Value.Set(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for option %q: %s", name, err)
+		}
+		if s, ok := f.Value.(*stringSlice); ok {
+			// Values provided later replace defaults, rather than being appended to them
+			s.provided = false
+		}
+	}
+	return nil
+}
+
+// EnvVarName returns the LD_ prefixed environment variable name for an option, e.g. LD_PROJ_KEY for projKey.
+func EnvVarName(o Option) string {
+	var sb strings.Builder
+	sb.WriteString("LD_")
+	for i, r := range o.name() {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			sb.WriteRune('_')
+		}
+		sb.WriteString(strings.ToUpper(string(r)))
+	}
+	return sb.String()
+}
@@ -0,0 +1,147 @@
+package options
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// withArgs resets options, and parses args as if they were provided on the command line. The returned function
+// restores the command line.
+func withArgs(t *testing.T, args ...string) func() {
+	prevArgs, prevFlags := os.Args, flag.CommandLine
+	os.Args = append([]string{"ld-find-code-refs"}, args...)
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	populated = false
+	Populate()
+	require.NoError(t, flag.CommandLine.Parse(args))
+	return func() {
+		os.Args, flag.CommandLine = prevArgs, prevFlags
+		populated = false
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	path := filepath.Join(dir, ConfigFileName)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestConfigFileOptions_roundTrip(t *testing.T) {
+	restore := withArgs(t, "-exclude=vendor/", "-contextLines=3", "-debug", "-flags=a,b", "-flags=c",
+		"-excludeFlags=test-*", "-excludeFlags=tmp-*")
+	config := ConfigFileOptions()
+	restore()
+
+	data, err := yaml.Marshal(config)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "flags:\n- a,b\n- c\n")
+	path := writeConfigFile(t, string(data))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	defer withArgs(t, "-configFile="+path)()
+	require.NoError(t, loadConfigFile())
+	require.Equal(t, "vendor/", Exclude.Value())
+	require.Equal(t, 3, ContextLines.Value())
+	require.True(t, Debug.Value())
+	require.Equal(t, []string{"a,b", "c"}, Flags.Value())
+	require.Equal(t, []string{"test-*", "tmp-*"}, ExcludeFlags.Value())
+}
+
+func TestConfigFileOptions_envSlice(t *testing.T) {
+	prev, ok := os.LookupEnv("LD_FLAGS")
+	os.Setenv("LD_FLAGS", "a,b")
+	defer func() {
+		if ok {
+			os.Setenv("LD_FLAGS", prev)
+		} else {
+			os.Unsetenv("LD_FLAGS")
+		}
+	}()
+	defer withArgs(t)()
+
+	config := ConfigFileOptions()
+	require.Equal(t, yaml.MapSlice{{Key: "flags", Value: []string{"a,b"}}}, config)
+}
+
+func TestLoadConfigFile_profiles(t *testing.T) {
+	path := writeConfigFile(t, `
+contextLines: 1
+exclude: vendor/
+profiles:
+  ci:
+    contextLines: 3
+    debug: true
+  nested:
+    profile: ci
+  recursive:
+    configFile: other.yaml
+  unknown:
+    notAnOption: true
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	specs := []struct {
+		name         string
+		args         []string
+		err          string
+		contextLines int
+		exclude      string
+		debug        bool
+	}{
+		{name: "no profile", contextLines: 1, exclude: "vendor/"},
+		{name: "profile", args: []string{"-profile=ci"}, contextLines: 3, exclude: "vendor/", debug: true},
+		{name: "command line overrides profile", args: []string{"-profile=ci", "-contextLines=2"}, contextLines: 2, exclude: "vendor/", debug: true},
+		{name: "command line overrides top level", args: []string{"-exclude=dist/"}, contextLines: 1, exclude: "dist/"},
+		{name: "unknown profile", args: []string{"-profile=prod"}, err: `profile "prod" not found in config file ` + path + `, available profiles: ci, nested, recursive, unknown`},
+		{name: "profile in profile", args: []string{"-profile=nested"}, err: `unknown option "profile" in profile "nested"`},
+		{name: "configFile in profile", args: []string{"-profile=recursive"}, err: `unknown option "configFile" in profile "recursive"`},
+		{name: "unknown option in profile", args: []string{"-profile=unknown"}, err: `unknown option "notAnOption" in profile "unknown"`},
+	}
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			defer withArgs(t, append([]string{"-configFile=" + path}, tt.args...)...)()
+			err := loadConfigFile()
+			if tt.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.contextLines, ContextLines.Value())
+			require.Equal(t, tt.exclude, Exclude.Value())
+			require.Equal(t, tt.debug, Debug.Value())
+		})
+	}
+}
+
+func TestSetConfigFileValue(t *testing.T) {
+	path := writeConfigFile(t, `# comment
+exclude: vendor/
+profiles:
+  ci:
+    exclude: dist/
+  local:
+    debug: true
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	restore := withArgs(t, "-profile=ci")
+	err := SetConfigFileValue(path, string(Exclude), "vendor/|dist/")
+	restore()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `exclude is set by profile "ci"`)
+
+	defer withArgs(t, "-profile=local")()
+	require.NoError(t, SetConfigFileValue(path, string(Exclude), "vendor/|dist/"))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "# comment\nexclude: vendor/|dist/\nprofiles:\n")
+}
@@ -5,9 +5,14 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
 )
 
 // Can't wait for contracts
@@ -20,6 +25,9 @@ type IntOption string
 type Int64Option string
 type BoolOption string
 
+// StringSliceOption may be provided multiple times. Each value is appended to the list of values.
+type StringSliceOption string
+
 func (o StringOption) name() string {
 	return string(o)
 }
@@ -32,6 +40,9 @@ func (o Int64Option) name() string {
 func (o BoolOption) name() string {
 	return string(o)
 }
+func (o StringSliceOption) name() string {
+	return string(o)
+}
 
 func (o StringOption) Value() string {
 	return flag.Lookup(string(o)).Value.String()
@@ -56,21 +67,155 @@ func (o BoolOption) Value() bool {
 	return flag.Lookup(string(o)).Value.(flag.Getter).Get().(bool)
 }
 
+func (o StringSliceOption) Value() []string {
+	return flag.Lookup(string(o)).Value.(flag.Getter).Get().([]string)
+}
+
+// RepoDir returns the first dir option, which is the directory the config file is read from, and the repository's
+// git metadata is read from. It is empty if dir was not provided.
+func RepoDir() string {
+	dirs := Dir.Value()
+	if len(dirs) == 0 {
+		return ""
+	}
+	return dirs[0]
+}
+
+// stringSlice is a flag.Value which appends each value it is set to. Default values are replaced by the first
+// value provided.
+type stringSlice struct {
+	values   []string
+	provided bool
+}
+
+func (s *stringSlice) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(s.values, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	if !s.provided {
+		s.values = nil
+		s.provided = true
+	}
+	s.values = append(s.values, value)
+	return nil
+}
+
+func (s *stringSlice) Get() interface{} {
+	return append([]string{}, s.values...)
+}
+
 const (
-	AccessToken       = StringOption("accessToken")
-	BaseUri           = StringOption("baseUri")
-	ContextLines      = IntOption("contextLines")
-	Debug             = BoolOption("debug")
-	DefaultBranch     = StringOption("defaultBranch")
-	Dir               = StringOption("dir")
-	Exclude           = StringOption("exclude")
-	ProjKey           = StringOption("projKey")
-	UpdateSequenceId  = Int64Option("updateSequenceId")
-	RepoName          = StringOption("repoName")
-	RepoType          = StringOption("repoType")
-	RepoUrl           = StringOption("repoUrl")
-	CommitUrlTemplate = StringOption("commitUrlTemplate")
-	HunkUrlTemplate   = StringOption("hunkUrlTemplate")
+	AccessToken        = StringOption("accessToken")
+	AccessTokenSecret  = StringOption("accessTokenSecret")
+	AccessTokenSource  = StringOption("accessTokenSource")
+	AllowPartialFlags  = BoolOption("allowPartialFlags")
+	ApiHeader          = StringSliceOption("apiHeader")
+	ApiKeepAlive       = BoolOption("apiKeepAlive")
+	ApiMaxIdleConns    = IntOption("apiMaxIdleConns")
+	ApiTimeout         = IntOption("apiTimeout")
+	ApplySuggestions   = BoolOption("applySuggestions")
+	BaseUri            = StringOption("baseUri")
+	BranchName         = StringOption("branchName")
+	CacheDir           = StringOption("cacheDir")
+	CatalogFile        = StringOption("catalogFile")
+	CatalogFormat      = StringOption("catalogFormat")
+	CatalogOwner       = StringOption("catalogOwner")
+	CacheMaxSize       = IntOption("cacheMaxSize")
+	ClientCert         = StringOption("clientCert")
+	ClientKey          = StringOption("clientKey")
+	CommitSequenceId   = BoolOption("commitSequenceId")
+	ConfigFile         = StringOption("configFile")
+	ContextLines       = IntOption("contextLines")
+	Debug              = BoolOption("debug")
+	DebugHttp          = BoolOption("debugHttp")
+	DefaultBranch      = StringOption("defaultBranch")
+	DeltaUpload        = BoolOption("deltaUpload")
+	DiffStrategy       = StringOption("diffStrategy")
+	Dir                = StringSliceOption("dir")
+	DryRun             = BoolOption("dryRun")
+	EmitJsonSummary    = BoolOption("emitJsonSummary")
+	Enabled            = BoolOption("enabled")
+	EnvironmentKey     = StringOption("environmentKey")
+	EnvPrefixes        = StringSliceOption("envPrefixes")
+	EnvReferences      = BoolOption("envReferences")
+	Exclude            = StringOption("exclude")
+	ExcludeFlags       = StringSliceOption("excludeFlags")
+	Explain            = StringOption("explain")
+	FailOnDisabledRepo = BoolOption("failOnDisabledRepo")
+	FlagMaintainers    = BoolOption("flagMaintainers")
+	FlagStatus         = BoolOption("flagStatus")
+	Flags              = StringSliceOption("flags")
+	HeatmapDepth       = IntOption("heatmapDepth")
+	HttpCaptureFile    = StringOption("httpCaptureFile")
+	HunkMetadata       = StringSliceOption("hunkMetadata")
+	IncludeExtensions  = StringOption("includeExtensions")
+	LanguageStats      = BoolOption("languageStats")
+	LfsPaths           = StringOption("lfsPaths")
+	LocalTime          = BoolOption("localTime")
+	MaxBlankLines      = IntOption("maxBlankLines")
+	MaxConcurrency     = IntOption("maxConcurrency")
+	MaxDepth           = IntOption("maxDepth")
+	MatcherPlugin      = StringSliceOption("matcherPlugin")
+	MaxFiles           = IntOption("maxFiles")
+	MaxFlags           = IntOption("maxFlags")
+	MaxHunkBytes       = IntOption("maxHunkBytes")
+	MaxScanSeconds     = IntOption("maxScanSeconds")
+	MaxUploadBytes     = IntOption("maxUploadBytes")
+	MinScore           = IntOption("minScore")
+	Niceness           = IntOption("niceness")
+	NoColor            = BoolOption("noColor")
+	NormalizeFlagKey   = StringSliceOption("normalizeFlagKey")
+	OnBudgetExceeded   = StringOption("onBudgetExceeded")
+	OnHeadDrift        = StringOption("onHeadDrift")
+	OnStaleHead        = StringOption("onStaleHead")
+	OnPullRequest      = StringOption("onPullRequest")
+	OnZeroReferences   = StringOption("onZeroReferences")
+	OneFileSystem      = BoolOption("oneFileSystem")
+	OutFile            = StringOption("outFile")
+	OutFormat          = StringOption("outFormat")
+	PathsRelativeToDir = BoolOption("pathsRelativeToDir")
+	Pathspec           = StringSliceOption("pathspec")
+	PlainHunks         = BoolOption("plainHunks")
+	PluginTimeout      = IntOption("pluginTimeout")
+	Profile            = StringOption("profile")
+	ProjAccessToken    = StringSliceOption("projAccessToken")
+	ProjKey            = StringOption("projKey")
+	SigningSecret      = StringOption("signingSecret")
+	SoftFail           = BoolOption("softFail")
+	SpoolDir           = StringOption("spoolDir")
+	StreamFlags        = BoolOption("streamFlags")
+	SuggestExcludes    = BoolOption("suggestExcludes")
+	SuggestOwners      = BoolOption("suggestOwners")
+	TabWidth           = IntOption("tabWidth")
+	Tag                = StringOption("tag")
+	TagPrefix          = StringOption("tagPrefix")
+	UpdateSequenceId   = Int64Option("updateSequenceId")
+	UploadMetadata     = BoolOption("uploadMetadata")
+	Vcs                = StringOption("vcs")
+	TestReferences     = StringOption("testReferences")
+	TmpDir             = StringOption("tmpDir")
+	TrackedOnly        = BoolOption("trackedOnly")
+	TrimWhitespace     = BoolOption("trimWhitespace")
+	Sample             = IntOption("sample")
+	SearchStrategy     = StringOption("searchStrategy")
+	SearchTool         = StringOption("searchTool")
+	ToolCacheDir       = StringOption("toolCacheDir")
+	RepoName           = StringOption("repoName")
+	Remote             = StringOption("remote")
+	Service            = StringSliceOption("service")
+	ResultsSigningKey  = StringOption("resultsSigningKey")
+	ResultsVerifyKey   = StringOption("resultsVerifyKey")
+	RepoType           = StringOption("repoType")
+	RepoUrl            = StringOption("repoUrl")
+	CommitUrlTemplate  = StringOption("commitUrlTemplate")
+	HunkUrlTemplate    = StringOption("hunkUrlTemplate")
+	VerifyDeterminism  = BoolOption("verifyDeterminism")
+	VerifyUpload       = BoolOption("verifyUpload")
+	WaitForLock        = BoolOption("waitForLock")
 )
 
 type option struct {
@@ -90,40 +235,211 @@ func (m optionMap) find(name string) *option {
 	return nil
 }
 
+// Acceptable values for the outFormat option
+const (
+	OutFormatJson     = "json"
+	OutFormatQuickfix = "quickfix"
+	OutFormatLsp      = "lsp"
+	OutFormatHtml     = "html"
+	OutFormatCsv      = "csv"
+)
+
+// Acceptable values for the catalogFormat option
+const (
+	CatalogFormatJson      = "json"
+	CatalogFormatBackstage = "backstage"
+)
+
+// Acceptable values for the testReferences option
+const (
+	TestReferencesInclude = "include"
+	TestReferencesExclude = "exclude"
+)
+
+// Acceptable values for the onBudgetExceeded option
+const (
+	OnBudgetExceededWarn = "warn"
+	OnBudgetExceededFail = "fail"
+)
+
+// Acceptable values for the diffStrategy option
+const (
+	DiffStrategyFirstParent = "firstParent"
+	DiffStrategyAllParents  = "allParents"
+)
+
+// Acceptable values for the onPullRequest option
+const (
+	OnPullRequestUpload       = "upload"
+	OnPullRequestSkip         = "skip"
+	OnPullRequestSourceBranch = "sourceBranch"
+)
+
+// Acceptable values for the onStaleHead option
+const (
+	OnStaleHeadIgnore = "ignore"
+	OnStaleHeadWarn   = "warn"
+	OnStaleHeadSkip   = "skip"
+)
+
+// Acceptable values for the onHeadDrift option
+const (
+	OnHeadDriftFail  = "fail"
+	OnHeadDriftRetry = "retry"
+	OnHeadDriftWarn  = "warn"
+)
+
+// Acceptable values for the onZeroReferences option
+const (
+	OnZeroReferencesUpload = "upload"
+	OnZeroReferencesSkip   = "skip"
+	OnZeroReferencesFail   = "fail"
+)
+
 const (
 	noUpdateSequenceId  = int64(-1)
 	defaultContextLines = 2
+	defaultCacheMaxSize = 1024
 )
 
 var options = optionMap{
-	AccessToken:       option{"", "LaunchDarkly personal access token with write-level access.", true},
-	BaseUri:           option{"https://app.launchdarkly.com", "LaunchDarkly base URI.", false},
-	ContextLines:      option{defaultContextLines, "The number of context lines to send to LaunchDarkly. If < 0, no source code will be sent to LaunchDarkly. If 0, only the lines containing flag references will be sent. If > 0, will send that number of context lines above and below the flag reference. A maximum of 5 context lines may be provided.", false},
-	DefaultBranch:     option{"master", "The git default branch. The LaunchDarkly UI will default to this branch.", false},
-	Dir:               option{"", "Path to existing checkout of the git repo.", false},
-	Debug:             option{false, "Enables verbose debug logging", false},
-	Exclude:           option{"", `A regular expression (PCRE) defining the files and directories which the flag finder should exclude. Partial matches are allowed. Examples: "vendor/", "vendor/*`, false},
-	ProjKey:           option{"", "LaunchDarkly project key.", true},
-	UpdateSequenceId:  option{noUpdateSequenceId, `An integer representing the order number of code reference updates. Used to version updates across concurrent executions of the flag finder. If not provided, data will always be updated. If provided, data will only be updated if the existing "updateSequenceId" is less than the new "updateSequenceId". Examples: the time a "git push" was initiated, CI build number, the current unix timestamp.`, false},
-	RepoName:          option{"", `Git repo name. Will be displayed in LaunchDarkly. Case insensitive. Both a repo name and the repo name with an organization identifier are valid. Examples: "linux", "torvalds/linux."`, true},
-	RepoType:          option{"custom", "The repo service provider. Used to correctly categorize repositories in the LaunchDarkly UI. Aceptable values: github|bitbucket|custom.", false},
-	RepoUrl:           option{"", "The display url for the repository. If provided for a github or bitbucket repository, LaunchDarkly will attempt to automatically generate source code links.", false},
-	CommitUrlTemplate: option{"", "If provided, LaunchDarkly will attempt to generate links to your Git service provider per commit. Example: `https://github.com/launchdarkly/ld-find-code-refs/commit/${sha}`. Allowed template variables: `branchName`, `sha`. If `commitUrlTemplate` is not provided, but `repoUrl` is provided and `repoType` is not custom, LaunchDarkly will automatically generate links to the repository for each commit.", false},
-	HunkUrlTemplate:   option{"", "If provided, LaunchDarkly will attempt to generate links to your Git service provider per code reference. Example: `https://github.com/launchdarkly/ld-find-code-refs/blob/${sha}/${filePath}#L${lineNumber}`. Allowed template variables: `sha`, `filePath`, `lineNumber`. If `hunkUrlTemplate` is not provided, but repoUrl is provided and `repoType` is not custom, LaunchDarkly will automatically generate links to the repository for each code reference.", false},
+	AccessToken:        option{"", "LaunchDarkly personal access token with write-level access.", true},
+	AccessTokenSecret:  option{"", "The name of a secret containing the LaunchDarkly access token, retrieved from the secret manager provided by accessTokenSource instead of providing accessToken. Append #field to select a field of a JSON secret.", false},
+	AccessTokenSource:  option{"", "The secret manager accessTokenSecret is retrieved from. Acceptable values: aws|gcp|vault. aws and gcp use the aws and gcloud command line tools. vault uses the VAULT_ADDR and VAULT_TOKEN environment variables.", false},
+	AllowPartialFlags:  option{false, "If enabled, flags are searched for even if not every flag in the project could be retrieved from LaunchDarkly, e.g. because of the access token's permissions, or maxFlags. Otherwise, the scan fails with the number of flags retrieved and expected.", false},
+	ApiHeader:          option{[]string{}, "An additional header sent with every LaunchDarkly API request, as key=value. May be provided multiple times. Useful for proxies and gateways which require extra headers. Header values are redacted from logs.", false},
+	ApiKeepAlive:       option{true, "If enabled, connections to LaunchDarkly are reused for later requests. Disable if a proxy or firewall drops idle connections.", false},
+	ApiMaxIdleConns:    option{ld.DefaultMaxIdleConns, "The maximum number of idle connections to LaunchDarkly kept open for reuse.", false},
+	ApiTimeout:         option{int(ld.DefaultRequestTimeout / time.Second), "The number of seconds to wait for LaunchDarkly to respond to each API request, after the request has been sent. The time spent sending a request isn't included, so large uploads over slow connections aren't cancelled. Requests which time out are retried. If 0, requests never time out.", false},
+	ApplySuggestions:   option{false, "If enabled, exclude in the config file is set to the current exclude pattern combined with the patterns suggested by suggestExcludes. The config file is created if it doesn't exist.", false},
+	BaseUri:            option{ld.DefaultBaseUri, "LaunchDarkly base URI.", false},
+	BranchName:         option{"", "If provided, code references are sent under this name, rather than the name of the checked out branch. Required if no branch is checked out, unless the tag option is provided.", false},
+	CatalogFile:        option{"", "If provided, a mapping of each flag to the services which reference it, as defined by the service option, is written to this path, for service catalogs.", false},
+	CatalogFormat:      option{CatalogFormatJson, "The format of catalogFile. Acceptable values: json|backstage. backstage writes a Backstage Resource entity for each flag, which is a dependency of the Components of the services referencing it.", false},
+	CatalogOwner:       option{"unknown", "The owner of the Backstage entities written to catalogFile, e.g. group:platform.", false},
+	CacheDir:           option{"", "If provided, flag lists and the code references last sent for each branch are stored in this directory, and reused by later scans. Flag lists are used if they can't be retrieved from LaunchDarkly, and previous code references are used by deltaUpload.", false},
+	CacheMaxSize:       option{defaultCacheMaxSize, "The maximum size of cacheDir, in megabytes. The least recently used entries are removed when it is exceeded.", false},
+	ClientCert:         option{"", "Client certificate presented to LaunchDarkly for mutual TLS, as a path to a PEM file or PEM encoded contents. Requires clientKey.", false},
+	ClientKey:          option{"", "Private key for clientCert, as a path to a PEM file or PEM encoded contents.", false},
+	CommitSequenceId:   option{true, "If enabled and updateSequenceId is not provided, the commit time of the scanned commit, in milliseconds since the epoch, is used as the updateSequenceId, so scanning an older commit can't overwrite code references sent for a newer one. Scanning the same commit again will not update its code references.", false},
+	ConfigFile:         option{"", "Path to a YAML config file containing option values. Defaults to " + ConfigFileName + " in dir, if present. Command line arguments take precedence over the config file.", false},
+	ContextLines:       option{defaultContextLines, "The number of context lines to send to LaunchDarkly. If < 0, no source code will be sent to LaunchDarkly. If 0, only the lines containing flag references will be sent. If > 0, will send that number of context lines above and below the flag reference. A maximum of 5 context lines may be provided.", false},
+	DefaultBranch:      option{"master", "The git default branch. The LaunchDarkly UI will default to this branch.", false},
+	DeltaUpload:        option{false, "If enabled, only the code references for files that have changed since the branch was last scanned will be sent to LaunchDarkly, when the previous code references can be retrieved.", false},
+	DiffStrategy:       option{DiffStrategyFirstParent, "How the compare command compares a single merge commit with its parents. Acceptable values: firstParent|allParents. If firstParent, the commit is compared with its first parent, so changes merged from other branches are included. If allParents, only changes compared with every parent are reported. Scans are not affected.", false},
+	Dir:                option{[]string{}, "Path to existing checkout of the git repo. May be provided multiple times to only search some directories of the repo, e.g. -dir services/api -dir services/web. The config file is read from the first directory.", false},
+	Debug:              option{false, "Enables verbose debug logging", false},
+	DebugHttp:          option{false, "Enables logging of LaunchDarkly API requests and responses, including status, latency, request id, and truncated bodies. Secrets are redacted.", false},
+	DryRun:             option{false, "If enabled, the scanner will run without sending code references to LaunchDarkly. Combine with outFile to write code references to a file.", false},
+	EmitJsonSummary:    option{false, "If enabled, a JSON summary of the scan, including its result and the number of code references found, is printed to stdout when the scan ends, and logs are written to stderr instead.", false},
+	Enabled:            option{true, "If disabled, usually with enabled: false in the repository's " + ConfigFileName + ", the scan exits successfully without searching for code references. Scans are also skipped if a .ld-find-code-refs-skip file is present in the repository root.", false},
+	EnvironmentKey:     option{"", "The key of an environment to retrieve flag keys from, if the access token isn't allowed to list a project's flags, e.g. because its role only grants access to that environment. Flag keys are retrieved from the environment's flag statuses.", false},
+	EnvPrefixes:        option{[]string{}, "Prefixes of environment variable names referencing flags, e.g. FEATURE_. Only used if envReferences is enabled. May be provided multiple times.", false},
+	EnvReferences:      option{false, "If enabled, environment variable names derived from flag keys, e.g. ENABLE_CHECKOUT for enable-checkout, are also searched for in configuration files, such as Dockerfiles, .env files, and YAML. These references are sent with configReference: true.", false},
+	Exclude:            option{"", `A regular expression (PCRE) defining the files and directories which the flag finder should exclude. Partial matches are allowed. Examples: "vendor/", "vendor/*`, false},
+	Explain:            option{"", "If provided, the scan only searches for this flag key, and prints how it was searched for, how many lines matched, why matches weren't counted as references, and a sample of the lines. Code references aren't sent.", false},
+	ExcludeFlags:       option{[]string{}, "A flag key, or glob pattern matching flag keys, e.g. test-*, which is not searched for. May be provided multiple times, or as a comma separated list.", false},
+	FailOnDisabledRepo: option{false, "If enabled, the scanner will exit with a non-zero status if code references have been disabled for the repository in LaunchDarkly. Otherwise, the scan is skipped with a warning.", false},
+	FlagStatus:         option{false, "If enabled, the status of each flag in every environment will be retrieved from LaunchDarkly, and reported alongside its number of code references in the log and outFile, to help identify stale flags.", false},
+	Flags:              option{[]string{}, "A flag key, or glob pattern matching flag keys, e.g. checkout-*, to search for. If provided, only matching flags are searched for. May be provided multiple times, or as a comma separated list. If every value is a flag key, rather than a pattern, flags are not retrieved from LaunchDarkly. If -, flag keys are read from stdin, separated by whitespace or commas.", false},
+	HeatmapDepth:       option{0, "If > 0, references are rolled up by directory, up to this many levels below the repository root, and written to outFile, to show which parts of the repository are most coupled to flags.", false},
+	HttpCaptureFile:    option{"", "If provided, LaunchDarkly API requests and responses will be written to this path, with secrets redacted. Captured requests can be sent again with the replay command.", false},
+	HunkMetadata:       option{[]string{}, "A metadata field attached to hunks, in the form [pattern:]key=value, e.g. tier=1, or services/payments:team=payments. Without a pattern, the field is attached to every hunk. Otherwise, it's attached to hunks in files matching the pattern, or in directories matching it. Patterns are matched with path.Match, against the path relative to the repository root if they contain /, otherwise against each file and directory name. Later fields override earlier fields with the same key. May be provided multiple times.", false},
+	IncludeExtensions:  option{"", "A comma separated list of file extensions or glob patterns. If provided, only matching files are searched for flag references. Examples: \"go,ts,py\", \"*.yaml,src/*.js\". Patterns without a slash match file names in any directory.", false},
+	LanguageStats:      option{false, "If enabled, the number of files scanned in each language, and the number of files and references to flags in them, are sent to LaunchDarkly with code references. References by language are always logged.", false},
+	LfsPaths:           option{"", "A comma separated list of file extensions or glob patterns, in the same form as includeExtensions, matching files stored with Git LFS which are retrieved with git lfs smudge and searched. Requires git-lfs. Other Git LFS pointer files are never searched, since their contents aren't the contents of the files.", false},
+	LocalTime:          option{false, "If enabled, log timestamps are displayed in the local time zone. Otherwise, they are displayed in UTC. Timestamps are always formatted as RFC3339, and timestamps sent to LaunchDarkly or written to outFile are always in UTC.", false},
+	MaxBlankLines:      option{-1, "If >= 0, runs of more than this many consecutive blank lines in code references are shortened. Hunks are split at long runs of blank lines between flag references. If < 0, blank lines are kept.", false},
+	MaxConcurrency:     option{0, "The maximum number of threads used to search for flag references. Defaults to the LD_MAX_CONCURRENCY environment variable if set, otherwise GOMAXPROCS.", false},
+	MaxFlags:           option{0, "If > 0, the maximum number of flags retrieved from each project. If a project has more flags, the scan fails unless allowPartialFlags is enabled.", false},
+	MaxDepth:           option{0, "If > 0, only files at most this many directories below dir are searched, so files directly in dir are at depth 1. Useful to avoid searching nested checkouts or deep generated directories. If 0, the depth isn't limited.", false},
+	MatcherPlugin:      option{[]string{}, "An external command which searches files for flag references instead of the search strategy, in the form patterns=command, where patterns is a comma separated list of file extensions or glob patterns, in the same form as includeExtensions, e.g. dsl=./bin/dsl-matcher. The command is run in the scanned directory for each matching file, receives the file's path and the flag keys as JSON on stdin, and writes its matches as JSON to stdout. If command is a .wasm module, it is run with wasmtime, without access to the file system, and receives the file's contents instead. May be provided multiple times.", false},
+	MaxFiles:           option{0, "If > 0, the maximum number of files searched for flag references. If there are more files, e.g. because a home directory or network drive was scanned by mistake, only the first maxFiles files, in path order, are searched, and onBudgetExceeded decides what happens.", false},
+	MaxHunkBytes:       option{0, "If > 0, the maximum size of each code reference, in bytes. Context lines are removed from whichever side of the flag reference has more of them until the code reference fits. Useful for files with very long lines. If 0, code references are only limited by contextLines.", false},
+	MaxScanSeconds:     option{0, "If > 0, the maximum number of seconds spent searching for flag references. If the search takes longer, it is stopped, and onBudgetExceeded decides what happens with the code references found.", false},
+	MaxUploadBytes:     option{0, "If > 0, the maximum size of the code references sent to LaunchDarkly, in bytes, as serialized for the upload. If exceeded, the files and flags contributing the most bytes are logged before contacting LaunchDarkly, and onBudgetExceeded decides what happens.", false},
+	MinScore:           option{0, "If > 0, code references with a lower relevance score are not sent to LaunchDarkly. References are scored from 100 (a flag evaluation, e.g. boolVariation(\"key\")), to 75 (a string literal), 50 (other code), 25 (a comment), and 10 (a test file). Scores are included in outFile.", false},
+	Niceness:           option{0, "Lowers the scheduling priority of the git, search tool, and plugin processes run by the scanner, so scans don't starve other jobs on shared hosts. They're run with nice, which must be installed. Acceptable values: 0 (normal priority) to 19 (lowest priority). Not supported on Windows.", false},
+	NoColor:            option{false, "If enabled, console output is never colored. Output is only colored when writing to a terminal, and colors are also disabled by the NO_COLOR environment variable.", false},
+	NormalizeFlagKey:   option{[]string{}, "A regular expression replacement applied to flag keys before they're searched for, as s/pattern/replacement/, optionally prefixed by a project key and = to only apply it to that project's flags, e.g. my-project=s/^web\\.//. References are attributed to the original flag keys. May be provided multiple times. Replacements are applied in order, and replacement may refer to groups as $1.", false},
+	OnBudgetExceeded:   option{OnBudgetExceededWarn, "What to do if maxFiles, maxScanSeconds, or maxUploadBytes is exceeded. Acceptable values: warn|fail. If warn, a warning is logged and the code references found are sent, although they may be incomplete. If fail, the scan fails without sending code references.", false},
+	OnHeadDrift:        option{OnHeadDriftWarn, "What to do if the checked out commit changes while the repository is scanned, e.g. when a CI workspace is reused, or the repository is pulled during a scan, so the code references found may not match the commit they're sent for. Acceptable values: fail|retry|warn. If fail, the scan fails. If retry, and the commit changed during the search, the new commit is searched, up to 2 more times, as long as the same branch is checked out. If warn, a warning is logged and code references are sent for the commit checked out when the scan started.", false},
+	OnPullRequest:      option{OnPullRequestUpload, "What to do when scanning a pull request build, detected from the CI environment, or a checked out ref such as refs/pull/42/merge. Acceptable values: upload|skip|sourceBranch. If upload, code references are sent under the scanned branch name. If skip, code references are not sent, although outFile is still written. If sourceBranch, code references are sent under the name of the pull request's source branch.", false},
+	OnStaleHead:        option{OnStaleHeadIgnore, "What to do if the commit previously sent to LaunchDarkly for the branch is not an ancestor of the checked out commit, e.g. when an older CI build finishes after a newer one. Acceptable values: ignore|warn|skip. If warn, a warning is logged and code references are sent. If skip, code references are not sent. warn and skip retrieve the code references previously sent for the branch before each upload, an extra request whose response may be large.", false},
+	OnZeroReferences:   option{"", "What to do if no flag keys are found, or no code references are found for them. Acceptable values: upload|skip|fail. If upload, an empty set of code references is sent, clearing any previously sent for the branch. If no flag keys are found, the search is skipped. If skip, code references are not sent, and those previously sent are left as they were. If fail, the scan fails. If not provided, the scan exits early if no flag keys are found, and an empty set of code references is sent if none are found.", false},
+	OneFileSystem:      option{false, "If enabled, only files on the same file system as dir are searched, so bind mounted volumes and other mount points in the repository aren't traversed. Not supported on Windows.", false},
+	OutFile:            option{"", "If provided, code references will be written to this path as versioned JSON, in the format accepted by the import and validate commands.", false},
+	OutFormat:          option{OutFormatJson, "The format of outFile. Acceptable values: json|quickfix|lsp|html|csv. json files can be used with the import, validate, and browse commands. quickfix writes `file:line:col: message` lines for editors. lsp writes LSP publishDiagnostics parameters. html writes a report for browsers. csv writes a row per code reference for spreadsheets.", false},
+	PluginTimeout:      option{30, "The maximum number of seconds a matcher plugin may take to search a file. If exceeded, the plugin is stopped, and the file's references are skipped. If 0, plugins aren't limited.", false},
+	PathsRelativeToDir: option{false, "If enabled, the paths of code references are relative to dir, rather than the root of the git repository. Only useful if dir is a subdirectory of the repository, and links to code references were generated from paths relative to it.", false},
+	PlainHunks:         option{false, "If enabled, hunks are sent without the language of their file, or their hunkMetadata fields, for integrations which require the original format of code references.", false},
+	Pathspec:           option{[]string{}, "A git pathspec, relative to the repository root, limiting the files searched, e.g. 'src/**' or ':!**/testdata/**'. May be provided multiple times. Files matching any pathspec, and no exclude pathspec, are listed with git ls-files and searched without an external search tool.", false},
+	Profile:            option{"", "The name of a profile in the config file. Options in the profile replace options at the top level of the config file, so the same config file can be used for different kinds of scans, e.g. local dry runs and CI scans.", false},
+	ProjAccessToken:    option{[]string{}, "An access token used to retrieve the flags of a project, as projKey=token, when scanning for flags from several projects which aren't all accessible with accessToken. May be provided multiple times, or as a comma separated list. accessToken is used for other projects, and to send code references.", false},
+	ResultsSigningKey:  option{"", "Path to a PEM encoded Ed25519 private key. If provided, a signature of the json outFile is written next to it, with the .sig extension, so the file can be verified by the import command with resultsVerifyKey.", false},
+	ResultsVerifyKey:   option{"", "Path to a PEM encoded Ed25519 public key. If provided, the import command only sends code references if the file's signature, written by a scan with resultsSigningKey, is valid.", false},
+	Service:            option{[]string{}, "A service in the repository, in the form name=directory, e.g. checkout=services/checkout. References in the directory, relative to the repository root, are attributed to the service in catalogFile. If directories are nested, the innermost is used. May be provided multiple times.", false},
+	SigningSecret:      option{"", "If provided, code reference uploads are signed with an HMAC-SHA256 of the request body, keyed by this secret, in the X-Code-Refs-Signature header, so gateways can verify that uploads were sent by a sanctioned pipeline.", false},
+	SoftFail:           option{false, "If enabled, scans which fail after options are validated log the error and exit successfully, so scheduled scans of many repositories aren't reported as failed because of one repository. With emitJsonSummary, the summary's result is error.", false},
+	SpoolDir:           option{"", "If provided, code references which couldn't be sent to LaunchDarkly are saved to this directory, so they can be sent later with the resume command instead of scanning the repository again.", false},
+	StreamFlags:        option{false, "If enabled, flag keys are searched for as each page of flags is retrieved from LaunchDarkly, with the native matcher, rather than after every flag key has been retrieved, so projects with tens of thousands of flags don't need to be held in memory as a list, or searched for with a large pattern. Not supported with more than one projKey, normalizeFlagKey, envReferences, lfsPaths, matcherPlugin, or searchStrategy combined or chunked, and flag keys cached by previous scans aren't used if LaunchDarkly can't be reached.", false},
+	ProjKey:            option{"", "LaunchDarkly project key. To find references to flags from several projects, provide a comma separated list of project keys.", true},
+	UpdateSequenceId:   option{noUpdateSequenceId, `An integer representing the order number of code reference updates. Used to version updates across concurrent executions of the flag finder. If not provided, the commit time of the scanned commit is used, unless commitSequenceId is disabled. If provided, data will only be updated if the existing "updateSequenceId" is less than the new "updateSequenceId". Examples: the time a "git push" was initiated, CI build number, the current unix timestamp.`, false},
+	VerifyDeterminism:  option{false, "If enabled, code references are built twice from the same search results, and the scan fails if the payloads differ. Used to test the scanner.", false},
+	UploadMetadata:     option{false, "If enabled, the url of the CI job, the name of the CI runner or host, and a hash of the scanner's options are sent to LaunchDarkly with code references, so uploads can be audited.", false},
+	FlagMaintainers:    option{false, "If enabled with flagStatus, flag reports include the email of each flag's maintainer in LaunchDarkly, so cleanup requests can go to the member responsible for the flag.", false},
+	SuggestExcludes:    option{false, "If enabled, exclude patterns are suggested after the scan for vendored directories, generated and minified files, and directories contributing a disproportionate share of references in comments and identifiers.", false},
+	SuggestOwners:      option{false, "If enabled with flagStatus, flag reports suggest owners for each flag: the LaunchDarkly members whose email matches the git author of the most lines referencing it. Owners are only included in the log and outFile, and are never sent to LaunchDarkly.", false},
+	TestReferences:     option{TestReferencesInclude, "How references in test files, e.g. *_test.go, *.spec.ts, and files in __tests__ or spec directories, are handled. Acceptable values: include|exclude. If include, references in test files are marked as test code, and counted separately in flag reports. If exclude, references in test files are not sent.", false},
+	Tag:                option{"", "If provided, code references are sent for this git tag, which must be checked out, under the tag's name with tagPrefix, e.g. release/v1.2.3, so snapshots of releases are kept separate from branches. The time the tag was created is used as the default updateSequenceId.", false},
+	TagPrefix:          option{"release/", "The prefix of the name code references for the tag option are sent under.", false},
+	TabWidth:           option{0, "If > 0, tabs in code references are replaced with spaces, up to the next multiple of this many columns. If 0, tabs are kept.", false},
+	VerifyUpload:       option{false, "If enabled, code references are retrieved from LaunchDarkly after they're sent, and the number of files, and hunks for each flag, are compared with what was sent, along with the bytes of source code for each flag, to detect code references trimmed by LaunchDarkly. Differences are logged as warnings.", false},
+	WaitForLock:        option{false, "If enabled, and the repository is being scanned by another process, e.g. a git hook and a manual run at the same time, the scanner waits for the other scan to finish. Otherwise, the scan fails. Locks left behind by scans which have exited are removed.", false},
+	TrackedOnly:        option{false, "If enabled, only files tracked by git are searched, so untracked files, such as build artifacts and editor swap files, never produce code references, even if they aren't ignored. Files are searched without an external search tool.", false},
+	Vcs:                option{command.VcsGit, "The version control system the repository was checked out from. Acceptable values: git|svn|perforce. svn and perforce read the checked out revision, and branch or stream, with the svn and p4 command line tools, and search every file in the workspace. repoName must be provided, and options which depend on git, such as pathspec, trackedOnly, lfsPaths, and tag, aren't supported.", false},
+	TrimWhitespace:     option{false, "If enabled, trailing whitespace is removed from each line of code references.", false},
+	TmpDir:             option{"", "Writable directory for temporary files created by the scanner, git, and the search tool. Defaults to the system temporary directory. Useful when running with a read-only root filesystem. Each run uses its own subdirectory, which is removed when it exits.", false},
+	Sample:             option{0, "If > 0, only a sample of this many hunks is sent to LaunchDarkly for each flag with more hunks, along with the flag's true number of hunks and references, to keep uploads for enormous repositories small. The same hunks are sampled by every scan, unless they change. Every hunk is included in outFile.", false},
+	SearchStrategy:     option{"auto", "How flag references are searched for. Acceptable values: auto|combined|chunked|native. combined searches for all flags at once with the search tool. chunked searches for groups of flags one at a time. native uses a built in matcher, and doesn't require a search tool. If auto, a strategy is chosen based on the number and length of flag keys and the size of the repository.", false},
+	SearchTool:         option{"auto", "The tool used to search for flag references. Acceptable values: auto|ag|rg. If auto, ag (The Silver Searcher) will be used if installed, otherwise rg (ripgrep). Files with a UTF-16 byte order mark are transcoded to UTF-8 by rg, but are not searched by ag.", false},
+	ToolCacheDir:       option{"", "Directory searched for tools not found in the system PATH, such as those downloaded by the doctor command. Defaults to ld-find-code-refs/bin in the user cache directory.", false},
+	RepoName:           option{"", `Git repo name. Will be displayed in LaunchDarkly. Case insensitive. Both a repo name and the repo name with an organization identifier are valid. Examples: "linux", "torvalds/linux." If not provided, the name of the repository of the remote chosen by the remote option is used.`, false},
+	Remote:             option{"", "The git remote identifying the repository, used when repoName is not provided. Defaults to origin, unless an upstream remote points at a different repository, in which case origin is assumed to be a fork, and upstream is used.", false},
+	RepoType:           option{"custom", "The repo service provider. Used to correctly categorize repositories in the LaunchDarkly UI. Aceptable values: github|bitbucket|custom.", false},
+	RepoUrl:            option{"", "The display url for the repository. If provided for a github or bitbucket repository, LaunchDarkly will attempt to automatically generate source code links.", false},
+	CommitUrlTemplate:  option{"", "If provided, LaunchDarkly will attempt to generate links to your Git service provider per commit. Example: `https://github.com/launchdarkly/ld-find-code-refs/commit/${sha}`. Allowed template variables: `branchName`, `sha`. If `commitUrlTemplate` is not provided, but `repoUrl` is provided and `repoType` is not custom, LaunchDarkly will automatically generate links to the repository for each commit.", false},
+	HunkUrlTemplate:    option{"", "If provided, LaunchDarkly will attempt to generate links to your Git service provider per code reference. Example: `https://github.com/launchdarkly/ld-find-code-refs/blob/${sha}/${filePath}#L${lineNumber}`. Allowed template variables: `sha`, `filePath`, `lineNumber`. If `hunkUrlTemplate` is not provided, but repoUrl is provided and `repoType` is not custom, LaunchDarkly will automatically generate links to the repository for each code reference.", false},
 }
 
-// Init reads specified options and exits if options of invalid types or unspecified options were provided.
-// Returns an error if a required option has not been set, or if an option is invalid.
-func Init() (err error, errCb func()) {
+// Parse sets options from args, the command line arguments following the command, if any. Arguments are only parsed
+// once, since options which may be provided multiple times would otherwise be appended to again.
+func Parse(args []string) {
 	if !populated {
 		Populate()
 	}
+	if !flag.Parsed() {
+		_ = flag.CommandLine.Parse(args)
+	}
+}
 
-	flag.Parse()
+// Init reads specified options and exits if options of invalid types or unspecified options were provided.
+// Returns an error if a required option has not been set, or if an option is invalid.
+func Init(args []string) (err error, errCb func()) {
+	Parse(args)
+
+	err = loadConfigFile()
+	if err != nil {
+		return err, flag.PrintDefaults
+	}
 
 	opt := ""
 	flag.VisitAll(func(f *flag.Flag) {
 		o := options.find(f.Name)
+		// The access token may be retrieved from a secret manager instead
+		if f.Name == string(AccessToken) && AccessTokenSecret.Value() != "" {
+			return
+		}
 		if o != nil && o.required {
 			val := f.Value.(flag.Getter).Get()
 			switch v := val.(type) {
@@ -146,10 +462,188 @@ func Init() (err error, errCb func()) {
 	if err != nil {
 		return err, flag.PrintDefaults
 	}
+	if ApiTimeout.Value() < 0 {
+		return fmt.Errorf("apiTimeout option must be >= 0"), flag.PrintDefaults
+	}
+	if ApiMaxIdleConns.Value() <= 0 {
+		return fmt.Errorf("apiMaxIdleConns option must be > 0"), flag.PrintDefaults
+	}
+	if MaxConcurrency.Value() < 0 {
+		return fmt.Errorf("maxConcurrency option must be >= 0"), flag.PrintDefaults
+	}
+	if CacheMaxSize.Value() <= 0 {
+		return fmt.Errorf("cacheMaxSize option must be > 0"), flag.PrintDefaults
+	}
+	if MinScore.Value() < 0 {
+		return fmt.Errorf("minScore option must be >= 0"), flag.PrintDefaults
+	}
+	if Niceness.Value() < 0 {
+		return fmt.Errorf("niceness option must be >= 0"), flag.PrintDefaults
+	}
+	err = Niceness.maximumError(19)
+	if err != nil {
+		return err, flag.PrintDefaults
+	}
 	repoType := strings.ToLower(RepoType.Value())
 	if repoType != "custom" && repoType != "github" && repoType != "bitbucket" {
 		return fmt.Errorf("repo type must be \"custom\", \"bitbucket\", or \"github\""), flag.PrintDefaults
 	}
+	searchTool := SearchTool.Value()
+	if searchTool != "auto" && searchTool != "ag" && searchTool != "rg" {
+		return fmt.Errorf("search tool must be \"auto\", \"ag\", or \"rg\""), flag.PrintDefaults
+	}
+	searchStrategy := SearchStrategy.Value()
+	if searchStrategy != "auto" && searchStrategy != "combined" && searchStrategy != "chunked" && searchStrategy != "native" {
+		return fmt.Errorf("search strategy must be \"auto\", \"combined\", \"chunked\", or \"native\""), flag.PrintDefaults
+	}
+	outFormat := OutFormat.Value()
+	if outFormat != OutFormatJson && outFormat != OutFormatQuickfix && outFormat != OutFormatLsp && outFormat != OutFormatHtml && outFormat != OutFormatCsv {
+		return fmt.Errorf("out format must be \"json\", \"quickfix\", \"lsp\", \"html\", or \"csv\""), flag.PrintDefaults
+	}
+	catalogFormat := CatalogFormat.Value()
+	if catalogFormat != CatalogFormatJson && catalogFormat != CatalogFormatBackstage {
+		return fmt.Errorf("catalog format must be \"json\" or \"backstage\""), flag.PrintDefaults
+	}
+	_, err = Services()
+	if err != nil {
+		return err, flag.PrintDefaults
+	}
+	_, err = HunkMetadataFields()
+	if err != nil {
+		return err, flag.PrintDefaults
+	}
+	if ResultsSigningKey.Value() != "" && (OutFile.Value() == "" || outFormat != OutFormatJson) {
+		return fmt.Errorf("resultsSigningKey option requires outFile, with the json outFormat"), flag.PrintDefaults
+	}
+	for _, pattern := range append(ListValues(Flags), ListValues(ExcludeFlags)...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid flag pattern %q: %s", pattern, err), flag.PrintDefaults
+		}
+	}
+	if MaxFlags.Value() < 0 {
+		return fmt.Errorf("maxFlags option must be >= 0"), flag.PrintDefaults
+	}
+	if MaxDepth.Value() < 0 {
+		return fmt.Errorf("maxDepth option must be >= 0"), flag.PrintDefaults
+	}
+	if MaxFiles.Value() < 0 {
+		return fmt.Errorf("maxFiles option must be >= 0"), flag.PrintDefaults
+	}
+	if MaxScanSeconds.Value() < 0 {
+		return fmt.Errorf("maxScanSeconds option must be >= 0"), flag.PrintDefaults
+	}
+	onBudgetExceeded := OnBudgetExceeded.Value()
+	if onBudgetExceeded != OnBudgetExceededWarn && onBudgetExceeded != OnBudgetExceededFail {
+		return fmt.Errorf("onBudgetExceeded option must be %q or %q", OnBudgetExceededWarn, OnBudgetExceededFail), flag.PrintDefaults
+	}
+	if MaxHunkBytes.Value() < 0 {
+		return fmt.Errorf("maxHunkBytes option must be >= 0"), flag.PrintDefaults
+	}
+	if SuggestOwners.Value() && !FlagStatus.Value() {
+		return fmt.Errorf("suggestOwners option requires the flagStatus option"), flag.PrintDefaults
+	}
+	if Sample.Value() < 0 {
+		return fmt.Errorf("sample option must be >= 0"), flag.PrintDefaults
+	}
+	if TabWidth.Value() < 0 {
+		return fmt.Errorf("tabWidth option must be >= 0"), flag.PrintDefaults
+	}
+	if HeatmapDepth.Value() < 0 {
+		return fmt.Errorf("heatmapDepth option must be >= 0"), flag.PrintDefaults
+	}
+	onStaleHead := OnStaleHead.Value()
+	if onStaleHead != OnStaleHeadIgnore && onStaleHead != OnStaleHeadWarn && onStaleHead != OnStaleHeadSkip {
+		return fmt.Errorf("on stale head must be \"ignore\", \"warn\", or \"skip\""), flag.PrintDefaults
+	}
+	onHeadDrift := OnHeadDrift.Value()
+	if onHeadDrift != OnHeadDriftFail && onHeadDrift != OnHeadDriftRetry && onHeadDrift != OnHeadDriftWarn {
+		return fmt.Errorf("onHeadDrift option must be %q, %q, or %q", OnHeadDriftFail, OnHeadDriftRetry, OnHeadDriftWarn), flag.PrintDefaults
+	}
+	diffStrategy := DiffStrategy.Value()
+	if diffStrategy != DiffStrategyFirstParent && diffStrategy != DiffStrategyAllParents {
+		return fmt.Errorf("diffStrategy option must be %q or %q", DiffStrategyFirstParent, DiffStrategyAllParents), flag.PrintDefaults
+	}
+	onPullRequest := OnPullRequest.Value()
+	if onPullRequest != OnPullRequestUpload && onPullRequest != OnPullRequestSkip && onPullRequest != OnPullRequestSourceBranch {
+		return fmt.Errorf("onPullRequest option must be %q, %q, or %q", OnPullRequestUpload, OnPullRequestSkip, OnPullRequestSourceBranch), flag.PrintDefaults
+	}
+	onZeroReferences := OnZeroReferences.Value()
+	if onZeroReferences != "" && onZeroReferences != OnZeroReferencesUpload && onZeroReferences != OnZeroReferencesSkip && onZeroReferences != OnZeroReferencesFail {
+		return fmt.Errorf("onZeroReferences option must be %q, %q, or %q", OnZeroReferencesUpload, OnZeroReferencesSkip, OnZeroReferencesFail), flag.PrintDefaults
+	}
+	if (AccessTokenSecret.Value() == "") != (AccessTokenSource.Value() == "") {
+		return fmt.Errorf("accessTokenSecret and accessTokenSource must be provided together"), flag.PrintDefaults
+	}
+	if source := AccessTokenSource.Value(); source != "" && source != "aws" && source != "gcp" && source != "vault" {
+		return fmt.Errorf("access token source must be \"aws\", \"gcp\", or \"vault\""), flag.PrintDefaults
+	}
+	if PathsRelativeToDir.Value() && len(Dir.Value()) > 1 {
+		return fmt.Errorf("pathsRelativeToDir option may not be enabled when more than one dir is provided"), flag.PrintDefaults
+	}
+	testReferences := TestReferences.Value()
+	if testReferences != TestReferencesInclude && testReferences != TestReferencesExclude {
+		return fmt.Errorf("testReferences option must be %q or %q", TestReferencesInclude, TestReferencesExclude), flag.PrintDefaults
+	}
+	if (ClientCert.Value() == "") != (ClientKey.Value() == "") {
+		return fmt.Errorf("clientCert and clientKey must be provided together"), flag.PrintDefaults
+	}
+	if ClientCert.Value() != "" {
+		_, err = ld.LoadClientCertificate(ClientCert.Value(), ClientKey.Value())
+		if err != nil {
+			return err, flag.PrintDefaults
+		}
+	}
+	_, err = ProjAccessTokens()
+	if err != nil {
+		return err, flag.PrintDefaults
+	}
+	_, err = FlagKeyRules()
+	if err != nil {
+		return err, flag.PrintDefaults
+	}
+	for _, h := range ApiHeader.Value() {
+		err = validateApiHeader(h)
+		if err != nil {
+			return err, flag.PrintDefaults
+		}
+	}
+	_, err = command.IncludeGlobs(IncludeExtensions.Value())
+	if err != nil {
+		return fmt.Errorf("includeExtensions option is invalid: %s", err), flag.PrintDefaults
+	}
+	_, err = command.IncludeGlobs(LfsPaths.Value())
+	if err != nil {
+		return fmt.Errorf("lfsPaths option is invalid: %s", err), flag.PrintDefaults
+	}
+	if vcs := Vcs.Value(); vcs != command.VcsGit {
+		if vcs != command.VcsSubversion && vcs != command.VcsPerforce {
+			return fmt.Errorf("vcs option must be %q, %q, or %q", command.VcsGit, command.VcsSubversion, command.VcsPerforce), flag.PrintDefaults
+		}
+		if RepoName.Value() == "" {
+			return fmt.Errorf("repoName must be provided when vcs is %s", vcs), flag.PrintDefaults
+		}
+		if len(Pathspec.Value()) > 0 || TrackedOnly.Value() || LfsPaths.Value() != "" || Tag.Value() != "" {
+			return fmt.Errorf("pathspec, trackedOnly, lfsPaths, and tag options are only supported when vcs is git"), flag.PrintDefaults
+		}
+	}
+	if StreamFlags.Value() {
+		if strings.Contains(ProjKey.Value(), ",") {
+			return fmt.Errorf("streamFlags option may not be enabled when more than one projKey is provided"), flag.PrintDefaults
+		}
+		if len(NormalizeFlagKey.Value()) > 0 || EnvReferences.Value() || LfsPaths.Value() != "" || len(MatcherPlugin.Value()) > 0 {
+			return fmt.Errorf("normalizeFlagKey, envReferences, lfsPaths, and matcherPlugin options aren't supported when streamFlags is enabled"), flag.PrintDefaults
+		}
+		if s := SearchStrategy.Value(); s != "auto" && s != "native" {
+			return fmt.Errorf("streamFlags option requires the native or auto searchStrategy"), flag.PrintDefaults
+		}
+	}
+	_, err = command.ParsePlugins(MatcherPlugin.Value())
+	if err != nil {
+		return fmt.Errorf("matcherPlugin option is invalid: %s", err), flag.PrintDefaults
+	}
+	if PluginTimeout.Value() < 0 {
+		return fmt.Errorf("pluginTimeout option must be >= 0"), flag.PrintDefaults
+	}
 	_, err = regexp.Compile(Exclude.Value())
 	if err != nil {
 		return fmt.Errorf("exclude must be a valid regular expression: %+v", err), flag.PrintDefaults
@@ -161,6 +655,182 @@ func Init() (err error, errCb func()) {
 	return nil, flag.PrintDefaults
 }
 
+// ListValues returns the values of a list option, split at commas, with empty values omitted.
+func ListValues(o StringSliceOption) []string {
+	ret := []string{}
+	for _, v := range o.Value() {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ret = append(ret, s)
+			}
+		}
+	}
+	return ret
+}
+
+// ProjAccessTokens returns the projAccessToken options, keyed by project key.
+func ProjAccessTokens() (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, v := range ProjAccessToken.Value() {
+		for _, pair := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				// The token isn't included in the error, since it may be logged
+				return nil, fmt.Errorf("projAccessToken option must be in the form projKey=token")
+			}
+			tokens[kv[0]] = kv[1]
+		}
+	}
+	return tokens, nil
+}
+
+// ServiceDir is a directory of the repository containing a service.
+type ServiceDir struct {
+	Name string
+	// Dir is relative to the repository root, with forward slashes, and no trailing slash.
+	Dir string
+}
+
+// serviceNameRegex matches names accepted by Backstage, so services can be referenced by catalog entities.
+var serviceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9]([-_.a-zA-Z0-9]{0,61}[a-zA-Z0-9])?$`)
+
+// Services returns the service options.
+func Services() ([]ServiceDir, error) {
+	services := []ServiceDir{}
+	for _, v := range Service.Value() {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("service option %q must be in the form name=directory", v)
+		}
+		if !serviceNameRegex.MatchString(parts[0]) {
+			return nil, fmt.Errorf("service name %q must be at most 63 letters, numbers, '-', '_', or '.', beginning and ending with a letter or number", parts[0])
+		}
+		dir := strings.Trim(path.Clean(strings.Replace(parts[1], "\\", "/", -1)), "/")
+		if dir == "" || dir == "." || strings.HasPrefix(dir, "../") || dir == ".." {
+			return nil, fmt.Errorf("service option %q must provide a directory within the repository", v)
+		}
+		services = append(services, ServiceDir{Name: parts[0], Dir: dir})
+	}
+	return services, nil
+}
+
+// HunkMetadataField is a metadata field attached to hunks in files matching Pattern, or to every hunk if Pattern is
+// empty.
+type HunkMetadataField struct {
+	Pattern string
+	Key     string
+	Value   string
+}
+
+// metadataKeyRegex matches keys of hunk metadata fields.
+var metadataKeyRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]*$`)
+
+// HunkMetadataFields returns the hunkMetadata options, in the order provided.
+func HunkMetadataFields() ([]HunkMetadataField, error) {
+	fields := []HunkMetadataField{}
+	for _, v := range HunkMetadata.Value() {
+		eq := strings.Index(v, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("hunkMetadata option %q must be in the form [pattern:]key=value", v)
+		}
+		field := HunkMetadataField{Key: v[:eq], Value: v[eq+1:]}
+		// Keys can't contain :, so the pattern ends at the last : before =
+		if colon := strings.LastIndex(field.Key, ":"); colon >= 0 {
+			field.Pattern, field.Key = field.Key[:colon], field.Key[colon+1:]
+			if field.Pattern == "" {
+				return nil, fmt.Errorf("hunkMetadata option %q must be in the form [pattern:]key=value", v)
+			}
+			if _, err := path.Match(field.Pattern, ""); err != nil {
+				return nil, fmt.Errorf("hunkMetadata option %q has an invalid pattern: %s", v, err)
+			}
+		}
+		if !metadataKeyRegex.MatchString(field.Key) {
+			return nil, fmt.Errorf("hunkMetadata key %q must begin with a letter, and contain only letters, numbers, '-', '_', or '.'", field.Key)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// FlagKeyRule is a regular expression replacement applied to flag keys before they're searched for.
+type FlagKeyRule struct {
+	// ProjKey limits the rule to the flags of a project. If empty, the rule applies to every project.
+	ProjKey     string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// flagKeyRuleRegex matches a normalizeFlagKey value, an optional project key and =, and an s command with any
+// delimiter which can't appear in a project key.
+var flagKeyRuleRegex = regexp.MustCompile(`^(?:([a-zA-Z0-9._-]+)=)?s([^a-zA-Z0-9._\s\\-])`)
+
+// FlagKeyRules returns the normalizeFlagKey options, in the order provided.
+func FlagKeyRules() ([]FlagKeyRule, error) {
+	rules := []FlagKeyRule{}
+	for _, v := range NormalizeFlagKey.Value() {
+		rule, err := parseFlagKeyRule(v)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseFlagKeyRule(v string) (FlagKeyRule, error) {
+	m := flagKeyRuleRegex.FindStringSubmatch(v)
+	if m == nil {
+		return FlagKeyRule{}, fmt.Errorf("normalizeFlagKey option %q must be in the form [projKey=]s/pattern/replacement/", v)
+	}
+	parts := splitUnescaped(v[len(m[0]):], m[2][0])
+	if len(parts) != 3 || parts[2] != "" {
+		return FlagKeyRule{}, fmt.Errorf("normalizeFlagKey option %q must be in the form [projKey=]s/pattern/replacement/", v)
+	}
+	pattern, err := regexp.Compile(parts[0])
+	if err != nil {
+		return FlagKeyRule{}, fmt.Errorf("normalizeFlagKey option %q has an invalid pattern: %s", v, err)
+	}
+	return FlagKeyRule{ProjKey: m[1], Pattern: pattern, Replacement: parts[1]}, nil
+}
+
+// splitUnescaped splits s at each delim which isn't escaped by a backslash. Escaped delimiters are unescaped, and
+// other escapes are kept, so they're interpreted by the regular expression.
+func splitUnescaped(s string, delim byte) []string {
+	parts := []string{}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			sb.WriteByte(delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			sb.WriteByte(s[i])
+			sb.WriteByte(s[i+1])
+			i++
+		case s[i] == delim:
+			parts = append(parts, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return append(parts, sb.String())
+}
+
+// headerRegex matches key=value headers, where key is a valid HTTP header name.
+var headerRegex = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+=[^\\r\\n]*$")
+
+func validateApiHeader(h string) error {
+	if !headerRegex.MatchString(h) {
+		return fmt.Errorf("api header %q must be a valid header in the form key=value", h)
+	}
+	key := strings.SplitN(h, "=", 2)[0]
+	if strings.EqualFold(key, "Authorization") {
+		return fmt.Errorf("api header %q may not be provided, use the accessToken option", key)
+	}
+	return nil
+}
+
 var populated = false
 
 func Populate() {
@@ -176,6 +846,8 @@ func Populate() {
 			flag.String(name, v, o.usage)
 		case bool:
 			flag.Bool(name, v, o.usage)
+		case []string:
+			flag.Var(&stringSlice{values: append([]string{}, v...)}, name, o.usage)
 		}
 	}
 }
@@ -0,0 +1,228 @@
+// Package scaffold generates ld-find-code-refs config files.
+package scaffold
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/lang"
+)
+
+// Directories which typically contain third party or generated code, and should be excluded from scans.
+var vendorDirs = map[string]bool{
+	"bower_components": true,
+	"Carthage":         true,
+	"node_modules":     true,
+	"Pods":             true,
+	"third_party":      true,
+	"vendor":           true,
+	".venv":            true,
+	"venv":             true,
+	"__pycache__":      true,
+}
+
+// IsVendorDir returns true if directories named name typically contain third party or generated code.
+func IsVendorDir(name string) bool {
+	return vendorDirs[name]
+}
+
+// Files which indicate the CI provider used by a repository.
+var ciProviders = []struct {
+	path     string
+	provider string
+}{
+	{".github/workflows", "github-actions"},
+	{".github/main.workflow", "github-actions"},
+	{".circleci/config.yml", "circleci"},
+	{"bitbucket-pipelines.yml", "bitbucket-pipelines"},
+	{".gitlab-ci.yml", "gitlab-ci"},
+	{".travis.yml", "travis-ci"},
+	{"azure-pipelines.yml", "azure-pipelines"},
+	{"Jenkinsfile", "jenkins"},
+}
+
+// RepoInfo describes a repository inspected by Inspect.
+type RepoInfo struct {
+	// Languages maps language identifiers to the number of files of that language.
+	Languages map[string]int
+	// VendorDirs contains the names of vendored code directories found in the repository.
+	VendorDirs []string
+	// MinifiedJs is true if the repository contains minified javascript files.
+	MinifiedJs bool
+	CIProvider string
+	RemoteUrl  string
+}
+
+// Inspect walks the repository at dir and detects its languages, vendored code directories, CI provider, and origin remote.
+func Inspect(dir string) (RepoInfo, error) {
+	info := RepoInfo{Languages: map[string]int{}}
+	foundVendorDirs := map[string]bool{}
+
+	err := filepath.Walk(dir, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		if f.IsDir() {
+			if name == ".git" {
+				return filepath.SkipDir
+			}
+			if vendorDirs[name] {
+				foundVendorDirs[name] = true
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if l := lang.Detect(p); l != "" {
+			info.Languages[l]++
+		}
+		if strings.HasSuffix(name, ".min.js") {
+			info.MinifiedJs = true
+		}
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+	for name := range foundVendorDirs {
+		info.VendorDirs = append(info.VendorDirs, name)
+	}
+	sort.Strings(info.VendorDirs)
+
+	for _, ci := range ciProviders {
+		if _, err := os.Stat(filepath.Join(dir, ci.path)); err == nil {
+			info.CIProvider = ci.provider
+			break
+		}
+	}
+
+	// The repository may not have a remote, in which case link templates can't be generated.
+	info.RemoteUrl, _ = command.RemoteUrl(dir, "origin")
+
+	return info, nil
+}
+
+// Config returns starter option values for the inspected repository.
+func (r RepoInfo) Config() yaml.MapSlice {
+	config := yaml.MapSlice{}
+
+	if exclude := r.exclude(); exclude != "" {
+		config = append(config, yaml.MapItem{Key: "exclude", Value: exclude})
+	}
+
+	repoUrl, repoType, repoName := parseRemote(r.RemoteUrl)
+	if repoUrl == "" {
+		return config
+	}
+	config = append(config,
+		yaml.MapItem{Key: "repoName", Value: repoName},
+		yaml.MapItem{Key: "repoType", Value: repoType},
+		yaml.MapItem{Key: "repoUrl", Value: repoUrl},
+	)
+
+	commitUrlTemplate, hunkUrlTemplate := linkTemplates(repoUrl)
+	if commitUrlTemplate != "" {
+		config = append(config,
+			yaml.MapItem{Key: "commitUrlTemplate", Value: commitUrlTemplate},
+			yaml.MapItem{Key: "hunkUrlTemplate", Value: hunkUrlTemplate},
+		)
+	}
+	return config
+}
+
+// Header returns a comment describing what was detected in the repository.
+func (r RepoInfo) Header() string {
+	var sb strings.Builder
+	sb.WriteString("# ld-find-code-refs configuration, generated by `ld-find-code-refs init`.\n")
+
+	if len(r.Languages) > 0 {
+		langs := make([]string, 0, len(r.Languages))
+		for l := range r.Languages {
+			langs = append(langs, l)
+		}
+		sort.Slice(langs, func(i, j int) bool {
+			if r.Languages[langs[i]] != r.Languages[langs[j]] {
+				return r.Languages[langs[i]] > r.Languages[langs[j]]
+			}
+			return langs[i] < langs[j]
+		})
+		counts := make([]string, 0, len(langs))
+		for _, l := range langs {
+			counts = append(counts, fmt.Sprintf("%s (%d files)", l, r.Languages[l]))
+		}
+		sb.WriteString("# Detected languages: " + strings.Join(counts, ", ") + "\n")
+	}
+	if r.CIProvider != "" {
+		sb.WriteString("# Detected CI provider: " + r.CIProvider + "\n")
+	}
+	if r.RemoteUrl == "" {
+		sb.WriteString("# No origin remote was found, so repository options could not be detected. Set repoName before scanning.\n")
+	}
+	sb.WriteString("#\n# Set projKey to the key of the LaunchDarkly project to find references for:\n# projKey: my-project\n")
+	return sb.String()
+}
+
+func (r RepoInfo) exclude() string {
+	patterns := []string{}
+	if len(r.VendorDirs) > 0 {
+		dirs := make([]string, 0, len(r.VendorDirs))
+		for _, d := range r.VendorDirs {
+			dirs = append(dirs, regexp.QuoteMeta(d))
+		}
+		patterns = append(patterns, "(^|/)("+strings.Join(dirs, "|")+")/")
+	}
+	if r.MinifiedJs {
+		patterns = append(patterns, `\.min\.js$`)
+	}
+	return strings.Join(patterns, "|")
+}
+
+// parseRemote converts a git remote url to a browsable repository url, and detects the repository type and name.
+func parseRemote(remote string) (repoUrl, repoType, repoName string) {
+	r, ok := command.ParseRemote("origin", remote)
+	if !ok {
+		return "", "", ""
+	}
+	return r.Url(), r.Type(), r.Name()
+}
+
+// linkTemplates returns commit and hunk url templates for known git hosting providers.
+func linkTemplates(repoUrl string) (commitUrlTemplate, hunkUrlTemplate string) {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return "", ""
+	}
+	switch {
+	case u.Host == "github.com", strings.HasPrefix(u.Host, "gitlab."):
+		return repoUrl + "/commit/${sha}", repoUrl + "/blob/${sha}/${filePath}#L${lineNumber}"
+	case u.Host == "bitbucket.org":
+		return repoUrl + "/commits/${sha}", repoUrl + "/src/${sha}/${filePath}#lines-${lineNumber}"
+	}
+	return "", ""
+}
+
+// Write writes a config file with an optional header comment, refusing to overwrite an existing file.
+func Write(path, header string, config yaml.MapSlice) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	data := []byte{}
+	if len(config) > 0 {
+		var err error
+		data, err = yaml.Marshal(config)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, append([]byte(header), data...), 0644)
+}
@@ -0,0 +1,60 @@
+package scaffold
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func Test_parseRemote(t *testing.T) {
+	tests := []struct {
+		remote   string
+		repoUrl  string
+		repoType string
+		repoName string
+	}{
+		{"git@github.com:launchdarkly/ld-find-code-refs.git", "https://github.com/launchdarkly/ld-find-code-refs", "github", "ld-find-code-refs"},
+		{"https://github.com/launchdarkly/ld-find-code-refs.git", "https://github.com/launchdarkly/ld-find-code-refs", "github", "ld-find-code-refs"},
+		{"ssh://git@bitbucket.org/launchdarkly/ld-find-code-refs.git", "https://bitbucket.org/launchdarkly/ld-find-code-refs", "bitbucket", "ld-find-code-refs"},
+		{"https://user@gitlab.example.com/group/sub/repo", "https://gitlab.example.com/group/sub/repo", "custom", "repo"},
+		{"", "", "", ""},
+		{"/local/path/repo.git", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remote, func(t *testing.T) {
+			repoUrl, repoType, repoName := parseRemote(tt.remote)
+			require.Equal(t, tt.repoUrl, repoUrl)
+			require.Equal(t, tt.repoType, repoType)
+			require.Equal(t, tt.repoName, repoName)
+		})
+	}
+}
+
+func Test_linkTemplates(t *testing.T) {
+	commit, hunk := linkTemplates("https://bitbucket.org/org/repo")
+	require.Equal(t, "https://bitbucket.org/org/repo/commits/${sha}", commit)
+	require.Equal(t, "https://bitbucket.org/org/repo/src/${sha}/${filePath}#lines-${lineNumber}", hunk)
+
+	commit, hunk = linkTemplates("https://git.example.com/org/repo")
+	require.Equal(t, "", commit)
+	require.Equal(t, "", hunk)
+}
+
+func Test_Config(t *testing.T) {
+	info := RepoInfo{
+		VendorDirs: []string{"node_modules", "vendor"},
+		MinifiedJs: true,
+		RemoteUrl:  "git@github.com:org/repo.git",
+	}
+	require.Equal(t, yaml.MapSlice{
+		{Key: "exclude", Value: `(^|/)(node_modules|vendor)/|\.min\.js$`},
+		{Key: "repoName", Value: "repo"},
+		{Key: "repoType", Value: "github"},
+		{Key: "repoUrl", Value: "https://github.com/org/repo"},
+		{Key: "commitUrlTemplate", Value: "https://github.com/org/repo/commit/${sha}"},
+		{Key: "hunkUrlTemplate", Value: "https://github.com/org/repo/blob/${sha}/${filePath}#L${lineNumber}"},
+	}, info.Config())
+
+	require.Equal(t, yaml.MapSlice{}, RepoInfo{}.Config())
+}
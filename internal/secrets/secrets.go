@@ -0,0 +1,174 @@
+// Package secrets retrieves secrets, such as the LaunchDarkly access token, from external secret managers.
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Supported secret providers
+const (
+	ProviderAws   = "aws"
+	ProviderGcp   = "gcp"
+	ProviderVault = "vault"
+)
+
+// Provider retrieves secrets from an external secret manager.
+type Provider interface {
+	// GetSecret returns the contents of the named secret.
+	GetSecret(name string) (string, error)
+}
+
+// NewProvider returns the provider with the given name.
+func NewProvider(name string) (Provider, error) {
+	switch name {
+	case ProviderAws:
+		return awsProvider{}, nil
+	case ProviderGcp:
+		return gcpProvider{}, nil
+	case ProviderVault:
+		return vaultProvider{addr: os.Getenv("VAULT_ADDR"), token: os.Getenv("VAULT_TOKEN"), namespace: os.Getenv("VAULT_NAMESPACE")}, nil
+	}
+	return nil, fmt.Errorf("unknown secret provider %q, must be %q, %q, or %q", name, ProviderAws, ProviderGcp, ProviderVault)
+}
+
+// Get retrieves a secret from a provider. The resource is the provider's name for the secret, optionally followed by
+// #field to select a field of a secret stored as a JSON object, e.g. ld-find-code-refs#accessToken. If no field is
+// provided and the secret is a JSON object with a single field, that field's value is returned.
+func Get(provider Provider, resource string) (string, error) {
+	name, field := resource, ""
+	if i := strings.LastIndex(resource, "#"); i >= 0 {
+		name, field = resource[:i], resource[i+1:]
+	}
+	secret, err := provider.GetSecret(name)
+	if err != nil {
+		return "", err
+	}
+
+	values := map[string]interface{}{}
+	isObject := json.Unmarshal([]byte(secret), &values) == nil
+	if field == "" {
+		if isObject && len(values) == 1 {
+			for _, v := range values {
+				if s, ok := v.(string); ok {
+					return s, nil
+				}
+			}
+		}
+		if isObject && len(values) > 1 {
+			return "", fmt.Errorf("secret %s has multiple fields, select one with %s#field", name, name)
+		}
+		return strings.TrimSpace(secret), nil
+	}
+
+	if !isObject {
+		return "", fmt.Errorf("could not select field %q, secret %s is not a JSON object", field, name)
+	}
+	value, ok := values[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s does not contain a string field %q", name, field)
+	}
+	return value, nil
+}
+
+// execCommand is replaced in tests.
+var execCommand = exec.Command
+
+// run runs a provider's command line tool, which handles credentials from any of the sources supported by the
+// provider, and returns its output.
+func run(name string, args ...string) (string, error) {
+	out, err := execCommand(name, args...).Output()
+	if execErr, ok := err.(*exec.Error); ok && execErr.Err == exec.ErrNotFound {
+		return "", fmt.Errorf("%s is required to retrieve secrets, but was not found in the system PATH", name)
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return "", fmt.Errorf("%s failed: %s", name, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %s", name, err)
+	}
+	return string(out), nil
+}
+
+// awsProvider retrieves secrets from AWS Secrets Manager using the aws cli. Secrets are identified by name or ARN.
+type awsProvider struct{}
+
+func (awsProvider) GetSecret(name string) (string, error) {
+	return run("aws", "secretsmanager", "get-secret-value", "--secret-id", name, "--query", "SecretString", "--output", "text")
+}
+
+// gcpProvider retrieves secrets from GCP Secret Manager using the gcloud cli. Secrets are identified by their
+// resource name, e.g. projects/my-project/secrets/my-secret/versions/latest, or by secret name in the default project.
+type gcpProvider struct{}
+
+func (gcpProvider) GetSecret(name string) (string, error) {
+	args := []string{"secrets", "versions", "access", "latest", "--secret", name}
+	parts := strings.Split(name, "/")
+	if len(parts) >= 4 && parts[0] == "projects" && parts[2] == "secrets" {
+		version := "latest"
+		if len(parts) == 6 && parts[4] == "versions" {
+			version = parts[5]
+		}
+		args = []string{"secrets", "versions", "access", version, "--secret", parts[3], "--project", parts[1]}
+	}
+	return run("gcloud", args...)
+}
+
+// vaultProvider retrieves secrets from HashiCorp Vault using its HTTP API, configured with the standard VAULT_ADDR,
+// VAULT_TOKEN, and VAULT_NAMESPACE environment variables. Secrets are identified by their API path, e.g.
+// secret/data/ld-find-code-refs. The secret's data is returned as a JSON object.
+type vaultProvider struct {
+	addr      string
+	token     string
+	namespace string
+}
+
+func (p vaultProvider) GetSecret(name string) (string, error) {
+	if p.addr == "" || p.token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set to retrieve secrets from vault")
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(p.addr, "/")+"/v1/"+strings.TrimLeft(name, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	if p.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.namespace)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s for %s", res.Status, name)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.Unmarshal(body, &secret)
+	if err != nil {
+		return "", fmt.Errorf("could not parse vault response: %s", err)
+	}
+	data := secret.Data
+	// KV version 2 secrets are nested under data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	ret, err := json.Marshal(data)
+	return string(ret), err
+}
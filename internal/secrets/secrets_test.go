@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type staticProvider string
+
+func (p staticProvider) GetSecret(name string) (string, error) {
+	return string(p), nil
+}
+
+func Test_Get(t *testing.T) {
+	specs := []struct {
+		name        string
+		secret      string
+		resource    string
+		expected    string
+		expectedErr bool
+	}{
+		{"plain secret", "api-x\n", "token", "api-x", false},
+		{"single field", `{"accessToken":"api-x"}`, "token", "api-x", false},
+		{"selected field", `{"accessToken":"api-x","other":"y"}`, "token#accessToken", "api-x", false},
+		{"multiple fields", `{"accessToken":"api-x","other":"y"}`, "token", "", true},
+		{"missing field", `{"accessToken":"api-x"}`, "token#missing", "", true},
+		{"field of plain secret", "api-x", "token#accessToken", "", true},
+	}
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := Get(staticProvider(tt.secret), tt.resource)
+			if tt.expectedErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.expected, token)
+		})
+	}
+}
+
+func Test_gcpProvider(t *testing.T) {
+	// echo the command line instead of running gcloud
+	defer func(c func(string, ...string) *exec.Cmd) { execCommand = c }(execCommand)
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("echo", append([]string{name}, args...)...)
+	}
+
+	out, err := gcpProvider{}.GetSecret("ld-token")
+	require.NoError(t, err)
+	require.Equal(t, "gcloud secrets versions access latest --secret ld-token", strings.TrimSpace(out))
+
+	out, err = gcpProvider{}.GetSecret("projects/my-project/secrets/ld-token/versions/3")
+	require.NoError(t, err)
+	require.Equal(t, "gcloud secrets versions access 3 --secret ld-token --project my-project", strings.TrimSpace(out))
+}
+
+func Test_vaultProvider(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "/v1/secret/data/ld", req.URL.Path)
+		if req.Header.Get("X-Vault-Token") != "vault-token" {
+			res.WriteHeader(http.StatusForbidden)
+			return
+		}
+		res.Write([]byte(`{"data":{"data":{"accessToken":"api-x"},"metadata":{"version":1}}}`))
+	}))
+	defer testServer.Close()
+
+	token, err := Get(vaultProvider{addr: testServer.URL, token: "vault-token"}, "secret/data/ld")
+	require.NoError(t, err)
+	require.Equal(t, "api-x", token)
+
+	_, err = Get(vaultProvider{addr: testServer.URL, token: "wrong"}, "secret/data/ld")
+	require.EqualError(t, err, "vault returned 403 Forbidden for secret/data/ld")
+
+	_, err = Get(vaultProvider{}, "secret/data/ld")
+	require.Error(t, err)
+}
@@ -0,0 +1,152 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// backfillPoint is the number of references to each flag at a historical commit.
+type backfillPoint struct {
+	// Revision is the revision provided to the backfill command, e.g. a tag.
+	Revision string `json:"revision"`
+	Sha      string `json:"sha"`
+	// CommitTime is the commit time of Sha, in milliseconds since the epoch.
+	CommitTime     int64          `json:"commitTime"`
+	ReferenceCount int            `json:"referenceCount"`
+	Flags          map[string]int `json:"flags"`
+}
+
+// Backfill scans each of revs, e.g. tags or monthly snapshots, in a temporary git worktree, and writes the number
+// of references to each flag at each revision as a JSON time series, ordered by commit time, to the path provided
+// by the outFile option, or stdout. Code references are not sent to LaunchDarkly.
+func Backfill(revs []string) {
+	defer command.RemoveRunDir()
+	cmd, flags, exclude := newRevisionScanner()
+
+	points := []backfillPoint{}
+	for _, rev := range revs {
+		point, err := backfillRevision(cmd, rev, flags, exclude)
+		if err != nil {
+			log.Error.Fatalf("could not scan %s: %s", rev, err)
+		}
+		log.Info.Printf("found %d code references across %d flags at %s (%s)", point.ReferenceCount, len(point.Flags), rev, point.Sha)
+		points = append(points, point)
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].CommitTime < points[j].CommitTime
+	})
+
+	err := writeJsonOutput(o.OutFile.Value(), points)
+	if err != nil {
+		log.Error.Fatalf("error writing backfill results: %s", err)
+	}
+}
+
+// newRevisionScanner initializes a client for searching the repository, and retrieves the flags to search for, for
+// commands which scan revisions other than the checked out commit.
+func newRevisionScanner() (command.Client, []string, *regexp.Regexp) {
+	err := command.InitEnv(o.TmpDir.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+
+	if vcs := o.Vcs.Value(); vcs != command.VcsGit {
+		log.Error.Fatalf("revisions other than the checked out revision can only be scanned in git repositories, but vcs is %s", vcs)
+	}
+	cmd, err := command.NewClient(command.VcsGit, o.Dir.Value(), o.PathsRelativeToDir.Value(), o.SearchTool.Value(), o.SearchStrategy.Value(), o.ToolCacheDir.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	cmd.MaxConcurrency = maxConcurrency()
+	cmd.MaxDepth = o.MaxDepth.Value()
+	cmd.OneFileSystem = o.OneFileSystem.Value()
+	// includeExtensions and lfsPaths options have already been validated
+	cmd.IncludeGlobs, _ = command.IncludeGlobs(o.IncludeExtensions.Value())
+	cmd.LfsGlobs, _ = command.IncludeGlobs(o.LfsPaths.Value())
+	cmd.Pathspecs = o.Pathspec.Value()
+	cmd.TrackedOnly = o.TrackedOnly.Value()
+	// matcherPlugin option has already been validated
+	cmd.Plugins, _ = command.ParsePlugins(o.MatcherPlugin.Value())
+	cmd.PluginTimeout = time.Duration(o.PluginTimeout.Value()) * time.Second
+
+	projKey := o.ProjKey.Value()
+	ldApi, _ := newApiClient(projKey)
+	flags, _ := getFilteredFlags(ldApi, projKey)
+	// exclude option has already been validated as regex
+	exclude, _ := regexp.Compile(o.Exclude.Value())
+	return cmd, flags, exclude
+}
+
+// backfillRevision counts the references to each flag at rev.
+func backfillRevision(cmd command.Client, rev string, flags []string, exclude *regexp.Regexp) (backfillPoint, error) {
+	refs, worktree, err := scanRevision(cmd, rev, flags, exclude)
+	if err != nil {
+		return backfillPoint{}, err
+	}
+	return makeBackfillPoint(rev, worktree.GitSha, worktree.GitTimestamp*1000, refs), nil
+}
+
+// scanRevision finds references to flags at rev, which is checked out in a temporary worktree. It returns the
+// references, and the client used to search the worktree, which has since been removed.
+func scanRevision(cmd command.Client, rev string, flags []string, exclude *regexp.Regexp) ([]ld.ReferenceHunksRep, command.Client, error) {
+	dir, err := ioutil.TempDir("", "ld-find-code-refs-worktree")
+	if err != nil {
+		return nil, cmd, err
+	}
+	defer os.RemoveAll(dir)
+
+	worktreeDir := filepath.Join(dir, "worktree")
+	worktree, err := cmd.Worktree(rev, worktreeDir)
+	if err != nil {
+		return nil, cmd, err
+	}
+	defer func() {
+		if err := cmd.RemoveWorktree(worktreeDir); err != nil {
+			log.Warning.Printf("%s", err)
+		}
+	}()
+
+	b := &branch{Name: rev, Head: worktree.GitSha}
+	b.GrepResults, err = b.findReferences(worktree, flags, 0, exclude)
+	if err != nil {
+		return nil, worktree, err
+	}
+	refs := b.makeBranchRep("", 0).References
+	return annotateLanguages(classifyTestCode(refs, excludeTestReferences()), o.PlainHunks.Value()), worktree, nil
+}
+
+// writeJsonOutput writes v as indented JSON to path, or stdout if path is empty.
+func writeJsonOutput(path string, v interface{}) error {
+	write := func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	if path == "" {
+		return write(os.Stdout)
+	}
+	return writeFile(path, write)
+}
+
+// makeBackfillPoint counts the references to each flag in refs.
+func makeBackfillPoint(rev, sha string, commitTime int64, refs []ld.ReferenceHunksRep) backfillPoint {
+	point := backfillPoint{Revision: rev, Sha: sha, CommitTime: commitTime, Flags: map[string]int{}}
+	for _, ref := range refs {
+		for _, hunk := range ref.Hunks {
+			point.ReferenceCount += hunk.ReferenceCount()
+			point.Flags[hunk.FlagKey] += hunk.ReferenceCount()
+		}
+	}
+	return point
+}
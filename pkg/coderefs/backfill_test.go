@@ -0,0 +1,27 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_makeBackfillPoint(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{
+			{FlagKey: "flag-1", Offsets: []ld.OffsetRep{{LineNumber: 1}, {LineNumber: 2}}},
+			{FlagKey: "flag-2"},
+		}},
+		{Path: "b.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+	}
+	require.Equal(t, backfillPoint{
+		Revision:       "v1",
+		Sha:            "abc",
+		CommitTime:     1000,
+		ReferenceCount: 4,
+		Flags:          map[string]int{"flag-1": 3, "flag-2": 1},
+	}, makeBackfillPoint("v1", "abc", 1000, refs))
+	require.Equal(t, map[string]int{}, makeBackfillPoint("v1", "abc", 1000, nil).Flags)
+}
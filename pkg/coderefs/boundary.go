@@ -0,0 +1,55 @@
+package coderefs
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isIdentifierRune returns true if r may be part of an identifier in common languages. Unlike \b in the regular
+// expressions passed to search tools, this includes $, which is common in minified JavaScript, and unicode letters.
+func isIdentifierRune(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isBoundedMatch returns true if line[start:end] is not part of a longer identifier. Only edges of the match
+// which are themselves identifier characters need to be delimited, e.g. the flag key "-beta" may follow a letter.
+func isBoundedMatch(line string, start, end int) bool {
+	if start > 0 {
+		first, _ := utf8.DecodeRuneInString(line[start:end])
+		before, _ := utf8.DecodeLastRuneInString(line[:start])
+		if isIdentifierRune(first) && isIdentifierRune(before) {
+			return false
+		}
+	}
+	if end < len(line) {
+		last, _ := utf8.DecodeLastRuneInString(line[start:end])
+		after, _ := utf8.DecodeRuneInString(line[end:])
+		if isIdentifierRune(last) && isIdentifierRune(after) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsBoundedFlag returns true if any flag key occurs in line, delimited by identifier boundaries.
+func containsBoundedFlag(line string, flags []string) bool {
+	for _, flag := range flags {
+		if flag == "" {
+			continue
+		}
+		offset := 0
+		for {
+			idx := strings.Index(line[offset:], flag)
+			if idx < 0 {
+				break
+			}
+			start := offset + idx
+			if isBoundedMatch(line, start, start+len(flag)) {
+				return true
+			}
+			offset = start + 1
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_containsBoundedFlag(t *testing.T) {
+	specs := []struct {
+		name     string
+		line     string
+		flag     string
+		expected bool
+	}{
+		{"whole line", "checkout", "checkout", true},
+		{"quoted", `variation("checkout", false)`, "checkout", true},
+		{"dollar prefix", "var $checkout=1", "checkout", false},
+		{"dollar suffix", "checkout$=1", "checkout", false},
+		{"minified", "a.checkout$b", "checkout", false},
+		{"unicode letter prefix", "écheckout", "checkout", false},
+		{"unicode letter suffix", "checkoutñ", "checkout", false},
+		{"unicode punctuation", "«checkout»", "checkout", true},
+		{"later occurrence is bounded", "$checkout || 'checkout'", "checkout", true},
+		{"key starting with punctuation", "x-beta", "-beta", true},
+		{"key containing dollar", "a($flag)", "$flag", true},
+		{"digit suffix", "checkout2", "checkout", false},
+	}
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, containsBoundedFlag(tt.line, []string{tt.flag}))
+		})
+	}
+}
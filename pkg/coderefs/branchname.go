@@ -0,0 +1,66 @@
+package coderefs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// maxBranchNameLen is the length of the longest branch name sent to LaunchDarkly. Longer names are truncated, and a
+// hash of the original name is appended, so they remain unique.
+const maxBranchNameLen = 200
+
+// isBranchNameByte returns true if LaunchDarkly accepts c in branch names: printable ASCII, other than spaces, and
+// characters with a special meaning in URLs. Git doesn't allow ~ in branch or tag names, so it's used to escape other
+// bytes, and names which have already been encoded aren't changed.
+func isBranchNameByte(c byte) bool {
+	return c > ' ' && c < 0x7f && strings.IndexByte("#%?\\", c) < 0
+}
+
+// encodeBranchName returns the name code references for a branch are sent under. Bytes LaunchDarkly doesn't accept,
+// e.g. spaces and unicode characters, are replaced by ~ and their value as two hexadecimal digits, so the original name
+// can be recovered. It returns false if the name was not changed.
+func encodeBranchName(name string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if isBranchNameByte(name[i]) {
+			b.WriteByte(name[i])
+		} else {
+			fmt.Fprintf(&b, "~%02X", name[i])
+		}
+	}
+	encoded := b.String()
+	if len(encoded) > maxBranchNameLen {
+		sum := sha256.Sum256([]byte(name))
+		suffix := "-" + hex.EncodeToString(sum[:4])
+		prefix := encoded[:maxBranchNameLen-len(suffix)]
+		// Escape sequences aren't split
+		if i := strings.LastIndexByte(prefix, '~'); i >= 0 && i > len(prefix)-3 {
+			prefix = prefix[:i]
+		}
+		encoded = prefix + suffix
+	}
+	return encoded, encoded != name
+}
+
+// encodeBranch sends code references for branches with names LaunchDarkly doesn't accept under their encoded name,
+// with the original name in the upload's metadata.
+func encodeBranch(branchRep ld.BranchRep) ld.BranchRep {
+	name, encoded := encodeBranchName(branchRep.Name)
+	if !encoded {
+		return branchRep
+	}
+	log.Warning.Printf("branch name %q isn't accepted by LaunchDarkly, sending code references under %q", branchRep.Name, name)
+	metadata := ld.UploadMetadata{}
+	if branchRep.Metadata != nil {
+		metadata = *branchRep.Metadata
+	}
+	metadata.BranchName = branchRep.Name
+	branchRep.Metadata = &metadata
+	branchRep.Name = name
+	return branchRep
+}
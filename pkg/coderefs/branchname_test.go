@@ -0,0 +1,56 @@
+package coderefs
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_encodeBranchName(t *testing.T) {
+	name, encoded := encodeBranchName("feature/checkout-v2_1.0")
+	require.False(t, encoded)
+	require.Equal(t, "feature/checkout-v2_1.0", name)
+	name, encoded = encodeBranchName("deps/foo+bar@2,x=y")
+	require.False(t, encoded)
+	require.Equal(t, "deps/foo+bar@2,x=y", name)
+
+	name, encoded = encodeBranchName("feature/café #12")
+	require.True(t, encoded)
+	require.Equal(t, "feature/caf~C3~A9~20~2312", name)
+	// The encoding is reversible, and encoded names aren't encoded again
+	decoded, err := url.PathUnescape(strings.Replace(name, "~", "%", -1))
+	require.NoError(t, err)
+	require.Equal(t, "feature/café #12", decoded)
+	again, encoded := encodeBranchName(name)
+	require.False(t, encoded)
+	require.Equal(t, name, again)
+
+	long := strings.Repeat("a", maxBranchNameLen+1)
+	name, encoded = encodeBranchName(long)
+	require.True(t, encoded)
+	require.Len(t, name, maxBranchNameLen)
+	other, _ := encodeBranchName(long + "b")
+	require.NotEqual(t, name, other)
+
+	// Escape sequences aren't truncated
+	name, _ = encodeBranchName(strings.Repeat("a", maxBranchNameLen-10) + "ééé")
+	require.True(t, strings.HasSuffix(name, "a-"+name[len(name)-8:]), name)
+}
+
+func Test_encodeBranch(t *testing.T) {
+	branchRep := ld.BranchRep{Name: "main", Metadata: &ld.UploadMetadata{Runner: "ci"}}
+	require.Equal(t, branchRep, encodeBranch(branchRep))
+
+	branchRep.Name = "main é"
+	encoded := encodeBranch(branchRep)
+	require.Equal(t, "main~20~C3~A9", encoded.Name)
+	require.Equal(t, &ld.UploadMetadata{Runner: "ci", BranchName: "main é"}, encoded.Metadata)
+	require.Equal(t, &ld.UploadMetadata{Runner: "ci"}, branchRep.Metadata)
+
+	encoded = encodeBranch(ld.BranchRep{Name: "é"})
+	require.Equal(t, &ld.UploadMetadata{BranchName: "é"}, encoded.Metadata)
+}
@@ -0,0 +1,217 @@
+package coderefs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+const (
+	browsePageSize = 20
+
+	highlightStart = "\x1b[1;33m"
+	highlightEnd   = "\x1b[0m"
+)
+
+// browser is an interactive terminal UI for exploring a results file. Users navigate from a list
+// of flags, to the files referencing a flag, to the hunks for that flag in a file.
+type browser struct {
+	in        *bufio.Scanner
+	out       io.Writer
+	highlight bool
+
+	// flags are sorted by number of references, descending
+	flags []string
+	// map of flag keys to paths to hunks
+	hunks map[string]map[string][]ld.HunkRep
+	// map of flag keys to number of references
+	counts map[string]int
+}
+
+// Browse presents an interactive terminal UI for exploring the code references in the results file at path.
+// If highlight is true, flag keys will be highlighted using ANSI escape codes.
+func Browse(path string, in io.Reader, out io.Writer, highlight bool) error {
+	f, err := readResultsFile(path)
+	if err != nil {
+		return err
+	}
+	return newBrowser(f.References, in, out, highlight).run()
+}
+
+func newBrowser(refs []ld.ReferenceHunksRep, in io.Reader, out io.Writer, highlight bool) *browser {
+	b := &browser{
+		in:        bufio.NewScanner(in),
+		out:       out,
+		highlight: highlight,
+		hunks:     map[string]map[string][]ld.HunkRep{},
+		counts:    map[string]int{},
+	}
+
+	for _, ref := range refs {
+		for _, hunk := range ref.Hunks {
+			if b.hunks[hunk.FlagKey] == nil {
+				b.hunks[hunk.FlagKey] = map[string][]ld.HunkRep{}
+				b.flags = append(b.flags, hunk.FlagKey)
+			}
+			b.hunks[hunk.FlagKey][ref.Path] = append(b.hunks[hunk.FlagKey][ref.Path], hunk)
+			b.counts[hunk.FlagKey] += hunk.ReferenceCount()
+		}
+	}
+	sort.Slice(b.flags, func(i, j int) bool {
+		if b.counts[b.flags[i]] != b.counts[b.flags[j]] {
+			return b.counts[b.flags[i]] > b.counts[b.flags[j]]
+		}
+		return b.flags[i] < b.flags[j]
+	})
+	return b
+}
+
+func (b *browser) run() error {
+	if len(b.flags) == 0 {
+		fmt.Fprintln(b.out, "No code references found.")
+		return nil
+	}
+
+	for {
+		items := make([]string, 0, len(b.flags))
+		for _, flag := range b.flags {
+			items = append(items, fmt.Sprintf("%s (%d references in %d files)", flag, b.counts[flag], len(b.hunks[flag])))
+		}
+		i, quit := b.choose("Flags", items, false)
+		if quit {
+			return b.in.Err()
+		}
+		flag := b.flags[i]
+
+		paths := make([]string, 0, len(b.hunks[flag]))
+		for path := range b.hunks[flag] {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for {
+			items := make([]string, 0, len(paths))
+			for _, path := range paths {
+				items = append(items, fmt.Sprintf("%s (%d references)", path, referenceCount(b.hunks[flag][path])))
+			}
+			j, quit := b.choose("Files referencing "+flag, items, true)
+			if quit {
+				return b.in.Err()
+			}
+			if j < 0 {
+				break
+			}
+			if b.showHunks(flag, paths[j]) {
+				return b.in.Err()
+			}
+		}
+	}
+}
+
+// choose displays a paginated list of items and prompts for a selection. It returns the index of the
+// selected item, or -1 if the user navigated back, and whether the user quit.
+func (b *browser) choose(title string, items []string, canGoBack bool) (int, bool) {
+	page := 0
+	numPages := (len(items) + browsePageSize - 1) / browsePageSize
+	for {
+		start := page * browsePageSize
+		end := start + browsePageSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		fmt.Fprintf(b.out, "\n%s (page %d of %d)\n", title, page+1, numPages)
+		for i := start; i < end; i++ {
+			fmt.Fprintf(b.out, "%4d. %s\n", i+1, items[i])
+		}
+
+		commands := []string{"[number] select"}
+		if page < numPages-1 {
+			commands = append(commands, "n: next page")
+		}
+		if page > 0 {
+			commands = append(commands, "p: previous page")
+		}
+		if canGoBack {
+			commands = append(commands, "b: back")
+		}
+		commands = append(commands, "q: quit")
+
+		input, ok := b.prompt(strings.Join(commands, ", "))
+		if !ok {
+			return -1, true
+		}
+		switch input {
+		case "q":
+			return -1, true
+		case "b":
+			if canGoBack {
+				return -1, false
+			}
+		case "n":
+			if page < numPages-1 {
+				page++
+			}
+			continue
+		case "p":
+			if page > 0 {
+				page--
+			}
+			continue
+		}
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(items) {
+			return n - 1, false
+		}
+		fmt.Fprintf(b.out, "Invalid selection: %q\n", input)
+	}
+}
+
+// showHunks displays the hunks for a flag in a file, and returns true if the user quit.
+func (b *browser) showHunks(flag, path string) bool {
+	fmt.Fprintf(b.out, "\n%s in %s\n", flag, path)
+	for _, hunk := range b.hunks[flag][path] {
+		fmt.Fprintln(b.out, strings.Repeat("-", 40))
+		if hunk.Lines == "" {
+			fmt.Fprintf(b.out, "%6d | (source code not available)\n", hunk.StartingLineNumber)
+			continue
+		}
+		for i, line := range strings.Split(strings.TrimSuffix(hunk.Lines, "\n"), "\n") {
+			if b.highlight {
+				line = strings.Replace(line, flag, highlightStart+flag+highlightEnd, -1)
+			}
+			fmt.Fprintf(b.out, "%6d | %s\n", hunk.StartingLineNumber+i, line)
+		}
+	}
+
+	for {
+		input, ok := b.prompt("b: back, q: quit")
+		if !ok || input == "q" {
+			return true
+		}
+		if input == "b" {
+			return false
+		}
+	}
+}
+
+func referenceCount(hunks []ld.HunkRep) int {
+	count := 0
+	for _, hunk := range hunks {
+		count += hunk.ReferenceCount()
+	}
+	return count
+}
+
+func (b *browser) prompt(commands string) (string, bool) {
+	fmt.Fprintf(b.out, "%s > ", commands)
+	if !b.in.Scan() {
+		fmt.Fprintln(b.out)
+		return "", false
+	}
+	return strings.TrimSpace(b.in.Text()), true
+}
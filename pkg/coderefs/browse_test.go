@@ -0,0 +1,108 @@
+package coderefs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_browser(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a/b", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 5, Lines: "context\nflag-1\n", FlagKey: "flag-1"},
+			{StartingLineNumber: 9, Lines: "flag-2\n", FlagKey: "flag-2"},
+		}},
+		{Path: "a/d", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 1, Lines: "flag-3 flag-3 flag-3\n", FlagKey: "flag-3", Offsets: []ld.OffsetRep{
+				{LineNumber: 1, StartColumn: 0, EndColumn: 6},
+				{LineNumber: 1, StartColumn: 7, EndColumn: 13},
+				{LineNumber: 1, StartColumn: 14, EndColumn: 20},
+			}},
+		}},
+		{Path: "a/c", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 1, Lines: "flag-1\n", FlagKey: "flag-1"},
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		highlight bool
+		contains  []string
+		excludes  []string
+	}{
+		{
+			name:     "lists flags by reference count",
+			input:    "q\n",
+			contains: []string{"   1. flag-3 (3 references in 1 files)\n   2. flag-1 (2 references in 2 files)\n   3. flag-2 (1 references in 1 files)\n"},
+		},
+		{
+			name:     "lists files for a flag",
+			input:    "2\nq\n",
+			contains: []string{"Files referencing flag-1", "   1. a/b (1 references)\n   2. a/c (1 references)\n"},
+		},
+		{
+			name:     "shows hunks with line numbers",
+			input:    "2\n1\nq\n",
+			contains: []string{"flag-1 in a/b", "     5 | context\n     6 | flag-1\n"},
+			excludes: []string{highlightStart},
+		},
+		{
+			name:      "highlights flag keys",
+			input:     "2\n1\nq\n",
+			highlight: true,
+			contains:  []string{"     6 | " + highlightStart + "flag-1" + highlightEnd + "\n"},
+		},
+		{
+			name:     "navigates back",
+			input:    "2\nb\n3\n1\nq\n",
+			contains: []string{"flag-2 in a/b", "     9 | flag-2\n"},
+		},
+		{
+			name:     "rejects invalid selections",
+			input:    "4\nq\n",
+			contains: []string{`Invalid selection: "4"`},
+		},
+		{
+			name:     "counts multiple references per line",
+			input:    "1\nq\n",
+			contains: []string{"   1. a/d (3 references)\n"},
+		},
+		{
+			name:  "exits at end of input",
+			input: "1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := newBrowser(refs, strings.NewReader(tt.input), &out, tt.highlight).run()
+			require.NoError(t, err)
+			for _, s := range tt.contains {
+				require.Contains(t, out.String(), s)
+			}
+			for _, s := range tt.excludes {
+				require.NotContains(t, out.String(), s)
+			}
+		})
+	}
+}
+
+func Test_browserPagination(t *testing.T) {
+	hunks := []ld.HunkRep{}
+	for i := 0; i < browsePageSize+1; i++ {
+		hunks = append(hunks, ld.HunkRep{StartingLineNumber: 1, FlagKey: "flag-" + strings.Repeat("a", i+1)})
+	}
+
+	var out bytes.Buffer
+	err := newBrowser([]ld.ReferenceHunksRep{{Path: "a", Hunks: hunks}}, strings.NewReader("n\nq\n"), &out, false).run()
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "Flags (page 1 of 2)")
+	require.Contains(t, out.String(), "Flags (page 2 of 2)")
+	require.Contains(t, out.String(), "  21. flag-"+strings.Repeat("a", browsePageSize+1))
+}
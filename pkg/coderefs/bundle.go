@@ -0,0 +1,245 @@
+package coderefs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+const (
+	// bundleVersion is the version of the bundle layout. It must be incremented whenever files are removed or
+	// renamed, or change meaning.
+	bundleVersion = 1
+
+	bundleManifestName  = "manifest.json"
+	bundleResultsName   = "results.json"
+	bundleSignatureName = bundleResultsName + signatureSuffix
+	bundleChecksumsName = "SHA256SUMS"
+
+	// bundleMaxFileBytes limits the size of each file read from a bundle, so a malicious bundle can't exhaust memory.
+	bundleMaxFileBytes = 512 << 20
+)
+
+// bundleManifest describes the contents of a bundle created by the export-bundle command.
+type bundleManifest struct {
+	BundleVersion int    `json:"bundleVersion"`
+	SchemaVersion int    `json:"schemaVersion"`
+	CreatedAt     string `json:"createdAt"`
+	Branch        string `json:"branch,omitempty"`
+	Head          string `json:"head,omitempty"`
+	// Files are the files in the bundle, other than the manifest and checksums.
+	Files []bundleFile `json:"files"`
+}
+
+type bundleFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// bundle is the verified contents of a bundle.
+type bundle struct {
+	Manifest bundleManifest
+	Results  []byte
+	// Signature is the signature of Results written by the resultsSigningKey option, or nil if the results weren't
+	// signed.
+	Signature []byte
+}
+
+// ExportBundle writes the results file at path, its signature, if any, a manifest, and checksums of each file to a
+// gzipped tar file at out, so code references can be moved to a connected host as a single artifact, and imported
+// with the import-bundle command.
+func ExportBundle(path, out string) error {
+	results, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Invalid files are rejected now, rather than on the connected host
+	f, err := parseResultsFile(results)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid results file: %s", path, err)
+	}
+	files := map[string][]byte{bundleResultsName: results}
+	sig, err := ioutil.ReadFile(path + signatureSuffix)
+	if err == nil {
+		files[bundleSignatureName] = sig
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	manifest := bundleManifest{BundleVersion: bundleVersion, SchemaVersion: f.SchemaVersion, CreatedAt: now.Format(time.RFC3339), Branch: f.Branch, Head: f.Head}
+	for _, name := range sortedNames(files) {
+		manifest.Files = append(manifest.Files, bundleFile{Name: name, Size: len(files[name]), Sha256: sha256Hex(files[name])})
+	}
+	files[bundleManifestName], err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	var sums strings.Builder
+	for _, name := range sortedNames(files) {
+		// The format written by sha256sum, so the bundle can be checked with `sha256sum -c`
+		fmt.Fprintf(&sums, "%s  %s\n", sha256Hex(files[name]), name)
+	}
+	files[bundleChecksumsName] = []byte(sums.String())
+
+	return writeFile(out, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		// The manifest is written first, so it can be read without reading the whole bundle
+		names := []string{bundleManifestName}
+		for _, name := range sortedNames(files) {
+			if name != bundleManifestName {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			data := files[name]
+			err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg})
+			if err != nil {
+				return err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// readBundle reads a bundle written by ExportBundle, and verifies its checksums and manifest.
+func readBundle(path string) (bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return bundle{}, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return bundle{}, err
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return bundle{}, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return bundle{}, fmt.Errorf("unexpected entry %s: bundles only contain regular files", hdr.Name)
+		}
+		if _, ok := files[hdr.Name]; ok {
+			return bundle{}, fmt.Errorf("duplicate entry %s", hdr.Name)
+		}
+		if hdr.Size > bundleMaxFileBytes {
+			return bundle{}, fmt.Errorf("%s is larger than %d bytes", hdr.Name, bundleMaxFileBytes)
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(tr, bundleMaxFileBytes))
+		if err != nil {
+			return bundle{}, err
+		}
+		files[hdr.Name] = data
+	}
+	return verifyBundle(files)
+}
+
+// verifyBundle checks that files contains every file listed in the manifest, and no others, and that every file
+// matches the checksums in both the manifest and the checksums file.
+func verifyBundle(files map[string][]byte) (bundle, error) {
+	var b bundle
+	for _, name := range []string{bundleManifestName, bundleChecksumsName, bundleResultsName} {
+		if _, ok := files[name]; !ok {
+			return b, fmt.Errorf("%s is missing", name)
+		}
+	}
+	if err := json.Unmarshal(files[bundleManifestName], &b.Manifest); err != nil {
+		return b, fmt.Errorf("invalid %s: %s", bundleManifestName, err)
+	}
+	if b.Manifest.BundleVersion != bundleVersion {
+		return b, fmt.Errorf("unsupported bundle version %d, expected %d. Upgrade ld-find-code-refs on this host", b.Manifest.BundleVersion, bundleVersion)
+	}
+	if b.Manifest.SchemaVersion > currentSchemaVersion {
+		return b, fmt.Errorf("unsupported results schema version %d. Upgrade ld-find-code-refs on this host", b.Manifest.SchemaVersion)
+	}
+
+	listed := map[string]bool{bundleManifestName: true, bundleChecksumsName: true}
+	for _, file := range b.Manifest.Files {
+		data, ok := files[file.Name]
+		if !ok {
+			return b, fmt.Errorf("%s is listed in %s, but is missing", file.Name, bundleManifestName)
+		}
+		if len(data) != file.Size || sha256Hex(data) != file.Sha256 {
+			return b, fmt.Errorf("%s does not match its checksum in %s", file.Name, bundleManifestName)
+		}
+		listed[file.Name] = true
+	}
+	for name := range files {
+		if !listed[name] {
+			return b, fmt.Errorf("%s is not listed in %s", name, bundleManifestName)
+		}
+	}
+
+	checked := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(files[bundleChecksumsName])), "\n") {
+		parts := strings.SplitN(line, "  ", 2)
+		if len(parts) != 2 {
+			return b, fmt.Errorf("invalid line in %s: %q", bundleChecksumsName, line)
+		}
+		data, ok := files[parts[1]]
+		if !ok || sha256Hex(data) != parts[0] {
+			return b, fmt.Errorf("%s does not match its checksum in %s", parts[1], bundleChecksumsName)
+		}
+		checked[parts[1]] = true
+	}
+	for name := range files {
+		if name != bundleChecksumsName && !checked[name] {
+			return b, fmt.Errorf("%s is not listed in %s", name, bundleChecksumsName)
+		}
+	}
+
+	b.Results = files[bundleResultsName]
+	b.Signature = files[bundleSignatureName]
+	return b, nil
+}
+
+// ImportBundle verifies the bundle at path, written by ExportBundle, and sends its code references to LaunchDarkly
+// as the import command does.
+func ImportBundle(path string) {
+	b, err := readBundle(path)
+	if err != nil {
+		log.Error.Fatalf("could not verify bundle %s: %s", path, err)
+	}
+	log.Info.Printf("verified bundle %s, created at %s", path, b.Manifest.CreatedAt)
+	importResults(path, b.Results, b.Signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
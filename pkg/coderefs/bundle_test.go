@@ -0,0 +1,103 @@
+package coderefs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "refs.json")
+	results := []byte(`{"schemaVersion": 1, "branch": "main", "head": "abc123", "references": []}`)
+	require.NoError(t, ioutil.WriteFile(path, results, 0644))
+	require.NoError(t, ioutil.WriteFile(path+signatureSuffix, []byte("c2ln\n"), 0644))
+
+	out := filepath.Join(dir, "refs.tar.gz")
+	require.NoError(t, ExportBundle(path, out))
+	b, err := readBundle(out)
+	require.NoError(t, err)
+	require.Equal(t, results, b.Results)
+	require.Equal(t, []byte("c2ln\n"), b.Signature)
+	require.Equal(t, bundleVersion, b.Manifest.BundleVersion)
+	require.Equal(t, "main", b.Manifest.Branch)
+	require.Equal(t, "abc123", b.Manifest.Head)
+	require.Len(t, b.Manifest.Files, 2)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"references": "invalid"}`), 0644))
+	require.Error(t, ExportBundle(path, out))
+}
+
+func Test_verifyBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "refs.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"references": []}`), 0644))
+	out := filepath.Join(dir, "refs.tar.gz")
+	require.NoError(t, ExportBundle(path, out))
+	files := readTarGz(t, out)
+
+	_, err = verifyBundle(files)
+	require.NoError(t, err)
+
+	tampered := copyFiles(files)
+	tampered[bundleResultsName] = []byte(`{"references": [], "branch": "other"}`)
+	_, err = verifyBundle(tampered)
+	require.EqualError(t, err, "results.json does not match its checksum in manifest.json")
+
+	extra := copyFiles(files)
+	extra["extra.txt"] = []byte("extra")
+	_, err = verifyBundle(extra)
+	require.EqualError(t, err, "extra.txt is not listed in manifest.json")
+
+	missing := copyFiles(files)
+	delete(missing, bundleChecksumsName)
+	_, err = verifyBundle(missing)
+	require.EqualError(t, err, "SHA256SUMS is missing")
+
+	badSums := copyFiles(files)
+	badSums[bundleChecksumsName] = []byte("0000  results.json\n")
+	_, err = verifyBundle(badSums)
+	require.EqualError(t, err, "results.json does not match its checksum in SHA256SUMS")
+
+	newer := copyFiles(files)
+	newer[bundleManifestName] = []byte(`{"bundleVersion": 2}`)
+	_, err = verifyBundle(newer)
+	require.EqualError(t, err, "unsupported bundle version 2, expected 1. Upgrade ld-find-code-refs on this host")
+}
+
+func readTarGz(t *testing.T, path string) map[string][]byte {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = data
+	}
+	return files
+}
+
+func copyFiles(files map[string][]byte) map[string][]byte {
+	ret := make(map[string][]byte, len(files))
+	for k, v := range files {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,78 @@
+package coderefs
+
+import (
+	"encoding/json"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/cache"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// cachedFlagList is the flag list of one or more projects, as stored in the cache.
+type cachedFlagList struct {
+	Flags        []string            `json:"flags"`
+	FlagProjects map[string][]string `json:"flagProjects,omitempty"`
+}
+
+// openCache opens the cache directory, if the cacheDir option is provided. It returns nil if caching is disabled,
+// or the cache can't be opened, since scans don't depend on it.
+func openCache() *cache.Cache {
+	dir := o.CacheDir.Value()
+	if dir == "" {
+		return nil
+	}
+	c, err := cache.Open(dir, int64(o.CacheMaxSize.Value())*1024*1024)
+	if err != nil {
+		log.Warning.Printf("could not open cache directory %s: %s", dir, err)
+		return nil
+	}
+	return c
+}
+
+func flagListKey(projKey string) string {
+	return cache.Key("flags", o.BaseUri.Value(), projKey)
+}
+
+func branchKey(repoName, branchName string) string {
+	return cache.Key("branch", o.BaseUri.Value(), repoName, branchName)
+}
+
+// getCached decodes a JSON cache entry into v, returning false if there is no valid entry.
+func getCached(c *cache.Cache, key string, v interface{}) bool {
+	if c == nil {
+		return false
+	}
+	data, ok := c.Get(key)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Debug.Printf("ignoring invalid cache entry %s: %s", key, err)
+		return false
+	}
+	return true
+}
+
+// putCached stores v in the cache as JSON. Failures are logged, but otherwise ignored.
+func putCached(c *cache.Cache, key string, v interface{}) {
+	if c == nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = c.Put(key, data)
+	}
+	if err != nil {
+		log.Warning.Printf("could not write to cache: %s", err)
+	}
+}
+
+// cachedBranch returns the code references last sent for a branch by a scan using the same cache, or nil.
+func cachedBranch(c *cache.Cache, repoName, branchName string) *ld.BranchRep {
+	var branchRep ld.BranchRep
+	if !getCached(c, branchKey(repoName, branchName), &branchRep) {
+		return nil
+	}
+	return &branchRep
+}
@@ -0,0 +1,217 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// serviceCatalog maps each flag to the services, and other directories, referencing it.
+type serviceCatalog struct {
+	Flags []catalogFlag `json:"flags"`
+}
+
+type catalogFlag struct {
+	ProjKey        string `json:"projKey"`
+	FlagKey        string `json:"flagKey"`
+	ReferenceCount int    `json:"referenceCount"`
+	// Services are the services referencing the flag, most references first.
+	Services []catalogReferences `json:"services,omitempty"`
+	// Directories are the directories referencing the flag outside of any service, most references first.
+	Directories []catalogReferences `json:"directories,omitempty"`
+}
+
+// catalogReferences is the number of references to a flag in a service or directory.
+type catalogReferences struct {
+	Name           string `json:"name"`
+	ReferenceCount int    `json:"referenceCount"`
+}
+
+// serviceForPath returns the service with the innermost directory containing p, if any.
+func serviceForPath(p string, services []o.ServiceDir) (string, bool) {
+	name, longest := "", -1
+	for _, s := range services {
+		if (p == s.Dir || strings.HasPrefix(p, s.Dir+"/")) && len(s.Dir) > longest {
+			name, longest = s.Name, len(s.Dir)
+		}
+	}
+	return name, longest >= 0
+}
+
+// makeServiceCatalog attributes the references to each flag to the services containing them. References outside
+// of any service are attributed to their directory, at most depth levels below the repository root.
+func makeServiceCatalog(refs []ld.ReferenceHunksRep, services []o.ServiceDir, depth int) serviceCatalog {
+	type flagKey struct{ projKey, flagKey string }
+	serviceRefs := map[flagKey]map[string]int{}
+	dirRefs := map[flagKey]map[string]int{}
+	for _, ref := range refs {
+		service, ok := serviceForPath(ref.Path, services)
+		counts := serviceRefs
+		name := service
+		if !ok {
+			counts = dirRefs
+			name = directoryAtDepth(ref.Path, depth)
+		}
+		for _, hunk := range ref.Hunks {
+			k := flagKey{hunk.ProjKey, hunk.FlagKey}
+			if counts[k] == nil {
+				counts[k] = map[string]int{}
+			}
+			counts[k][name] += hunk.ReferenceCount()
+		}
+	}
+
+	keys := []flagKey{}
+	for k := range serviceRefs {
+		keys = append(keys, k)
+	}
+	for k := range dirRefs {
+		if serviceRefs[k] == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].projKey != keys[j].projKey {
+			return keys[i].projKey < keys[j].projKey
+		}
+		return keys[i].flagKey < keys[j].flagKey
+	})
+
+	catalog := serviceCatalog{Flags: make([]catalogFlag, 0, len(keys))}
+	for _, k := range keys {
+		flag := catalogFlag{ProjKey: k.projKey, FlagKey: k.flagKey, Services: sortedReferences(serviceRefs[k]), Directories: sortedReferences(dirRefs[k])}
+		for _, r := range append(flag.Services, flag.Directories...) {
+			flag.ReferenceCount += r.ReferenceCount
+		}
+		catalog.Flags = append(catalog.Flags, flag)
+	}
+	return catalog
+}
+
+func sortedReferences(counts map[string]int) []catalogReferences {
+	if len(counts) == 0 {
+		return nil
+	}
+	ret := make([]catalogReferences, 0, len(counts))
+	for name, count := range counts {
+		ret = append(ret, catalogReferences{Name: name, ReferenceCount: count})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ReferenceCount != ret[j].ReferenceCount {
+			return ret[i].ReferenceCount > ret[j].ReferenceCount
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// backstageEntity is a Backstage catalog entity, in the form of catalog-info.yaml files.
+type backstageEntity struct {
+	ApiVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   backstageMetadata `yaml:"metadata"`
+	Spec       backstageSpec     `yaml:"spec"`
+}
+
+type backstageMetadata struct {
+	Name        string            `yaml:"name"`
+	Title       string            `yaml:"title"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type backstageSpec struct {
+	Type         string   `yaml:"type"`
+	Owner        string   `yaml:"owner"`
+	DependencyOf []string `yaml:"dependencyOf,omitempty"`
+}
+
+// backstageInvalidRegex matches runs of characters which aren't allowed in Backstage entity names.
+var backstageInvalidRegex = regexp.MustCompile(`[^-_.a-zA-Z0-9]+`)
+
+// backstageName returns a Backstage entity name for a flag. Flags are prefixed with their project key if more than
+// one project is scanned, since keys are only unique within a project.
+func backstageName(projKey, flagKey string, prefix bool) string {
+	name := flagKey
+	if prefix {
+		name = projKey + "-" + flagKey
+	}
+	name = backstageInvalidRegex.ReplaceAllString(name, "-")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-_.")
+}
+
+// writeBackstageCatalog writes a Backstage Resource entity for each flag, which is a dependency of the Component of
+// each service referencing it.
+func writeBackstageCatalog(w io.Writer, catalog serviceCatalog, owner string) error {
+	projects := map[string]bool{}
+	for _, f := range catalog.Flags {
+		projects[f.ProjKey] = true
+	}
+	for _, f := range catalog.Flags {
+		entity := backstageEntity{
+			ApiVersion: "backstage.io/v1alpha1",
+			Kind:       "Resource",
+			Metadata: backstageMetadata{
+				Name:  backstageName(f.ProjKey, f.FlagKey, len(projects) > 1),
+				Title: f.FlagKey,
+				Annotations: map[string]string{
+					"launchdarkly.com/project-key": f.ProjKey,
+					"launchdarkly.com/flag-key":    f.FlagKey,
+				},
+			},
+			Spec: backstageSpec{Type: "feature-flag", Owner: owner},
+		}
+		for _, s := range f.Services {
+			entity.Spec.DependencyOf = append(entity.Spec.DependencyOf, "component:"+s.Name)
+		}
+		data, err := yaml.Marshal(entity)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "---\n"); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeServiceCatalog writes the flags referenced by each service to the path provided by the catalogFile option,
+// if any.
+func writeServiceCatalog(refs []ld.ReferenceHunksRep) {
+	path := o.CatalogFile.Value()
+	if path == "" {
+		return
+	}
+	// service option has already been validated
+	services, _ := o.Services()
+	depth := o.HeatmapDepth.Value()
+	if depth <= 0 {
+		depth = 1
+	}
+	catalog := makeServiceCatalog(refs, services, depth)
+	err := writeFile(path, func(w io.Writer) error {
+		if o.CatalogFormat.Value() == o.CatalogFormatBackstage {
+			return writeBackstageCatalog(w, catalog, o.CatalogOwner.Value())
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(catalog)
+	})
+	if err != nil {
+		log.Error.Fatalf("error writing service catalog to %s: %s", path, err)
+	}
+	log.Info.Printf("wrote the services referencing %d flags to %s", len(catalog.Flags), path)
+}
@@ -0,0 +1,75 @@
+package coderefs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+func Test_makeServiceCatalog(t *testing.T) {
+	services := []o.ServiceDir{{Name: "web", Dir: "services/web"}, {Name: "web-admin", Dir: "services/web/admin"}, {Name: "api", Dir: "services/api"}}
+	refs := []ld.ReferenceHunksRep{
+		{Path: "services/web/main.go", Hunks: []ld.HunkRep{{ProjKey: "default", FlagKey: "checkout", Offsets: []ld.OffsetRep{{LineNumber: 1}, {LineNumber: 2}}}}},
+		{Path: "services/web/admin/main.go", Hunks: []ld.HunkRep{{ProjKey: "default", FlagKey: "checkout"}}},
+		{Path: "services/webhooks/main.go", Hunks: []ld.HunkRep{{ProjKey: "default", FlagKey: "checkout"}}},
+		{Path: "scripts/seed.sh", Hunks: []ld.HunkRep{{ProjKey: "default", FlagKey: "seed-data"}}},
+	}
+	catalog := makeServiceCatalog(refs, services, 1)
+	require.Equal(t, serviceCatalog{Flags: []catalogFlag{
+		{
+			ProjKey:        "default",
+			FlagKey:        "checkout",
+			ReferenceCount: 4,
+			Services:       []catalogReferences{{Name: "web", ReferenceCount: 2}, {Name: "web-admin", ReferenceCount: 1}},
+			Directories:    []catalogReferences{{Name: "services", ReferenceCount: 1}},
+		},
+		{
+			ProjKey:        "default",
+			FlagKey:        "seed-data",
+			ReferenceCount: 1,
+			Directories:    []catalogReferences{{Name: "scripts", ReferenceCount: 1}},
+		},
+	}}, catalog)
+}
+
+func Test_writeBackstageCatalog(t *testing.T) {
+	catalog := serviceCatalog{Flags: []catalogFlag{
+		{ProjKey: "default", FlagKey: "checkout", Services: []catalogReferences{{Name: "web", ReferenceCount: 2}}},
+		{ProjKey: "default", FlagKey: "seed data!", Directories: []catalogReferences{{Name: "scripts", ReferenceCount: 1}}},
+	}}
+	var buf bytes.Buffer
+	require.NoError(t, writeBackstageCatalog(&buf, catalog, "group:platform"))
+	require.Equal(t, `---
+apiVersion: backstage.io/v1alpha1
+kind: Resource
+metadata:
+  name: checkout
+  title: checkout
+  annotations:
+    launchdarkly.com/flag-key: checkout
+    launchdarkly.com/project-key: default
+spec:
+  type: feature-flag
+  owner: group:platform
+  dependencyOf:
+  - component:web
+---
+apiVersion: backstage.io/v1alpha1
+kind: Resource
+metadata:
+  name: seed-data
+  title: seed data!
+  annotations:
+    launchdarkly.com/flag-key: seed data!
+    launchdarkly.com/project-key: default
+spec:
+  type: feature-flag
+  owner: group:platform
+`, buf.String())
+
+	require.Equal(t, "default-checkout", backstageName("default", "checkout", true))
+}
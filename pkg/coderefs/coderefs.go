@@ -2,8 +2,14 @@ package coderefs
 
 import (
 	"container/list"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +18,7 @@ import (
 	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
 	"github.com/launchdarkly/ld-find-code-refs/internal/log"
 	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+	"github.com/launchdarkly/ld-find-code-refs/internal/secrets"
 )
 
 // These are defensive limits intended to prevent corner cases stemming from
@@ -34,6 +41,14 @@ type grepResultLine struct {
 	LineNum  int
 	LineText string
 	FlagKeys []string
+	// FlagColumns maps each flag key in FlagKeys to the columns of every occurrence of the key on the line.
+	FlagColumns map[string][]columnRange
+}
+
+// columnRange is the position of a flag key within a line, as 0-based byte offsets. End is exclusive.
+type columnRange struct {
+	Start int
+	End   int
 }
 
 type grepResultLines []grepResultLine
@@ -55,28 +70,440 @@ type branch struct {
 	Head             string
 	IsDefault        bool
 	UpdateSequenceId *int64
-	SyncTime         int64
-	GrepResults      grepResultLines
+	// SequenceTime is the time, in seconds, the default updateSequenceId is derived from
+	SequenceTime int64
+	SyncTime     int64
+	CommitTime   int64
+	GrepResults  grepResultLines
+	// GrepOutput is the output of the search GrepResults were generated from
+	GrepOutput [][]string
+	// FileErrors records files whose code references couldn't be built
+	FileErrors *command.FileErrors
 }
 
 func Scan() {
-	cmd, err := command.NewClient(o.Dir.Value())
+	if o.SoftFail.Value() {
+		log.FatalHook = softFail
+	}
+	err := command.InitEnv(o.TmpDir.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	defer command.RemoveRunDir()
+
+	if niceness := o.Niceness.Value(); niceness > 0 {
+		err = command.SetNiceness(niceness)
+		if err != nil {
+			log.Warning.Printf("could not set niceness: %s", err)
+		}
+	}
+	concurrency := maxConcurrency()
+	runtime.GOMAXPROCS(concurrency)
+
+	cmd, err := command.NewClient(o.Vcs.Value(), o.Dir.Value(), o.PathsRelativeToDir.Value(), o.SearchTool.Value(), o.SearchStrategy.Value(), o.ToolCacheDir.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	if reason, skip := skippedByRepository(cmd.Workspace, o.Enabled.Value()); skip {
+		log.Info.Printf("skipped by repository configuration: %s", reason)
+		scanSummary.ProjKey = o.ProjKey.Value()
+		emitSummary(summarySkipped, skippedByConfiguration)
+		return
+	}
+	// The signing key is read before scanning, so a missing key doesn't waste a scan
+	var signingKey ed25519.PrivateKey
+	if keyPath := o.ResultsSigningKey.Value(); keyPath != "" {
+		signingKey, err = readSigningKey(keyPath)
+		if err != nil {
+			log.Error.Fatalf("could not read resultsSigningKey: %s", err)
+		}
+	}
+	lock, err := cmd.LockWorkspace(o.WaitForLock.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	defer lock.Release()
+	cmd.MaxConcurrency = concurrency
+	cmd.MaxFiles = o.MaxFiles.Value()
+	cmd.MaxDepth = o.MaxDepth.Value()
+	cmd.OneFileSystem = o.OneFileSystem.Value()
+	// includeExtensions and lfsPaths options have already been validated
+	cmd.IncludeGlobs, _ = command.IncludeGlobs(o.IncludeExtensions.Value())
+	cmd.LfsGlobs, _ = command.IncludeGlobs(o.LfsPaths.Value())
+	cmd.Pathspecs = o.Pathspec.Value()
+	cmd.TrackedOnly = o.TrackedOnly.Value()
+	// matcherPlugin option has already been validated
+	cmd.Plugins, _ = command.ParsePlugins(o.MatcherPlugin.Value())
+	cmd.PluginTimeout = time.Duration(o.PluginTimeout.Value()) * time.Second
+	cmd.FileErrors = &command.FileErrors{}
+
+	// exclude option has already been validated as regex
+	exclude, _ := regexp.Compile(o.Exclude.Value())
+	if flag := o.Explain.Value(); flag != "" {
+		explain(cmd, flag, exclude)
+		return
+	}
+
+	branchName, sequenceTime, upload, err := scannedBranch(cmd)
 	if err != nil {
 		log.Error.Fatalf("%s", err)
 	}
 
 	projKey := o.ProjKey.Value()
+	scanSummary.ProjKey = projKey
+	scanSummary.Branch = branchName
+	var ldApi ld.ApiClient
+	var repoParams ld.RepoParams
+	if upload {
+		ldApi, repoParams = initApiClient(projKey)
+	} else {
+		// Flags are still retrieved, but the repository isn't created or updated
+		ldApi, repoParams = newApiClient(projKey)
+	}
+	scanSummary.RepoName = repoParams.Name
+	var filteredFlags []string
+	var flagProjects map[string][]string
+	flagCount := 0
+	if o.StreamFlags.Value() {
+		// Flag keys are added to the matcher as they're retrieved, rather than collected into a list
+		cmd.FlagMatcher = streamFlags(ldApi, projKey)
+		flagCount = cmd.FlagMatcher.Len()
+	} else {
+		filteredFlags, flagProjects = getFilteredFlags(ldApi, projKey)
+		flagCount = len(filteredFlags)
+	}
+	// normalizeFlagKey option has already been validated
+	rules, _ := o.FlagKeyRules()
+	searchedFlags, canonicalFlags := normalizeFlagKeys(filteredFlags, flagProjects, projKey, rules)
+	var envKeys map[string][]string
+	if o.EnvReferences.Value() {
+		var envNames []string
+		envNames, envKeys = envFlagKeys(searchedFlags, o.EnvPrefixes.Value())
+		searchedFlags = append(append([]string{}, searchedFlags...), envNames...)
+	}
+
+	ctxLines := o.ContextLines.Value()
+	b := &branch{
+		Name:         branchName,
+		IsDefault:    o.DefaultBranch.Value() == branchName,
+		SequenceTime: sequenceTime,
+		SyncTime:     makeTimestamp(),
+		CommitTime:   cmd.GitTimestamp * 1000, // seconds to milliseconds
+		Head:         cmd.GitSha,
+	}
+
+	if seconds := o.MaxScanSeconds.Value(); seconds > 0 {
+		cmd.Deadline = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	refs, err := searchHead(&cmd, b, searchedFlags, ctxLines, exclude)
+	if budgetErr, ok := err.(*command.BudgetExceededError); ok {
+		scanBudgetExceeded(budgetErr)
+	} else if err != nil {
+		log.Error.Fatalf("error searching for flag key references: %s", err)
+	}
+	b.GrepResults = refs
+	b.FileErrors = cmd.FileErrors
+	b.UpdateSequenceId = updateSequenceId(b.SequenceTime, !scanSummary.Incomplete)
+
+	buildBranchRep := func(b *branch) ld.BranchRep {
+		branchRep := b.makeBranchRep(projKey, ctxLines)
+		if envKeys != nil {
+			branchRep.References = attributeEnvReferences(branchRep.References, envKeys)
+		}
+		branchRep.References = formatReferences(branchRep.References, hunkFormatOptions())
+		branchRep.References = classifyTestCode(branchRep.References, excludeTestReferences())
+		branchRep.References = annotateLanguages(branchRep.References, o.PlainHunks.Value())
+		branchRep.References = addHunkMetadata(branchRep.References, hunkMetadataFields(), o.PlainHunks.Value())
+		if canonicalFlags != nil {
+			branchRep.References = attributeFlagKeys(branchRep.References, canonicalFlags)
+		} else if flagProjects != nil {
+			branchRep.References = assignProjects(branchRep.References, flagProjects)
+		}
+		branchRep.References = addContentHashes(branchRep.References)
+		return branchRep
+	}
+	branchRep := buildBranchRep(b)
+	reportFileErrors(cmd.FileErrors.List())
+	if o.VerifyDeterminism.Value() {
+		// Rebuild from the search output, without logging the same warnings again
+		var rebuilt ld.BranchRep
+		log.Quiet(func() {
+			second := *b
+			second.FileErrors = &command.FileErrors{}
+			second.GrepResults = generateReferencesFromGrep(searchedFlags, b.GrepOutput, ctxLines, exclude, second.FileErrors)
+			rebuilt = buildBranchRep(&second)
+		})
+		err = verifyDeterminism(branchRep, rebuilt)
+		if err != nil {
+			log.Error.Fatalf("%s", err)
+		}
+		log.Info.Printf("verified that code references built twice from the same search results are identical")
+	}
+	languages := scannedLanguages(cmd, exclude, branchRep.References)
+	var reports []flagReport
+	if o.FlagStatus.Value() {
+		reports = getFlagReports(ldApi, cmd, branchRep)
+	}
+	drifted := checkHeadAfterSearch(cmd, "before the results were written")
+	writeOutFile(branchRep, cmd.Workspace, reports, signingKey)
+	writeServiceCatalog(branchRep.References)
+	if o.SuggestExcludes.Value() || o.ApplySuggestions.Value() {
+		printExcludeSuggestions(branchRep.References)
+	}
+	summarizeBranch(branchRep, flagCount)
+	if o.DryRun.Value() {
+		log.Info.Printf("dry run found %d code references in %d hunks across %d flags and %d files for project: %s", branchRep.TotalReferenceCount(), branchRep.TotalHunkCount(), flagCount, len(branchRep.References), projKey)
+		if o.Debug.Value() {
+			branchRep.PrintReferenceCountTable()
+		}
+		emitSummary(summaryDryRun, "")
+		return
+	}
+	if !upload {
+		log.Info.Printf("found %d code references in %d hunks across %d flags and %d files for project: %s", branchRep.TotalReferenceCount(), branchRep.TotalHunkCount(), flagCount, len(branchRep.References), projKey)
+		emitSummary(summarySkipped, skippedPullRequest)
+		return
+	}
+	if minScore := o.MinScore.Value(); minScore > 0 {
+		branchRep.References = filterByScore(branchRep.References, minScore)
+	}
+	if n := o.Sample.Value(); n > 0 {
+		branchRep.References, branchRep.Samples = sampleReferences(branchRep.References, n)
+		for _, sample := range branchRep.Samples {
+			log.Info.Printf("sending a sample of %d of %d hunks for flag %s, which has %d code references", sample.SampledHunkCount, sample.HunkCount, sample.FlagKey, sample.ReferenceCount)
+		}
+	}
+	if len(branchRep.References) == 0 {
+		send, err := noReferencesFound(flagCount)
+		if err != nil {
+			log.Error.Fatalf("%s", err)
+		}
+		if !send {
+			emitSummary(summarySkipped, skippedNoReferences)
+			return
+		}
+	}
+	if o.LanguageStats.Value() {
+		if branchRep.Metadata == nil {
+			branchRep.Metadata = &ld.UploadMetadata{}
+		}
+		branchRep.Metadata.Languages = languages
+	}
+	// Report the code references sent, after filtering and sampling
+	summarizeBranch(branchRep, flagCount)
+	checkUploadSize(branchRep)
+	if !drifted {
+		checkHeadAfterSearch(cmd, "before code references were sent")
+	}
+	stale, prev := staleHead(ldApi, cmd, branchRep, repoParams.Name)
+	if stale {
+		emitSummary(summarySkipped, skippedStaleHead)
+		return
+	}
+	log.Info.Printf("sending %d code references in %d hunks across %d flags and %d files to LaunchDarkly for project: %s", branchRep.TotalReferenceCount(), branchRep.TotalHunkCount(), flagCount, len(branchRep.References), projKey)
+
+	if putBranch(ldApi, branchRep, repoParams.Name, prev) {
+		emitSummary(summaryUploaded, "")
+	} else {
+		emitSummary(summarySkipped, skippedSequenceConflict)
+	}
+}
+
+// maxConcurrency returns the maxConcurrency option if provided, otherwise the value of its environment variable, or
+// GOMAXPROCS.
+func maxConcurrency() int {
+	if n := o.MaxConcurrency.Value(); n > 0 {
+		return n
+	}
+	if env := os.Getenv(o.EnvVarName(o.MaxConcurrency)); env != "" {
+		n, err := strconv.Atoi(env)
+		if err == nil && n > 0 {
+			return n
+		}
+		log.Warning.Printf("ignoring invalid %s: %q", o.EnvVarName(o.MaxConcurrency), env)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// staleHead checks that the commit previously sent for the branch is an ancestor of the checked out commit, so
+// an older build doesn't overwrite code references sent by a newer one. It returns true if code references
+// should not be sent, according to the onStaleHead option, and the code references previously sent, if they were
+// retrieved, so they can be reused to send only changed files.
+func staleHead(ldApi ld.ApiClient, cmd command.Client, branchRep ld.BranchRep, repoName string) (bool, *ld.BranchRep) {
+	policy := o.OnStaleHead.Value()
+	if policy == o.OnStaleHeadIgnore {
+		return false, nil
+	}
+	name, _ := encodeBranchName(branchRep.Name)
+	prev, err := ldApi.GetCodeReferenceBranch(repoName, name)
+	if err != nil {
+		if err != ld.NotFoundErr {
+			log.Debug.Printf("could not retrieve previous code references for branch %s: %s", branchRep.Name, err)
+		}
+		return false, nil
+	}
+	if prev.Head == "" || prev.Head == branchRep.Head {
+		return false, prev
+	}
+	isAncestor, err := cmd.IsAncestor(prev.Head)
+	if err != nil {
+		log.Debug.Printf("could not determine whether previously sent commit %s is an ancestor of %s, it may not have been fetched: %s", prev.Head, branchRep.Head, err)
+		return false, prev
+	}
+	if isAncestor {
+		return false, prev
+	}
+	if policy == o.OnStaleHeadSkip {
+		log.Warning.Printf("skipping update: code references for branch %s were previously sent for commit %s, which is not an ancestor of %s", branchRep.Name, prev.Head, branchRep.Head)
+		return true, prev
+	}
+	log.Warning.Printf("code references for branch %s were previously sent for commit %s, which is not an ancestor of %s, and will be overwritten", branchRep.Name, prev.Head, branchRep.Head)
+	return false, prev
+}
+
+// getFlagReports fetches the status of each flag, and prints a report of each flag's references and status.
+// Statuses are only used for local reports, so errors are logged as warnings.
+func getFlagReports(ldApi ld.ApiClient, cmd command.Client, branchRep ld.BranchRep) []flagReport {
+	statuses := map[string]ld.FlagStatus{}
+	maintainers := map[string]ld.FlagMaintainer{}
+	for _, projKey := range projKeys(o.ProjKey.Value()) {
+		ldApi.Options.ProjKey = projKey
+		flags, err := ldApi.GetProjectFlags()
+		if err != nil {
+			log.Warning.Printf("could not retrieve flag statuses from LaunchDarkly: %s", err)
+			return nil
+		}
+		projStatuses, err := ldApi.GetFlagStatuses(flags)
+		if err != nil {
+			log.Warning.Printf("could not retrieve flag statuses from LaunchDarkly: %s", err)
+			return nil
+		}
+		for flagKey, status := range projStatuses {
+			statuses[flagKey] = status
+		}
+		for flagKey, m := range flags.Maintainers() {
+			maintainers[flagKey] = m
+		}
+	}
+	reports := makeFlagReports(branchRep.References, statuses, time.Now())
+	getMembers := memberLookup(ldApi)
+	if o.FlagMaintainers.Value() {
+		emails, err := maintainerEmails(maintainers, getMembers)
+		if err != nil {
+			log.Warning.Printf("could not retrieve members from LaunchDarkly to find flag maintainers: %s", err)
+		}
+		assignMaintainers(reports, emails)
+	}
+	if o.SuggestOwners.Value() {
+		// Owners are never sent to LaunchDarkly, since they identify the authors of code
+		members, err := getMembers()
+		if err != nil {
+			log.Warning.Printf("could not retrieve members from LaunchDarkly: %s", err)
+		} else {
+			assignOwners(reports, flagOwners(branchRep.References, members, cmd.BlameEmails))
+		}
+	}
+	printFlagReports(log.Output(), reports)
+	return reports
+}
+
+// writeOutFile writes code references to the path provided by the outFile option, if any, in the format
+// provided by the outFormat option. root is the absolute path of the scanned directory. If signingKey isn't nil, a
+// signature of the json file is written next to it.
+func writeOutFile(branchRep ld.BranchRep, root string, reports []flagReport, signingKey ed25519.PrivateKey) {
+	path := o.OutFile.Value()
+	if path == "" {
+		return
+	}
+
+	references := addSharedFlags(addScores(addPermalinks(branchRep.References, permalinkOptionsTemplate(), branchRep.Head)))
+	if references == nil {
+		references = []ld.ReferenceHunksRep{}
+	}
+	branchRep.References = references
+
+	var directories []directoryReport
+	if depth := o.HeatmapDepth.Value(); depth > 0 {
+		directories = makeDirectoryReports(references, depth)
+	}
+
+	var err error
+	switch o.OutFormat.Value() {
+	case o.OutFormatHtml:
+		err = writeFile(path, func(w io.Writer) error { return writeHtmlReport(w, branchRep, directories, reports) })
+	case o.OutFormatCsv:
+		err = writeFile(path, func(w io.Writer) error { return writeCsv(w, references) })
+	case o.OutFormatQuickfix:
+		err = writeFile(path, func(w io.Writer) error { return writeQuickfix(w, references) })
+	case o.OutFormatLsp:
+		err = writeFile(path, func(w io.Writer) error { return writeLspDiagnostics(w, root, references) })
+	default:
+		err = writeResultsFile(path, resultsFile{Branch: branchRep.Name, Head: branchRep.Head, References: references, Flags: reports, Directories: directories})
+		if err == nil && signingKey != nil {
+			err = signResultsFile(path, signingKey)
+		}
+	}
+	if err != nil {
+		log.Error.Fatalf("error writing code references to %s: %s", path, err)
+	}
+	log.Info.Printf("wrote code references to %s", path)
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// initApiClient validates the configured project keys, initializes the LaunchDarkly API client, and
+// creates or updates the code reference repository connection, unless this is a dry run.
+func initApiClient(projKey string) (ld.ApiClient, ld.RepoParams) {
+	ldApi, repoParams := newApiClient(projKey)
+	if o.DryRun.Value() {
+		return ldApi, repoParams
+	}
+
+	err := ldApi.MaybeUpsertCodeReferenceRepository(repoParams)
+	if err == ld.RepositoryDisabledErr {
+		// Repositories are disabled by LaunchDarkly admins, so this is usually intentional
+		const msg = "code references for repository %s have been disabled in LaunchDarkly, skipping scan. To re-enable them, visit the Code references page in your LaunchDarkly integration settings"
+		if o.FailOnDisabledRepo.Value() {
+			log.Error.Fatalf(msg, repoParams.Name)
+		}
+		log.Warning.Printf(msg, repoParams.Name)
+		scanSummary.RepoName = repoParams.Name
+		emitSummary(summarySkipped, skippedRepositoryDisabled)
+		log.Exit(0)
+	} else if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
 
-	// Check for potential sdk keys or access tokens provided as the project key
-	if len(projKey) > maxProjKeyLength {
-		if strings.HasPrefix(projKey, "sdk-") {
-			log.Warning.Printf("provided projKey (%s) appears to be a LaunchDarkly SDK key", "sdk-xxxx")
-		} else if strings.HasPrefix(projKey, "api-") {
-			log.Warning.Printf("provided projKey (%s) appears to be a LaunchDarkly API access token", "api-xxxx")
+	return ldApi, repoParams
+}
+
+// newApiClient validates the configured project keys, and initializes the LaunchDarkly API client without
+// connecting the repository, for commands which don't send code references.
+func newApiClient(projKey string) (ld.ApiClient, ld.RepoParams) {
+	keys := projKeys(projKey)
+	for _, projKey := range keys {
+		// Check for potential sdk keys or access tokens provided as the project key
+		if len(projKey) > maxProjKeyLength {
+			if strings.HasPrefix(projKey, "sdk-") {
+				log.Warning.Printf("provided projKey (%s) appears to be a LaunchDarkly SDK key", "sdk-xxxx")
+			} else if strings.HasPrefix(projKey, "api-") {
+				log.Warning.Printf("provided projKey (%s) appears to be a LaunchDarkly API access token", "api-xxxx")
+			}
 		}
 	}
 
-	ldApi := ld.InitApiClient(ld.ApiOptions{ApiKey: o.AccessToken.Value(), BaseUri: o.BaseUri.Value(), ProjKey: projKey})
+	ldApi := ld.InitApiClient(newApiOptions(keys))
 	repoParams := ld.RepoParams{
 		Type:              o.RepoType.Value(),
 		Name:              o.RepoName.Value(),
@@ -84,67 +511,303 @@ func Scan() {
 		CommitUrlTemplate: o.CommitUrlTemplate.Value(),
 		HunkUrlTemplate:   o.HunkUrlTemplate.Value(),
 	}
+	if repoParams.Name == "" {
+		err := detectRepo(&repoParams, o.RepoDir(), o.Remote.Value())
+		if err != nil {
+			log.Error.Fatalf("repoName was not provided, and could not be detected from the repository's remotes: %s", err)
+		}
+	}
+	return ldApi, repoParams
+}
 
-	err = ldApi.MaybeUpsertCodeReferenceRepository(repoParams)
+// newApiOptions returns the options used to connect to the LaunchDarkly API, for the project keys keys.
+func newApiOptions(keys []string) ld.ApiOptions {
+	apiOptions := ld.ApiOptions{ApiKey: accessToken(), BaseUri: o.BaseUri.Value(), ProjKey: keys[0], DebugHttp: o.DebugHttp.Value(), Headers: http.Header{}}
+	// projAccessToken options have already been validated
+	apiOptions.ProjectApiKeys, _ = o.ProjAccessTokens()
+	apiOptions.SigningSecret = o.SigningSecret.Value()
+	apiOptions.MaxFlags = o.MaxFlags.Value()
+	apiOptions.AllowPartialFlags = o.AllowPartialFlags.Value()
+	apiOptions.EnvironmentKey = o.EnvironmentKey.Value()
+	apiOptions.RequestTimeout = time.Duration(o.ApiTimeout.Value()) * time.Second
+	if apiOptions.RequestTimeout == 0 {
+		apiOptions.RequestTimeout = -1
+	}
+	apiOptions.DisableKeepAlives = !o.ApiKeepAlive.Value()
+	apiOptions.MaxIdleConns = o.ApiMaxIdleConns.Value()
+	for tokenProjKey := range apiOptions.ProjectApiKeys {
+		found := false
+		for _, projKey := range keys {
+			found = found || projKey == tokenProjKey
+		}
+		if !found {
+			log.Warning.Printf("projAccessToken was provided for project %s, which is not in projKey", tokenProjKey)
+		}
+	}
+	for _, h := range o.ApiHeader.Value() {
+		// apiHeader options have already been validated as key=value
+		kv := strings.SplitN(h, "=", 2)
+		apiOptions.Headers.Add(kv[0], kv[1])
+	}
+	if o.ClientCert.Value() != "" {
+		cert, err := ld.LoadClientCertificate(o.ClientCert.Value(), o.ClientKey.Value())
+		if err != nil {
+			log.Error.Fatalf("%s", err)
+		}
+		apiOptions.ClientCertificate = cert
+	}
+	if path := o.HttpCaptureFile.Value(); path != "" {
+		// Captures may contain source code, so they're only readable by the current user
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Error.Fatalf("could not create HTTP capture file: %s", err)
+		}
+		apiOptions.HttpCapture = f
+	}
+	return apiOptions
+}
+
+// accessToken returns the access token provided by the accessToken option, or retrieves it from a secret manager.
+func accessToken() string {
+	if o.AccessTokenSecret.Value() == "" {
+		return o.AccessToken.Value()
+	}
+	provider, err := secrets.NewProvider(o.AccessTokenSource.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	token, err := secrets.Get(provider, o.AccessTokenSecret.Value())
 	if err != nil {
-		log.Error.Fatalf(err.Error())
+		log.Error.Fatalf("could not retrieve access token from %s: %s", o.AccessTokenSource.Value(), err)
 	}
+	log.Debug.Printf("retrieved access token from %s secret %s", o.AccessTokenSource.Value(), o.AccessTokenSecret.Value())
+	return token
+}
 
-	flags, err := getFlags(ldApi)
+// projKeys returns the project keys provided by the projKey option, which may be a comma separated list.
+func projKeys(projKey string) []string {
+	keys := []string{}
+	for _, k := range strings.Split(projKey, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		// projKey is required, so this is only reached if it only contains commas
+		log.Error.Fatalf("invalid projKey: %q", projKey)
+	}
+	return keys
+}
+
+// getFilteredFlags retrieves the flag keys for each project, applying the onZeroReferences option if there are no flag
+// keys to search for.
+// If more than one project is provided, it also returns the projects each flag key belongs to.
+func getFilteredFlags(ldApi ld.ApiClient, projKey string) ([]string, map[string][]string) {
+	filter, err := flagFilterOptions()
 	if err != nil {
-		log.Error.Fatalf("could not retrieve flag keys from LaunchDarkly: %s", err)
+		log.Error.Fatalf("%s", err)
+	}
+	var flags []string
+	var flagProjects map[string][]string
+	keys := projKeys(projKey)
+	if explicitFlags, ok := filter.explicitFlags(); ok && len(keys) == 1 {
+		// With more than one project, flags are retrieved to find which project each belongs to
+		log.Info.Printf("searching for %d flags provided by the flags option, without retrieving flags from LaunchDarkly", len(explicitFlags))
+		flags = explicitFlags
+	} else {
+		flags, flagProjects = getProjectsFlags(ldApi, projKey, keys)
+		flags = filter.apply(flags)
 	}
 	if len(flags) == 0 {
-		log.Info.Printf("no flag keys found for project: %s, exiting early", projKey)
-		os.Exit(0)
+		exitIfNoFlags(fmt.Sprintf("no flag keys found for project: %s", projKey))
+		return nil, flagProjects
 	}
 
 	filteredFlags, omittedFlags := filterShortFlagKeys(flags)
 	if len(filteredFlags) == 0 {
-		log.Info.Printf("no flag keys longer than the minimum flag key length (%v) were found for project: %s, exiting early",
-			minFlagKeyLen, projKey)
-		os.Exit(0)
+		exitIfNoFlags(fmt.Sprintf("no flag keys longer than the minimum flag key length (%v) were found for project: %s", minFlagKeyLen, projKey))
+		return nil, flagProjects
 	} else if len(omittedFlags) > 0 {
 		log.Warning.Printf("omitting %d flags with keys less than minimum (%d)", len(omittedFlags), minFlagKeyLen)
 	}
+	warnFlagConflicts(filteredFlags, flagProjects)
+	return filteredFlags, flagProjects
+}
 
-	ctxLines := o.ContextLines.Value()
-	var updateId *int64
-	if o.UpdateSequenceId.Value() >= 0 {
-		updateIdOption := o.UpdateSequenceId.Value()
-		updateId = &updateIdOption
+// getProjectsFlags retrieves the flag keys of each project, and which projects each flag belongs to if there is more
+// than one project. If flag keys can't be retrieved, those cached by a previous scan are used, if any.
+func getProjectsFlags(ldApi ld.ApiClient, projKey string, keys []string) ([]string, map[string][]string) {
+	var flags []string
+	var flagProjects map[string][]string
+	var err error
+	if len(keys) == 1 {
+		flags, err = getFlags(ldApi)
+	} else {
+		flags, flagProjects, err = getProjectFlags(ldApi, keys)
 	}
-	b := &branch{
-		Name:             cmd.GitBranch,
-		IsDefault:        o.DefaultBranch.Value() == cmd.GitBranch,
-		UpdateSequenceId: updateId,
-		SyncTime:         makeTimestamp(),
-		Head:             cmd.GitSha,
+	if _, ok := err.(*ld.TruncatedFlagListError); ok {
+		// Cached flags aren't used, since they may also be incomplete
+		log.Error.Fatalf("could not retrieve every flag key from LaunchDarkly: %s", err)
+	}
+	c := openCache()
+	if err != nil {
+		var cached cachedFlagList
+		if !getCached(c, flagListKey(projKey), &cached) {
+			log.Error.Fatalf("could not retrieve flag keys from LaunchDarkly: %s", err)
+		}
+		log.Warning.Printf("could not retrieve flag keys from LaunchDarkly, using the flag keys cached by a previous scan: %s", err)
+		return cached.Flags, cached.FlagProjects
 	}
+	putCached(c, flagListKey(projKey), cachedFlagList{Flags: flags, FlagProjects: flagProjects})
+	return flags, flagProjects
+}
 
-	// exclude option has already been validated as regex
-	exclude, _ := regexp.Compile(o.Exclude.Value())
-	refs, err := b.findReferences(cmd, filteredFlags, ctxLines, exclude)
+// getProjectFlags retrieves the flag keys of several projects. Flag keys are unique within a project, but
+// the same key may be used by more than one project.
+func getProjectFlags(ldApi ld.ApiClient, projKeys []string) ([]string, map[string][]string, error) {
+	projectFlags, err := ldApi.GetFlagKeyLists(projKeys)
 	if err != nil {
-		log.Error.Fatalf("error searching for flag key references: %s", err)
+		return nil, nil, err
 	}
-	b.GrepResults = refs
+	flags := []string{}
+	flagProjects := map[string][]string{}
+	// Iterate over projects in the order provided, so results are consistent
+	for _, projKey := range projKeys {
+		for _, flag := range projectFlags[projKey] {
+			if _, ok := flagProjects[flag]; !ok {
+				flags = append(flags, flag)
+			}
+			flagProjects[flag] = append(flagProjects[flag], projKey)
+		}
+	}
+	return flags, flagProjects, nil
+}
 
-	branchRep := b.makeBranchRep(projKey, ctxLines)
-	log.Info.Printf("sending %d code references across %d flags and %d files to LaunchDarkly for project: %s", branchRep.TotalHunkCount(), len(filteredFlags), len(branchRep.References), projKey)
+// assignProjects sets the project of each hunk according to its flag key. Hunks for flag keys used by more
+// than one project are repeated for each project.
+func assignProjects(refs []ld.ReferenceHunksRep, flagProjects map[string][]string) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			for _, projKey := range flagProjects[hunk.FlagKey] {
+				hunk.ProjKey = projKey
+				hunks = append(hunks, hunk)
+			}
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
 
+// scannedBranch returns the name code references for the checked out commit are sent under, and the time, in seconds,
+// used for the default updateSequenceId. When the tag option is provided, code references are sent under the tag's
+// name, with the tag prefix, and the time the tag was created is used. The branchName option overrides either name.
+// It also returns false if code references shouldn't be sent, because a pull request is being built.
+func scannedBranch(cmd command.Client) (string, int64, bool, error) {
+	name, sequenceTime := cmd.GitBranch, cmd.GitTimestamp
+	if tag := o.Tag.Value(); tag != "" {
+		sha, err := cmd.TagSha(tag)
+		if err != nil {
+			return "", 0, false, err
+		}
+		if sha != cmd.GitSha {
+			return "", 0, false, fmt.Errorf("tag %s points to %s, but %s is checked out", tag, sha, cmd.GitSha)
+		}
+		sequenceTime, err = cmd.TagTime(tag)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("error parsing tag timestamp: %s", err)
+		}
+		name = o.TagPrefix.Value() + tag
+	}
+	branchName := o.BranchName.Value()
+	if branchName != "" {
+		name = branchName
+	}
+	name, upload, err := pullRequestBranch(name, branchName != "")
+	if err != nil {
+		return "", 0, false, err
+	}
+	// Pull request builds are often checked out without a branch, which is only needed to send code references
+	if name == "" && upload {
+		return "", 0, false, fmt.Errorf("git repo at %s must be checked out to a valid branch, or the tag or branchName option must be provided", cmd.Workspace)
+	}
+	return name, sequenceTime, upload, nil
+}
+
+// updateSequenceId returns the updateSequenceId option, if provided. Otherwise, unless commitSequenceId is
+// disabled, the commit time of the scanned commit is used, so code references sent for a newer commit can't be
+// overwritten by a scan of an older one, e.g. when an old build is retried. commitTime is in seconds, and 0 if unknown.
+// When scanning a tag, commitTime is the time the tag was created. Unless the scan is incomplete, e.g. because it
+// exceeded maxFiles, the millisecond after the commit time is used, so a complete scan of a commit replaces an
+// incomplete one, but not the other way around.
+func updateSequenceId(commitTime int64, complete bool) *int64 {
+	if o.UpdateSequenceId.Value() >= 0 {
+		updateId := o.UpdateSequenceId.Value()
+		return &updateId
+	}
+	if commitTime <= 0 || !o.CommitSequenceId.Value() {
+		return nil
+	}
+	// Milliseconds, like the updateSequenceId derived from push times by the GitHub Actions integration
+	updateId := commitTime * 1000
+	if complete {
+		updateId++
+	}
+	log.Info.Printf("using the time of the scanned commit or tag (%s) as the updateSequenceId: %d", time.Unix(commitTime, 0).UTC().Format(time.RFC3339), updateId)
+	return &updateId
+}
+
+// putBranch sends code references to LaunchDarkly. prev is the code references previously sent for the branch, if
+// they were already retrieved. It returns false if they weren't sent because of the updateSequenceId.
+func putBranch(ldApi ld.ApiClient, branchRep ld.BranchRep, repoName string, prev *ld.BranchRep) bool {
+	branchRep = encodeBranch(branchRep)
 	if o.Debug.Value() {
 		branchRep.PrintReferenceCountTable()
 	}
 
-	err = ldApi.PutCodeReferenceBranch(branchRep, repoParams.Name)
+	metadata := uploadMetadata()
+	if branchRep.Metadata != nil && (len(branchRep.Metadata.Languages) > 0 || branchRep.Metadata.BranchName != "") {
+		if metadata == nil {
+			metadata = &ld.UploadMetadata{}
+		}
+		metadata.Languages = branchRep.Metadata.Languages
+		metadata.BranchName = branchRep.Metadata.BranchName
+	}
+	branchRep.Metadata = metadata
+	c := openCache()
+	if o.DeltaUpload.Value() {
+		// Code references just retrieved from LaunchDarkly are more recent than those cached
+		if prev == nil {
+			prev = cachedBranch(c, repoName, branchRep.Name)
+		}
+		sent, conflict := patchBranch(ldApi, branchRep, repoName, prev)
+		if sent && !conflict {
+			putCached(c, branchKey(repoName, branchRep.Name), branchRep)
+			unspoolBranch(repoName, branchRep.Name)
+			verifyUpload(ldApi, branchRep, repoName)
+		}
+		if sent {
+			return !conflict
+		}
+	}
+	err := ldApi.PutCodeReferenceBranch(branchRep, repoName)
 	if err != nil {
-		if err == ld.BranchUpdateSequenceIdConflictErr && b.UpdateSequenceId != nil {
-			log.Warning.Printf("updateSequenceId (%d) must be greater than previously submitted updateSequenceId", *b.UpdateSequenceId)
+		if err == ld.BranchUpdateSequenceIdConflictErr && branchRep.UpdateSequenceId != nil {
+			log.Warning.Printf("updateSequenceId (%d) must be greater than previously submitted updateSequenceId", *branchRep.UpdateSequenceId)
 		} else {
+			if err == ld.EntityTooLargeErr {
+				logSizeContributors(branchRep)
+			}
+			spoolBranch(branchRep, repoName)
 			log.Error.Fatalf("error sending code references to LaunchDarkly: %s", err)
 		}
+		return false
 	}
+	putCached(c, branchKey(repoName, branchRep.Name), branchRep)
+	unspoolBranch(repoName, branchRep.Name)
+	verifyUpload(ldApi, branchRep, repoName)
+	return true
 }
 
 // Very short flag keys lead to many false positives when searching in code,
@@ -170,65 +833,165 @@ func getFlags(ldApi ld.ApiClient) ([]string, error) {
 	return flags, nil
 }
 
+// findReferences searches for references to flags. If the search exceeded its budget, the references found are
+// returned with a *command.BudgetExceededError.
 func (b *branch) findReferences(cmd command.Client, flags []string, ctxLines int, exclude *regexp.Regexp) (grepResultLines, error) {
+	// Without flag keys, there's nothing to search for
+	if len(flags) == 0 && (cmd.FlagMatcher == nil || cmd.FlagMatcher.Len() == 0) {
+		return grepResultLines{}, nil
+	}
 	grepResult, err := cmd.SearchForFlags(flags, ctxLines)
-	if err != nil {
+	if _, partial := err.(*command.BudgetExceededError); err != nil && !partial {
 		return grepResultLines{}, err
 	}
 
-	return generateReferencesFromGrep(flags, grepResult, ctxLines, exclude), nil
+	b.GrepOutput = grepResult
+	return generateReferencesFromGrep(flags, grepResult, ctxLines, exclude, cmd.FileErrors), err
+}
+
+// scanBudgetExceeded applies the onBudgetExceeded option when maxFiles or maxScanSeconds is exceeded.
+func scanBudgetExceeded(err *command.BudgetExceededError) {
+	if o.OnBudgetExceeded.Value() == o.OnBudgetExceededFail {
+		log.Error.Fatalf("%s. Increase maxFiles or maxScanSeconds, or limit the files searched with dir, exclude, or includeExtensions", err)
+	}
+	log.Warning.Printf("%s. Code references are incomplete", err)
+	scanSummary.Incomplete = true
+}
+
+// maxLoggedFileErrors is the number of files which couldn't be searched that are logged as warnings. The rest are
+// logged at the debug level.
+const maxLoggedFileErrors = 10
+
+// reportFileErrors logs the files which couldn't be searched, and records them in the summary of the scan. Code
+// references found in other files are still sent.
+func reportFileErrors(errs []command.FileError) {
+	if len(errs) == 0 {
+		return
+	}
+	log.Warning.Printf("could not search %d files, references in them will be missing", len(errs))
+	for i, e := range errs {
+		if i < maxLoggedFileErrors {
+			log.Warning.Printf("could not search %s: %s", e.Path, e.Reason)
+		} else {
+			log.Debug.Printf("could not search %s: %s", e.Path, e.Reason)
+		}
+	}
+	if len(errs) > maxLoggedFileErrors {
+		log.Warning.Printf("%d more files could not be searched, enable debug to list them", len(errs)-maxLoggedFileErrors)
+	}
+	scanSummary.FileErrors = errs
 }
 
-func generateReferencesFromGrep(flags []string, grepResult [][]string, ctxLines int, exclude *regexp.Regexp) []grepResultLine {
+// generateReferencesFromGrep converts search results to references. If processing the results for a file panics, the
+// file is skipped, and the panic is recorded in errs.
+func generateReferencesFromGrep(flags []string, grepResult [][]string, ctxLines int, exclude *regexp.Regexp, errs *command.FileErrors) []grepResultLine {
 	references := []grepResultLine{}
+	rejected := 0
+	failed := map[string]bool{}
 
 	for _, r := range grepResult {
 		path := r[1]
-		if exclude != nil && exclude.String() != "" && exclude.MatchString(path) {
+		if failed[path] || exclude != nil && exclude.String() != "" && exclude.MatchString(path) {
 			continue
 		}
-		contextContainsFlagKey := r[2] == ":"
-		lineNumber := r[3]
-		lineText := r[4]
-		lineNum, err := strconv.Atoi(lineNumber)
-		if err != nil {
-			log.Error.Fatalf("encountered an unexpected error generating flag references: %s", err)
-		}
-		ref := grepResultLine{Path: path, LineNum: lineNum}
-		if contextContainsFlagKey {
-			ref.FlagKeys = findReferencedFlags(lineText, flags)
-		}
-		if ctxLines >= 0 {
-			ref.LineText = lineText
+		failed[path] = !errs.Isolate(path, func() {
+			ref, ok := makeGrepResultLine(r, flags, ctxLines)
+			if !ok {
+				rejected++
+			}
+			references = append(references, ref)
+		})
+	}
+
+	if rejected > 0 {
+		log.Debug.Printf("ignored %d lines matched by the search tool where flag keys are part of a longer identifier", rejected)
+	}
+	if len(failed) == 0 {
+		return references
+	}
+	// References found in a file before processing it panicked are dropped
+	ret := references[:0]
+	for _, ref := range references {
+		if !failed[ref.Path] {
+			ret = append(ret, ref)
 		}
-		references = append(references, ref)
 	}
+	return ret
+}
 
-	return references
+// makeGrepResultLine converts a search result to a reference, and returns false if a match was rejected because the
+// flag key was part of a longer identifier.
+func makeGrepResultLine(r []string, flags []string, ctxLines int) (grepResultLine, bool) {
+	path := r[1]
+	valid := true
+	contextContainsFlagKey := r[2] == ":"
+	lineNumber := r[3]
+	// Search tools include the carriage return of lines ending with CRLF
+	lineText := strings.TrimSuffix(r[4], "\r")
+	// Search tools' word boundaries don't account for identifiers containing $ or unicode letters, so matches
+	// are checked again. Lines without a valid match are kept as context, since they may be near one.
+	pluginFlags := command.PluginFlagKeys(r)
+	// Lines found by a FlagMatcher list the keys occurring in them, so only those are checked
+	if matched := command.MatchedFlagKeys(r); matched != nil {
+		flags = matched
+	}
+	if contextContainsFlagKey && pluginFlags == nil && !containsBoundedFlag(lineText, flags) {
+		contextContainsFlagKey = false
+		valid = false
+	}
+	lineNum, err := strconv.Atoi(lineNumber)
+	if err != nil {
+		log.Error.Fatalf("encountered an unexpected error generating flag references: %s", err)
+	}
+	ref := grepResultLine{Path: path, LineNum: lineNum}
+	if contextContainsFlagKey && pluginFlags != nil {
+		// Matcher plugins may find references which don't contain the flag key, so those have no columns
+		ref.FlagKeys = pluginFlags
+		ref.FlagColumns = findFlagColumns(lineText, ref.FlagKeys)
+	} else if contextContainsFlagKey {
+		ref.FlagKeys = findReferencedFlags(lineText, flags)
+		ref.FlagColumns = findFlagColumns(lineText, ref.FlagKeys)
+	}
+	if ctxLines >= 0 {
+		ref.LineText = lineText
+	}
+	return ref, valid
 }
 
+// findReferencedFlags returns the flags which occur in ref, other than as part of a longer identifier, or within
+// occurrences of longer flag keys.
 func findReferencedFlags(ref string, flags []string) []string {
+	occurrences := flagOccurrences(ref, flags)
 	ret := []string{}
 	for _, flag := range flags {
-		if strings.Contains(ref, flag) {
+		if len(occurrences[flag]) > 0 {
 			ret = append(ret, flag)
 		}
 	}
 	return ret
 }
 
+// findFlagColumns returns the columns of every occurrence of each flag key in a line, attributing occurrences of
+// overlapping keys to the longest key.
+func findFlagColumns(line string, flags []string) map[string][]columnRange {
+	return flagOccurrences(line, flags)
+}
+
 func (b *branch) makeBranchRep(projKey string, ctxLines int) ld.BranchRep {
 	return ld.BranchRep{
 		Name:             strings.TrimPrefix(b.Name, "refs/heads/"),
 		Head:             b.Head,
 		UpdateSequenceId: b.UpdateSequenceId,
 		SyncTime:         b.SyncTime,
+		CommitTime:       b.CommitTime,
 		IsDefault:        b.IsDefault,
-		References:       b.GrepResults.makeReferenceHunksReps(projKey, ctxLines),
+		References:       b.GrepResults.makeReferenceHunksReps(projKey, ctxLines, b.FileErrors),
 	}
 }
 
-func (g grepResultLines) makeReferenceHunksReps(projKey string, ctxLines int) []ld.ReferenceHunksRep {
+// makeReferenceHunksReps groups references into hunks for each file. If building the hunks for a file panics, the file
+// is skipped, and the panic is recorded in errs.
+func (g grepResultLines) makeReferenceHunksReps(projKey string, ctxLines int, errs *command.FileErrors) []ld.ReferenceHunksRep {
 	reps := []ld.ReferenceHunksRep{}
 
 	aggregatedGrepResults := g.aggregateByPath()
@@ -246,7 +1009,10 @@ func (g grepResultLines) makeReferenceHunksReps(projKey string, ctxLines int) []
 			break
 		}
 
-		hunks := fileGrepResults.makeHunkReps(projKey, ctxLines)
+		var hunks []ld.HunkRep
+		if !errs.Isolate(fileGrepResults.path, func() { hunks = fileGrepResults.makeHunkReps(projKey, ctxLines) }) {
+			continue
+		}
 
 		if len(hunks) > maxHunksPerFileCount {
 			log.Warning.Printf("found %d code references in %s, which exceeded the limit of %d, truncating file hunks", len(hunks), fileGrepResults.path, maxHunksPerFileCount)
@@ -328,8 +1094,15 @@ func (fgr *fileGrepResults) addFlagReference(key string, ref *list.Element) {
 func (fgr fileGrepResults) makeHunkReps(projKey string, ctxLines int) []ld.HunkRep {
 	hunks := []ld.HunkRep{}
 
-	for flagKey, flagReferences := range fgr.flagReferenceMap {
-		flagHunks := buildHunksForFlag(projKey, flagKey, fgr.path, flagReferences, fgr.fileGrepResultLines, ctxLines)
+	// Flags are sorted, so hunks, and the hunks kept when a file exceeds maxHunksPerFileCount, don't depend on
+	// map iteration order
+	flagKeys := make([]string, 0, len(fgr.flagReferenceMap))
+	for flagKey := range fgr.flagReferenceMap {
+		flagKeys = append(flagKeys, flagKey)
+	}
+	sort.Strings(flagKeys)
+	for _, flagKey := range flagKeys {
+		flagHunks := buildHunksForFlag(projKey, flagKey, fgr.path, fgr.flagReferenceMap[flagKey], fgr.fileGrepResultLines, ctxLines)
 		hunks = append(hunks, flagHunks...)
 	}
 
@@ -345,6 +1118,7 @@ func buildHunksForFlag(projKey, flag, path string, flagReferences []*list.Elemen
 	lastSeenLineNum := -1
 
 	var hunkStringBuilder strings.Builder
+	var hunkOffsets []ld.OffsetRep
 
 	appendToPreviousHunk := false
 
@@ -359,18 +1133,20 @@ func buildHunksForFlag(projKey, flag, path string, flagReferences []*list.Elemen
 
 		// Attempt to seek to the start of the new hunk.
 		for i := 0; i < ctxLines; i++ {
-			// If we seek to a nil pointer, we're at the start of the file and can go no further.
-			if ptr.Prev() != nil {
-				ptr = ptr.Prev()
-				numCtxLinesBeforeFlagRef++
-			}
-
-			// If we seek earlier than the end of the last hunk, this reference overlaps at least
-			// partially with the last hunk and we should (possibly) expand the previous hunk rather than
-			// starting a new hunk.
-			if ptr.Value.(grepResultLine).LineNum <= lastSeenLineNum {
-				appendToPreviousHunk = true
+			// If the previous line is missing, we're at the start of the file, or the start of the lines returned
+			// around this reference, and can go no further. Lines further back belong to another reference.
+			prev := ptr.Prev()
+			if prev == nil || prev.Value.(grepResultLine).LineNum != ptr.Value.(grepResultLine).LineNum-1 {
+				break
 			}
+			ptr = prev
+			numCtxLinesBeforeFlagRef++
+		}
+		// If we seek earlier than the end of the last hunk, this reference overlaps at least
+		// partially with the last hunk and we should (possibly) expand the previous hunk rather than
+		// starting a new hunk.
+		if ptr.Value.(grepResultLine).LineNum <= lastSeenLineNum {
+			appendToPreviousHunk = true
 		}
 
 		// If we are starting a new hunk, initialize it
@@ -378,6 +1154,7 @@ func buildHunksForFlag(projKey, flag, path string, flagReferences []*list.Elemen
 			currentHunk = initHunk(projKey, flag)
 			currentHunk.StartingLineNumber = ptr.Value.(grepResultLine).LineNum
 			hunkStringBuilder.Reset()
+			hunkOffsets = nil
 		}
 
 		// From the current position (at the theoretical start of the hunk) seek forward line by line X times,
@@ -386,25 +1163,35 @@ func buildHunksForFlag(projKey, flag, path string, flagReferences []*list.Elemen
 		//   For each line, check if we have seeked past the end of the last hunk
 		//     If so: write that line to the hunkStringBuilder
 		//     Record that line as the last seen line.
+		//   Stop early at the end of the file, or of the lines returned around this reference.
 		for i := 0; i < numCtxLinesBeforeFlagRef+1+ctxLines; i++ {
 			ptrLineNum := ptr.Value.(grepResultLine).LineNum
 			if ptrLineNum > lastSeenLineNum {
 				lineText := truncateLine(ptr.Value.(grepResultLine).LineText)
 				hunkStringBuilder.WriteString(lineText + "\n")
+				for _, col := range ptr.Value.(grepResultLine).FlagColumns[flag] {
+					if col, ok := clampColumns(col, ptr.Value.(grepResultLine).LineText); ok {
+						hunkOffsets = append(hunkOffsets, ld.OffsetRep{LineNumber: ptrLineNum, StartColumn: col.Start, EndColumn: col.End})
+					}
+				}
 				lastSeenLineNum = ptrLineNum
 				numHunkedLines += 1
 			}
 
-			if ptr.Next() != nil {
-				ptr = ptr.Next()
+			next := ptr.Next()
+			if next == nil || next.Value.(grepResultLine).LineNum != ptrLineNum+1 {
+				break
 			}
+			ptr = next
 		}
 
 		if appendToPreviousHunk {
 			previousHunk.Lines = hunkStringBuilder.String()
+			previousHunk.Offsets = hunkOffsets
 			appendToPreviousHunk = false
 		} else {
 			currentHunk.Lines = hunkStringBuilder.String()
+			currentHunk.Offsets = hunkOffsets
 			hunks = append(hunks, currentHunk)
 			previousHunk = &hunks[len(hunks)-1]
 		}
@@ -441,8 +1228,27 @@ func truncateLine(line string) string {
 	if len(line) > maxLineCharCount {
 		// convert to rune slice so that we don't truncate multibyte unicode characters
 		runes := []rune(line)
+		if len(runes) <= maxLineCharCount {
+			return line
+		}
 		return string(runes[0:maxLineCharCount]) + "…"
 	} else {
 		return line
 	}
 }
+
+// clampColumns limits a flag reference's columns to the part of line kept by truncateLine. It returns false if the
+// reference starts in the part which is removed, or its columns are invalid.
+func clampColumns(col columnRange, line string) (columnRange, bool) {
+	length := len(line)
+	if truncated := truncateLine(line); truncated != line {
+		length = len(strings.TrimSuffix(truncated, "…"))
+	}
+	if col.Start < 0 || col.Start >= length || col.End <= col.Start {
+		return col, false
+	}
+	if col.End > length {
+		col.End = length
+	}
+	return col, true
+}
@@ -1,6 +1,7 @@
 package coderefs
 
 import (
+	"flag"
 	"regexp"
 	"sort"
 	"strings"
@@ -8,7 +9,10 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
 	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
 )
 
 // Since our hunking algorithm uses some maps, resulting slice orders are not deterministic
@@ -18,6 +22,9 @@ type byStartingLineNumber []ld.HunkRep
 func (h byStartingLineNumber) Len() int      { return len(h) }
 func (h byStartingLineNumber) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
 func (h byStartingLineNumber) Less(i, j int) bool {
+	if h[i].StartingLineNumber == h[j].StartingLineNumber {
+		return h[i].FlagKey < h[j].FlagKey
+	}
 	return h[i].StartingLineNumber < h[j].StartingLineNumber
 }
 
@@ -38,7 +45,7 @@ func Test_generateReferencesFromGrep(t *testing.T) {
 			},
 			ctxLines: 0,
 			want: []grepResultLine{
-				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}},
+				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}, FlagColumns: map[string][]columnRange{"someFlag": {{0, 8}}}},
 			},
 		},
 		{
@@ -59,7 +66,7 @@ func Test_generateReferencesFromGrep(t *testing.T) {
 			},
 			ctxLines: -1,
 			want: []grepResultLine{
-				{Path: "flags.txt", LineNum: 12, FlagKeys: []string{"someFlag"}},
+				{Path: "flags.txt", LineNum: 12, FlagKeys: []string{"someFlag"}, FlagColumns: map[string][]columnRange{"someFlag": {{0, 8}}}},
 			},
 		},
 		{
@@ -71,8 +78,30 @@ func Test_generateReferencesFromGrep(t *testing.T) {
 			},
 			ctxLines: 0,
 			want: []grepResultLine{
-				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}},
-				{Path: "path/flags.txt", LineNum: 12, LineText: "someFlag anotherFlag", FlagKeys: []string{"someFlag", "anotherFlag"}},
+				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}, FlagColumns: map[string][]columnRange{"someFlag": {{0, 8}}}},
+				{Path: "path/flags.txt", LineNum: 12, LineText: "someFlag anotherFlag", FlagKeys: []string{"someFlag", "anotherFlag"}, FlagColumns: map[string][]columnRange{"someFlag": {{0, 8}}, "anotherFlag": {{9, 20}}}},
+			},
+		},
+		{
+			name:  "succeeds with CRLF line endings",
+			flags: []string{"someFlag", "anotherFlag"},
+			grepResult: [][]string{
+				{"", "flags.txt", ":", "12", "someFlag\r"},
+			},
+			ctxLines: 0,
+			want: []grepResultLine{
+				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}, FlagColumns: map[string][]columnRange{"someFlag": {{0, 8}}}},
+			},
+		},
+		{
+			name:  "ignores flag keys in longer identifiers",
+			flags: []string{"someFlag", "anotherFlag"},
+			grepResult: [][]string{
+				{"", "flags.js", ":", "12", "$someFlag"},
+			},
+			ctxLines: 0,
+			want: []grepResultLine{
+				{Path: "flags.js", LineNum: 12, LineText: "$someFlag"},
 			},
 		},
 		{
@@ -86,7 +115,7 @@ func Test_generateReferencesFromGrep(t *testing.T) {
 			ctxLines: 1,
 			want: []grepResultLine{
 				{Path: "flags.txt", LineNum: 11, LineText: "not a flag key line"},
-				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}},
+				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}, FlagColumns: map[string][]columnRange{"someFlag": {{0, 8}}}},
 				{Path: "flags.txt", LineNum: 13, LineText: "not a flag key line"},
 			},
 		},
@@ -103,9 +132,9 @@ func Test_generateReferencesFromGrep(t *testing.T) {
 			ctxLines: 1,
 			want: []grepResultLine{
 				{Path: "flags.txt", LineNum: 11, LineText: "not a flag key line"},
-				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}},
+				{Path: "flags.txt", LineNum: 12, LineText: "someFlag", FlagKeys: []string{"someFlag"}, FlagColumns: map[string][]columnRange{"someFlag": {{0, 8}}}},
 				{Path: "flags.txt", LineNum: 13, LineText: "not a flag key line"},
-				{Path: "flags.txt", LineNum: 14, LineText: "anotherFlag", FlagKeys: []string{"anotherFlag"}},
+				{Path: "flags.txt", LineNum: 14, LineText: "anotherFlag", FlagKeys: []string{"anotherFlag"}, FlagColumns: map[string][]columnRange{"anotherFlag": {{0, 11}}}},
 				{Path: "flags.txt", LineNum: 15, LineText: "not a flag key line"},
 			},
 		},
@@ -114,12 +143,32 @@ func Test_generateReferencesFromGrep(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			ex, err := regexp.Compile(tt.exclude)
 			require.NoError(t, err)
-			got := generateReferencesFromGrep(tt.flags, tt.grepResult, tt.ctxLines, ex)
+			got := generateReferencesFromGrep(tt.flags, tt.grepResult, tt.ctxLines, ex, nil)
 			require.Equal(t, tt.want, got)
 		})
 	}
 }
 
+func Test_generateReferencesFromGrep_isolatesFiles(t *testing.T) {
+	grepResult := [][]string{
+		{"", "a.txt", ":", "1", "someFlag"},
+		// A malformed result, which makes processing b.txt panic
+		{"", "b.txt", ":", "1", "someFlag"},
+		{"", "b.txt", ":"},
+		{"", "b.txt", ":", "3", "someFlag"},
+		{"", "c.txt", ":", "1", "someFlag"},
+	}
+	errs := &command.FileErrors{}
+	got := generateReferencesFromGrep([]string{"someFlag"}, grepResult, 0, nil, errs)
+	require.Len(t, got, 2)
+	require.Equal(t, "a.txt", got[0].Path)
+	require.Equal(t, "c.txt", got[1].Path)
+	fileErrs := errs.List()
+	require.Len(t, fileErrs, 1)
+	require.Equal(t, "b.txt", fileErrs[0].Path)
+	require.Contains(t, fileErrs[0].Reason, "panic: runtime error: index out of range")
+}
+
 func Test_findReferencedFlags(t *testing.T) {
 	tests := []struct {
 		name string
@@ -141,10 +190,30 @@ func Test_findReferencedFlags(t *testing.T) {
 			ref:  "line contains no flags",
 			want: []string{},
 		},
+		{
+			name: "ignores flags in longer identifiers",
+			ref:  "line contains someFlagV2 and $anotherFlag",
+			want: []string{},
+		},
+		{
+			name: "finds flags delimited by punctuation",
+			ref:  "variation('someFlag-v2', anotherFlag.key)",
+			want: []string{"someFlag", "anotherFlag"},
+		},
+		{
+			name: "finds a flag after an occurrence in a longer identifier",
+			ref:  "mySomeFlag = someFlag",
+			want: []string{"someFlag"},
+		},
+		{
+			name: "attributes nested keys to the longest flag",
+			ref:  "line contains someFlag-beta",
+			want: []string{"someFlag-beta"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := findReferencedFlags(tt.ref, []string{"someFlag", "anotherFlag"})
+			got := findReferencedFlags(tt.ref, []string{"someFlag", "anotherFlag", "someFlag-beta"})
 			require.Equal(t, tt.want, got)
 		})
 	}
@@ -244,7 +313,7 @@ func Test_makeReferenceHunksReps(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := tt.refs.makeReferenceHunksReps(projKey, 1)
+			got := tt.refs.makeReferenceHunksReps(projKey, 1, nil)
 
 			require.Equal(t, tt.want, got)
 		})
@@ -336,6 +405,45 @@ func Test_makeHunkReps(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "multiple references, single flag, one hunk with offsets",
+			ctxLines: 1,
+			refs: grepResultLines{
+				grepResultLine{
+					Path:        "a/b",
+					LineNum:     5,
+					LineText:    "flag-1 || flag-1",
+					FlagKeys:    []string{"flag-1"},
+					FlagColumns: map[string][]columnRange{"flag-1": {{0, 6}, {10, 16}}},
+				},
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  6,
+					LineText: "context inner",
+					FlagKeys: []string{},
+				},
+				grepResultLine{
+					Path:        "a/b",
+					LineNum:     7,
+					LineText:    "  flag-1",
+					FlagKeys:    []string{"flag-1"},
+					FlagColumns: map[string][]columnRange{"flag-1": {{2, 8}}},
+				},
+			},
+			want: []ld.HunkRep{
+				ld.HunkRep{
+					StartingLineNumber: 5,
+					Lines:              "flag-1 || flag-1\ncontext inner\n  flag-1\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-1",
+					Offsets: []ld.OffsetRep{
+						{LineNumber: 5, StartColumn: 0, EndColumn: 6},
+						{LineNumber: 5, StartColumn: 10, EndColumn: 16},
+						{LineNumber: 7, StartColumn: 2, EndColumn: 8},
+					},
+				},
+			},
+		},
 		{
 			name:     "multiple references, multiple context lines, single flag, one hunk",
 			ctxLines: 2,
@@ -486,6 +594,47 @@ func Test_makeHunkReps(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "multiple flags on the same line, one hunk per flag with the same lines",
+			ctxLines: 1,
+			refs: grepResultLines{
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  5,
+					LineText: "context -1",
+					FlagKeys: []string{},
+				},
+				grepResultLine{
+					Path:        "a/b",
+					LineNum:     6,
+					LineText:    "flag-1 && flag-2",
+					FlagKeys:    []string{"flag-1", "flag-2"},
+					FlagColumns: map[string][]columnRange{"flag-1": {{0, 6}}, "flag-2": {{10, 16}}},
+				},
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  7,
+					LineText: "context +1",
+					FlagKeys: []string{},
+				},
+			},
+			want: []ld.HunkRep{
+				ld.HunkRep{
+					StartingLineNumber: 5,
+					Lines:              "context -1\nflag-1 && flag-2\ncontext +1\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-1",
+					Offsets:            []ld.OffsetRep{{LineNumber: 6, StartColumn: 0, EndColumn: 6}},
+				},
+				ld.HunkRep{
+					StartingLineNumber: 5,
+					Lines:              "context -1\nflag-1 && flag-2\ncontext +1\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-2",
+					Offsets:            []ld.OffsetRep{{LineNumber: 6, StartColumn: 10, EndColumn: 16}},
+				},
+			},
+		},
 		{
 			name:     "multiple consecutive (non overlapping) references, multiple flags, multiple hunks",
 			ctxLines: 1,
@@ -749,6 +898,128 @@ func Test_makeHunkReps(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "reference on the first line with more context lines than available",
+			ctxLines: 3,
+			refs: grepResultLines{
+				grepResultLine{
+					Path:        "a/b",
+					LineNum:     1,
+					LineText:    "flag-1",
+					FlagKeys:    []string{"flag-1"},
+					FlagColumns: map[string][]columnRange{"flag-1": {{0, 6}}},
+				},
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  2,
+					LineText: "context+1",
+					FlagKeys: []string{},
+				},
+			},
+			want: []ld.HunkRep{
+				ld.HunkRep{
+					StartingLineNumber: 1,
+					Lines:              "flag-1\ncontext+1\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-1",
+					Offsets:            []ld.OffsetRep{{LineNumber: 1, StartColumn: 0, EndColumn: 6}},
+				},
+			},
+		},
+		{
+			name:     "reference on the last line with more context lines than available",
+			ctxLines: 3,
+			refs: grepResultLines{
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  7,
+					LineText: "context-1",
+					FlagKeys: []string{},
+				},
+				grepResultLine{
+					Path:        "a/b",
+					LineNum:     8,
+					LineText:    "  flag-1",
+					FlagKeys:    []string{"flag-1"},
+					FlagColumns: map[string][]columnRange{"flag-1": {{2, 8}}},
+				},
+			},
+			want: []ld.HunkRep{
+				ld.HunkRep{
+					StartingLineNumber: 7,
+					Lines:              "context-1\n  flag-1\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-1",
+					Offsets:            []ld.OffsetRep{{LineNumber: 8, StartColumn: 2, EndColumn: 8}},
+				},
+			},
+		},
+		{
+			name:     "references separated by lines outside context",
+			ctxLines: 2,
+			refs: grepResultLines{
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  1,
+					LineText: "flag-1",
+					FlagKeys: []string{"flag-1"},
+				},
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  2,
+					LineText: "context+1",
+					FlagKeys: []string{},
+				},
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  20,
+					LineText: "context-1",
+					FlagKeys: []string{},
+				},
+				grepResultLine{
+					Path:     "a/b",
+					LineNum:  21,
+					LineText: "flag-1",
+					FlagKeys: []string{"flag-1"},
+				},
+			},
+			want: []ld.HunkRep{
+				ld.HunkRep{
+					StartingLineNumber: 1,
+					Lines:              "flag-1\ncontext+1\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-1",
+				},
+				ld.HunkRep{
+					StartingLineNumber: 20,
+					Lines:              "context-1\nflag-1\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-1",
+				},
+			},
+		},
+		{
+			name:     "offsets beyond a truncated line",
+			ctxLines: 0,
+			refs: grepResultLines{
+				grepResultLine{
+					Path:        "a/b",
+					LineNum:     1,
+					LineText:    strings.Repeat("a", maxLineCharCount-2) + "flag-1 flag-1",
+					FlagKeys:    []string{"flag-1"},
+					FlagColumns: map[string][]columnRange{"flag-1": {{maxLineCharCount - 2, maxLineCharCount + 4}, {maxLineCharCount + 5, maxLineCharCount + 11}}},
+				},
+			},
+			want: []ld.HunkRep{
+				ld.HunkRep{
+					StartingLineNumber: 1,
+					Lines:              strings.Repeat("a", maxLineCharCount-2) + "fl…\n",
+					ProjKey:            projKey,
+					FlagKey:            "flag-1",
+					Offsets:            []ld.OffsetRep{{LineNumber: 1, StartColumn: maxLineCharCount - 2, EndColumn: maxLineCharCount}},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -834,6 +1105,24 @@ func Test_groupIntoPathMap(t *testing.T) {
 	require.Equal(t, bLines.Back().Value, grepResultPathBLine2)
 }
 
+func Test_projKeys(t *testing.T) {
+	require.Equal(t, []string{"default"}, projKeys("default"))
+	require.Equal(t, []string{"proj-1", "proj-2"}, projKeys("proj-1, proj-2,"))
+}
+
+func Test_assignProjects(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{{Path: "a", Hunks: []ld.HunkRep{
+		{FlagKey: "flag-1", StartingLineNumber: 1},
+		{FlagKey: "shared-flag", StartingLineNumber: 5},
+	}}}
+	flagProjects := map[string][]string{"flag-1": {"proj-1"}, "shared-flag": {"proj-1", "proj-2"}}
+	require.Equal(t, []ld.ReferenceHunksRep{{Path: "a", Hunks: []ld.HunkRep{
+		{ProjKey: "proj-1", FlagKey: "flag-1", StartingLineNumber: 1},
+		{ProjKey: "proj-1", FlagKey: "shared-flag", StartingLineNumber: 5},
+		{ProjKey: "proj-2", FlagKey: "shared-flag", StartingLineNumber: 5},
+	}}}, assignProjects(refs, flagProjects))
+}
+
 func Test_filterShortFlags(t *testing.T) {
 	// Note: these specs assume minFlagKeyLen is 3
 	tests := []struct {
@@ -901,6 +1190,11 @@ func Test_truncateLine(t *testing.T) {
 			line: veryLongLine,
 			want: veryLongLine[0:maxLineCharCount] + "…",
 		},
+		{
+			name: "multibyte line longer than max length in bytes only",
+			line: strings.Repeat("é", maxLineCharCount),
+			want: strings.Repeat("é", maxLineCharCount),
+		},
 	}
 
 	for _, tt := range tests {
@@ -910,3 +1204,23 @@ func Test_truncateLine(t *testing.T) {
 		})
 	}
 }
+
+// populateOptions registers options with their default values, if they haven't been already.
+func populateOptions() {
+	if flag.Lookup(string(o.Dir)) == nil {
+		o.Populate()
+	}
+}
+
+func Test_updateSequenceId(t *testing.T) {
+	log.Init(false)
+	populateOptions()
+
+	require.Nil(t, updateSequenceId(0, true))
+	complete, partial := updateSequenceId(1556668800, true), updateSequenceId(1556668800, false)
+	require.Equal(t, int64(1556668800001), *complete)
+	require.Equal(t, int64(1556668800000), *partial)
+	// A complete scan replaces a partial scan of the same commit, and any scan of a newer commit replaces both
+	require.True(t, *complete > *partial)
+	require.True(t, *updateSequenceId(1556668801, false) > *complete)
+}
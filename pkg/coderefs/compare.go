@@ -0,0 +1,324 @@
+package coderefs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// comparison is the change in references to each flag between two sets of code references.
+type comparison struct {
+	Base  string      `json:"base"`
+	Head  string      `json:"head"`
+	Flags []flagDelta `json:"flags"`
+}
+
+// flagDelta is the change in references to a flag between base and head.
+type flagDelta struct {
+	FlagKey            string `json:"flagKey"`
+	BaseReferenceCount int    `json:"baseReferenceCount"`
+	HeadReferenceCount int    `json:"headReferenceCount"`
+	// AddedFiles reference the flag in head, but not in base.
+	AddedFiles []string `json:"addedFiles,omitempty"`
+	// RemovedFiles reference the flag in base, but not in head.
+	RemovedFiles []string `json:"removedFiles,omitempty"`
+	// MovedHunks is the number of hunks in head which are unchanged from base, but start on a different line.
+	MovedHunks int `json:"movedHunks,omitempty"`
+}
+
+// CompareFiles prints the change in references to each flag between two results files, and writes it to outFile as
+// JSON, if provided.
+func CompareFiles(w io.Writer, base, head, outFile string) error {
+	baseRefs, _, err := readComparedFile(base)
+	if err != nil {
+		return err
+	}
+	headRefs, _, err := readComparedFile(head)
+	if err != nil {
+		return err
+	}
+	return writeComparison(w, comparison{Base: base, Head: head, Flags: compareReferences(baseRefs, headRefs)}, outFile)
+}
+
+// Compare prints the change in references to each flag between base and head, which are each either a results file,
+// or a git revision which is scanned in a temporary worktree, and writes it to the path provided by the outFile
+// option as JSON. Code references are not sent to LaunchDarkly.
+func Compare(base, head string) {
+	defer command.RemoveRunDir()
+	var cmd command.Client
+	var flags []string
+	var exclude *regexp.Regexp
+	load := func(arg string) ([]ld.ReferenceHunksRep, string) {
+		if IsComparedFile(arg) {
+			refs, sha, err := readComparedFile(arg)
+			if err != nil {
+				log.Error.Fatalf("%s", err)
+			}
+			return refs, sha
+		}
+		if flags == nil {
+			cmd, flags, exclude = newRevisionScanner()
+		}
+		refs, worktree, err := scanRevision(cmd, arg, flags, exclude)
+		if err != nil {
+			log.Error.Fatalf("could not scan %s: %s", arg, err)
+		}
+		return refs, worktree.GitSha
+	}
+
+	baseRefs, baseSha := load(base)
+	headRefs, headSha := load(head)
+	// At least one side is a revision, so cmd has been initialized
+	c := comparison{Base: base, Head: head, Flags: compareCommits(cmd, baseRefs, baseSha, headRefs, headSha)}
+	err := writeComparison(os.Stdout, c, o.OutFile.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+}
+
+// CompareCommit prints the change in references to each flag made by the commit rev, compared with its parents, and
+// writes it to the path provided by the outFile option as JSON. Merge commits are compared with their first parent,
+// unless the diffStrategy option is allParents, in which case only changes compared with every parent are reported,
+// i.e. changes made by the merge itself, rather than by the branches it merged. Code references are not sent to
+// LaunchDarkly.
+func CompareCommit(rev string) {
+	defer command.RemoveRunDir()
+	cmd, flags, exclude := newRevisionScanner()
+	headRefs, worktree, err := scanRevision(cmd, rev, flags, exclude)
+	if err != nil {
+		log.Error.Fatalf("could not scan %s: %s", rev, err)
+	}
+	parents, err := cmd.Parents(worktree.GitSha)
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	parents = comparedParents(parents, o.DiffStrategy.Value())
+
+	// A root commit is compared with an empty repository
+	deltas := [][]flagDelta{}
+	if len(parents) == 0 {
+		deltas = append(deltas, compareReferences(nil, headRefs))
+	}
+	for _, parent := range parents {
+		parentRefs, _, err := scanRevision(cmd, parent, flags, exclude)
+		if err != nil {
+			log.Error.Fatalf("could not scan %s: %s", parent, err)
+		}
+		deltas = append(deltas, compareCommits(cmd, parentRefs, parent, headRefs, worktree.GitSha))
+	}
+	c := comparison{Base: strings.Join(parents, ","), Head: rev, Flags: commonDeltas(deltas)}
+	err = writeComparison(os.Stdout, c, o.OutFile.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+}
+
+// comparedParents returns the parents a commit is compared with, according to the diffStrategy option.
+func comparedParents(parents []string, diffStrategy string) []string {
+	if diffStrategy == o.DiffStrategyFirstParent && len(parents) > 1 {
+		return parents[:1]
+	}
+	return parents
+}
+
+// compareCommits returns the change in references to each flag between the commits base and head, following files
+// renamed between them. If either commit is unknown, renames aren't followed.
+func compareCommits(cmd command.Client, baseRefs []ld.ReferenceHunksRep, baseSha string, headRefs []ld.ReferenceHunksRep, headSha string) []flagDelta {
+	if baseSha != "" && headSha != "" {
+		renames, err := cmd.Renames(baseSha, headSha)
+		if err != nil {
+			log.Warning.Printf("%s. References in renamed files will be reported as removed and added", err)
+		} else {
+			baseRefs = renameReferences(baseRefs, renames)
+		}
+	}
+	return compareReferences(baseRefs, headRefs)
+}
+
+// commonDeltas returns the changes to each flag found in every one of deltas, which compare the same commit with
+// each of its parents. Reference counts are those compared with the first parent.
+func commonDeltas(deltas [][]flagDelta) []flagDelta {
+	if len(deltas) == 1 {
+		return deltas[0]
+	}
+	ret := []flagDelta{}
+	for _, d := range deltas[0] {
+		common := true
+		for _, parentDeltas := range deltas[1:] {
+			match, ok := findDelta(parentDeltas, d.FlagKey)
+			if !ok {
+				common = false
+				break
+			}
+			d.AddedFiles = intersectFiles(d.AddedFiles, match.AddedFiles)
+			d.RemovedFiles = intersectFiles(d.RemovedFiles, match.RemovedFiles)
+		}
+		if common {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+func findDelta(deltas []flagDelta, flagKey string) (flagDelta, bool) {
+	for _, d := range deltas {
+		if d.FlagKey == flagKey {
+			return d, true
+		}
+	}
+	return flagDelta{}, false
+}
+
+// intersectFiles returns the files in both a and b, in the order of a.
+func intersectFiles(a, b []string) []string {
+	var ret []string
+	for _, path := range a {
+		for _, other := range b {
+			if path == other {
+				ret = append(ret, path)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// IsComparedFile returns true if path, an argument of the compare command, is a results file rather than a revision.
+func IsComparedFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// readComparedFile returns the code references in a results file, and the commit they were found at, if known.
+func readComparedFile(path string) ([]ld.ReferenceHunksRep, string, error) {
+	f, err := readResultsFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read code references from %s: %s", path, err)
+	}
+	return f.References, f.Head, nil
+}
+
+// renameReferences moves references in files renamed between base and head to the files' paths in head, so the
+// references aren't reported as removed from one file, and added to another.
+func renameReferences(refs []ld.ReferenceHunksRep, renames map[string]string) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, len(refs))
+	for i, ref := range refs {
+		if path, ok := renames[ref.Path]; ok {
+			ref.Path = path
+		}
+		ret[i] = ref
+	}
+	return ret
+}
+
+func writeComparison(w io.Writer, c comparison, outFile string) error {
+	printFlagDeltas(w, c.Flags)
+	if outFile == "" {
+		return nil
+	}
+	err := writeJsonOutput(outFile, c)
+	if err != nil {
+		return fmt.Errorf("error writing comparison to %s: %s", outFile, err)
+	}
+	return nil
+}
+
+// compareReferences returns the change in references to each flag whose references changed between base and head,
+// sorted by flag key.
+func compareReferences(base, head []ld.ReferenceHunksRep) []flagDelta {
+	baseCounts, baseFiles := countByFlag(base)
+	headCounts, headFiles := countByFlag(head)
+	moved := movedHunks(base, head)
+	flagKeys := map[string]bool{}
+	for flagKey := range baseCounts {
+		flagKeys[flagKey] = true
+	}
+	for flagKey := range headCounts {
+		flagKeys[flagKey] = true
+	}
+
+	deltas := []flagDelta{}
+	for flagKey := range flagKeys {
+		d := flagDelta{
+			FlagKey:            flagKey,
+			BaseReferenceCount: baseCounts[flagKey],
+			HeadReferenceCount: headCounts[flagKey],
+			AddedFiles:         fileDifference(headFiles[flagKey], baseFiles[flagKey]),
+			RemovedFiles:       fileDifference(baseFiles[flagKey], headFiles[flagKey]),
+			MovedHunks:         moved[flagKey],
+		}
+		if d.BaseReferenceCount != d.HeadReferenceCount || len(d.AddedFiles) > 0 || len(d.RemovedFiles) > 0 || d.MovedHunks > 0 {
+			deltas = append(deltas, d)
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].FlagKey < deltas[j].FlagKey
+	})
+	return deltas
+}
+
+// countByFlag returns the number of references to each flag, and the files referencing each flag.
+func countByFlag(refs []ld.ReferenceHunksRep) (map[string]int, map[string]map[string]bool) {
+	counts := map[string]int{}
+	files := map[string]map[string]bool{}
+	for _, ref := range refs {
+		for _, hunk := range ref.Hunks {
+			counts[hunk.FlagKey] += hunk.ReferenceCount()
+			if files[hunk.FlagKey] == nil {
+				files[hunk.FlagKey] = map[string]bool{}
+			}
+			files[hunk.FlagKey][ref.Path] = true
+		}
+	}
+	return counts, files
+}
+
+// fileDifference returns the files in a but not b, sorted.
+func fileDifference(a, b map[string]bool) []string {
+	var ret []string
+	for path := range a {
+		if !b[path] {
+			ret = append(ret, path)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// printFlagDeltas writes a table of the change in references to each flag.
+func printFlagDeltas(w io.Writer, deltas []flagDelta) {
+	if len(deltas) == 0 {
+		fmt.Fprintln(w, "No flag references were added, removed, or moved.")
+		return
+	}
+	data := [][]string{}
+	for _, d := range deltas {
+		change := strconv.Itoa(d.HeadReferenceCount - d.BaseReferenceCount)
+		if d.HeadReferenceCount > d.BaseReferenceCount {
+			change = "+" + change
+		}
+		data = append(data, []string{d.FlagKey, strconv.Itoa(d.BaseReferenceCount), strconv.Itoa(d.HeadReferenceCount), change, fmt.Sprintf("+%d -%d", len(d.AddedFiles), len(d.RemovedFiles)), strconv.Itoa(d.MovedHunks)})
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Flag", "Base", "Head", "Change", "Files", "Moved"})
+	table.SetBorder(false)
+	if log.Colors(w) {
+		bold := tablewriter.Colors{tablewriter.Bold}
+		table.SetHeaderColor(bold, bold, bold, bold, bold, bold)
+	}
+	table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT})
+	table.SetAutoWrapText(false)
+	table.AppendBulk(data)
+	table.Render()
+}
@@ -0,0 +1,88 @@
+package coderefs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+func Test_compareReferences(t *testing.T) {
+	base := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}, {FlagKey: "flag-2"}}},
+		{Path: "b.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Offsets: []ld.OffsetRep{{LineNumber: 1}, {LineNumber: 2}}}}},
+		{Path: "c.go", Hunks: []ld.HunkRep{{FlagKey: "flag-3"}}},
+	}
+	head := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-2"}, {FlagKey: "flag-4"}}},
+		{Path: "c.go", Hunks: []ld.HunkRep{{FlagKey: "flag-3"}}},
+		{Path: "d.go", Hunks: []ld.HunkRep{{FlagKey: "flag-4"}}},
+	}
+	require.Equal(t, []flagDelta{
+		{FlagKey: "flag-1", BaseReferenceCount: 3, HeadReferenceCount: 0, RemovedFiles: []string{"a.go", "b.go"}},
+		{FlagKey: "flag-4", BaseReferenceCount: 0, HeadReferenceCount: 2, AddedFiles: []string{"a.go", "d.go"}},
+	}, compareReferences(base, head))
+	require.Empty(t, compareReferences(base, base))
+
+	// References which move between files are reported, even if the number of references is unchanged
+	moved := []ld.ReferenceHunksRep{{Path: "e.go", Hunks: []ld.HunkRep{{FlagKey: "flag-3"}}}}
+	require.Equal(t, []flagDelta{
+		{FlagKey: "flag-3", BaseReferenceCount: 1, HeadReferenceCount: 1, AddedFiles: []string{"e.go"}, RemovedFiles: []string{"c.go"}},
+	}, compareReferences(base[2:], moved))
+
+	// Unless the file was renamed
+	require.Empty(t, compareReferences(renameReferences(base[2:], map[string]string{"c.go": "e.go"}), moved))
+	require.Equal(t, "c.go", base[2].Path)
+}
+
+func Test_printFlagDeltas(t *testing.T) {
+	var buf bytes.Buffer
+	printFlagDeltas(&buf, []flagDelta{
+		{FlagKey: "flag-1", BaseReferenceCount: 3, RemovedFiles: []string{"a.go", "b.go"}},
+		{FlagKey: "flag-4", HeadReferenceCount: 2, AddedFiles: []string{"a.go"}},
+		{FlagKey: "flag-5", BaseReferenceCount: 1, HeadReferenceCount: 1, MovedHunks: 1},
+	})
+	require.Contains(t, buf.String(), "flag-1 |    3 |    0 |     -3 | +0 -2 |     0")
+	require.Contains(t, buf.String(), "flag-4 |    0 |    2 |     +2 | +1 -0 |     0")
+	require.Contains(t, buf.String(), "flag-5 |    1 |    1 |      0 | +0 -0 |     1")
+
+	buf.Reset()
+	printFlagDeltas(&buf, nil)
+	require.Equal(t, "No flag references were added, removed, or moved.\n", buf.String())
+}
+
+func Test_commonDeltas(t *testing.T) {
+	head := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+		{Path: "b.go", Hunks: []ld.HunkRep{{FlagKey: "flag-2"}}},
+		{Path: "merge.go", Hunks: []ld.HunkRep{{FlagKey: "flag-3"}}},
+	}
+	// An octopus merge of three branches, each adding a reference, and a reference added by the merge itself
+	parents := [][]ld.ReferenceHunksRep{
+		{{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}}},
+		{{Path: "b.go", Hunks: []ld.HunkRep{{FlagKey: "flag-2"}}}},
+		{},
+	}
+	deltas := [][]flagDelta{}
+	for _, p := range parents {
+		deltas = append(deltas, compareReferences(p, head))
+	}
+
+	require.Equal(t, []flagDelta{
+		{FlagKey: "flag-2", BaseReferenceCount: 0, HeadReferenceCount: 1, AddedFiles: []string{"b.go"}},
+		{FlagKey: "flag-3", BaseReferenceCount: 0, HeadReferenceCount: 1, AddedFiles: []string{"merge.go"}},
+	}, commonDeltas(deltas[:1]))
+	require.Equal(t, []flagDelta{
+		{FlagKey: "flag-3", BaseReferenceCount: 0, HeadReferenceCount: 1, AddedFiles: []string{"merge.go"}},
+	}, commonDeltas(deltas))
+}
+
+func Test_comparedParents(t *testing.T) {
+	require.Equal(t, []string{"a"}, comparedParents([]string{"a", "b", "c"}, o.DiffStrategyFirstParent))
+	require.Equal(t, []string{"a", "b", "c"}, comparedParents([]string{"a", "b", "c"}, o.DiffStrategyAllParents))
+	require.Equal(t, []string{"a"}, comparedParents([]string{"a"}, o.DiffStrategyAllParents))
+	require.Empty(t, comparedParents(nil, o.DiffStrategyFirstParent))
+}
@@ -0,0 +1,118 @@
+package coderefs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// maxConflictWarnings limits the number of flag key conflicts logged individually.
+const maxConflictWarnings = 10
+
+// flagConflict is a flag key which is part of a longer flag key, so occurrences of the longer key also contain it.
+type flagConflict struct {
+	Key    string
+	Longer string
+}
+
+// findFlagConflicts returns each pair of flag keys where one key is part of the other, sorted by key.
+func findFlagConflicts(flags []string) []flagConflict {
+	sorted := append([]string{}, flags...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) < len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	conflicts := []flagConflict{}
+	for i, key := range sorted {
+		for _, longer := range sorted[i+1:] {
+			if len(longer) > len(key) && strings.Contains(longer, key) {
+				conflicts = append(conflicts, flagConflict{Key: key, Longer: longer})
+			}
+		}
+	}
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return conflicts[i].Key < conflicts[j].Key
+	})
+	return conflicts
+}
+
+// warnFlagConflicts logs flag keys which are part of longer flag keys, and flag keys which exist in more than one
+// project, since their references may not be attributed to the flag a developer intended.
+func warnFlagConflicts(flags []string, flagProjects map[string][]string) {
+	conflicts := findFlagConflicts(flags)
+	for i, c := range conflicts {
+		if i == maxConflictWarnings {
+			log.Warning.Printf("%d more flag keys are part of longer flag keys. Enable debug to list them", len(conflicts)-maxConflictWarnings)
+		}
+		logger := log.Warning
+		if i >= maxConflictWarnings {
+			logger = log.Debug
+		}
+		logger.Printf("flag key %q is part of flag key %q: occurrences of %q are only attributed to %q", c.Key, c.Longer, c.Longer, c.Longer)
+	}
+
+	duplicates := []string{}
+	for flag, projects := range flagProjects {
+		if len(projects) > 1 {
+			duplicates = append(duplicates, flag)
+		}
+	}
+	sort.Strings(duplicates)
+	for _, flag := range duplicates {
+		log.Info.Printf("flag key %q exists in projects %s: its references are attributed to each project", flag, strings.Join(flagProjects[flag], ", "))
+	}
+}
+
+// flagOccurrences returns the columns of every occurrence of each flag key in a line which is not part of a longer
+// identifier, like the word boundaries of the search pattern. When flag keys overlap, the longest match wins:
+// occurrences of a key within an occurrence of a longer key are attributed to the longer key.
+func flagOccurrences(line string, flags []string) map[string][]columnRange {
+	all := map[string][]columnRange{}
+	for _, flag := range flags {
+		if flag == "" {
+			continue
+		}
+		offset := 0
+		for {
+			idx := strings.Index(line[offset:], flag)
+			if idx < 0 {
+				break
+			}
+			start := offset + idx
+			if !isBoundedMatch(line, start, start+len(flag)) {
+				offset = start + 1
+				continue
+			}
+			all[flag] = append(all[flag], columnRange{Start: start, End: start + len(flag)})
+			offset = start + len(flag)
+		}
+	}
+
+	ret := map[string][]columnRange{}
+	for flag, ranges := range all {
+		for _, r := range ranges {
+			if !withinLongerFlag(all, flag, r) {
+				ret[flag] = append(ret[flag], r)
+			}
+		}
+	}
+	return ret
+}
+
+// withinLongerFlag returns true if r, an occurrence of flag, is within an occurrence of a longer flag key.
+func withinLongerFlag(occurrences map[string][]columnRange, flag string, r columnRange) bool {
+	for other, ranges := range occurrences {
+		if len(other) <= len(flag) {
+			continue
+		}
+		for _, o := range ranges {
+			if o.Start <= r.Start && r.End <= o.End {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_findFlagConflicts(t *testing.T) {
+	require.Equal(t, []flagConflict{
+		{Key: "beta", Longer: "beta-ui"},
+		{Key: "beta", Longer: "new-beta-ui"},
+		{Key: "beta-ui", Longer: "new-beta-ui"},
+	}, findFlagConflicts([]string{"new-beta-ui", "beta", "checkout", "beta-ui"}))
+	require.Empty(t, findFlagConflicts([]string{"flag-1", "flag-2"}))
+}
+
+func Test_flagOccurrences(t *testing.T) {
+	flags := []string{"beta", "beta-ui", "new-beta-ui"}
+	require.Equal(t, map[string][]columnRange{
+		"new-beta-ui": {{Start: 0, End: 11}},
+		"beta-ui":     {{Start: 12, End: 19}},
+		"beta":        {{Start: 20, End: 24}},
+	}, flagOccurrences("new-beta-ui beta-ui beta", flags))
+
+	// The longest match wins, so the shorter keys aren't referenced
+	require.Equal(t, []string{"new-beta-ui"}, findReferencedFlags("if (flags['new-beta-ui'])", flags))
+	require.Equal(t, []string{"beta", "new-beta-ui"}, findReferencedFlags("new-beta-ui || beta", flags))
+}
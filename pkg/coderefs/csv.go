@@ -0,0 +1,40 @@
+package coderefs
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+var csvHeader = []string{"flagKey", "projKey", "path", "startingLineNumber", "referenceCount", "testCode", "url", "score", "sharedFlags", "metadata"}
+
+// writeCsv writes a row for each hunk, with the highest scores first, for spreadsheets and other reporting tools.
+func writeCsv(w io.Writer, refs []ld.ReferenceHunksRep) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, hunk := range hunksByScore(refs) {
+		err = cw.Write([]string{
+			hunk.FlagKey,
+			hunk.ProjKey,
+			hunk.Path,
+			strconv.Itoa(hunk.StartingLineNumber),
+			strconv.Itoa(hunk.ReferenceCount()),
+			strconv.FormatBool(hunk.TestCode),
+			hunk.Url,
+			strconv.Itoa(hunk.Score),
+			strings.Join(hunk.SharedFlags, " "),
+			formatHunkMetadata(hunk.Metadata),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,142 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// patchBranch sends only the references for files that have changed since the branch was last sent, if the
+// previous references can be retrieved. If cached is not nil, it is used as the previous references instead of
+// retrieving them from LaunchDarkly. sent is false if the full branch should be sent instead, and conflict is
+// true if the update was rejected because of its updateSequenceId.
+func patchBranch(ldApi ld.ApiClient, branchRep ld.BranchRep, repoName string, cached *ld.BranchRep) (sent, conflict bool) {
+	prev := cached
+	if prev == nil {
+		var err error
+		prev, err = ldApi.GetCodeReferenceBranch(repoName, branchRep.Name)
+		if err != nil {
+			if err != ld.NotFoundErr {
+				log.Warning.Printf("could not retrieve previous code references for branch %s, sending all code references: %s", branchRep.Name, err)
+			}
+			return false, false
+		}
+	}
+	if len(prev.References) == 0 {
+		return false, false
+	}
+
+	patch, changed, removed := branchPatch(*prev, branchRep)
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return false, false
+	}
+	branchBytes, err := json.Marshal(branchRep)
+	if err != nil || len(patchBytes) >= len(branchBytes) {
+		return false, false
+	}
+
+	moved := 0
+	for _, n := range movedHunks(prev.References, branchRep.References) {
+		moved += n
+	}
+	log.Info.Printf("sending code references for %d changed files, in which %d hunks only moved to other lines, removing %d files, and leaving %d files unchanged", changed, moved, removed, len(prev.References)-changed-removed)
+	err = ldApi.PatchCodeReferenceBranch(repoName, branchRep.Name, patch)
+	if err == ld.BranchUpdateSequenceIdConflictErr && branchRep.UpdateSequenceId != nil {
+		// Sending all code references would conflict too
+		log.Warning.Printf("updateSequenceId (%d) must be greater than previously submitted updateSequenceId", *branchRep.UpdateSequenceId)
+		return true, true
+	}
+	if err != nil {
+		log.Warning.Printf("could not send changed code references, sending all code references: %s", err)
+		return false, false
+	}
+	return true, false
+}
+
+// branchPatch returns a JSON patch that updates prev to next, replacing only the references for files whose
+// hunks have changed. The patch tests that the head of the branch hasn't changed since prev was retrieved,
+// so it fails rather than corrupting the branch if another update was sent in the meantime.
+func branchPatch(prev, next ld.BranchRep) (patch []ld.PatchOperation, changed, removed int) {
+	patch = []ld.PatchOperation{
+		{Op: "test", Path: "/head", Value: prev.Head},
+		{Op: "add", Path: "/head", Value: next.Head},
+		{Op: "add", Path: "/syncTime", Value: next.SyncTime},
+		{Op: "add", Path: "/isDefault", Value: next.IsDefault},
+	}
+	if next.UpdateSequenceId != nil {
+		patch = append(patch, ld.PatchOperation{Op: "add", Path: "/updateSequenceId", Value: *next.UpdateSequenceId})
+	}
+	if next.CommitTime != 0 {
+		patch = append(patch, ld.PatchOperation{Op: "add", Path: "/commitTime", Value: next.CommitTime})
+	}
+	if next.Metadata != nil {
+		patch = append(patch, ld.PatchOperation{Op: "add", Path: "/metadata", Value: next.Metadata})
+	}
+	if len(next.Samples) > 0 || len(prev.Samples) > 0 {
+		samples := next.Samples
+		if samples == nil {
+			samples = []ld.SampledFlagRep{}
+		}
+		patch = append(patch, ld.PatchOperation{Op: "add", Path: "/samples", Value: samples})
+	}
+
+	nextRefs := make(map[string]ld.ReferenceHunksRep, len(next.References))
+	for _, ref := range next.References {
+		nextRefs[ref.Path] = ref
+	}
+
+	prevPaths := make(map[string]bool, len(prev.References))
+	removals := []int{}
+	for i, ref := range prev.References {
+		prevPaths[ref.Path] = true
+		nextRef, ok := nextRefs[ref.Path]
+		if !ok {
+			removals = append(removals, i)
+			continue
+		}
+		if !sameHunks(ref.Hunks, nextRef.Hunks) {
+			patch = append(patch, ld.PatchOperation{Op: "replace", Path: fmt.Sprintf("/references/%d", i), Value: nextRef})
+			changed++
+		}
+	}
+	// Remove from the end of the list, so the indexes of earlier references aren't affected
+	for i := len(removals) - 1; i >= 0; i-- {
+		patch = append(patch, ld.PatchOperation{Op: "remove", Path: fmt.Sprintf("/references/%d", removals[i])})
+	}
+	removed = len(removals)
+
+	for _, ref := range next.References {
+		if !prevPaths[ref.Path] {
+			patch = append(patch, ld.PatchOperation{Op: "add", Path: "/references/-", Value: ref})
+			changed++
+		}
+	}
+	return patch, changed, removed
+}
+
+// sameHunks returns true if two lists of hunks are equal, regardless of order.
+func sameHunks(a, b []ld.HunkRep) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return reflect.DeepEqual(sortedHunks(a), sortedHunks(b))
+}
+
+func sortedHunks(hunks []ld.HunkRep) []ld.HunkRep {
+	ret := append([]ld.HunkRep{}, hunks...)
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].FlagKey != ret[j].FlagKey {
+			return ret[i].FlagKey < ret[j].FlagKey
+		}
+		if ret[i].StartingLineNumber != ret[j].StartingLineNumber {
+			return ret[i].StartingLineNumber < ret[j].StartingLineNumber
+		}
+		return ret[i].Lines < ret[j].Lines
+	})
+	return ret
+}
@@ -0,0 +1,50 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_branchPatch(t *testing.T) {
+	flag1 := ld.HunkRep{FlagKey: "flag-1", StartingLineNumber: 1, Lines: "flag-1"}
+	flag2 := ld.HunkRep{FlagKey: "flag-2", StartingLineNumber: 5, Lines: "flag-2"}
+	seqId := int64(2)
+	prev := ld.BranchRep{Name: "master", Head: "abc", References: []ld.ReferenceHunksRep{
+		{Path: "unchanged", Hunks: []ld.HunkRep{flag1, flag2}},
+		{Path: "removed-1", Hunks: []ld.HunkRep{flag1}},
+		{Path: "changed", Hunks: []ld.HunkRep{flag1}},
+		{Path: "removed-2", Hunks: []ld.HunkRep{flag2}},
+	}}
+	next := ld.BranchRep{Name: "master", Head: "def", SyncTime: 100, UpdateSequenceId: &seqId, References: []ld.ReferenceHunksRep{
+		{Path: "added", Hunks: []ld.HunkRep{flag2}},
+		{Path: "changed", Hunks: []ld.HunkRep{flag2}},
+		// hunks in a different order are unchanged
+		{Path: "unchanged", Hunks: []ld.HunkRep{flag2, flag1}},
+	}}
+
+	patch, changed, removed := branchPatch(prev, next)
+	require.Equal(t, 2, changed)
+	require.Equal(t, 2, removed)
+	require.Equal(t, []ld.PatchOperation{
+		{Op: "test", Path: "/head", Value: "abc"},
+		{Op: "add", Path: "/head", Value: "def"},
+		{Op: "add", Path: "/syncTime", Value: int64(100)},
+		{Op: "add", Path: "/isDefault", Value: false},
+		{Op: "add", Path: "/updateSequenceId", Value: int64(2)},
+		{Op: "replace", Path: "/references/2", Value: next.References[1]},
+		{Op: "remove", Path: "/references/3"},
+		{Op: "remove", Path: "/references/1"},
+		{Op: "add", Path: "/references/-", Value: next.References[0]},
+	}, patch)
+}
+
+func Test_sameHunks(t *testing.T) {
+	a := ld.HunkRep{FlagKey: "flag", StartingLineNumber: 1}
+	b := ld.HunkRep{FlagKey: "flag", StartingLineNumber: 2}
+	require.True(t, sameHunks([]ld.HunkRep{a, b}, []ld.HunkRep{b, a}))
+	require.False(t, sameHunks([]ld.HunkRep{a}, []ld.HunkRep{b}))
+	require.False(t, sameHunks([]ld.HunkRep{a}, []ld.HunkRep{a, b}))
+}
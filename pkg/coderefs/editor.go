@@ -0,0 +1,163 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// flagLocation is the position of a flag key reference within a file. Lines and columns are 1-based,
+// and columns are byte offsets. EndColumn is exclusive.
+type flagLocation struct {
+	Line        int
+	Column      int
+	EndColumn   int
+	LineText    string
+	FlagKey     string
+	ProjKey     string
+	HasLineText bool
+}
+
+// flagLocations returns the position of every flag key reference in a hunk. Column offsets reported
+// in the hunk are used when present, otherwise the hunk's lines are searched for the flag key. If the
+// hunk has no source code or offsets, the hunk's starting line is used.
+func flagLocations(hunk ld.HunkRep) []flagLocation {
+	locations := []flagLocation{}
+	lines := strings.Split(strings.TrimSuffix(hunk.Lines, "\n"), "\n")
+	if len(hunk.Offsets) > 0 {
+		for _, offset := range hunk.Offsets {
+			l := flagLocation{
+				Line:      offset.LineNumber,
+				Column:    offset.StartColumn + 1,
+				EndColumn: offset.EndColumn + 1,
+				FlagKey:   hunk.FlagKey,
+				ProjKey:   hunk.ProjKey,
+			}
+			if i := offset.LineNumber - hunk.StartingLineNumber; hunk.Lines != "" && i >= 0 && i < len(lines) && offset.EndColumn <= len(lines[i]) {
+				l.LineText = lines[i]
+				l.HasLineText = true
+			}
+			locations = append(locations, l)
+		}
+		return locations
+	}
+	if hunk.Lines == "" {
+		return append(locations, flagLocation{Line: hunk.StartingLineNumber, Column: 1, EndColumn: 1, FlagKey: hunk.FlagKey, ProjKey: hunk.ProjKey})
+	}
+
+	for i, line := range lines {
+		offset := 0
+		for {
+			idx := strings.Index(line[offset:], hunk.FlagKey)
+			if idx < 0 {
+				break
+			}
+			start := offset + idx
+			locations = append(locations, flagLocation{
+				Line:        hunk.StartingLineNumber + i,
+				Column:      start + 1,
+				EndColumn:   start + len(hunk.FlagKey) + 1,
+				LineText:    line,
+				FlagKey:     hunk.FlagKey,
+				ProjKey:     hunk.ProjKey,
+				HasLineText: true,
+			})
+			offset = start + len(hunk.FlagKey)
+		}
+	}
+	return locations
+}
+
+func (l flagLocation) message() string {
+	return fmt.Sprintf("reference to LaunchDarkly flag %s in project %s", l.FlagKey, l.ProjKey)
+}
+
+// writeQuickfix writes flag references as `file:line:col: message` lines, which can be loaded into
+// a Vim quickfix list with `vim -q` or parsed by most editors' error formats.
+func writeQuickfix(w io.Writer, refs []ld.ReferenceHunksRep) error {
+	for _, ref := range refs {
+		for _, hunk := range ref.Hunks {
+			for _, l := range flagLocations(hunk) {
+				_, err := fmt.Fprintf(w, "%s:%d:%d: %s\n", ref.Path, l.Line, l.Column, l.message())
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// LSP types, see https://microsoft.github.io/language-server-protocol/specification
+type lspPosition struct {
+	// Line is 0-based
+	Line int `json:"line"`
+	// Character is a 0-based offset in UTF-16 code units
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspPublishDiagnosticsParams struct {
+	URI         string          `json:"uri"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+const lspSeverityInformation = 3
+
+// writeLspDiagnostics writes flag references as a JSON array of LSP publishDiagnostics parameters, one per file.
+// root is the absolute path of the scanned directory, used to generate file URIs.
+func writeLspDiagnostics(w io.Writer, root string, refs []ld.ReferenceHunksRep) error {
+	params := []lspPublishDiagnosticsParams{}
+	for _, ref := range refs {
+		uri := url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(root, ref.Path))}
+		p := lspPublishDiagnosticsParams{URI: uri.String(), Diagnostics: []lspDiagnostic{}}
+		for _, hunk := range ref.Hunks {
+			for _, l := range flagLocations(hunk) {
+				// Without source code, byte offsets are the best available approximation of character offsets
+				start := lspPosition{Line: l.Line - 1, Character: l.Column - 1}
+				end := lspPosition{Line: l.Line - 1, Character: l.EndColumn - 1}
+				if l.HasLineText {
+					start.Character = utf16Len(l.LineText[:l.Column-1])
+					end.Character = utf16Len(l.LineText[:l.EndColumn-1])
+				}
+				p.Diagnostics = append(p.Diagnostics, lspDiagnostic{
+					Range:    lspRange{Start: start, End: end},
+					Severity: lspSeverityInformation,
+					Code:     l.FlagKey,
+					Source:   "ld-find-code-refs",
+					Message:  l.message(),
+				})
+			}
+		}
+		params = append(params, p)
+	}
+
+	data, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
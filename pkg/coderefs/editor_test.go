@@ -0,0 +1,65 @@
+package coderefs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+var editorTestRefs = []ld.ReferenceHunksRep{
+	{Path: "a/b.js", Hunks: []ld.HunkRep{
+		{StartingLineNumber: 5, Lines: "context\nif (flag-1 || flag-1)\n", ProjKey: "proj", FlagKey: "flag-1"},
+	}},
+	{Path: "a/c.js", Hunks: []ld.HunkRep{
+		{StartingLineNumber: 1, Lines: "'é' flag-2\n", ProjKey: "proj", FlagKey: "flag-2"},
+		{StartingLineNumber: 9, ProjKey: "proj", FlagKey: "flag-1"},
+		{StartingLineNumber: 20, ProjKey: "proj", FlagKey: "flag-3", Offsets: []ld.OffsetRep{{LineNumber: 21, StartColumn: 2, EndColumn: 8}}},
+	}},
+}
+
+func Test_writeQuickfix(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, writeQuickfix(&out, editorTestRefs))
+	require.Equal(t, `a/b.js:6:5: reference to LaunchDarkly flag flag-1 in project proj
+a/b.js:6:15: reference to LaunchDarkly flag flag-1 in project proj
+a/c.js:1:6: reference to LaunchDarkly flag flag-2 in project proj
+a/c.js:9:1: reference to LaunchDarkly flag flag-1 in project proj
+a/c.js:21:3: reference to LaunchDarkly flag flag-3 in project proj
+`, out.String())
+}
+
+func Test_writeLspDiagnostics(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, writeLspDiagnostics(&out, "/repo", editorTestRefs[1:]))
+	require.JSONEq(t, `[
+  {
+    "uri": "file:///repo/a/c.js",
+    "diagnostics": [
+      {
+        "range": {"start": {"line": 0, "character": 4}, "end": {"line": 0, "character": 10}},
+        "severity": 3,
+        "code": "flag-2",
+        "source": "ld-find-code-refs",
+        "message": "reference to LaunchDarkly flag flag-2 in project proj"
+      },
+      {
+        "range": {"start": {"line": 8, "character": 0}, "end": {"line": 8, "character": 0}},
+        "severity": 3,
+        "code": "flag-1",
+        "source": "ld-find-code-refs",
+        "message": "reference to LaunchDarkly flag flag-1 in project proj"
+      },
+      {
+        "range": {"start": {"line": 20, "character": 2}, "end": {"line": 20, "character": 8}},
+        "severity": 3,
+        "code": "flag-3",
+        "source": "ld-find-code-refs",
+        "message": "reference to LaunchDarkly flag flag-3 in project proj"
+      }
+    ]
+  }
+]`, out.String())
+}
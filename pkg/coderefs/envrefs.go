@@ -0,0 +1,117 @@
+package coderefs
+
+import (
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// configFileNames are the names of configuration files without a conventional extension.
+var configFileNames = map[string]bool{
+	"Dockerfile":    true,
+	"Containerfile": true,
+	".env":          true,
+}
+
+// configFileRegex matches the names of configuration files, e.g. docker-compose.yml, values.yaml, .env.production,
+// and main.tfvars.
+var configFileRegex = regexp.MustCompile(`(?i)(\.(ya?ml|toml|ini|properties|conf|cfg|env|tf|tfvars)|^\.env\..+|^Dockerfile\..+)$`)
+
+// isConfigPath returns true if the path, relative to the repository root, is a configuration file where flags may
+// be referenced by environment variable names.
+func isConfigPath(p string) bool {
+	name := path.Base(p)
+	return configFileNames[name] || configFileRegex.MatchString(name)
+}
+
+// envVarName returns the environment variable name conventionally derived from a flag key, in upper snake case, e.g.
+// ENABLE_CHECKOUT for enable-checkout, enable.checkout, or enableCheckout.
+func envVarName(flag string) string {
+	var sb strings.Builder
+	var prev rune
+	for _, r := range flag {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(unicode.ToUpper(r))
+		case sb.Len() > 0 && prev != '_':
+			sb.WriteByte('_')
+			r = '_'
+		default:
+			r = '_'
+		}
+		prev = r
+	}
+	return strings.TrimSuffix(sb.String(), "_")
+}
+
+// envFlagKeys returns the environment variable names derived from flag keys, with and without each prefix, and
+// the flag keys each name is derived from. Names which are also flag keys, or which are shorter than the minimum
+// flag key length, are omitted.
+func envFlagKeys(flags []string, prefixes []string) ([]string, map[string][]string) {
+	isFlag := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		isFlag[flag] = true
+	}
+	names := []string{}
+	envKeys := map[string][]string{}
+	for _, flag := range flags {
+		name := envVarName(flag)
+		if name == "" {
+			continue
+		}
+		for _, prefixed := range append([]string{name}, prefixedNames(name, prefixes)...) {
+			if isFlag[prefixed] || len(prefixed) < minFlagKeyLen {
+				continue
+			}
+			if _, ok := envKeys[prefixed]; !ok {
+				names = append(names, prefixed)
+			}
+			envKeys[prefixed] = append(envKeys[prefixed], flag)
+		}
+	}
+	return names, envKeys
+}
+
+func prefixedNames(name string, prefixes []string) []string {
+	ret := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ret = append(ret, prefix+name)
+	}
+	return ret
+}
+
+// attributeEnvReferences replaces the environment variable name of each hunk with the flag keys it's derived from,
+// and marks the hunk as a config reference. Hunks of environment variable names outside of configuration files are
+// removed, as are files left without hunks.
+func attributeEnvReferences(refs []ld.ReferenceHunksRep, envKeys map[string][]string) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		config := isConfigPath(ref.Path)
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			flags, ok := envKeys[hunk.FlagKey]
+			if !ok {
+				hunks = append(hunks, hunk)
+				continue
+			}
+			if !config {
+				continue
+			}
+			for _, flag := range flags {
+				hunk.FlagKey = flag
+				hunk.ConfigReference = true
+				hunks = append(hunks, hunk)
+			}
+		}
+		if len(hunks) > 0 {
+			ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,60 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_envVarName(t *testing.T) {
+	specs := map[string]string{
+		"enable-checkout":  "ENABLE_CHECKOUT",
+		"enable.checkout":  "ENABLE_CHECKOUT",
+		"enableCheckout":   "ENABLE_CHECKOUT",
+		"v2-Checkout--new": "V2_CHECKOUT_NEW",
+		"-leading-":        "LEADING",
+		"--":               "",
+	}
+	for flag, expected := range specs {
+		require.Equal(t, expected, envVarName(flag), flag)
+	}
+}
+
+func Test_isConfigPath(t *testing.T) {
+	for _, p := range []string{"Dockerfile", "deploy/Dockerfile.prod", "docker-compose.yml", "charts/app/values.yaml", ".env", "config/.env.production", "app.properties", "main.tfvars"} {
+		require.True(t, isConfigPath(p), p)
+	}
+	for _, p := range []string{"main.go", "src/env.ts", "README.md", "environment.json"} {
+		require.False(t, isConfigPath(p), p)
+	}
+}
+
+func Test_envFlagKeys(t *testing.T) {
+	names, envKeys := envFlagKeys([]string{"enable-checkout", "enableCheckout", "AB", "SHOUTING_FLAG", "shouting-flag"}, []string{"FEATURE_"})
+	require.Equal(t, []string{"ENABLE_CHECKOUT", "FEATURE_ENABLE_CHECKOUT", "FEATURE_AB", "FEATURE_SHOUTING_FLAG"}, names)
+	require.Equal(t, map[string][]string{
+		"ENABLE_CHECKOUT":         {"enable-checkout", "enableCheckout"},
+		"FEATURE_ENABLE_CHECKOUT": {"enable-checkout", "enableCheckout"},
+		"FEATURE_AB":              {"AB"},
+		"FEATURE_SHOUTING_FLAG":   {"SHOUTING_FLAG", "shouting-flag"},
+	}, envKeys)
+}
+
+func Test_attributeEnvReferences(t *testing.T) {
+	envKeys := map[string][]string{"FEATURE_ENABLE_CHECKOUT": {"enable-checkout"}}
+	refs := []ld.ReferenceHunksRep{
+		{Path: "main.go", Hunks: []ld.HunkRep{{StartingLineNumber: 1, FlagKey: "FEATURE_ENABLE_CHECKOUT"}}},
+		{Path: "docker-compose.yml", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 3, FlagKey: "FEATURE_ENABLE_CHECKOUT"},
+			{StartingLineNumber: 8, FlagKey: "enable-checkout"},
+		}},
+	}
+	require.Equal(t, []ld.ReferenceHunksRep{
+		{Path: "docker-compose.yml", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 3, FlagKey: "enable-checkout", ConfigReference: true},
+			{StartingLineNumber: 8, FlagKey: "enable-checkout"},
+		}},
+	}, attributeEnvReferences(refs, envKeys))
+}
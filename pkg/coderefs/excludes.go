@@ -0,0 +1,254 @@
+package coderefs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+	"github.com/launchdarkly/ld-find-code-refs/internal/scaffold"
+)
+
+const (
+	// minSuggestionHunks is the fewest hunks a directory must contain to be suggested as an exclude.
+	minSuggestionHunks = 5
+	// minSuggestionShare is the smallest share of all hunks a directory must contain to be suggested as an exclude.
+	minSuggestionShare = 0.1
+	// minLowScoreShare is the smallest share of a directory's hunks which must have low relevance scores for it to be
+	// suggested as an exclude.
+	minLowScoreShare = 0.9
+	// minifiedLineBytes is the length of lines assumed to be minified.
+	minifiedLineBytes = 500
+)
+
+// generatedFileRegexes match the names of files which are typically generated or minified, so references in them
+// aren't changed by hand.
+var generatedFileRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`\.min\.(js|css)$`),
+	regexp.MustCompile(`\.(js|css)\.map$`),
+	regexp.MustCompile(`\.pb\.go$`),
+	regexp.MustCompile(`_pb2\.py$`),
+	regexp.MustCompile(`\.g\.dart$`),
+	regexp.MustCompile(`\.generated\.\w+$`),
+}
+
+// excludeSuggestion is a pattern for the exclude option, matching files which contributed references that are
+// unlikely to need changes when flags are removed.
+type excludeSuggestion struct {
+	Pattern string
+	Reason  string
+	Hunks   int
+}
+
+// isLowScore returns true if a hunk's references are incidental: comments, or flag keys in identifiers. References in
+// test files aren't, since they're handled by the testReferences option.
+func isLowScore(hunk ld.HunkRep) bool {
+	return !hunk.TestCode && hunkScore(hunk) < scoreLiteral
+}
+
+// vendorDir returns the name of the first directory containing the file at p which typically contains third party
+// code, e.g. node_modules, or "" if there isn't one.
+func vendorDir(p string) string {
+	elems := strings.Split(p, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		if scaffold.IsVendorDir(elem) {
+			return elem
+		}
+	}
+	return ""
+}
+
+// isMinified returns true if any line of a hunk is long enough to be minified.
+func isMinified(hunk ld.HunkRep) bool {
+	for _, line := range strings.Split(hunk.Lines, "\n") {
+		if len(line) >= minifiedLineBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestExcludes returns exclude patterns for vendored directories, generated and minified files, and directories
+// where almost every reference has a low relevance score, never evaluates a flag, and which contribute a large share
+// of all references. Directories are only suggested if none of their parents are.
+func suggestExcludes(refs []ld.ReferenceHunksRep) []excludeSuggestion {
+	type dirStats struct {
+		hunks, low, evaluations int
+	}
+	totalHunks := 0
+	dirs := map[string]*dirStats{}
+	vendored := map[string]int{}
+	// The pattern matching each generated file, and files which appear to be minified
+	generated := map[string]string{}
+	minified := map[string]bool{}
+	hunks := map[string]int{}
+	for _, ref := range refs {
+		totalHunks += len(ref.Hunks)
+		hunks[ref.Path] += len(ref.Hunks)
+		if name := vendorDir(ref.Path); name != "" {
+			vendored[name] += len(ref.Hunks)
+			continue
+		}
+		for dir := path.Dir(ref.Path); dir != "."; dir = path.Dir(dir) {
+			stats := dirs[dir]
+			if stats == nil {
+				stats = &dirStats{}
+				dirs[dir] = stats
+			}
+			for _, hunk := range ref.Hunks {
+				stats.hunks++
+				if isLowScore(hunk) {
+					stats.low++
+				}
+				if hunkScore(hunk) == scoreEvaluation {
+					stats.evaluations++
+				}
+			}
+		}
+		for _, r := range generatedFileRegexes {
+			if r.MatchString(ref.Path) {
+				generated[ref.Path] = r.String()
+				break
+			}
+		}
+		if generated[ref.Path] != "" {
+			continue
+		}
+		for _, hunk := range ref.Hunks {
+			if isMinified(hunk) {
+				minified[ref.Path] = true
+				break
+			}
+		}
+	}
+
+	suggestions := []excludeSuggestion{}
+	vendorDirs := make([]string, 0, len(vendored))
+	for name := range vendored {
+		vendorDirs = append(vendorDirs, name)
+	}
+	sort.Strings(vendorDirs)
+	for _, name := range vendorDirs {
+		suggestions = append(suggestions, excludeSuggestion{
+			Pattern: "(^|/)" + regexp.QuoteMeta(name) + "/",
+			Reason:  "vendored code",
+			Hunks:   vendored[name],
+		})
+	}
+
+	// Shallower directories are suggested first, so their subdirectories can be skipped
+	dirNames := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		dirNames = append(dirNames, dir)
+	}
+	sort.Slice(dirNames, func(i, j int) bool {
+		di, dj := strings.Count(dirNames[i], "/"), strings.Count(dirNames[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return dirNames[i] < dirNames[j]
+	})
+	suggested := []string{}
+	within := func(p string) bool {
+		for _, dir := range suggested {
+			if strings.HasPrefix(p, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	for _, dir := range dirNames {
+		stats := dirs[dir]
+		if within(dir) || stats.hunks < minSuggestionHunks || stats.evaluations > 0 ||
+			float64(stats.hunks) < minSuggestionShare*float64(totalHunks) || float64(stats.low) < minLowScoreShare*float64(stats.hunks) {
+			continue
+		}
+		suggested = append(suggested, dir)
+		suggestions = append(suggestions, excludeSuggestion{
+			Pattern: "^" + regexp.QuoteMeta(dir) + "/",
+			Reason:  fmt.Sprintf("%d%% of references are in comments or identifiers, and no flags are evaluated", stats.low*100/stats.hunks),
+			Hunks:   stats.hunks,
+		})
+	}
+
+	// Files in suggested directories are already excluded
+	generatedHunks := map[string]int{}
+	for p, pattern := range generated {
+		if !within(p) {
+			generatedHunks[pattern] += hunks[p]
+		}
+	}
+	for _, r := range generatedFileRegexes {
+		if n := generatedHunks[r.String()]; n > 0 {
+			suggestions = append(suggestions, excludeSuggestion{Pattern: r.String(), Reason: "generated or minified files", Hunks: n})
+		}
+	}
+	minifiedFiles := make([]string, 0, len(minified))
+	for p := range minified {
+		if !within(p) {
+			minifiedFiles = append(minifiedFiles, p)
+		}
+	}
+	sort.Strings(minifiedFiles)
+	for _, p := range minifiedFiles {
+		suggestions = append(suggestions, excludeSuggestion{
+			Pattern: "^" + regexp.QuoteMeta(p) + "$",
+			Reason:  fmt.Sprintf("lines of %d or more characters, which appear to be minified", minifiedLineBytes),
+			Hunks:   hunks[p],
+		})
+	}
+	return suggestions
+}
+
+// combineExcludes returns an exclude pattern matching the files matched by exclude or any of the suggestions.
+func combineExcludes(exclude string, suggestions []excludeSuggestion) string {
+	patterns := []string{}
+	if exclude != "" {
+		patterns = append(patterns, exclude)
+	}
+	for _, s := range suggestions {
+		patterns = append(patterns, s.Pattern)
+	}
+	return strings.Join(patterns, "|")
+}
+
+func writeExcludeSuggestions(w io.Writer, suggestions []excludeSuggestion, exclude string) error {
+	var b strings.Builder
+	if len(suggestions) == 0 {
+		b.WriteString("No excludes suggested: references aren't concentrated in vendored, generated, or minified files\n")
+	} else {
+		b.WriteString("Suggested excludes, for references unlikely to need changes when flags are removed:\n")
+		for _, s := range suggestions {
+			fmt.Fprintf(&b, "  %s\t%d hunks: %s\n", s.Pattern, s.Hunks, s.Reason)
+		}
+		fmt.Fprintf(&b, "To exclude them, set exclude to: %s\n", exclude)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// printExcludeSuggestions prints exclude patterns suggested by the references found, and if the applySuggestions
+// option is enabled, sets exclude in the config file to the current exclude pattern combined with the suggestions.
+func printExcludeSuggestions(refs []ld.ReferenceHunksRep) {
+	suggestions := suggestExcludes(refs)
+	exclude := combineExcludes(o.Exclude.Value(), suggestions)
+	err := writeExcludeSuggestions(log.Output(), suggestions, exclude)
+	if err != nil {
+		log.Warning.Printf("could not print exclude suggestions: %s", err)
+	}
+	if !o.ApplySuggestions.Value() || len(suggestions) == 0 {
+		return
+	}
+	configPath := o.ConfigFilePath()
+	err = o.SetConfigFileValue(configPath, string(o.Exclude), exclude)
+	if err != nil {
+		log.Warning.Printf("could not apply exclude suggestions: %s", err)
+		return
+	}
+	log.Info.Printf("applied %d exclude suggestions to %s", len(suggestions), configPath)
+}
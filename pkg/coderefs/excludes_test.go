@@ -0,0 +1,59 @@
+package coderefs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_suggestExcludes(t *testing.T) {
+	// hunk returns a hunk referencing my-flag in line
+	hunk := func(line string) ld.HunkRep {
+		start := strings.Index(line, "my-flag")
+		return ld.HunkRep{
+			FlagKey:            "my-flag",
+			StartingLineNumber: 1,
+			Lines:              line,
+			Offsets:            []ld.OffsetRep{{LineNumber: 1, StartColumn: start, EndColumn: start + len("my-flag")}},
+		}
+	}
+	repeat := func(n int, h ld.HunkRep) []ld.HunkRep {
+		hunks := make([]ld.HunkRep, n)
+		for i := range hunks {
+			hunks[i] = h
+		}
+		return hunks
+	}
+	comment := hunk("// my-flag")
+	evaluation := hunk(`client.boolVariation("my-flag", user, false)`)
+
+	refs := []ld.ReferenceHunksRep{
+		{Path: "src/app.go", Hunks: repeat(10, evaluation)},
+		{Path: "src/web/node_modules/lib/index.js", Hunks: repeat(3, comment)},
+		// Almost every reference in docs is a comment, so it's suggested rather than its subdirectory
+		{Path: "docs/guide/flags.md", Hunks: repeat(17, comment)},
+		{Path: "docs/notes.md", Hunks: []ld.HunkRep{comment, hunk(`"my-flag"`)}},
+		// Comments alongside an evaluation aren't suggested
+		{Path: "lib/client.go", Hunks: append(repeat(8, comment), evaluation)},
+		{Path: "api/flags.pb.go", Hunks: repeat(2, comment)},
+		{Path: "docs/guide/flags.min.js", Hunks: repeat(1, comment)},
+		{Path: "static/bundle.js", Hunks: []ld.HunkRep{hunk("var a=" + strings.Repeat("x", minifiedLineBytes) + `;f("my-flag")`)}},
+	}
+	require.Equal(t, []excludeSuggestion{
+		{Pattern: "(^|/)node_modules/", Reason: "vendored code", Hunks: 3},
+		{Pattern: "^docs/", Reason: "95% of references are in comments or identifiers, and no flags are evaluated", Hunks: 20},
+		{Pattern: `\.pb\.go$`, Reason: "generated or minified files", Hunks: 2},
+		{Pattern: `^static/bundle\.js$`, Reason: "lines of 500 or more characters, which appear to be minified", Hunks: 1},
+	}, suggestExcludes(refs))
+
+	require.Empty(t, suggestExcludes(refs[:1]))
+}
+
+func Test_combineExcludes(t *testing.T) {
+	suggestions := []excludeSuggestion{{Pattern: "^docs/"}, {Pattern: `\.pb\.go$`}}
+	require.Equal(t, `^docs/|\.pb\.go$`, combineExcludes("", suggestions))
+	require.Equal(t, `testdata/|^docs/|\.pb\.go$`, combineExcludes("testdata/", suggestions))
+}
@@ -0,0 +1,115 @@
+package coderefs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// maxExplainSamples is the largest number of lines printed for each outcome of a match by the explain option.
+const maxExplainSamples = 5
+
+// flagExplanation describes the search for a single flag key and what happened to each line it matched, so users can
+// debug why references to a flag aren't found.
+type flagExplanation struct {
+	FlagKey string
+	// Omitted is the reason the flag key is never searched for by scans, if any.
+	Omitted string
+	Method  string
+	Pattern string
+	// Matched is the number of lines matched by the search, which are either excluded, unbounded, or references.
+	Matched    int
+	Excluded   []string
+	Unbounded  []string
+	References []string
+}
+
+// explain searches for a single flag key, and prints how it was searched for and why each line it matched was or
+// wasn't counted as a reference.
+func explain(cmd command.Client, flag string, exclude *regexp.Regexp) {
+	e := flagExplanation{FlagKey: flag}
+	if len(flag) < minFlagKeyLen {
+		e.Omitted = fmt.Sprintf("flag keys shorter than %d characters are never searched for", minFlagKeyLen)
+	} else if filter, err := flagFilterOptions(); err == nil && len(filter.apply([]string{flag})) == 0 {
+		e.Omitted = "the flag key is excluded by the flags or excludeFlags options"
+	}
+
+	flags := []string{flag}
+	method, grepResult, err := cmd.SearchForFlagsWithMethod(flags, 0)
+	if budgetErr, ok := err.(*command.BudgetExceededError); ok {
+		log.Warning.Printf("%s. Matches are incomplete", budgetErr)
+	} else if err != nil {
+		log.Error.Fatalf("error searching for flag key references: %s", err)
+	}
+	e = explainMatches(e, grepResult, exclude)
+	e.Method = method
+	e.Pattern = command.FlagPattern(flags)
+	if method == command.NativeSearchMethod {
+		e.Pattern = fmt.Sprintf("%q, delimited by identifier boundaries", flag)
+	}
+
+	err = writeExplanation(os.Stdout, e)
+	if err != nil {
+		log.Error.Fatalf("could not write explanation: %s", err)
+	}
+}
+
+// explainMatches sorts the lines matched by a search for e's flag key by what happened to them: excluded by the
+// exclude option, rejected because the key is part of a longer identifier, or kept as references.
+func explainMatches(e flagExplanation, grepResult [][]string, exclude *regexp.Regexp) flagExplanation {
+	for _, r := range grepResult {
+		if r[2] != ":" {
+			continue
+		}
+		e.Matched++
+		path, lineText := r[1], strings.TrimSuffix(r[4], "\r")
+		line := fmt.Sprintf("%s:%s: %s", path, r[3], truncateLine(strings.TrimSpace(lineText)))
+		pluginFlags := command.PluginFlagKeys(r)
+		switch {
+		case exclude != nil && exclude.String() != "" && exclude.MatchString(path):
+			e.Excluded = append(e.Excluded, line)
+		case pluginFlags == nil && !containsBoundedFlag(lineText, []string{e.FlagKey}):
+			e.Unbounded = append(e.Unbounded, line)
+		default:
+			e.References = append(e.References, line)
+		}
+	}
+	return e
+}
+
+func writeExplanation(w io.Writer, e flagExplanation) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Flag key:  %s\n", e.FlagKey)
+	if e.Omitted != "" {
+		fmt.Fprintf(&b, "Warning:   %s, so scans won't find references to it\n", e.Omitted)
+	}
+	fmt.Fprintf(&b, "Search:    %s\n", e.Method)
+	fmt.Fprintf(&b, "Pattern:   %s\n", e.Pattern)
+	fmt.Fprintf(&b, "Matched:   %d lines\n", e.Matched)
+	fmt.Fprintf(&b, "  excluded by the exclude option:       %d\n", len(e.Excluded))
+	fmt.Fprintf(&b, "  flag key part of a longer identifier: %d\n", len(e.Unbounded))
+	fmt.Fprintf(&b, "  references:                           %d\n", len(e.References))
+	samples := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		for i, line := range lines {
+			if i == maxExplainSamples {
+				fmt.Fprintf(&b, "  ... and %d more\n", len(lines)-maxExplainSamples)
+				break
+			}
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	samples("References", e.References)
+	samples("Part of a longer identifier", e.Unbounded)
+	samples("Excluded", e.Excluded)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,44 @@
+package coderefs
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_explainMatches(t *testing.T) {
+	grepResult := [][]string{
+		{"", "src/a.go", ":", "1", `  variation("flag-1")`},
+		{"", "src/a.go", "-", "2", "context"},
+		{"", "src/b.js", ":", "3", "$flag-1 = true\r"},
+		{"", "vendor/c.go", ":", "4", "flag-1"},
+	}
+	e := explainMatches(flagExplanation{FlagKey: "flag-1"}, grepResult, regexp.MustCompile("vendor/"))
+	require.Equal(t, 3, e.Matched)
+	require.Equal(t, []string{`src/a.go:1: variation("flag-1")`}, e.References)
+	require.Equal(t, []string{"src/b.js:3: $flag-1 = true"}, e.Unbounded)
+	require.Equal(t, []string{"vendor/c.go:4: flag-1"}, e.Excluded)
+
+	e = explainMatches(flagExplanation{FlagKey: "flag-1"}, grepResult, regexp.MustCompile(""))
+	require.Len(t, e.References, 2)
+	require.Empty(t, e.Excluded)
+}
+
+func Test_writeExplanation(t *testing.T) {
+	refs := []string{}
+	for i := 0; i < maxExplainSamples+2; i++ {
+		refs = append(refs, "a.go:1: flag-1")
+	}
+	var buf bytes.Buffer
+	e := flagExplanation{FlagKey: "ab", Omitted: "too short", Method: "native matcher", Pattern: `"ab"`, Matched: len(refs), References: refs}
+	require.NoError(t, writeExplanation(&buf, e))
+	out := buf.String()
+	require.Contains(t, out, "Warning:   too short, so scans won't find references to it\n")
+	require.Contains(t, out, "  references:                           7\n")
+	require.Equal(t, maxExplainSamples, strings.Count(out, "  a.go:1: flag-1\n"))
+	require.Contains(t, out, "  ... and 2 more\n")
+	require.NotContains(t, out, "Excluded:")
+}
@@ -0,0 +1,126 @@
+package coderefs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"unicode"
+
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// flagFilter limits the flags searched for to those matching any include pattern, if provided, and no exclude
+// patterns. Patterns are flag keys, or globs matched with path.Match, e.g. checkout-*.
+type flagFilter struct {
+	include []string
+	exclude []string
+}
+
+// stdinFlags is the value of the flags option which reads flag keys from stdin.
+const stdinFlags = "-"
+
+var (
+	stdinFlagsOnce  sync.Once
+	includeFlags    []string
+	includeFlagsErr error
+)
+
+// flagFilterOptions returns the flagFilter configured by the flags and excludeFlags options.
+func flagFilterOptions() (flagFilter, error) {
+	// Stdin can only be read once, so the keys read are reused by every caller
+	stdinFlagsOnce.Do(func() {
+		includeFlags, includeFlagsErr = readStdinFlags(o.ListValues(o.Flags), os.Stdin)
+	})
+	if includeFlagsErr != nil {
+		return flagFilter{}, includeFlagsErr
+	}
+	return flagFilter{include: includeFlags, exclude: o.ListValues(o.ExcludeFlags)}, nil
+}
+
+// readStdinFlags replaces "-" in patterns with the flag keys read from r, so keys can be piped in from other tools.
+// Keys are separated by whitespace or commas, and lines starting with # are ignored.
+func readStdinFlags(patterns []string, r io.Reader) ([]string, error) {
+	ret := []string{}
+	read := false
+	for _, pattern := range patterns {
+		if pattern != stdinFlags {
+			ret = append(ret, pattern)
+			continue
+		}
+		if read {
+			continue
+		}
+		read = true
+		count := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			for _, key := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+				if _, err := path.Match(key, ""); err != nil {
+					return nil, fmt.Errorf("invalid flag pattern %q read from stdin: %s", key, err)
+				}
+				ret = append(ret, key)
+				count++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("could not read flag keys from stdin: %s", err)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("no flag keys were read from stdin")
+		}
+	}
+	return ret, nil
+}
+
+// explicitFlags returns the flags to search for if every include pattern is a flag key, rather than a glob, so
+// flags don't need to be retrieved from LaunchDarkly.
+func (f flagFilter) explicitFlags() ([]string, bool) {
+	if len(f.include) == 0 {
+		return nil, false
+	}
+	for _, pattern := range f.include {
+		if isGlob(pattern) {
+			return nil, false
+		}
+	}
+	return f.apply(f.include), true
+}
+
+// apply returns the flags which match the filter, in their original order.
+func (f flagFilter) apply(flags []string) []string {
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return flags
+	}
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, flag := range flags {
+		if seen[flag] || (len(f.include) > 0 && !matchesAny(f.include, flag)) || matchesAny(f.exclude, flag) {
+			continue
+		}
+		seen[flag] = true
+		ret = append(ret, flag)
+	}
+	return ret
+}
+
+func matchesAny(patterns []string, flag string) bool {
+	for _, pattern := range patterns {
+		// Patterns have already been validated
+		if ok, _ := path.Match(pattern, flag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
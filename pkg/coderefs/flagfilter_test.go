@@ -0,0 +1,41 @@
+package coderefs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_flagFilter(t *testing.T) {
+	flags := []string{"checkout-v1", "checkout-v2", "search", "test-flag"}
+
+	require.Equal(t, flags, flagFilter{}.apply(flags))
+	require.Equal(t, []string{"checkout-v1", "checkout-v2"}, flagFilter{include: []string{"checkout-*"}}.apply(flags))
+	require.Equal(t, []string{"checkout-v1", "search"}, flagFilter{include: []string{"checkout-*", "search"}, exclude: []string{"*-v2"}}.apply(flags))
+	require.Equal(t, []string{"checkout-v1", "checkout-v2", "search"}, flagFilter{exclude: []string{"test-*"}}.apply(flags))
+
+	_, ok := flagFilter{}.explicitFlags()
+	require.False(t, ok)
+	_, ok = flagFilter{include: []string{"search", "checkout-*"}}.explicitFlags()
+	require.False(t, ok, "patterns require flags to be retrieved")
+	explicit, ok := flagFilter{include: []string{"search", "checkout-v1", "search"}, exclude: []string{"checkout-*"}}.explicitFlags()
+	require.True(t, ok)
+	require.Equal(t, []string{"search"}, explicit)
+}
+
+func Test_readStdinFlags(t *testing.T) {
+	stdin := "# flags to clean up\nflag-1, flag-2\n\n  flag-3\tflag-4\n"
+	flags, err := readStdinFlags([]string{"other", "-", "-"}, strings.NewReader(stdin))
+	require.NoError(t, err)
+	require.Equal(t, []string{"other", "flag-1", "flag-2", "flag-3", "flag-4"}, flags)
+
+	flags, err = readStdinFlags([]string{"flag-1"}, strings.NewReader(stdin))
+	require.NoError(t, err)
+	require.Equal(t, []string{"flag-1"}, flags, "stdin is only read if requested")
+
+	_, err = readStdinFlags([]string{"flag-1", "-"}, strings.NewReader("\n# nothing\n"))
+	require.Error(t, err)
+	_, err = readStdinFlags([]string{"-"}, strings.NewReader("flag-["))
+	require.Error(t, err)
+}
@@ -0,0 +1,229 @@
+package coderefs
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// hunkFormat controls how the lines of hunks are formatted, to reduce payload size and improve readability in the
+// LaunchDarkly UI. The zero value leaves hunks unchanged.
+type hunkFormat struct {
+	// trimWhitespace removes trailing whitespace from each line
+	trimWhitespace bool
+	// maxBlankLines is the maximum number of consecutive blank lines kept, or < 0 to keep all blank lines
+	maxBlankLines int
+	// tabWidth, if > 0, replaces tabs with spaces up to the next multiple of tabWidth columns
+	tabWidth int
+	// maxHunkBytes, if > 0, is the maximum size of the lines of a hunk. Context lines are removed to fit.
+	maxHunkBytes int
+}
+
+func hunkFormatOptions() hunkFormat {
+	return hunkFormat{
+		trimWhitespace: o.TrimWhitespace.Value(),
+		maxBlankLines:  o.MaxBlankLines.Value(),
+		tabWidth:       o.TabWidth.Value(),
+		maxHunkBytes:   o.MaxHunkBytes.Value(),
+	}
+}
+
+func (f hunkFormat) isZero() bool {
+	return !f.trimWhitespace && f.maxBlankLines < 0 && f.tabWidth <= 0 && f.maxHunkBytes <= 0
+}
+
+// formatReferences formats the lines of every hunk. Hunks may be split, if they contain a long run of blank lines.
+func formatReferences(refs []ld.ReferenceHunksRep, f hunkFormat) []ld.ReferenceHunksRep {
+	if f.isZero() {
+		return refs
+	}
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			hunks = append(hunks, f.formatHunk(hunk)...)
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
+
+// formatHunk formats the lines of a hunk, adjusting the columns of its offsets to match. Runs of more than
+// maxBlankLines blank lines are shortened at the start and end of the hunk. In the middle of the hunk, they're removed
+// by splitting the hunk in two, and parts without flag references are dropped.
+func (f hunkFormat) formatHunk(hunk ld.HunkRep) []ld.HunkRep {
+	if hunk.Lines == "" {
+		return []ld.HunkRep{hunk}
+	}
+	lines := strings.Split(strings.TrimSuffix(hunk.Lines, "\n"), "\n")
+	offsets := append([]ld.OffsetRep{}, hunk.Offsets...)
+	for i, line := range lines {
+		lineNum := hunk.StartingLineNumber + i
+		if f.tabWidth > 0 && strings.Contains(line, "\t") {
+			var columns []int
+			line, columns = expandTabs(line, f.tabWidth)
+			for j := range offsets {
+				if offsets[j].LineNumber == lineNum {
+					offsets[j].StartColumn = mapColumn(columns, offsets[j].StartColumn)
+					offsets[j].EndColumn = mapColumn(columns, offsets[j].EndColumn)
+				}
+			}
+		}
+		if f.trimWhitespace {
+			line = strings.TrimRightFunc(line, unicode.IsSpace)
+		}
+		lines[i] = line
+	}
+
+	ret := []ld.HunkRep{}
+	for _, s := range f.segments(lines, len(hunk.Offsets) > 0) {
+		if f.maxHunkBytes > 0 {
+			s = fitSegment(lines, s, referencedLines(hunk, s), f.maxHunkBytes)
+		}
+		part := hunk
+		part.StartingLineNumber = hunk.StartingLineNumber + s.start
+		part.Lines = strings.Join(lines[s.start:s.end], "\n") + "\n"
+		part.Offsets = nil
+		for _, offset := range offsets {
+			if offset.LineNumber >= part.StartingLineNumber && offset.LineNumber < part.StartingLineNumber+s.end-s.start {
+				part.Offsets = append(part.Offsets, offset)
+			}
+		}
+		if len(hunk.Offsets) > 0 && len(part.Offsets) == 0 {
+			continue
+		}
+		ret = append(ret, part)
+	}
+	if len(ret) == 0 {
+		// Keep hunks which are entirely blank, rather than dropping a reference
+		return []ld.HunkRep{hunk}
+	}
+	return ret
+}
+
+type segment struct {
+	start, end int
+}
+
+// segments returns the ranges of lines kept after shortening runs of blank lines. If split is false, runs in
+// the middle of the lines are kept, since the hunk can't be split without knowing which lines contain references.
+func (f hunkFormat) segments(lines []string, split bool) []segment {
+	if f.maxBlankLines < 0 {
+		return []segment{{0, len(lines)}}
+	}
+	ret := []segment{}
+	start := 0
+	for i := 0; i < len(lines); {
+		if strings.TrimSpace(lines[i]) != "" {
+			i++
+			continue
+		}
+		runStart := i
+		for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			i++
+		}
+		if i-runStart <= f.maxBlankLines {
+			continue
+		}
+		switch {
+		case runStart == 0:
+			start = i - f.maxBlankLines
+		case i == len(lines):
+			ret = append(ret, segment{start, runStart + f.maxBlankLines})
+			start = len(lines)
+		case split:
+			ret = append(ret, segment{start, runStart})
+			start = i
+		}
+	}
+	if start < len(lines) {
+		ret = append(ret, segment{start, len(lines)})
+	}
+	return ret
+}
+
+// referencedLines returns the range of lines in a segment containing flag references. If the hunk has no offsets,
+// the middle line of the segment is used.
+func referencedLines(hunk ld.HunkRep, s segment) segment {
+	ret := segment{-1, -1}
+	for _, offset := range hunk.Offsets {
+		i := offset.LineNumber - hunk.StartingLineNumber
+		if i < s.start || i >= s.end {
+			continue
+		}
+		if ret.start < 0 || i < ret.start {
+			ret.start = i
+		}
+		if i+1 > ret.end {
+			ret.end = i + 1
+		}
+	}
+	if ret.start < 0 {
+		mid := (s.start + s.end) / 2
+		return segment{mid, mid + 1}
+	}
+	return ret
+}
+
+// fitSegment removes context lines from the edges of a segment until its lines fit in maxBytes, keeping the
+// referenced lines centered: lines are removed from whichever edge has more context lines, or the longer edge line
+// if both edges have the same number of context lines. Referenced lines are
+// never removed, so the result may still be larger than maxBytes.
+func fitSegment(lines []string, s, referenced segment, maxBytes int) segment {
+	size := 0
+	for _, line := range lines[s.start:s.end] {
+		size += len(line) + 1
+	}
+	for size > maxBytes {
+		before, after := referenced.start-s.start, s.end-referenced.end
+		switch {
+		case before == 0 && after == 0:
+			return s
+		case before > after, before == after && len(lines[s.start]) >= len(lines[s.end-1]):
+			size -= len(lines[s.start]) + 1
+			s.start++
+		default:
+			s.end--
+			size -= len(lines[s.end]) + 1
+		}
+	}
+	return s
+}
+
+// expandTabs replaces tabs with spaces, up to the next multiple of width columns. It also returns the byte
+// offset in the result of each byte offset of line, including the end of the line.
+func expandTabs(line string, width int) (string, []int) {
+	var sb strings.Builder
+	columns := make([]int, 0, len(line)+1)
+	col := 0
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		for j := 0; j < size; j++ {
+			columns = append(columns, sb.Len())
+		}
+		if r == '\t' {
+			spaces := width - col%width
+			sb.WriteString(strings.Repeat(" ", spaces))
+			col += spaces
+		} else {
+			sb.WriteString(line[i : i+size])
+			col++
+		}
+		i += size
+	}
+	columns = append(columns, sb.Len())
+	return sb.String(), columns
+}
+
+// mapColumn returns the column of the result of expandTabs corresponding to col.
+func mapColumn(columns []int, col int) int {
+	if col < len(columns) {
+		return columns[col]
+	}
+	// Columns past the end of the line are shifted by the same amount as the end of the line
+	end := len(columns) - 1
+	return col + columns[end] - end
+}
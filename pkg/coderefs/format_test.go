@@ -0,0 +1,83 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_formatHunk(t *testing.T) {
+	hunk := ld.HunkRep{
+		StartingLineNumber: 10,
+		Lines:              "\n\n\n\tif flag-1 {  \n\t\tx()\n\n\n\n}\tflag-1\n\n\n",
+		ProjKey:            "default",
+		FlagKey:            "flag-1",
+		Offsets: []ld.OffsetRep{
+			{LineNumber: 13, StartColumn: 4, EndColumn: 10},
+			{LineNumber: 18, StartColumn: 2, EndColumn: 8},
+		},
+	}
+
+	specs := []struct {
+		name     string
+		format   hunkFormat
+		expected []ld.HunkRep
+	}{
+		{"unchanged", hunkFormat{maxBlankLines: -1}, []ld.HunkRep{hunk}},
+		{"trim whitespace", hunkFormat{trimWhitespace: true, maxBlankLines: -1}, []ld.HunkRep{
+			{StartingLineNumber: 10, Lines: "\n\n\n\tif flag-1 {\n\t\tx()\n\n\n\n}\tflag-1\n\n\n", ProjKey: "default", FlagKey: "flag-1", Offsets: hunk.Offsets},
+		}},
+		{"tabs", hunkFormat{maxBlankLines: -1, tabWidth: 4}, []ld.HunkRep{
+			{StartingLineNumber: 10, Lines: "\n\n\n    if flag-1 {  \n        x()\n\n\n\n}   flag-1\n\n\n", ProjKey: "default", FlagKey: "flag-1", Offsets: []ld.OffsetRep{
+				{LineNumber: 13, StartColumn: 7, EndColumn: 13},
+				{LineNumber: 18, StartColumn: 4, EndColumn: 10},
+			}},
+		}},
+		{"blank lines", hunkFormat{maxBlankLines: 1}, []ld.HunkRep{
+			{StartingLineNumber: 12, Lines: "\n\tif flag-1 {  \n\t\tx()\n", ProjKey: "default", FlagKey: "flag-1", Offsets: hunk.Offsets[:1]},
+			{StartingLineNumber: 18, Lines: "}\tflag-1\n\n", ProjKey: "default", FlagKey: "flag-1", Offsets: hunk.Offsets[1:]},
+		}},
+		{"no blank lines", hunkFormat{maxBlankLines: 0}, []ld.HunkRep{
+			{StartingLineNumber: 13, Lines: "\tif flag-1 {  \n\t\tx()\n", ProjKey: "default", FlagKey: "flag-1", Offsets: hunk.Offsets[:1]},
+			{StartingLineNumber: 18, Lines: "}\tflag-1\n", ProjKey: "default", FlagKey: "flag-1", Offsets: hunk.Offsets[1:]},
+		}},
+	}
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.format.formatHunk(hunk))
+		})
+	}
+}
+
+func Test_formatHunk_withoutOffsets(t *testing.T) {
+	// Hunks without offsets aren't split, since it's unknown which lines contain references
+	hunk := ld.HunkRep{StartingLineNumber: 1, Lines: "\n\nflag-1\n\n\n\nx\n\n", FlagKey: "flag-1"}
+	require.Equal(t, []ld.HunkRep{{StartingLineNumber: 2, Lines: "\nflag-1\n\n\n\nx\n\n", FlagKey: "flag-1"}}, hunkFormat{maxBlankLines: 1}.formatHunk(hunk))
+}
+
+func Test_expandTabs(t *testing.T) {
+	line, columns := expandTabs("é\tx\t", 4)
+	require.Equal(t, "é   x   ", line)
+	require.Equal(t, []int{0, 0, 2, 5, 6, 9}, columns)
+	require.Equal(t, 10, mapColumn(columns, 6))
+}
+
+func Test_fitSegment(t *testing.T) {
+	lines := []string{"aaaaaaaaa", "b", "ccccccccc", "flag-1", "d", "eeeeeeeee", "f"}
+	referenced := segment{3, 4}
+	all := segment{0, len(lines)}
+
+	require.Equal(t, all, fitSegment(lines, all, referenced, 1000))
+	// Lines are removed from the edge with more context lines, or the edge with the longer line on ties
+	require.Equal(t, segment{1, 7}, fitSegment(lines, all, referenced, 38))
+	require.Equal(t, segment{2, 5}, fitSegment(lines, all, referenced, 20))
+	require.Equal(t, segment{3, 5}, fitSegment(lines, all, referenced, 9))
+	require.Equal(t, referenced, fitSegment(lines, all, referenced, 1), "referenced lines are kept")
+}
+
+func Test_formatHunk_maxHunkBytes(t *testing.T) {
+	hunk := ld.HunkRep{StartingLineNumber: 1, Lines: "long context\nflag-1\nx\n", FlagKey: "flag-1", Offsets: []ld.OffsetRep{{LineNumber: 2, StartColumn: 0, EndColumn: 6}}}
+	require.Equal(t, []ld.HunkRep{{StartingLineNumber: 2, Lines: "flag-1\nx\n", FlagKey: "flag-1", Offsets: hunk.Offsets}}, hunkFormat{maxBlankLines: -1, maxHunkBytes: 10}.formatHunk(hunk))
+}
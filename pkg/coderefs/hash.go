@@ -0,0 +1,87 @@
+package coderefs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// contentHash identifies a hunk across scans, so it can be tracked as line numbers change. It is a hash of the path,
+// flag key, and lines of the hunk, with whitespace collapsed and blank lines removed, so reformatting or moving a
+// reference doesn't change it, but editing the lines around it does.
+func contentHash(path string, hunk ld.HunkRep) string {
+	h := sha256.New()
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write([]byte(hunk.FlagKey))
+	h.Write([]byte{0})
+	for _, line := range strings.Split(hunk.Lines, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			h.Write([]byte(strings.Join(fields, " ")))
+			h.Write([]byte{'\n'})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// addContentHashes returns a copy of refs with the ContentHash of each hunk set. Hunks without lines, when ctxLines
+// is negative, aren't hashed, since they can't be told apart from other references to the flag in the same file.
+func addContentHashes(refs []ld.ReferenceHunksRep) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			if hunk.Lines != "" {
+				hunk.ContentHash = contentHash(ref.Path, hunk)
+			}
+			hunks = append(hunks, hunk)
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
+
+// movedHunks returns the number of hunks of each flag in head which have the same content as a hunk in base, but a
+// different starting line number. Hunks are hashed again rather than using their ContentHash, since base may have
+// been renamed to the paths in head, and results files written by earlier versions don't include hashes.
+func movedHunks(base, head []ld.ReferenceHunksRep) map[string]int {
+	baseLines := map[string][]int{}
+	for _, ref := range base {
+		for _, hunk := range ref.Hunks {
+			if hunk.Lines == "" {
+				continue
+			}
+			hash := contentHash(ref.Path, hunk)
+			baseLines[hash] = append(baseLines[hash], hunk.StartingLineNumber)
+		}
+	}
+
+	moved := map[string]int{}
+	for _, ref := range head {
+		for _, hunk := range ref.Hunks {
+			if hunk.Lines == "" {
+				continue
+			}
+			hash := contentHash(ref.Path, hunk)
+			lines := baseLines[hash]
+			if len(lines) == 0 {
+				continue
+			}
+			// Match each hunk in base at most once, preferring one which hasn't moved
+			match := 0
+			for i, line := range lines {
+				if line == hunk.StartingLineNumber {
+					match = i
+					break
+				}
+			}
+			if lines[match] != hunk.StartingLineNumber {
+				moved[hunk.FlagKey]++
+			}
+			baseLines[hash] = append(lines[:match:match], lines[match+1:]...)
+		}
+	}
+	return moved
+}
@@ -0,0 +1,57 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_contentHash(t *testing.T) {
+	hunk := ld.HunkRep{StartingLineNumber: 3, FlagKey: "flag-1", Lines: "if flag-1 {\n\treturn\n}\n"}
+	hash := contentHash("a.go", hunk)
+	require.Len(t, hash, 64)
+
+	// Moving or reformatting a hunk doesn't change its hash
+	moved := hunk
+	moved.StartingLineNumber = 10
+	moved.Lines = "if  flag-1 {\n\n    return\n}"
+	require.Equal(t, hash, contentHash("a.go", moved))
+
+	// Editing it, or finding it in another file or for another flag, does
+	edited := hunk
+	edited.Lines = "if flag-1 {\n\treturn nil\n}\n"
+	require.NotEqual(t, hash, contentHash("a.go", edited))
+	require.NotEqual(t, hash, contentHash("b.go", hunk))
+	other := hunk
+	other.FlagKey = "flag-2"
+	require.NotEqual(t, hash, contentHash("a.go", other))
+}
+
+func Test_addContentHashes(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Lines: "flag-1\n"}}}}
+	got := addContentHashes(refs)
+	require.Equal(t, contentHash("a.go", refs[0].Hunks[0]), got[0].Hunks[0].ContentHash)
+	require.Empty(t, refs[0].Hunks[0].ContentHash)
+
+	require.Empty(t, addContentHashes([]ld.ReferenceHunksRep{{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}}})[0].Hunks[0].ContentHash)
+}
+
+func Test_movedHunks(t *testing.T) {
+	base := []ld.ReferenceHunksRep{{Path: "a.go", Hunks: []ld.HunkRep{
+		{StartingLineNumber: 1, FlagKey: "flag-1", Lines: "flag-1\n"},
+		{StartingLineNumber: 5, FlagKey: "flag-1", Lines: "flag-1\n"},
+		{StartingLineNumber: 9, FlagKey: "flag-2", Lines: "flag-2\n"},
+		{StartingLineNumber: 12, FlagKey: "flag-3", Lines: "flag-3\n"},
+	}}}
+	head := []ld.ReferenceHunksRep{{Path: "a.go", Hunks: []ld.HunkRep{
+		// One of the identical hunks is unchanged, and the other moved
+		{StartingLineNumber: 5, FlagKey: "flag-1", Lines: "flag-1\n"},
+		{StartingLineNumber: 7, FlagKey: "flag-1", Lines: "flag-1\n"},
+		{StartingLineNumber: 11, FlagKey: "flag-2", Lines: "flag-2\n"},
+		{StartingLineNumber: 14, FlagKey: "flag-3", Lines: "flag-3 changed\n"},
+	}}}
+	require.Equal(t, map[string]int{"flag-1": 1, "flag-2": 1}, movedHunks(base, head))
+	require.Empty(t, movedHunks(base, base))
+}
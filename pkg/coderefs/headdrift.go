@@ -0,0 +1,96 @@
+package coderefs
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// maxHeadDriftRetries is the number of times the search is repeated if onHeadDrift is retry, and the checked out
+// commit keeps changing.
+const maxHeadDriftRetries = 2
+
+// headDrift returns the commit checked out in the workspace, and true if it isn't the commit being scanned. If the
+// checked out commit can't be read, it's assumed not to have changed.
+func headDrift(cmd command.Client) (string, bool) {
+	head, err := cmd.CheckedOutRevision()
+	if err != nil {
+		log.Warning.Printf("could not check whether the checked out commit changed during the scan: %s", err)
+		return "", false
+	}
+	return head, head != cmd.GitSha
+}
+
+// searchHead searches for references to flags, and applies the onHeadDrift option if the checked out commit changed
+// during the search. If the search is retried, cmd and b are updated for the commit searched.
+func searchHead(cmd *command.Client, b *branch, flags []string, ctxLines int, exclude *regexp.Regexp) (grepResultLines, error) {
+	for attempt := 0; ; attempt++ {
+		refs, err := b.findReferences(*cmd, flags, ctxLines, exclude)
+		if _, partial := err.(*command.BudgetExceededError); err != nil && !partial {
+			return refs, err
+		}
+		head, drifted := headDrift(*cmd)
+		if !drifted {
+			return refs, err
+		}
+		msg := fmt.Sprintf("the checked out commit changed from %s to %s during the search, so code references found may not match %s", cmd.GitSha, head, cmd.GitSha)
+		switch o.OnHeadDrift.Value() {
+		case o.OnHeadDriftWarn:
+			log.Warning.Printf("%s", msg)
+			return refs, err
+		case o.OnHeadDriftRetry:
+			if attempt == maxHeadDriftRetries {
+				return nil, fmt.Errorf("%s. It changed during %d searches, so the scan was stopped", msg, attempt+1)
+			}
+			log.Warning.Printf("%s. Searching %s instead", msg, head)
+			if err := reloadHead(cmd, b); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, fmt.Errorf("%s. Set onHeadDrift to %q to search the new commit, or avoid changing the repository during scans", msg, o.OnHeadDriftRetry)
+	}
+}
+
+// reloadHead updates cmd and b for the commit checked out in the workspace. It fails if a different branch is checked
+// out, since code references for the new branch shouldn't be sent under the name of the old one.
+func reloadHead(cmd *command.Client, b *branch) error {
+	err := cmd.ReloadRevision()
+	if err != nil {
+		return fmt.Errorf("could not read the checked out commit: %s", err)
+	}
+	name, sequenceTime, _, err := scannedBranch(*cmd)
+	if err != nil {
+		return err
+	}
+	if name != b.Name {
+		return fmt.Errorf("the checked out branch changed from %s to %s during the search", b.Name, name)
+	}
+	// Files which couldn't be searched may be readable in the new commit
+	cmd.FileErrors = &command.FileErrors{}
+	b.Head = cmd.GitSha
+	b.CommitTime = cmd.GitTimestamp * 1000 // seconds to milliseconds
+	b.SequenceTime = sequenceTime
+	return nil
+}
+
+// checkHeadAfterSearch applies the onHeadDrift option if the checked out commit changed after the search, before
+// results are written, or code references are sent, described by stage. The workspace is still read after the search,
+// e.g. to count references by language, or find flag owners, but the search isn't retried, so the scan fails unless
+// onHeadDrift is warn. It returns true if the commit changed, and the scan continued.
+func checkHeadAfterSearch(cmd command.Client, stage string) bool {
+	head, drifted := headDrift(cmd)
+	if !drifted {
+		return false
+	}
+	msg := fmt.Sprintf("the checked out commit changed from %s to %s %s, so code references found may not match %s", cmd.GitSha, head, stage, cmd.GitSha)
+	if o.OnHeadDrift.Value() == o.OnHeadDriftWarn {
+		log.Warning.Printf("%s", msg)
+		return true
+	}
+	log.Error.Fatalf("%s. Avoid changing the repository during scans, or set onHeadDrift to %q", msg, o.OnHeadDriftWarn)
+	return false
+}
@@ -0,0 +1,201 @@
+package coderefs
+
+import (
+	"html/template"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// directoryReport is the number of references to each flag in a directory, including its subdirectories, so
+// the parts of a repository most coupled to flags can be identified.
+type directoryReport struct {
+	// Path is the directory, relative to the repository root. Files in the root are reported as ".".
+	Path           string         `json:"path"`
+	ReferenceCount int            `json:"referenceCount"`
+	Flags          map[string]int `json:"flags"`
+}
+
+// makeDirectoryReports rolls references up into directories at most depth levels below the repository root,
+// sorted by reference count, descending.
+func makeDirectoryReports(refs []ld.ReferenceHunksRep, depth int) []directoryReport {
+	byDir := map[string]*directoryReport{}
+	for _, ref := range refs {
+		dir := directoryAtDepth(ref.Path, depth)
+		report, ok := byDir[dir]
+		if !ok {
+			report = &directoryReport{Path: dir, Flags: map[string]int{}}
+			byDir[dir] = report
+		}
+		for _, hunk := range ref.Hunks {
+			report.ReferenceCount += hunk.ReferenceCount()
+			report.Flags[hunk.FlagKey] += hunk.ReferenceCount()
+		}
+	}
+
+	reports := make([]directoryReport, 0, len(byDir))
+	for _, r := range byDir {
+		reports = append(reports, *r)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].ReferenceCount != reports[j].ReferenceCount {
+			return reports[i].ReferenceCount > reports[j].ReferenceCount
+		}
+		return reports[i].Path < reports[j].Path
+	})
+	return reports
+}
+
+// directoryAtDepth returns the directory containing a file, truncated to at most depth levels.
+func directoryAtDepth(p string, depth int) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return dir
+	}
+	parts := strings.Split(dir, "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// flagCount is a flag key and its number of references, for sorted output.
+type flagCount struct {
+	FlagKey string
+	Count   int
+}
+
+// topFlags returns the flags with the most references in a directory.
+func (r directoryReport) topFlags(max int) []flagCount {
+	flags := make([]flagCount, 0, len(r.Flags))
+	for k, v := range r.Flags {
+		flags = append(flags, flagCount{k, v})
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		if flags[i].Count != flags[j].Count {
+			return flags[i].Count > flags[j].Count
+		}
+		return flags[i].FlagKey < flags[j].FlagKey
+	})
+	if len(flags) > max {
+		flags = flags[:max]
+	}
+	return flags
+}
+
+const maxHtmlReportFlags = 10
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flag code references: {{.Branch}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+td.bar { width: 40%; }
+td.bar div { background: #405bff; height: 1em; }
+.flag { display: inline-block; margin: 0 4px 2px 0; padding: 0 4px; border-radius: 3px; }
+</style>
+</head>
+<body>
+<h1>Flag code references</h1>
+<p>{{.ReferenceCount}} references to {{.FlagCount}} flags in {{.FileCount}} files on {{.Branch}}{{if .Head}} at {{.Head}}{{end}}.</p>
+{{if .Directories}}
+<h2>References by directory</h2>
+<table>
+<tr><th>Directory</th><th>References</th><th></th><th>Most referenced flags</th></tr>
+{{range .Directories}}<tr>
+<td>{{.Path}}</td><td>{{.ReferenceCount}}</td>
+<td class="bar"><div style="width: {{.Width}}%; opacity: {{.Opacity}}"></div></td>
+<td>{{range .Flags}}<span class="flag" style="background: rgba(64, 91, 255, {{.Opacity}})">{{.FlagKey}} ({{.Count}})</span>{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}
+{{if .Reports}}
+<h2>Flags</h2>
+<table>
+<tr><th>Flag</th><th>References</th><th>Status</th></tr>
+{{range .Reports}}<tr><td>{{.FlagKey}}</td><td>{{.ReferenceCount}}</td><td>{{.Summary}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Links}}
+<h2>Code references</h2>
+<table>
+<tr><th>Flag</th><th>Location</th><th>Score</th><th>Same lines as</th></tr>
+{{range .Links}}<tr><td>{{.FlagKey}}</td><td><a href="{{.Url}}">{{.Path}}:{{.Line}}</a></td><td>{{.Score}}</td><td>{{.SharedFlags}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+type htmlLink struct {
+	FlagKey string
+	Path    string
+	Line    int
+	Url     string
+	Score   int
+	// SharedFlags lists the other flags referenced on the same lines
+	SharedFlags string
+}
+
+type htmlFlag struct {
+	flagCount
+	Opacity string
+}
+
+type htmlDirectory struct {
+	Path           string
+	ReferenceCount int
+	Width          int
+	Opacity        string
+	Flags          []htmlFlag
+}
+
+// writeHtmlReport writes a report of references by directory, shaded by their share of the most referenced
+// directory, the status of each flag if available, and links to each code reference if permalinks were generated,
+// with the highest scores first.
+func writeHtmlReport(w io.Writer, branchRep ld.BranchRep, directories []directoryReport, reports []flagReport) error {
+	flags := map[string]bool{}
+	links := []htmlLink{}
+	for _, hunk := range hunksByScore(branchRep.References) {
+		flags[hunk.FlagKey] = true
+		if hunk.Url != "" {
+			links = append(links, htmlLink{FlagKey: hunk.FlagKey, Path: hunk.Path, Line: hunk.StartingLineNumber, Url: hunk.Url, Score: hunk.Score, SharedFlags: strings.Join(hunk.SharedFlags, ", ")})
+		}
+	}
+
+	max := 1
+	if len(directories) > 0 && directories[0].ReferenceCount > max {
+		max = directories[0].ReferenceCount
+	}
+	shade := func(count int) string {
+		// Keep the lightest shades visible
+		return strconv.FormatFloat(0.15+0.85*float64(count)/float64(max), 'f', 2, 64)
+	}
+	dirs := make([]htmlDirectory, 0, len(directories))
+	for _, d := range directories {
+		dir := htmlDirectory{Path: d.Path, ReferenceCount: d.ReferenceCount, Width: 100 * d.ReferenceCount / max, Opacity: shade(d.ReferenceCount)}
+		for _, f := range d.topFlags(maxHtmlReportFlags) {
+			dir.Flags = append(dir.Flags, htmlFlag{f, shade(f.Count)})
+		}
+		dirs = append(dirs, dir)
+	}
+
+	return htmlReportTemplate.Execute(w, map[string]interface{}{
+		"Branch":         branchRep.Name,
+		"Head":           branchRep.Head,
+		"ReferenceCount": branchRep.TotalReferenceCount(),
+		"FlagCount":      len(flags),
+		"FileCount":      len(branchRep.References),
+		"Directories":    dirs,
+		"Reports":        reports,
+		"Links":          links,
+	})
+}
@@ -0,0 +1,50 @@
+package coderefs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_makeDirectoryReports(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "main.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+		{Path: "services/api/handler.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}, {FlagKey: "flag-2"}}},
+		{Path: "services/api/internal/db.go", Hunks: []ld.HunkRep{{FlagKey: "flag-2", Offsets: []ld.OffsetRep{{LineNumber: 1}, {LineNumber: 2}}}}},
+		{Path: "services/web/index.js", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+	}
+
+	require.Equal(t, []directoryReport{
+		{Path: "services", ReferenceCount: 5, Flags: map[string]int{"flag-1": 2, "flag-2": 3}},
+		{Path: ".", ReferenceCount: 1, Flags: map[string]int{"flag-1": 1}},
+	}, makeDirectoryReports(refs, 1))
+
+	require.Equal(t, []directoryReport{
+		{Path: "services/api", ReferenceCount: 4, Flags: map[string]int{"flag-1": 1, "flag-2": 3}},
+		{Path: ".", ReferenceCount: 1, Flags: map[string]int{"flag-1": 1}},
+		{Path: "services/web", ReferenceCount: 1, Flags: map[string]int{"flag-1": 1}},
+	}, makeDirectoryReports(refs, 2))
+}
+
+func Test_writeHtmlReport(t *testing.T) {
+	branchRep := ld.BranchRep{Name: "main", Head: "abc", References: []ld.ReferenceHunksRep{
+		{Path: "<b>web/index.js", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+	}}
+	var buf bytes.Buffer
+	require.NoError(t, writeHtmlReport(&buf, branchRep, makeDirectoryReports(branchRep.References, 1), nil))
+	html := buf.String()
+	require.Contains(t, html, "1 references to 1 flags in 1 files on main at abc.")
+	require.Contains(t, html, "<td>&lt;b&gt;web</td><td>1</td>")
+	require.Contains(t, html, "flag-1 (1)")
+	require.Contains(t, html, "width: 100%")
+	require.NotContains(t, html, "<b>")
+	require.NotContains(t, html, "Code references")
+
+	buf.Reset()
+	branchRep.References = addPermalinks(branchRep.References, "https://example.com/${sha}/${filePath}#L${lineNumber}", "abc")
+	require.NoError(t, writeHtmlReport(&buf, branchRep, nil, nil))
+	require.Contains(t, buf.String(), `<a href="https://example.com/abc/%3Cb%3Eweb/index.js#L0">&lt;b&gt;web/index.js:0</a>`)
+}
@@ -0,0 +1,73 @@
+package coderefs
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// matchesMetadataPattern returns true if a file, or a directory containing it, matches pattern. Patterns containing
+// / are matched against paths relative to the repository root, and other patterns against each file and directory
+// name.
+func matchesMetadataPattern(pattern, p string) bool {
+	if pattern == "" {
+		return true
+	}
+	elems := strings.Split(p, "/")
+	for i := range elems {
+		target := elems[i]
+		if strings.Contains(pattern, "/") {
+			target = strings.Join(elems[:i+1], "/")
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addHunkMetadata attaches the metadata fields matching the path of each file to its hunks, replacing any metadata
+// they had. Later fields override earlier fields with the same key. If plain, metadata is removed instead.
+func addHunkMetadata(refs []ld.ReferenceHunksRep, fields []o.HunkMetadataField, plain bool) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		var metadata map[string]string
+		if !plain {
+			for _, field := range fields {
+				if !matchesMetadataPattern(field.Pattern, ref.Path) {
+					continue
+				}
+				if metadata == nil {
+					metadata = map[string]string{}
+				}
+				metadata[field.Key] = field.Value
+			}
+		}
+		hunks := make([]ld.HunkRep, len(ref.Hunks))
+		for i, hunk := range ref.Hunks {
+			hunk.Metadata = metadata
+			hunks[i] = hunk
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
+
+// hunkMetadataFields returns the hunkMetadata options, which have already been validated.
+func hunkMetadataFields() []o.HunkMetadataField {
+	fields, _ := o.HunkMetadataFields()
+	return fields
+}
+
+// formatHunkMetadata formats metadata as key=value pairs separated by semicolons, sorted by key.
+func formatHunkMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
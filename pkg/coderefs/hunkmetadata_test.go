@@ -0,0 +1,46 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+func Test_matchesMetadataPattern(t *testing.T) {
+	require.True(t, matchesMetadataPattern("", "a/b.go"))
+	require.True(t, matchesMetadataPattern("services/payments", "services/payments/api/main.go"))
+	require.True(t, matchesMetadataPattern("services/*/api", "services/payments/api/main.go"))
+	require.False(t, matchesMetadataPattern("services/payments", "services/payments-v2/main.go"))
+	require.False(t, matchesMetadataPattern("api", "services/payments/api.go"))
+	require.True(t, matchesMetadataPattern("api", "services/payments/api/main.go"))
+	require.True(t, matchesMetadataPattern("*.sql", "db/migrations/001.sql"))
+	require.False(t, matchesMetadataPattern("db/*.sql", "db/migrations/001.sql"))
+}
+
+func Test_addHunkMetadata(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "services/payments/main.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}, {FlagKey: "flag-2"}}},
+		{Path: "web/app.ts", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Metadata: map[string]string{"stale": "true"}}}},
+	}
+	fields := []o.HunkMetadataField{
+		{Key: "tier", Value: "3"},
+		{Pattern: "services/payments", Key: "team", Value: "payments"},
+		{Pattern: "services/payments", Key: "tier", Value: "1"},
+	}
+	got := addHunkMetadata(refs, fields, false)
+	require.Equal(t, map[string]string{"tier": "1", "team": "payments"}, got[0].Hunks[0].Metadata)
+	require.Equal(t, map[string]string{"tier": "1", "team": "payments"}, got[0].Hunks[1].Metadata)
+	require.Equal(t, map[string]string{"tier": "3"}, got[1].Hunks[0].Metadata)
+	require.Nil(t, refs[0].Hunks[0].Metadata)
+
+	require.Nil(t, addHunkMetadata(refs, nil, false)[1].Hunks[0].Metadata)
+	require.Nil(t, addHunkMetadata(refs, fields, true)[0].Hunks[0].Metadata)
+}
+
+func Test_formatHunkMetadata(t *testing.T) {
+	require.Equal(t, "", formatHunkMetadata(nil))
+	require.Equal(t, "team=payments;tier=1", formatHunkMetadata(map[string]string{"tier": "1", "team": "payments"}))
+}
@@ -0,0 +1,226 @@
+package coderefs
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// Import reads code references produced by an external scanner from path, and sends them to
+// LaunchDarkly using the same validation, trimming, and upload steps as Scan.
+func Import(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Error.Fatalf("could not read code references from %s: %s", path, err)
+	}
+	var sig []byte
+	if o.ResultsVerifyKey.Value() != "" {
+		sig, err = ioutil.ReadFile(path + signatureSuffix)
+		if err != nil {
+			log.Error.Fatalf("could not read the signature of %s: %s", path, err)
+		}
+	}
+	importResults(path, data, sig)
+}
+
+// importResults sends the code references in data, the contents of the results file at path, to LaunchDarkly. If
+// the resultsVerifyKey option is provided, sig must be a valid signature of data.
+func importResults(path string, data, sig []byte) {
+	err := command.InitEnv(o.TmpDir.Value())
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	defer command.RemoveRunDir()
+
+	if keyPath := o.ResultsVerifyKey.Value(); keyPath != "" {
+		key, err := readVerifyKey(keyPath)
+		if err != nil {
+			log.Error.Fatalf("could not read resultsVerifyKey: %s", err)
+		}
+		if sig == nil {
+			log.Error.Fatalf("could not verify %s: it was not signed", path)
+		}
+		// The contents that were read are verified, so the file can't be replaced after it's verified
+		err = verifyResultsSignature(data, sig, key)
+		if err != nil {
+			log.Error.Fatalf("could not verify the signature of %s: %s", path, err)
+		}
+		log.Info.Printf("verified the signature of %s", path)
+	}
+	f, err := parseResultsFile(data)
+	if err != nil {
+		log.Error.Fatalf("could not read code references from %s: %s", path, err)
+	}
+
+	// The commit time is only known if the head is the checked out commit
+	commitTime := int64(0)
+	if f.Branch == "" || f.Head == "" {
+		cmd, err := command.NewVcsClient(o.Vcs.Value(), o.RepoDir())
+		if err != nil {
+			log.Error.Fatalf("branch and head were not provided by %s, and could not be determined from the repository: %s", path, err)
+		}
+		if f.Branch == "" {
+			f.Branch = cmd.GitBranch
+		}
+		if f.Head == "" {
+			f.Head = cmd.GitSha
+			commitTime = cmd.GitTimestamp
+		}
+	}
+
+	if branchName := o.BranchName.Value(); branchName != "" {
+		f.Branch = branchName
+	}
+	if f.Branch == "" {
+		log.Error.Fatalf("branch was not provided by %s, and no branch is checked out. Provide the branchName option", path)
+	}
+
+	projKey := o.ProjKey.Value()
+	if len(projKeys(projKey)) > 1 {
+		log.Error.Fatalf("import only supports a single projKey")
+	}
+	ldApi, repoParams := initApiClient(projKey)
+	filteredFlags, _ := getFilteredFlags(ldApi, projKey)
+
+	branchRep := ld.BranchRep{
+		Name:             strings.TrimPrefix(f.Branch, "refs/heads/"),
+		Head:             f.Head,
+		UpdateSequenceId: updateSequenceId(commitTime, true),
+		SyncTime:         makeTimestamp(),
+		CommitTime:       commitTime * 1000, // seconds to milliseconds
+		IsDefault:        o.DefaultBranch.Value() == f.Branch,
+		References:       validateImportedReferences(f.References, projKey, filteredFlags, o.ContextLines.Value()),
+	}
+	branchRep.References = formatReferences(branchRep.References, hunkFormatOptions())
+	branchRep.References = classifyTestCode(branchRep.References, excludeTestReferences())
+	branchRep.References = annotateLanguages(branchRep.References, o.PlainHunks.Value())
+	branchRep.References = addHunkMetadata(branchRep.References, hunkMetadataFields(), o.PlainHunks.Value())
+	branchRep.References = addContentHashes(branchRep.References)
+	log.Info.Printf("sending %d imported code references in %d hunks across %d files to LaunchDarkly for project: %s", branchRep.TotalReferenceCount(), branchRep.TotalHunkCount(), len(branchRep.References), projKey)
+
+	putBranch(ldApi, branchRep, repoParams.Name, nil)
+}
+
+// importedPath returns the cleaned, slash separated form of an imported path, and false if it isn't a path to a file
+// inside the repository, e.g. /etc/x or a/../../x.
+func importedPath(p string) (string, bool) {
+	if p == "" || filepath.IsAbs(p) {
+		return "", false
+	}
+	p = path.Clean(filepath.ToSlash(p))
+	if p == "." || p == ".." || strings.HasPrefix(p, "/") || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+	return p, true
+}
+
+// validateImportedReferences drops imported hunks that are malformed or reference unknown flag keys,
+// and applies the same payload limits and line truncation used for scanned references.
+func validateImportedReferences(refs []ld.ReferenceHunksRep, projKey string, flags []string, ctxLines int) []ld.ReferenceHunksRep {
+	knownFlags := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		knownFlags[flag] = true
+	}
+	unknownFlags := map[string]int{}
+
+	reps := []ld.ReferenceHunksRep{}
+	numHunks := 0
+	for _, ref := range refs {
+		if len(reps) >= maxFileCount {
+			log.Warning.Printf("imported %d files with code references, which exceeded the limit of %d", len(refs), maxFileCount)
+			break
+		}
+		if numHunks > maxHunkCount {
+			log.Warning.Printf("imported %d code references across all files, which exceeeded the limit of %d. skipping remaining code references", numHunks, maxHunkCount)
+			break
+		}
+
+		path, ok := importedPath(ref.Path)
+		if !ok {
+			log.Warning.Printf("skipping imported code references with invalid path %q: paths must be relative to the repository root, and inside it", ref.Path)
+			continue
+		}
+
+		hunks := []ld.HunkRep{}
+		for _, hunk := range ref.Hunks {
+			if !knownFlags[hunk.FlagKey] {
+				unknownFlags[hunk.FlagKey]++
+				continue
+			}
+			if hunk.StartingLineNumber < 1 {
+				log.Warning.Printf("skipping imported code reference for flag %s in %s with invalid starting line number %d", hunk.FlagKey, path, hunk.StartingLineNumber)
+				continue
+			}
+			hunk.ProjKey = projKey
+			hunk.Offsets = validOffsets(hunk)
+			hunk.Url = ""
+			hunk.Score = 0
+			hunk.SharedFlags = nil
+			if ctxLines < 0 {
+				hunk.Lines = ""
+			} else {
+				hunk.Lines = truncateLines(hunk.Lines)
+			}
+			hunks = append(hunks, hunk)
+		}
+		if len(hunks) == 0 {
+			continue
+		}
+
+		if len(hunks) > maxHunksPerFileCount {
+			log.Warning.Printf("imported %d code references in %s, which exceeded the limit of %d, truncating file hunks", len(hunks), path, maxHunksPerFileCount)
+			hunks = hunks[0:maxHunksPerFileCount]
+		}
+		numHunks += len(hunks)
+
+		reps = append(reps, ld.ReferenceHunksRep{Path: path, Hunks: hunks})
+	}
+
+	for key, count := range unknownFlags {
+		log.Warning.Printf("skipping %d imported code references for flag key %q, which was not found in project %s", count, key, projKey)
+	}
+
+	return reps
+}
+
+// truncateLines applies truncateLine to each line of a multiline hunk.
+func truncateLines(lines string) string {
+	var sb strings.Builder
+	for _, line := range strings.SplitAfter(lines, "\n") {
+		text := strings.TrimSuffix(line, "\n")
+		sb.WriteString(truncateLine(text))
+		if len(text) != len(line) {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// validOffsets drops offsets that are not within a hunk's lines.
+func validOffsets(hunk ld.HunkRep) []ld.OffsetRep {
+	if len(hunk.Offsets) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(hunk.Lines, "\n"), "\n")
+	offsets := []ld.OffsetRep{}
+	for _, offset := range hunk.Offsets {
+		i := offset.LineNumber - hunk.StartingLineNumber
+		if i < 0 || offset.StartColumn < 0 || offset.EndColumn < offset.StartColumn {
+			continue
+		}
+		if hunk.Lines != "" && (i >= len(lines) || offset.EndColumn > len(lines[i])) {
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) < len(hunk.Offsets) {
+		log.Warning.Printf("skipping %d imported column offsets for flag %s outside of the code reference", len(hunk.Offsets)-len(offsets), hunk.FlagKey)
+	}
+	return offsets
+}
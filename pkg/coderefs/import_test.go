@@ -0,0 +1,165 @@
+package coderefs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+func TestMain(m *testing.M) {
+	log.Init(false)
+	os.Exit(m.Run())
+}
+
+func Test_validateImportedReferences(t *testing.T) {
+	projKey := "test"
+	flags := []string{"flag-1", "flag-2"}
+
+	tests := []struct {
+		name     string
+		ctxLines int
+		refs     []ld.ReferenceHunksRep
+		want     []ld.ReferenceHunksRep
+	}{
+		{
+			name:     "no references",
+			ctxLines: 0,
+			refs:     []ld.ReferenceHunksRep{},
+			want:     []ld.ReferenceHunksRep{},
+		},
+		{
+			name:     "sets project key and normalizes paths",
+			ctxLines: 0,
+			refs: []ld.ReferenceHunksRep{
+				{Path: "./a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "flag-1\n", FlagKey: "flag-1"}}},
+			},
+			want: []ld.ReferenceHunksRep{
+				{Path: "a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "flag-1\n", ProjKey: projKey, FlagKey: "flag-1"}}},
+			},
+		},
+		{
+			name:     "drops unknown flags and invalid line numbers",
+			ctxLines: 0,
+			refs: []ld.ReferenceHunksRep{
+				{Path: "a/b", Hunks: []ld.HunkRep{
+					{StartingLineNumber: 1, Lines: "unknown-flag\n", FlagKey: "unknown-flag"},
+					{StartingLineNumber: 0, Lines: "flag-1\n", FlagKey: "flag-1"},
+					{StartingLineNumber: 3, Lines: "flag-2\n", FlagKey: "flag-2"},
+				}},
+				{Path: "a/c", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "unknown-flag\n", FlagKey: "unknown-flag"}}},
+			},
+			want: []ld.ReferenceHunksRep{
+				{Path: "a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 3, Lines: "flag-2\n", ProjKey: projKey, FlagKey: "flag-2"}}},
+			},
+		},
+		{
+			name:     "drops invalid paths",
+			ctxLines: 0,
+			refs: []ld.ReferenceHunksRep{
+				{Path: "", Hunks: []ld.HunkRep{{StartingLineNumber: 1, FlagKey: "flag-1"}}},
+				{Path: "/a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 1, FlagKey: "flag-1"}}},
+				{Path: "/etc/x", Hunks: []ld.HunkRep{{StartingLineNumber: 1, FlagKey: "flag-1"}}},
+				{Path: "../x", Hunks: []ld.HunkRep{{StartingLineNumber: 1, FlagKey: "flag-1"}}},
+				{Path: "a/../../x", Hunks: []ld.HunkRep{{StartingLineNumber: 1, FlagKey: "flag-1"}}},
+				{Path: "a/..", Hunks: []ld.HunkRep{{StartingLineNumber: 1, FlagKey: "flag-1"}}},
+			},
+			want: []ld.ReferenceHunksRep{},
+		},
+		{
+			name:     "cleans paths",
+			ctxLines: 0,
+			refs: []ld.ReferenceHunksRep{
+				{Path: "a//b/./../c", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "flag-1\n", FlagKey: "flag-1"}}},
+			},
+			want: []ld.ReferenceHunksRep{
+				{Path: "a/c", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "flag-1\n", ProjKey: projKey, FlagKey: "flag-1"}}},
+			},
+		},
+		{
+			name:     "drops offsets outside of the hunk",
+			ctxLines: 0,
+			refs: []ld.ReferenceHunksRep{
+				{Path: "a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 2, Lines: "flag-1\n", FlagKey: "flag-1", Offsets: []ld.OffsetRep{
+					{LineNumber: 2, StartColumn: 0, EndColumn: 6},
+					{LineNumber: 1, StartColumn: 0, EndColumn: 6},
+					{LineNumber: 2, StartColumn: 1, EndColumn: 7},
+				}}}},
+			},
+			want: []ld.ReferenceHunksRep{
+				{Path: "a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 2, Lines: "flag-1\n", ProjKey: projKey, FlagKey: "flag-1", Offsets: []ld.OffsetRep{
+					{LineNumber: 2, StartColumn: 0, EndColumn: 6},
+				}}}},
+			},
+		},
+		{
+			name:     "removes source code with negative context lines",
+			ctxLines: -1,
+			refs: []ld.ReferenceHunksRep{
+				{Path: "a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "flag-1\n", FlagKey: "flag-1"}}},
+			},
+			want: []ld.ReferenceHunksRep{
+				{Path: "a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 1, ProjKey: projKey, FlagKey: "flag-1"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateImportedReferences(tt.refs, projKey, flags, tt.ctxLines)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_validateImportedReferencesLimitsHunksPerFile(t *testing.T) {
+	hunks := make([]ld.HunkRep, maxHunksPerFileCount+1)
+	for i := range hunks {
+		hunks[i] = ld.HunkRep{StartingLineNumber: i + 1, FlagKey: "flag-1"}
+	}
+
+	got := validateImportedReferences([]ld.ReferenceHunksRep{{Path: "a", Hunks: hunks}}, "test", []string{"flag-1"}, 0)
+	require.Len(t, got, 1)
+	require.Len(t, got[0].Hunks, maxHunksPerFileCount)
+}
+
+func Test_truncateLines(t *testing.T) {
+	veryLongLine := strings.Repeat("a", maxLineCharCount+1)
+
+	tests := []struct {
+		name  string
+		lines string
+		want  string
+	}{
+		{
+			name:  "empty",
+			lines: "",
+			want:  "",
+		},
+		{
+			name:  "preserves newlines",
+			lines: "a\nb\n",
+			want:  "a\nb\n",
+		},
+		{
+			name:  "no trailing newline",
+			lines: "a\nb",
+			want:  "a\nb",
+		},
+		{
+			name:  "truncates long lines",
+			lines: "a\n" + veryLongLine + "\n",
+			want:  "a\n" + veryLongLine[0:maxLineCharCount] + "…\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, truncateLines(tt.lines))
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package coderefs
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/lang"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// otherLanguage is the language of files which aren't detected by lang.Detect.
+const otherLanguage = "other"
+
+// languageStats counts the files scanned in each language, and the files and references to flags in them, with the
+// most referenced languages first. files are the paths of the files scanned, which should include every path in
+// refs.
+func languageStats(files []string, refs []ld.ReferenceHunksRep) []ld.LanguageStatsRep {
+	stats := map[string]*ld.LanguageStatsRep{}
+	stat := func(path string) *ld.LanguageStatsRep {
+		l := lang.Detect(path)
+		if l == "" {
+			l = otherLanguage
+		}
+		if stats[l] == nil {
+			stats[l] = &ld.LanguageStatsRep{Language: l}
+		}
+		return stats[l]
+	}
+	scanned := map[string]bool{}
+	for _, f := range files {
+		scanned[f] = true
+		stat(f).FileCount++
+	}
+	for _, ref := range refs {
+		s := stat(ref.Path)
+		if !scanned[ref.Path] {
+			// Files which were searched, but not listed, e.g. because they were created during the scan
+			s.FileCount++
+		}
+		s.ReferencedFileCount++
+		for _, hunk := range ref.Hunks {
+			s.ReferenceCount += hunk.ReferenceCount()
+		}
+	}
+
+	ret := make([]ld.LanguageStatsRep, 0, len(stats))
+	for _, s := range stats {
+		ret = append(ret, *s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ReferenceCount != ret[j].ReferenceCount {
+			return ret[i].ReferenceCount > ret[j].ReferenceCount
+		}
+		return ret[i].Language < ret[j].Language
+	})
+	return ret
+}
+
+// annotateLanguages sets the language of each hunk from the path of its file. If plain, languages are removed
+// instead, so hunks are sent in their original format.
+func annotateLanguages(refs []ld.ReferenceHunksRep, plain bool) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		l := ""
+		if !plain {
+			l = lang.Detect(ref.Path)
+		}
+		hunks := make([]ld.HunkRep, len(ref.Hunks))
+		for i, hunk := range ref.Hunks {
+			hunk.Language = l
+			hunks[i] = hunk
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
+
+// languageSummary describes the references in each language which has any, e.g. "go: 12 references in 3 of 40
+// files, python: 2 references in 1 of 7 files".
+func languageSummary(stats []ld.LanguageStatsRep) string {
+	parts := []string{}
+	for _, s := range stats {
+		if s.ReferenceCount > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d references in %d of %d files", s.Language, s.ReferenceCount, s.ReferencedFileCount, s.FileCount))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// scannedLanguages counts the files and references in each language, and logs the languages with references.
+func scannedLanguages(cmd command.Client, exclude *regexp.Regexp, refs []ld.ReferenceHunksRep) []ld.LanguageStatsRep {
+	files, err := cmd.ListFiles()
+	if err != nil {
+		log.Debug.Printf("could not list files to count references by language: %s", err)
+		return nil
+	}
+	scanned := make([]string, 0, len(files))
+	for _, f := range files {
+		if exclude == nil || exclude.String() == "" || !exclude.MatchString(f) {
+			scanned = append(scanned, f)
+		}
+	}
+	stats := languageStats(scanned, refs)
+	if summary := languageSummary(stats); summary != "" {
+		log.Info.Printf("code references by language: %s", summary)
+	}
+	return stats
+}
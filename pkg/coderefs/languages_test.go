@@ -0,0 +1,42 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_languageStats(t *testing.T) {
+	files := []string{"main.go", "util.go", "app.ts", "README", "Dockerfile"}
+	refs := []ld.ReferenceHunksRep{
+		{Path: "main.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Offsets: []ld.OffsetRep{{}, {}}}, {FlagKey: "flag-2"}}},
+		{Path: "app.ts", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+		{Path: "new.py", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+	}
+	stats := languageStats(files, refs)
+	require.Equal(t, []ld.LanguageStatsRep{
+		{Language: "go", FileCount: 2, ReferencedFileCount: 1, ReferenceCount: 3},
+		{Language: "python", FileCount: 1, ReferencedFileCount: 1, ReferenceCount: 1},
+		{Language: "typescript", FileCount: 1, ReferencedFileCount: 1, ReferenceCount: 1},
+		{Language: "dockerfile", FileCount: 1},
+		{Language: "other", FileCount: 1},
+	}, stats)
+	require.Equal(t, "go: 3 references in 1 of 2 files, python: 1 references in 1 of 1 files, typescript: 1 references in 1 of 1 files", languageSummary(stats))
+}
+
+func Test_annotateLanguages(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "main.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}, {FlagKey: "flag-2"}}},
+		{Path: "README", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Language: "markdown"}}},
+	}
+	annotated := annotateLanguages(refs, false)
+	require.Equal(t, "go", annotated[0].Hunks[0].Language)
+	require.Equal(t, "go", annotated[0].Hunks[1].Language)
+	require.Equal(t, "", annotated[1].Hunks[0].Language)
+	require.Equal(t, "", refs[0].Hunks[0].Language)
+
+	plain := annotateLanguages(annotated, true)
+	require.Equal(t, "", plain[0].Hunks[0].Language)
+}
@@ -0,0 +1,59 @@
+package coderefs
+
+import (
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// memberLookup returns the members of the LaunchDarkly account, retrieving them the first time it's called, so
+// maintainers and owners share a single lookup per scan.
+func memberLookup(ldApi ld.ApiClient) func() ([]ld.Member, error) {
+	var members []ld.Member
+	var err error
+	retrieved := false
+	return func() ([]ld.Member, error) {
+		if !retrieved {
+			members, err = ldApi.GetMembers()
+			retrieved = true
+		}
+		return members, err
+	}
+}
+
+// maintainerEmails returns the email of the maintainer of each flag, keyed by flag key. Members are only looked up if
+// a maintainer is identified by id alone. Maintainers who can't be found are omitted.
+func maintainerEmails(maintainers map[string]ld.FlagMaintainer, members func() ([]ld.Member, error)) (map[string]string, error) {
+	emails := map[string]string{}
+	var emailsById map[string]string
+	for flagKey, m := range maintainers {
+		if m.Email != "" {
+			emails[flagKey] = m.Email
+			continue
+		}
+		if emailsById == nil {
+			list, err := members()
+			if err != nil {
+				return emails, err
+			}
+			emailsById = make(map[string]string, len(list))
+			for _, member := range list {
+				emailsById[member.Id] = member.Email
+			}
+		}
+		if email := emailsById[m.Id]; email != "" {
+			emails[flagKey] = email
+		}
+	}
+	return emails, nil
+}
+
+// assignMaintainers adds the maintainer of each flag to its report.
+func assignMaintainers(reports []flagReport, emails map[string]string) {
+	for i, r := range reports {
+		email := emails[r.FlagKey]
+		if email == "" {
+			continue
+		}
+		reports[i].Maintainer = email
+		reports[i].Summary += ", maintained by " + email
+	}
+}
@@ -0,0 +1,39 @@
+package coderefs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_maintainerEmails(t *testing.T) {
+	lookups := 0
+	members := func() ([]ld.Member, error) {
+		lookups++
+		return []ld.Member{{Id: "m2", Email: "john@example.com"}}, nil
+	}
+	emails, err := maintainerEmails(map[string]ld.FlagMaintainer{"flag-1": {Id: "m1", Email: "jane@example.com"}}, members)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"flag-1": "jane@example.com"}, emails)
+	require.Equal(t, 0, lookups)
+
+	emails, err = maintainerEmails(map[string]ld.FlagMaintainer{"flag-2": {Id: "m2"}, "flag-3": {Id: "m2"}, "flag-4": {Id: "m4"}}, members)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"flag-2": "john@example.com", "flag-3": "john@example.com"}, emails)
+	require.Equal(t, 1, lookups)
+
+	_, err = maintainerEmails(map[string]ld.FlagMaintainer{"flag-2": {Id: "m2"}}, func() ([]ld.Member, error) { return nil, errors.New("forbidden") })
+	require.Error(t, err)
+}
+
+func Test_assignMaintainers(t *testing.T) {
+	reports := []flagReport{{FlagKey: "flag-1", Summary: "not referenced"}, {FlagKey: "flag-2", Summary: "not referenced"}}
+	assignMaintainers(reports, map[string]string{"flag-1": "jane@example.com"})
+	require.Equal(t, []flagReport{
+		{FlagKey: "flag-1", Summary: "not referenced, maintained by jane@example.com", Maintainer: "jane@example.com"},
+		{FlagKey: "flag-2", Summary: "not referenced"},
+	}, reports)
+}
@@ -0,0 +1,43 @@
+package coderefs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/container"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// uploadMetadata returns the metadata sent with code references, if the uploadMetadata option is enabled.
+func uploadMetadata() *ld.UploadMetadata {
+	if !o.UploadMetadata.Value() {
+		return nil
+	}
+	return &ld.UploadMetadata{
+		CiJobUrl:   container.JobUrl(),
+		Runner:     container.Runner(),
+		ConfigHash: configHash(o.ConfigFileOptions()),
+	}
+}
+
+// configHash returns a hash of options in the form written to config files, which excludes secrets and the location
+// of the repository. updateSequenceId is excluded too, since it usually changes every time the scanner runs.
+func configHash(config yaml.MapSlice) string {
+	filtered := yaml.MapSlice{}
+	for _, item := range config {
+		if item.Key != string(o.UpdateSequenceId) {
+			filtered = append(filtered, item)
+		}
+	}
+	data, err := yaml.Marshal(filtered)
+	if err != nil {
+		log.Debug.Printf("could not hash options: %s", err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,18 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func Test_configHash(t *testing.T) {
+	config := yaml.MapSlice{{Key: "contextLines", Value: 1}, {Key: "projKey", Value: "default"}}
+	withSequenceId := append(yaml.MapSlice{}, config...)
+	withSequenceId = append(withSequenceId, yaml.MapItem{Key: "updateSequenceId", Value: 100})
+
+	require.Len(t, configHash(config), 64)
+	require.Equal(t, configHash(config), configHash(withSequenceId), "updateSequenceId should not change the hash")
+	require.NotEqual(t, configHash(config), configHash(config[:1]))
+}
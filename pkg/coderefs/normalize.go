@@ -0,0 +1,77 @@
+package coderefs
+
+import (
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// canonicalFlag is a flag key, as it's known to LaunchDarkly, and its project.
+type canonicalFlag struct {
+	Key     string
+	ProjKey string
+}
+
+// normalizeFlagKeys applies the normalizeFlagKey rules for each flag's projects, and returns the keys to search for,
+// and the canonical flags each searched key is attributed to. flagProjects may be nil if only one project, projKey,
+// is scanned. If no rules are provided, flags are returned unchanged, and the canonical flags are nil.
+func normalizeFlagKeys(flags []string, flagProjects map[string][]string, projKey string, rules []o.FlagKeyRule) ([]string, map[string][]canonicalFlag) {
+	if len(rules) == 0 {
+		return flags, nil
+	}
+	searched := []string{}
+	canonical := map[string][]canonicalFlag{}
+	omitted := 0
+	for _, flag := range flags {
+		projects := flagProjects[flag]
+		if flagProjects == nil {
+			projects = []string{projKey}
+		}
+		for _, proj := range projects {
+			key := normalizeFlagKey(flag, proj, rules)
+			if len(key) < minFlagKeyLen {
+				log.Debug.Printf("omitting flag %s in project %s, since its normalized key %q is shorter than the minimum (%d)", flag, proj, key, minFlagKeyLen)
+				omitted++
+				continue
+			}
+			if _, ok := canonical[key]; !ok {
+				searched = append(searched, key)
+			} else {
+				log.Debug.Printf("flag %s in project %s normalizes to %s, which is shared with another flag", flag, proj, key)
+			}
+			canonical[key] = append(canonical[key], canonicalFlag{Key: flag, ProjKey: proj})
+		}
+	}
+	if omitted > 0 {
+		log.Warning.Printf("omitting %d flags with normalized keys less than minimum (%d)", omitted, minFlagKeyLen)
+	}
+	return searched, canonical
+}
+
+// normalizeFlagKey applies the rules for projKey to flag, in order.
+func normalizeFlagKey(flag, projKey string, rules []o.FlagKeyRule) string {
+	for _, rule := range rules {
+		if rule.ProjKey == "" || rule.ProjKey == projKey {
+			flag = rule.Pattern.ReplaceAllString(flag, rule.Replacement)
+		}
+	}
+	return flag
+}
+
+// attributeFlagKeys replaces the normalized flag key of each hunk with its canonical flag key and project. Hunks for
+// normalized keys shared by more than one flag are repeated for each flag.
+func attributeFlagKeys(refs []ld.ReferenceHunksRep, canonical map[string][]canonicalFlag) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			for _, flag := range canonical[hunk.FlagKey] {
+				hunk.FlagKey = flag.Key
+				hunk.ProjKey = flag.ProjKey
+				hunks = append(hunks, hunk)
+			}
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
@@ -0,0 +1,52 @@
+package coderefs
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+func Test_normalizeFlagKeys(t *testing.T) {
+	rules := []o.FlagKeyRule{
+		{Pattern: regexp.MustCompile(`^proj\.`), Replacement: ""},
+		{ProjKey: "web", Pattern: regexp.MustCompile(`-(\w+)$`), Replacement: "_$1"},
+	}
+
+	flags, canonical := normalizeFlagKeys([]string{"flag-a", "proj.flag-a"}, nil, "default", nil)
+	require.Equal(t, []string{"flag-a", "proj.flag-a"}, flags)
+	require.Nil(t, canonical)
+
+	flags, canonical = normalizeFlagKeys([]string{"proj.flag-a", "proj.ab", "flag-a", "flag-b"}, nil, "default", rules)
+	require.Equal(t, []string{"flag-a", "flag-b"}, flags)
+	require.Equal(t, map[string][]canonicalFlag{
+		"flag-a": {{Key: "proj.flag-a", ProjKey: "default"}, {Key: "flag-a", ProjKey: "default"}},
+		"flag-b": {{Key: "flag-b", ProjKey: "default"}},
+	}, canonical)
+
+	flags, canonical = normalizeFlagKeys([]string{"proj.flag-a"}, map[string][]string{"proj.flag-a": {"default", "web"}}, "default,web", rules)
+	require.Equal(t, []string{"flag-a", "flag_a"}, flags)
+	require.Equal(t, map[string][]canonicalFlag{
+		"flag-a": {{Key: "proj.flag-a", ProjKey: "default"}},
+		"flag_a": {{Key: "proj.flag-a", ProjKey: "web"}},
+	}, canonical)
+}
+
+func Test_attributeFlagKeys(t *testing.T) {
+	canonical := map[string][]canonicalFlag{
+		"flag-a": {{Key: "proj.flag-a", ProjKey: "default"}, {Key: "flag-a", ProjKey: "default"}},
+		"flag_a": {{Key: "proj.flag-a", ProjKey: "web"}},
+	}
+	refs := []ld.ReferenceHunksRep{{Path: "a.go", Hunks: []ld.HunkRep{
+		{StartingLineNumber: 1, FlagKey: "flag-a"},
+		{StartingLineNumber: 5, FlagKey: "flag_a"},
+	}}}
+	require.Equal(t, []ld.ReferenceHunksRep{{Path: "a.go", Hunks: []ld.HunkRep{
+		{StartingLineNumber: 1, ProjKey: "default", FlagKey: "proj.flag-a"},
+		{StartingLineNumber: 1, ProjKey: "default", FlagKey: "flag-a"},
+		{StartingLineNumber: 5, ProjKey: "web", FlagKey: "proj.flag-a"},
+	}}}, attributeFlagKeys(refs, canonical))
+}
@@ -0,0 +1,102 @@
+package coderefs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// blamer returns the email address of the author of each of lines in a file, keyed by line number.
+type blamer func(path string, lines []int) (map[int]string, error)
+
+// flagOwners returns the emails of LaunchDarkly members who authored lines referencing each flag, keyed by flag key,
+// ordered by the number of referencing lines they authored, descending. Authors who aren't members are omitted,
+// since they may have left the organization.
+func flagOwners(refs []ld.ReferenceHunksRep, members []ld.Member, blame blamer) map[string][]string {
+	memberEmails := map[string]string{}
+	for _, m := range members {
+		memberEmails[strings.ToLower(m.Email)] = m.Email
+	}
+
+	lineCounts := map[string]map[string]int{}
+	for _, ref := range refs {
+		flagLines := map[int][]string{}
+		lines := []int{}
+		for _, hunk := range ref.Hunks {
+			for _, line := range hunkReferenceLines(hunk) {
+				if _, ok := flagLines[line]; !ok {
+					lines = append(lines, line)
+				}
+				flagLines[line] = append(flagLines[line], hunk.FlagKey)
+			}
+		}
+		sort.Ints(lines)
+		// Each file is only blamed once, for every line referencing a flag
+		authors, err := blame(ref.Path, lines)
+		if err != nil {
+			log.Debug.Printf("could not find the authors of %s: %s", ref.Path, err)
+			continue
+		}
+		for line, email := range authors {
+			email, ok := memberEmails[strings.ToLower(email)]
+			if !ok {
+				continue
+			}
+			for _, flagKey := range flagLines[line] {
+				if lineCounts[flagKey] == nil {
+					lineCounts[flagKey] = map[string]int{}
+				}
+				lineCounts[flagKey][email]++
+			}
+		}
+	}
+
+	owners := map[string][]string{}
+	for flagKey, counts := range lineCounts {
+		emails := make([]string, 0, len(counts))
+		for email := range counts {
+			emails = append(emails, email)
+		}
+		sort.Slice(emails, func(i, j int) bool {
+			if counts[emails[i]] != counts[emails[j]] {
+				return counts[emails[i]] > counts[emails[j]]
+			}
+			return emails[i] < emails[j]
+		})
+		owners[flagKey] = emails
+	}
+	return owners
+}
+
+// hunkReferenceLines returns the line numbers of the flag references in a hunk. Every line is returned for hunks
+// without offsets.
+func hunkReferenceLines(hunk ld.HunkRep) []int {
+	lines := []int{}
+	if len(hunk.Offsets) == 0 {
+		for i := 0; i < strings.Count(hunk.Lines, "\n"); i++ {
+			lines = append(lines, hunk.StartingLineNumber+i)
+		}
+		return lines
+	}
+	seen := map[int]bool{}
+	for _, offset := range hunk.Offsets {
+		if !seen[offset.LineNumber] {
+			seen[offset.LineNumber] = true
+			lines = append(lines, offset.LineNumber)
+		}
+	}
+	return lines
+}
+
+// assignOwners adds the suggested owners of each flag to its report.
+func assignOwners(reports []flagReport, owners map[string][]string) {
+	for i, r := range reports {
+		if len(owners[r.FlagKey]) == 0 {
+			continue
+		}
+		reports[i].Owners = owners[r.FlagKey]
+		reports[i].Summary += ", suggested owner " + owners[r.FlagKey][0]
+	}
+}
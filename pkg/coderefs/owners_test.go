@@ -0,0 +1,51 @@
+package coderefs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_flagOwners(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 1, Lines: "x\nflag-1\nflag-1 flag-2\n", FlagKey: "flag-1", Offsets: []ld.OffsetRep{{LineNumber: 2}, {LineNumber: 3}}},
+			{StartingLineNumber: 3, Lines: "flag-1 flag-2\n", FlagKey: "flag-2", Offsets: []ld.OffsetRep{{LineNumber: 3, StartColumn: 7}}},
+		}},
+		{Path: "b.go", Hunks: []ld.HunkRep{{StartingLineNumber: 5, Lines: "flag-1\n", FlagKey: "flag-1"}}},
+		{Path: "missing.go", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "flag-2\n", FlagKey: "flag-2"}}},
+	}
+	authors := map[string]map[int]string{
+		"a.go": {1: "jane@example.com", 2: "John@Example.com", 3: "jane@example.com"},
+		"b.go": {5: "john@example.com"},
+	}
+	blamed := map[string][]int{}
+	blame := func(path string, lines []int) (map[int]string, error) {
+		blamed[path] = lines
+		if authors[path] == nil {
+			return nil, errors.New("no such path")
+		}
+		return authors[path], nil
+	}
+	members := []ld.Member{{Email: "jane@example.com"}, {Email: "john@example.com"}}
+
+	owners := flagOwners(refs, members, blame)
+	require.Equal(t, map[string][]string{
+		"flag-1": {"john@example.com", "jane@example.com"},
+		"flag-2": {"jane@example.com"},
+	}, owners)
+	// Only lines referencing flags are blamed
+	require.Equal(t, []int{2, 3}, blamed["a.go"])
+
+	// Authors who aren't members aren't suggested
+	require.Empty(t, flagOwners(refs, []ld.Member{{Email: "someone@example.com"}}, blame))
+
+	reports := []flagReport{{FlagKey: "flag-1", Summary: "referenced 3 times"}, {FlagKey: "flag-3", Summary: "not referenced"}}
+	assignOwners(reports, owners)
+	require.Equal(t, "referenced 3 times, suggested owner john@example.com", reports[0].Summary)
+	require.Equal(t, []string{"john@example.com", "jane@example.com"}, reports[0].Owners)
+	require.Equal(t, "not referenced", reports[1].Summary)
+}
@@ -0,0 +1,62 @@
+package coderefs
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// permalinkTemplate returns the template used to link to each hunk at the scanned commit: the hunkUrlTemplate option,
+// if provided, otherwise a template for the repoType, like those generated by LaunchDarkly. It is empty if links
+// can't be generated.
+func permalinkTemplate(hunkUrlTemplate, repoType, repoUrl string) string {
+	if hunkUrlTemplate != "" {
+		return hunkUrlTemplate
+	}
+	repoUrl = strings.TrimSuffix(repoUrl, "/")
+	if repoUrl == "" {
+		return ""
+	}
+	switch strings.ToLower(repoType) {
+	case "github":
+		return repoUrl + "/blob/${sha}/${filePath}#L${lineNumber}"
+	case "bitbucket":
+		return repoUrl + "/src/${sha}/${filePath}#lines-${lineNumber}"
+	}
+	return ""
+}
+
+func permalinkOptionsTemplate() string {
+	return permalinkTemplate(o.HunkUrlTemplate.Value(), o.RepoType.Value(), o.RepoUrl.Value())
+}
+
+// addPermalinks returns a copy of refs with the Url of each hunk set from tmpl, pinned to the commit sha. refs are
+// copied since links are only written to local outputs, and not sent to LaunchDarkly.
+func addPermalinks(refs []ld.ReferenceHunksRep, tmpl, sha string) []ld.ReferenceHunksRep {
+	if tmpl == "" || sha == "" {
+		return refs
+	}
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		segments := strings.Split(ref.Path, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		filePath := strings.Join(segments, "/")
+
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			hunk.Url = strings.NewReplacer(
+				"${sha}", sha,
+				"${filePath}", filePath,
+				"${lineNumber}", strconv.Itoa(hunk.StartingLineNumber),
+			).Replace(tmpl)
+			hunks = append(hunks, hunk)
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
@@ -0,0 +1,39 @@
+package coderefs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_permalinkTemplate(t *testing.T) {
+	require.Equal(t, "https://git.example.com/${sha}/${filePath}", permalinkTemplate("https://git.example.com/${sha}/${filePath}", "github", "https://github.com/org/repo"))
+	require.Equal(t, "https://github.com/org/repo/blob/${sha}/${filePath}#L${lineNumber}", permalinkTemplate("", "GitHub", "https://github.com/org/repo/"))
+	require.Equal(t, "https://bitbucket.org/org/repo/src/${sha}/${filePath}#lines-${lineNumber}", permalinkTemplate("", "bitbucket", "https://bitbucket.org/org/repo"))
+	require.Empty(t, permalinkTemplate("", "custom", "https://git.example.com/org/repo"))
+	require.Empty(t, permalinkTemplate("", "github", ""))
+}
+
+func Test_addPermalinks(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "dir/a file.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", StartingLineNumber: 12}, {FlagKey: "flag-2", StartingLineNumber: 40}}},
+	}
+	linked := addPermalinks(refs, "https://github.com/org/repo/blob/${sha}/${filePath}#L${lineNumber}", "abc123")
+	require.Equal(t, "https://github.com/org/repo/blob/abc123/dir/a%20file.go#L12", linked[0].Hunks[0].Url)
+	require.Equal(t, "https://github.com/org/repo/blob/abc123/dir/a%20file.go#L40", linked[0].Hunks[1].Url)
+	require.Empty(t, refs[0].Hunks[0].Url, "references sent to LaunchDarkly should not be modified")
+
+	require.Equal(t, refs, addPermalinks(refs, "", "abc123"))
+}
+
+func Test_writeCsv(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a,b.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", ProjKey: "proj", StartingLineNumber: 3, TestCode: true, Url: "https://example.com/a"}}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeCsv(&buf, refs))
+	require.Equal(t, "flagKey,projKey,path,startingLineNumber,referenceCount,testCode,url,score,sharedFlags,metadata\nflag-1,proj,\"a,b.go\",3,1,true,https://example.com/a,0,,\n", buf.String())
+}
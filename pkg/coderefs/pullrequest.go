@@ -0,0 +1,43 @@
+package coderefs
+
+import (
+	"fmt"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/container"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// pullRequestBranch applies the onPullRequest option to branchName, the name of the scanned branch, if a pull request
+// is being built. It returns the name code references are sent under, and false if they shouldn't be sent. If explicit,
+// branchName was provided by the branchName option, and is used rather than the pull request's source branch.
+func pullRequestBranch(branchName string, explicit bool) (string, bool, error) {
+	pr, ok := container.DetectPullRequest(branchName)
+	if !ok {
+		return branchName, true, nil
+	}
+	desc := "a pull request"
+	if pr.Number != "" {
+		desc = "pull request #" + pr.Number
+	}
+
+	switch o.OnPullRequest.Value() {
+	case o.OnPullRequestSkip:
+		log.Info.Printf("scanning %s, code references will not be sent to LaunchDarkly", desc)
+		return branchName, false, nil
+	case o.OnPullRequestSourceBranch:
+		if explicit {
+			log.Info.Printf("scanning %s, code references will be sent for the provided branch %s", desc, branchName)
+			return branchName, true, nil
+		}
+		if pr.SourceBranch == "" {
+			return "", false, fmt.Errorf("scanning %s, but its source branch could not be detected. Provide the branchName option, or set onPullRequest to %q", desc, o.OnPullRequestSkip)
+		}
+		log.Info.Printf("scanning %s, code references will be sent for its source branch %s", desc, pr.SourceBranch)
+		return pr.SourceBranch, true, nil
+	}
+	if !explicit && (container.IsPullRequestRef(branchName) || branchName == "merge") {
+		log.Warning.Printf("scanning %s, code references will be sent for branch %s. Set onPullRequest to %q or %q to avoid sending them for a branch which doesn't exist", desc, branchName, o.OnPullRequestSkip, o.OnPullRequestSourceBranch)
+	}
+	return branchName, true, nil
+}
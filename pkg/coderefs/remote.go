@@ -0,0 +1,33 @@
+package coderefs
+
+import (
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// detectRepo sets the repository name from the remote chosen by command.CanonicalRemote, so builds of forks report
+// code references for the repository they were forked from. The repository url and type are also set, unless the
+// url was provided, so links point at the same repository.
+func detectRepo(params *ld.RepoParams, dir, remote string) error {
+	remotes, err := command.Remotes(dir)
+	if err != nil {
+		return err
+	}
+	repo, err := command.CanonicalRemote(remotes, remote)
+	if err != nil {
+		return err
+	}
+	if _, ok := remotes["origin"]; ok && remote == "" && repo.Remote != "origin" {
+		log.Info.Printf("origin points at a fork of %s, so code references are sent for the %s remote", repo.Path, repo.Remote)
+	}
+	params.Name = repo.Name()
+	if params.Url == "" {
+		params.Url = repo.Url()
+		if params.Type == "custom" {
+			params.Type = repo.Type()
+		}
+	}
+	log.Info.Printf("detected repoName %s from the %s remote", params.Name, repo.Remote)
+	return nil
+}
@@ -0,0 +1,380 @@
+package coderefs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// currentSchemaVersion is the version of the results file format written by this program.
+// It must be incremented whenever a field is removed, renamed, or changes meaning. New optional
+// fields may be added without incrementing the version, so consumers should ignore unknown fields.
+const currentSchemaVersion = 1
+
+// resultsFile is the interchange format for code references. It is written by the outFile option,
+// and read by the import and validate commands.
+type resultsFile struct {
+	// SchemaVersion is optional when reading, and defaults to 1.
+	SchemaVersion int `json:"schemaVersion"`
+	// Branch and Head are optional when importing. If omitted, the currently checked out branch and
+	// commit in the dir option will be used.
+	Branch     string                 `json:"branch,omitempty"`
+	Head       string                 `json:"head,omitempty"`
+	References []ld.ReferenceHunksRep `json:"references"`
+	// Flags is only written when flag statuses were fetched, and is ignored when importing.
+	Flags []flagReport `json:"flags,omitempty"`
+	// Directories is only written when the heatmapDepth option is provided, and is ignored when importing.
+	Directories []directoryReport `json:"directories,omitempty"`
+}
+
+// resultsSchema is the JSON schema for resultsFile. Its maximum schemaVersion must equal currentSchemaVersion.
+const resultsSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/launchdarkly/ld-find-code-refs/schema/results.json",
+  "title": "ld-find-code-refs results",
+  "type": "object",
+  "required": ["references"],
+  "properties": {
+    "schemaVersion": {
+      "description": "Version of this format. Defaults to 1 if omitted.",
+      "type": "integer",
+      "minimum": 1,
+      "maximum": 1
+    },
+    "branch": {
+      "description": "The branch the references were found on.",
+      "type": "string"
+    },
+    "head": {
+      "description": "The commit sha the references were found on.",
+      "type": "string"
+    },
+    "references": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["path", "hunks"],
+        "properties": {
+          "path": {
+            "description": "Path of the file containing the references, relative to the repository root.",
+            "type": "string",
+            "minLength": 1
+          },
+          "hunks": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["startingLineNumber", "flagKey"],
+              "properties": {
+                "startingLineNumber": {
+                  "description": "The 1-based line number of the first line in lines.",
+                  "type": "integer",
+                  "minimum": 1
+                },
+                "lines": {
+                  "description": "Source code for the reference, including any context lines.",
+                  "type": "string"
+                },
+                "projKey": {
+                  "type": "string"
+                },
+                "flagKey": {
+                  "type": "string",
+                  "minLength": 1
+                },
+                "contentHash": {
+                  "description": "Identifies the hunk across commits. A hash of the path, flag key, and lines, with whitespace collapsed, so it doesn't change when the hunk moves to other lines.",
+                  "type": "string"
+                },
+                "testCode": {
+                  "description": "True if the hunk was found in a test file.",
+                  "type": "boolean"
+                },
+                "language": {
+                  "description": "The code fence identifier of the language of the hunk's file, e.g. go. Recomputed by import.",
+                  "type": "string"
+                },
+                "url": {
+                  "description": "A link to the hunk's source code at the scanned commit. Ignored by import.",
+                  "type": "string"
+                },
+                "score": {
+                  "description": "The relevance of the hunk's flag references, from 100 for flag evaluations to 10 for test files. Ignored by import.",
+                  "type": "integer"
+                },
+                "metadata": {
+                  "description": "Fields attached to the hunk by the hunkMetadata option. Recomputed by import.",
+                  "type": "object",
+                  "additionalProperties": {
+                    "type": "string"
+                  }
+                },
+                "sharedFlags": {
+                  "description": "The keys of other flags referenced on the same lines, which have hunks of their own. Ignored by import.",
+                  "type": "array",
+                  "items": {
+                    "type": "string"
+                  }
+                },
+                "offsets": {
+                  "description": "Positions of each occurrence of the flag key in lines.",
+                  "type": "array",
+                  "items": {
+                    "type": "object",
+                    "required": ["lineNumber", "startColumn", "endColumn"],
+                    "properties": {
+                      "lineNumber": {
+                        "description": "The 1-based line number of the occurrence.",
+                        "type": "integer",
+                        "minimum": 1
+                      },
+                      "startColumn": {
+                        "description": "The 0-based byte offset of the start of the flag key in the line.",
+                        "type": "integer",
+                        "minimum": 0
+                      },
+                      "endColumn": {
+                        "description": "The 0-based byte offset of the end of the flag key in the line, exclusive.",
+                        "type": "integer",
+                        "minimum": 0
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "flags": {
+      "description": "The number of references to each flag, and its status in each environment. Only present if flag statuses were retrieved.",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["flagKey", "referenceCount"],
+        "properties": {
+          "flagKey": {
+            "type": "string",
+            "minLength": 1
+          },
+          "referenceCount": {
+            "type": "integer",
+            "minimum": 0
+          },
+          "testReferenceCount": {
+            "description": "The number of references in referenceCount found in test files.",
+            "type": "integer",
+            "minimum": 0
+          },
+          "environments": {
+            "type": "object"
+          },
+          "summary": {
+            "type": "string"
+          },
+          "maintainer": {
+            "description": "The email of the flag's maintainer in LaunchDarkly.",
+            "type": "string"
+          },
+          "owners": {
+            "description": "The emails of LaunchDarkly members who authored the flag's references, most references first.",
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          }
+        }
+      }
+    },
+    "directories": {
+      "description": "The number of references to each flag in each directory, including subdirectories. Only present if heatmapDepth was provided.",
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["path", "referenceCount", "flags"],
+        "properties": {
+          "path": {
+            "description": "The directory, relative to the repository root. Files in the root are reported as \".\".",
+            "type": "string",
+            "minLength": 1
+          },
+          "referenceCount": {
+            "type": "integer",
+            "minimum": 0
+          },
+          "flags": {
+            "description": "The number of references to each flag key in the directory.",
+            "type": "object"
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+// ResultsSchema returns the JSON schema for files written by the outFile option and accepted by the import command.
+func ResultsSchema() string {
+	return resultsSchema
+}
+
+// ValidateResultsFile checks that the file at path conforms to the results schema. A non-nil error is
+// returned if the file could not be read, otherwise a list of validation errors is returned.
+func ValidateResultsFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return validateResults(data)
+}
+
+func validateResults(data []byte) ([]string, error) {
+	var schema jsonSchema
+	err := json.Unmarshal([]byte(resultsSchema), &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	err = decoder.Decode(&doc)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid json: %s", err)}, nil
+	}
+
+	return schema.validate("", doc), nil
+}
+
+func readResultsFile(path string) (resultsFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return resultsFile{}, err
+	}
+	return parseResultsFile(data)
+}
+
+func parseResultsFile(data []byte) (resultsFile, error) {
+	var f resultsFile
+	validationErrs, err := validateResults(data)
+	if err != nil {
+		return f, err
+	}
+	if len(validationErrs) > 0 {
+		return f, fmt.Errorf("file does not match results schema: %s", strings.Join(validationErrs, "; "))
+	}
+
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return f, err
+	}
+	if f.SchemaVersion == 0 {
+		f.SchemaVersion = 1
+	}
+	return f, nil
+}
+
+func writeResultsFile(path string, f resultsFile) error {
+	f.SchemaVersion = currentSchemaVersion
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// jsonSchema supports the subset of JSON schema keywords used by resultsSchema.
+type jsonSchema struct {
+	Type       string                 `json:"type"`
+	Required   []string               `json:"required"`
+	Properties map[string]*jsonSchema `json:"properties"`
+	Items      *jsonSchema            `json:"items"`
+	Minimum    *float64               `json:"minimum"`
+	Maximum    *float64               `json:"maximum"`
+	MinLength  *int                   `json:"minLength"`
+}
+
+func (s *jsonSchema) validate(path string, v interface{}) []string {
+	errs := []string{}
+	fail := func(format string, args ...interface{}) []string {
+		location := path
+		if location == "" {
+			location = "(root)"
+		}
+		return append(errs, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fail("expected object")
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				errs = fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if value, ok := obj[name]; ok {
+				errs = append(errs, s.Properties[name].validate(joinSchemaPath(path, name), value)...)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fail("expected array")
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("expected string")
+		}
+		if s.MinLength != nil && len(str) < *s.MinLength {
+			return fail("must be at least %d characters", *s.MinLength)
+		}
+	case "integer":
+		num, ok := v.(json.Number)
+		if !ok {
+			return fail("expected integer")
+		}
+		i, err := num.Int64()
+		if err != nil {
+			return fail("expected integer")
+		}
+		if s.Minimum != nil && float64(i) < *s.Minimum {
+			return fail("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && float64(i) > *s.Maximum {
+			return fail("must be <= %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fail("expected boolean")
+		}
+	}
+	return errs
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,101 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_resultsSchemaVersion(t *testing.T) {
+	var schema jsonSchema
+	require.NoError(t, json.Unmarshal([]byte(resultsSchema), &schema))
+	require.Equal(t, float64(currentSchemaVersion), *schema.Properties["schemaVersion"].Maximum)
+}
+
+func Test_validateResults(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "valid",
+			data: `{"schemaVersion":1,"branch":"master","references":[{"path":"a/b","hunks":[{"startingLineNumber":1,"lines":"flag-1\n","flagKey":"flag-1"}]}]}`,
+			want: []string{},
+		},
+		{
+			name: "valid without schema version",
+			data: `{"references":[]}`,
+			want: []string{},
+		},
+		{
+			name: "ignores unknown fields",
+			data: `{"references":[],"somethingNew":true}`,
+			want: []string{},
+		},
+		{
+			name: "invalid json",
+			data: `{`,
+			want: []string{"invalid json: unexpected EOF"},
+		},
+		{
+			name: "unsupported schema version",
+			data: `{"schemaVersion":2,"references":[]}`,
+			want: []string{"schemaVersion: must be <= 1"},
+		},
+		{
+			name: "missing required properties",
+			data: `{"references":[{"hunks":[{"lines":""}]}]}`,
+			want: []string{
+				`references[0]: missing required property "path"`,
+				`references[0].hunks[0]: missing required property "startingLineNumber"`,
+				`references[0].hunks[0]: missing required property "flagKey"`,
+			},
+		},
+		{
+			name: "invalid types and values",
+			data: `{"branch":1,"references":[{"path":"","hunks":[{"startingLineNumber":0.5,"flagKey":"flag-1"},{"startingLineNumber":0,"flagKey":"flag-1"}]}]}`,
+			want: []string{
+				"branch: expected string",
+				"references[0].hunks[0].startingLineNumber: expected integer",
+				"references[0].hunks[1].startingLineNumber: must be >= 1",
+				"references[0].path: must be at least 1 characters",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateResults([]byte(tt.data))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_writeAndReadResultsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.json")
+
+	f := resultsFile{
+		Branch: "master",
+		Head:   "abc",
+		References: []ld.ReferenceHunksRep{
+			{Path: "a/b", Hunks: []ld.HunkRep{{StartingLineNumber: 1, Lines: "flag-1\n", ProjKey: "test", FlagKey: "flag-1"}}},
+		},
+	}
+	require.NoError(t, writeResultsFile(path, f))
+
+	got, err := readResultsFile(path)
+	require.NoError(t, err)
+	f.SchemaVersion = currentSchemaVersion
+	require.Equal(t, f, got)
+}
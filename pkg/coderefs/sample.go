@@ -0,0 +1,89 @@
+package coderefs
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// sampleKey identifies a flag in a project.
+type sampleKey struct {
+	projKey string
+	flagKey string
+}
+
+// sampledHunk identifies a hunk, and its position in the sampling order.
+type sampledHunk struct {
+	path     string
+	line     int
+	priority uint64
+}
+
+// sampleReferences keeps a sample of n hunks for each flag with more than n hunks, and returns the true number of
+// hunks and references to each sampled flag. Hunks are sampled by hashing their flag, path, and line, so the same
+// hunks are kept by every scan of an unchanged repository, and uploads don't churn. refs is not modified.
+func sampleReferences(refs []ld.ReferenceHunksRep, n int) ([]ld.ReferenceHunksRep, []ld.SampledFlagRep) {
+	if n <= 0 {
+		return refs, nil
+	}
+	hunks := map[sampleKey][]sampledHunk{}
+	references := map[sampleKey]int{}
+	for _, ref := range refs {
+		for _, hunk := range ref.Hunks {
+			key := sampleKey{hunk.ProjKey, hunk.FlagKey}
+			hunks[key] = append(hunks[key], sampledHunk{ref.Path, hunk.StartingLineNumber, samplePriority(key, ref.Path, hunk.StartingLineNumber)})
+			references[key] += hunk.ReferenceCount()
+		}
+	}
+
+	samples := []ld.SampledFlagRep{}
+	// keep is the set of hunks of sampled flags which are sent
+	keep := map[sampleKey]map[sampledHunk]bool{}
+	for key, flagHunks := range hunks {
+		if len(flagHunks) <= n {
+			continue
+		}
+		sort.Slice(flagHunks, func(i, j int) bool { return flagHunks[i].priority < flagHunks[j].priority })
+		keep[key] = map[sampledHunk]bool{}
+		for _, h := range flagHunks[:n] {
+			keep[key][h] = true
+		}
+		samples = append(samples, ld.SampledFlagRep{ProjKey: key.projKey, FlagKey: key.flagKey, HunkCount: len(flagHunks), ReferenceCount: references[key], SampledHunkCount: n})
+	}
+	if len(samples) == 0 {
+		return refs, nil
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].FlagKey != samples[j].FlagKey {
+			return samples[i].FlagKey < samples[j].FlagKey
+		}
+		return samples[i].ProjKey < samples[j].ProjKey
+	})
+
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		kept := []ld.HunkRep{}
+		for _, hunk := range ref.Hunks {
+			key := sampleKey{hunk.ProjKey, hunk.FlagKey}
+			if sampled, ok := keep[key]; ok && !sampled[sampledHunk{ref.Path, hunk.StartingLineNumber, samplePriority(key, ref.Path, hunk.StartingLineNumber)}] {
+				continue
+			}
+			kept = append(kept, hunk)
+		}
+		if len(kept) > 0 {
+			ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: kept})
+		}
+	}
+	return ret, samples
+}
+
+func samplePriority(key sampleKey, path string, line int) uint64 {
+	h := fnv.New64a()
+	for _, s := range []string{key.projKey, key.flagKey, path, strconv.Itoa(line)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
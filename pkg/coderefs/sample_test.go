@@ -0,0 +1,38 @@
+package coderefs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_sampleReferences(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{}
+	for i := 0; i < 10; i++ {
+		refs = append(refs, ld.ReferenceHunksRep{Path: fmt.Sprintf("file%d.go", i), Hunks: []ld.HunkRep{
+			{ProjKey: "proj", FlagKey: "common-flag", StartingLineNumber: 1, Offsets: []ld.OffsetRep{{LineNumber: 1}, {LineNumber: 2}}},
+		}})
+	}
+	refs[0].Hunks = append(refs[0].Hunks, ld.HunkRep{ProjKey: "proj", FlagKey: "rare-flag", StartingLineNumber: 5})
+
+	same, samples := sampleReferences(refs, 0)
+	require.Equal(t, refs, same)
+	require.Nil(t, samples)
+
+	sampled, samples := sampleReferences(refs, 3)
+	require.Equal(t, []ld.SampledFlagRep{{ProjKey: "proj", FlagKey: "common-flag", HunkCount: 10, ReferenceCount: 20, SampledHunkCount: 3}}, samples)
+	counts := map[string]int{}
+	for _, ref := range sampled {
+		for _, hunk := range ref.Hunks {
+			counts[hunk.FlagKey]++
+		}
+	}
+	require.Equal(t, map[string]int{"common-flag": 3, "rare-flag": 1}, counts)
+	require.Len(t, refs, 10, "refs should not be modified")
+
+	again, _ := sampleReferences(refs, 3)
+	require.Equal(t, sampled, again, "the same hunks should be sampled every time")
+}
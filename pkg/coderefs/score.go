@@ -0,0 +1,160 @@
+package coderefs
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// Relevance scores of flag references, from most to least actionable
+const (
+	// scoreEvaluation is the score of a flag key passed to a function evaluating flags, e.g. boolVariation("key")
+	scoreEvaluation = 100
+	// scoreLiteral is the score of a flag key in a string literal
+	scoreLiteral = 75
+	// scoreCode is the score of other references, e.g. in identifiers, and of hunks without source code
+	scoreCode = 50
+	// scoreComment is the score of a flag key in a comment
+	scoreComment = 25
+	// scoreTestCode is the score of every reference in a test file
+	scoreTestCode = 10
+)
+
+// commentPrefixes start line comments, or lines in block comments, in common languages. Lines starting with # or *
+// are checked by notCommentRegex too.
+var commentPrefixes = []string{"//", "/*", "*", "#", "--", "<!--", ";"}
+
+// notCommentRegex matches lines which start like comments, but are code: preprocessor directives, e.g. #define or
+// #if, Rust attributes, e.g. #[cfg(feature = "x")], and pointer dereferences, e.g. *enabled = true.
+var notCommentRegex = regexp.MustCompile(`^(#\s*(define|undef|include|import|if|ifdef|ifndef|elif|else|endif|pragma|region|endregion)\b|#!?\[|\*[^\s*/])`)
+
+// evaluationCallRegex matches the text preceding a flag key passed as the first or second argument of a function
+// which appears to evaluate flags, e.g. client.boolVariation(", useFlag(', or isFeatureEnabled(ctx, ".
+var evaluationCallRegex = regexp.MustCompile("(?i)(variation|flag|feature|toggle|enabled)\\w*\\s*\\(\\s*(\\w+\\s*,\\s*)?[\"'`]?$")
+
+// scoreOccurrence returns the relevance score of a flag key at line[start:end].
+func scoreOccurrence(line string, start, end int) int {
+	before, after := line[:start], line[end:]
+	trimmed := strings.TrimSpace(line)
+	for _, prefix := range commentPrefixes {
+		if strings.HasPrefix(trimmed, prefix) && !notCommentRegex.MatchString(trimmed) {
+			return scoreComment
+		}
+	}
+	if commentBefore(before) {
+		return scoreComment
+	}
+	if evaluationCallRegex.MatchString(before) {
+		return scoreEvaluation
+	}
+	if len(before) > 0 && len(after) > 0 && strings.IndexByte("\"'`", after[0]) >= 0 && before[len(before)-1] == after[0] {
+		return scoreLiteral
+	}
+	return scoreCode
+}
+
+// commentBefore returns true if a line or block comment starts in before, outside of string literals, so e.g. the
+// // of a URL isn't treated as a comment.
+func commentBefore(before string) bool {
+	var quote byte
+	for i := 0; i < len(before); i++ {
+		c := before[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '/' && i+1 < len(before) && (before[i+1] == '/' || before[i+1] == '*'):
+			return true
+		}
+	}
+	return false
+}
+
+// hunkScore returns the relevance score of a hunk: the highest score of the flag references in it, or scoreTestCode
+// if it was found in a test file.
+func hunkScore(hunk ld.HunkRep) int {
+	if hunk.TestCode {
+		return scoreTestCode
+	}
+	if hunk.Lines == "" {
+		return scoreCode
+	}
+	lines := strings.Split(hunk.Lines, "\n")
+	score := 0
+	for _, offset := range hunk.Offsets {
+		i := offset.LineNumber - hunk.StartingLineNumber
+		if i < 0 || i >= len(lines) || offset.EndColumn > len(lines[i]) || offset.StartColumn >= offset.EndColumn {
+			continue
+		}
+		if s := scoreOccurrence(lines[i], offset.StartColumn, offset.EndColumn); s > score {
+			score = s
+		}
+	}
+	if score == 0 {
+		return scoreCode
+	}
+	return score
+}
+
+// addScores returns a copy of refs with the relevance score of each hunk. refs is not modified, since scores are only
+// included in local outputs.
+func addScores(refs []ld.ReferenceHunksRep) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			hunk.Score = hunkScore(hunk)
+			hunks = append(hunks, hunk)
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
+
+// filterByScore removes hunks with a relevance score lower than minScore, and files without any remaining hunks.
+func filterByScore(refs []ld.ReferenceHunksRep, minScore int) []ld.ReferenceHunksRep {
+	if minScore <= 0 {
+		return refs
+	}
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		hunks := []ld.HunkRep{}
+		for _, hunk := range ref.Hunks {
+			if hunkScore(hunk) >= minScore {
+				hunks = append(hunks, hunk)
+			}
+		}
+		if len(hunks) > 0 {
+			ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+		}
+	}
+	return ret
+}
+
+// scoredHunk is a hunk and the path of the file it was found in.
+type scoredHunk struct {
+	Path string
+	ld.HunkRep
+}
+
+// hunksByScore returns the hunks of refs with the highest scores first. Hunks with the same score are kept in
+// the order of refs.
+func hunksByScore(refs []ld.ReferenceHunksRep) []scoredHunk {
+	ret := []scoredHunk{}
+	for _, ref := range refs {
+		for _, hunk := range ref.Hunks {
+			ret = append(ret, scoredHunk{ref.Path, hunk})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Score > ret[j].Score
+	})
+	return ret
+}
@@ -0,0 +1,75 @@
+package coderefs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_scoreOccurrence(t *testing.T) {
+	specs := []struct {
+		name     string
+		line     string
+		expected int
+	}{
+		{"evaluation", `if client.boolVariation("my-flag", user, false) {`, scoreEvaluation},
+		{"evaluation with context", `enabled, _ := ldClient.BoolVariation(ctx, "my-flag", false)`, scoreEvaluation},
+		{"react hook", `const on = useFlag('my-flag');`, scoreEvaluation},
+		{"literal", `flags := []string{"my-flag"}`, scoreLiteral},
+		{"identifier", `if my-flag > 0 {`, scoreCode},
+		{"line comment", `// remove my-flag after launch`, scoreComment},
+		{"trailing comment", `x := 1 // my-flag`, scoreComment},
+		{"commented evaluation", `# client.variation("my-flag", user)`, scoreComment},
+		{"block comment", ` * Remove my-flag after launch.`, scoreComment},
+		{"url literal", `url := "https://example.com/flags/my-flag"`, scoreCode},
+		{"url evaluation", `fetch("https://example.com/flags"); enabled := client.boolVariation("my-flag", user, false)`, scoreEvaluation},
+		{"comment after url", `url := "https://example.com" // my-flag`, scoreComment},
+		{"escaped quote", `s := "\"//"; f("my-flag")`, scoreLiteral},
+		{"preprocessor", `#define CHECKOUT_FLAG "my-flag"`, scoreLiteral},
+		{"python comment", `#remove my-flag`, scoreComment},
+		{"rust attribute", `#[cfg(feature = "my-flag")]`, scoreLiteral},
+		{"dereference", `*enabled = client.boolVariation("my-flag", user, false)`, scoreEvaluation},
+	}
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			start := strings.Index(tt.line, "my-flag")
+			require.Equal(t, tt.expected, scoreOccurrence(tt.line, start, start+len("my-flag")))
+		})
+	}
+}
+
+func Test_hunkScore(t *testing.T) {
+	hunk := ld.HunkRep{
+		StartingLineNumber: 10,
+		Lines:              "// my-flag\nclient.variation(\"my-flag\")\n",
+		FlagKey:            "my-flag",
+		Offsets:            []ld.OffsetRep{{LineNumber: 10, StartColumn: 3, EndColumn: 10}, {LineNumber: 11, StartColumn: 18, EndColumn: 25}},
+	}
+	require.Equal(t, scoreEvaluation, hunkScore(hunk))
+
+	hunk.Offsets = hunk.Offsets[:1]
+	require.Equal(t, scoreComment, hunkScore(hunk))
+
+	hunk.TestCode = true
+	require.Equal(t, scoreTestCode, hunkScore(hunk))
+	require.Equal(t, scoreCode, hunkScore(ld.HunkRep{FlagKey: "my-flag"}))
+}
+
+func Test_filterByScore(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "my-flag", StartingLineNumber: 1, Lines: `"my-flag"`, Offsets: []ld.OffsetRep{{LineNumber: 1, StartColumn: 1, EndColumn: 8}}}}},
+		{Path: "a_test.go", Hunks: []ld.HunkRep{{FlagKey: "my-flag", StartingLineNumber: 1, TestCode: true}}},
+	}
+	require.Equal(t, refs, filterByScore(refs, 0))
+	require.Equal(t, refs[:1], filterByScore(refs, scoreLiteral))
+
+	scored := addScores(refs)
+	require.Equal(t, scoreLiteral, scored[0].Hunks[0].Score)
+	require.Zero(t, refs[0].Hunks[0].Score, "references sent to LaunchDarkly should not be modified")
+
+	sorted := hunksByScore(addScores([]ld.ReferenceHunksRep{refs[1], refs[0]}))
+	require.Equal(t, []string{"a.go", "a_test.go"}, []string{sorted[0].Path, sorted[1].Path})
+}
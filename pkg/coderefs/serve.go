@@ -0,0 +1,142 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// resultsServer serves the code references in a results file over HTTP, so editor plugins and dashboards can query
+// them without scanning again. The file is read again when it changes, so the latest scan results are served.
+type resultsServer struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	results resultsFile
+}
+
+// flagSummaryRep is the number of references to a flag, returned by the /flags endpoint.
+type flagSummaryRep struct {
+	FlagKey        string `json:"flagKey"`
+	ReferenceCount int    `json:"referenceCount"`
+	FileCount      int    `json:"fileCount"`
+}
+
+// NewResultsHandler returns an HTTP handler for the code references in the results file at path, with endpoints:
+//
+//	GET /flags                            the flags referenced, with their number of references and files
+//	GET /references?flag=key&path=file    code references, optionally filtered by flag key and file path
+func NewResultsHandler(path string) (http.Handler, error) {
+	s := &resultsServer{path: path}
+	_, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flags", s.flags)
+	mux.HandleFunc("/references", s.references)
+	return mux, nil
+}
+
+// load returns the results in the file, reading it again if it has been modified since it was last read.
+func (s *resultsServer) load() (resultsFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return s.results, err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return s.results, nil
+	}
+	results, err := readResultsFile(s.path)
+	if err != nil {
+		return s.results, err
+	}
+	log.Info.Printf("loaded %d files with code references from %s", len(results.References), s.path)
+	s.results = results
+	s.modTime = info.ModTime()
+	return s.results, nil
+}
+
+func (s *resultsServer) flags(w http.ResponseWriter, r *http.Request) {
+	results, ok := s.get(w, r)
+	if !ok {
+		return
+	}
+	summaries := map[string]*flagSummaryRep{}
+	for _, ref := range results.References {
+		files := map[string]bool{}
+		for _, hunk := range ref.Hunks {
+			summary := summaries[hunk.FlagKey]
+			if summary == nil {
+				summary = &flagSummaryRep{FlagKey: hunk.FlagKey}
+				summaries[hunk.FlagKey] = summary
+			}
+			summary.ReferenceCount += hunk.ReferenceCount()
+			if !files[hunk.FlagKey] {
+				files[hunk.FlagKey] = true
+				summary.FileCount++
+			}
+		}
+	}
+	ret := make([]flagSummaryRep, 0, len(summaries))
+	for _, summary := range summaries {
+		ret = append(ret, *summary)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].FlagKey < ret[j].FlagKey })
+	writeJson(w, ret)
+}
+
+func (s *resultsServer) references(w http.ResponseWriter, r *http.Request) {
+	results, ok := s.get(w, r)
+	if !ok {
+		return
+	}
+	flagKey, path := r.URL.Query().Get("flag"), r.URL.Query().Get("path")
+	ret := []ld.ReferenceHunksRep{}
+	for _, ref := range results.References {
+		if path != "" && ref.Path != path {
+			continue
+		}
+		hunks := []ld.HunkRep{}
+		for _, hunk := range ref.Hunks {
+			if flagKey == "" || hunk.FlagKey == flagKey {
+				hunks = append(hunks, hunk)
+			}
+		}
+		if len(hunks) > 0 {
+			ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+		}
+	}
+	writeJson(w, ret)
+}
+
+// get returns the latest results for a GET request, or writes an error response.
+func (s *resultsServer) get(w http.ResponseWriter, r *http.Request) (resultsFile, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return resultsFile{}, false
+	}
+	results, err := s.load()
+	if err != nil {
+		// The previous results are still served if the file is being rewritten
+		log.Warning.Printf("could not read %s, serving previous results: %s", s.path, err)
+	}
+	return results, true
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Debug.Printf("could not write response: %s", err)
+	}
+}
@@ -0,0 +1,70 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func TestNewResultsHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "refs.json")
+
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 1, Lines: "flag-1 flag-1\n", FlagKey: "flag-1", Offsets: []ld.OffsetRep{{LineNumber: 1, StartColumn: 0, EndColumn: 6}, {LineNumber: 1, StartColumn: 7, EndColumn: 13}}},
+			{StartingLineNumber: 5, Lines: "flag-2\n", FlagKey: "flag-2"},
+		}},
+		{Path: "b.go", Hunks: []ld.HunkRep{{StartingLineNumber: 2, Lines: "flag-1\n", FlagKey: "flag-1"}}},
+	}
+	require.NoError(t, writeResultsFile(path, resultsFile{References: refs}))
+
+	handler, err := NewResultsHandler(path)
+	require.NoError(t, err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	get := func(url string, v interface{}) {
+		res, err := http.Get(server.URL + url)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+	}
+
+	var flags []flagSummaryRep
+	get("/flags", &flags)
+	require.Equal(t, []flagSummaryRep{{FlagKey: "flag-1", ReferenceCount: 3, FileCount: 2}, {FlagKey: "flag-2", ReferenceCount: 1, FileCount: 1}}, flags)
+
+	var found []ld.ReferenceHunksRep
+	get("/references?flag=flag-1", &found)
+	require.Equal(t, []ld.ReferenceHunksRep{{Path: "a.go", Hunks: refs[0].Hunks[:1]}, refs[1]}, found)
+
+	found = nil
+	get("/references?path=a.go&flag=flag-2", &found)
+	require.Equal(t, []ld.ReferenceHunksRep{{Path: "a.go", Hunks: refs[0].Hunks[1:]}}, found)
+
+	// Results are reloaded when the file changes
+	require.NoError(t, writeResultsFile(path, resultsFile{References: refs[1:]}))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	found = nil
+	get("/references", &found)
+	require.Equal(t, refs[1:], found)
+
+	res, err := http.Post(server.URL+"/flags", "application/json", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
@@ -0,0 +1,67 @@
+package coderefs
+
+import (
+	"sort"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+// addSharedFlags returns a copy of refs with the SharedFlags of each hunk set to the other flags referenced on the
+// same lines. Each flag referenced on a line gets its own hunk, with the same lines as the hunks of the other flags,
+// so local outputs list the other flags to show that these hunks aren't duplicates. refs is not modified, since shared
+// flags are only included in local outputs.
+func addSharedFlags(refs []ld.ReferenceHunksRep) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		// The flags referenced on each line of the file
+		lineFlags := map[referencedLine]map[string]bool{}
+		for _, hunk := range ref.Hunks {
+			for _, line := range sharedLines(hunk) {
+				if lineFlags[line] == nil {
+					lineFlags[line] = map[string]bool{}
+				}
+				lineFlags[line][hunk.FlagKey] = true
+			}
+		}
+
+		hunks := make([]ld.HunkRep, 0, len(ref.Hunks))
+		for _, hunk := range ref.Hunks {
+			shared := map[string]bool{}
+			for _, line := range sharedLines(hunk) {
+				for flagKey := range lineFlags[line] {
+					if flagKey != hunk.FlagKey {
+						shared[flagKey] = true
+					}
+				}
+			}
+			hunk.SharedFlags = nil
+			for flagKey := range shared {
+				hunk.SharedFlags = append(hunk.SharedFlags, flagKey)
+			}
+			sort.Strings(hunk.SharedFlags)
+			hunks = append(hunks, hunk)
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
+
+// referencedLine identifies a line containing flag references. Lines is only set for hunks without offsets.
+type referencedLine struct {
+	LineNumber int
+	Lines      string
+}
+
+// sharedLines returns the lines of the flag references in a hunk. The lines referencing the flag in hunks without
+// offsets, such as those found by matcher plugins, aren't known, so those hunks only share flags with hunks of the same
+// lines.
+func sharedLines(hunk ld.HunkRep) []referencedLine {
+	if len(hunk.Offsets) == 0 {
+		return []referencedLine{{hunk.StartingLineNumber, hunk.Lines}}
+	}
+	lines := make([]referencedLine, 0, len(hunk.Offsets))
+	for _, offset := range hunk.Offsets {
+		lines = append(lines, referencedLine{LineNumber: offset.LineNumber})
+	}
+	return lines
+}
@@ -0,0 +1,35 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_addSharedFlags(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 1, Lines: "x\nflag-1 && flag-2\ny\n", FlagKey: "flag-1", Offsets: []ld.OffsetRep{{LineNumber: 2, StartColumn: 0, EndColumn: 6}}},
+			{StartingLineNumber: 1, Lines: "x\nflag-1 && flag-2\ny\nflag-2\n", FlagKey: "flag-2", Offsets: []ld.OffsetRep{{LineNumber: 2, StartColumn: 10, EndColumn: 16}, {LineNumber: 4, StartColumn: 0, EndColumn: 6}}},
+			{StartingLineNumber: 3, Lines: "y\nflag-2\nflag-3\n", FlagKey: "flag-3", Offsets: []ld.OffsetRep{{LineNumber: 5, StartColumn: 0, EndColumn: 6}}},
+			{StartingLineNumber: 9, Lines: "plugin\n", FlagKey: "flag-4"},
+			{StartingLineNumber: 9, Lines: "plugin\n", FlagKey: "flag-5"},
+		}},
+		{Path: "b.go", Hunks: []ld.HunkRep{
+			{StartingLineNumber: 2, Lines: "flag-3\n", FlagKey: "flag-3", Offsets: []ld.OffsetRep{{LineNumber: 2, StartColumn: 0, EndColumn: 6}}},
+		}},
+	}
+	got := addSharedFlags(refs)
+
+	shared := [][]string{}
+	for _, ref := range got {
+		for _, hunk := range ref.Hunks {
+			shared = append(shared, hunk.SharedFlags)
+		}
+	}
+	// Hunks sharing context lines, without references on the same lines, aren't shared
+	require.Equal(t, [][]string{{"flag-2"}, {"flag-1"}, nil, {"flag-5"}, {"flag-4"}, nil}, shared)
+	require.Nil(t, refs[0].Hunks[0].SharedFlags)
+}
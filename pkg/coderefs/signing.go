@@ -0,0 +1,86 @@
+package coderefs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// signatureSuffix is appended to the path of a results file to get the path of its signature.
+const signatureSuffix = ".sig"
+
+// readSigningKey reads an Ed25519 private key from a PEM encoded PKCS #8 file, as generated by
+// `openssl genpkey -algorithm ed25519`.
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPemBlock(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	return edKey, nil
+}
+
+// readVerifyKey reads an Ed25519 public key from a PEM encoded PKIX file, as generated by `openssl pkey -pubout`.
+func readVerifyKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPemBlock(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+	}
+	return edKey, nil
+}
+
+func readPemBlock(path, blockType string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded %s", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// signResultsFile writes a signature of the results file at path to the path with signatureSuffix, so the file can
+// be verified before it's imported.
+func signResultsFile(path string, key ed25519.PrivateKey) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return ioutil.WriteFile(path+signatureSuffix, []byte(sig+"\n"), 0644)
+}
+
+// verifyResultsSignature returns an error unless encoded is a valid signature of data, as written by
+// signResultsFile.
+func verifyResultsSignature(data, encoded []byte, key ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return errors.New("signature does not match, the file may have been modified after it was signed")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package coderefs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePem(t *testing.T, path, blockType string, der []byte) {
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+}
+
+func Test_signResultsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDer, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	writePem(t, filepath.Join(dir, "key.pem"), "PRIVATE KEY", privDer)
+	writePem(t, filepath.Join(dir, "key.pub"), "PUBLIC KEY", pubDer)
+
+	signingKey, err := readSigningKey(filepath.Join(dir, "key.pem"))
+	require.NoError(t, err)
+	verifyKey, err := readVerifyKey(filepath.Join(dir, "key.pub"))
+	require.NoError(t, err)
+	_, err = readSigningKey(filepath.Join(dir, "key.pub"))
+	require.EqualError(t, err, filepath.Join(dir, "key.pub")+" does not contain a PEM encoded PRIVATE KEY")
+
+	path := filepath.Join(dir, "refs.json")
+	data := []byte(`{"schemaVersion": 1, "references": []}`)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+	require.NoError(t, signResultsFile(path, signingKey))
+	sig, err := ioutil.ReadFile(path + signatureSuffix)
+	require.NoError(t, err)
+	require.NoError(t, verifyResultsSignature(data, sig, verifyKey))
+
+	err = verifyResultsSignature([]byte(`{"schemaVersion": 1, "references": [{}]}`), sig, verifyKey)
+	require.EqualError(t, err, "signature does not match, the file may have been modified after it was signed")
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.Error(t, verifyResultsSignature(data, sig, otherPub))
+
+	require.Error(t, verifyResultsSignature(data, []byte("not base64!"), verifyKey))
+}
@@ -0,0 +1,22 @@
+package coderefs
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// skipMarkerFile opts a repository out of scans when present in its root, so pipeline templates shared by many
+// repositories can run the scanner everywhere.
+const skipMarkerFile = ".ld-find-code-refs-skip"
+
+// skippedByRepository returns the reason the repository at root has opted out of scans, if it has, either with the
+// skip marker file or by disabling the enabled option, usually in its config file.
+func skippedByRepository(root string, enabled bool) (string, bool) {
+	if _, err := os.Stat(filepath.Join(root, skipMarkerFile)); err == nil {
+		return skipMarkerFile + " is present in the repository root", true
+	}
+	if !enabled {
+		return "the enabled option is false", true
+	}
+	return "", false
+}
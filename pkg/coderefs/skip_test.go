@@ -0,0 +1,28 @@
+package coderefs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_skippedByRepository(t *testing.T) {
+	root, err := ioutil.TempDir("", "skip")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	_, skip := skippedByRepository(root, true)
+	require.False(t, skip)
+
+	reason, skip := skippedByRepository(root, false)
+	require.True(t, skip)
+	require.Equal(t, "the enabled option is false", reason)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, skipMarkerFile), nil, 0644))
+	reason, skip = skippedByRepository(root, true)
+	require.True(t, skip)
+	require.Equal(t, ".ld-find-code-refs-skip is present in the repository root", reason)
+}
@@ -0,0 +1,123 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/cache"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// spooledUpload is the code references for a branch which couldn't be sent to LaunchDarkly, saved so they can be
+// sent by the resume command without scanning the repository again. Access tokens are not saved.
+type spooledUpload struct {
+	BaseUri   string       `json:"baseUri"`
+	RepoName  string       `json:"repoName"`
+	SpooledAt time.Time    `json:"spooledAt"`
+	Branch    ld.BranchRep `json:"branch"`
+}
+
+// spoolKey returns the name of the spool entry for a branch. A branch has at most one pending upload, so the
+// code references from the latest scan replace those from earlier failed scans.
+func spoolKey(baseUri, repoName, branchName string) string {
+	return cache.Key("spool", baseUri, repoName, branchName)
+}
+
+// spoolBranch saves code references which couldn't be sent to the spoolDir directory, if provided. Failures are logged,
+// since the scan has already failed.
+func spoolBranch(branchRep ld.BranchRep, repoName string) {
+	dir := o.SpoolDir.Value()
+	if dir == "" {
+		return
+	}
+	upload := spooledUpload{BaseUri: o.BaseUri.Value(), RepoName: repoName, SpooledAt: time.Now().UTC(), Branch: branchRep}
+	data, err := json.Marshal(upload)
+	if err == nil {
+		var spool *cache.Cache
+		spool, err = cache.Open(dir, 0)
+		if err == nil {
+			err = spool.Put(spoolKey(upload.BaseUri, repoName, branchRep.Name), data)
+		}
+	}
+	if err != nil {
+		log.Warning.Printf("could not save code references to spool directory %s: %s", dir, err)
+		return
+	}
+	log.Info.Printf("saved code references for branch %s to spool directory %s. To send them without scanning again, run: ld-find-code-refs resume -spoolDir %s", branchRep.Name, dir, dir)
+}
+
+// unspoolBranch removes any pending upload for a branch from the spoolDir directory, once newer code references
+// have been sent.
+func unspoolBranch(repoName, branchName string) {
+	dir := o.SpoolDir.Value()
+	if dir == "" {
+		return
+	}
+	err := os.Remove(filepath.Join(dir, spoolKey(o.BaseUri.Value(), repoName, branchName)))
+	if err == nil {
+		log.Debug.Printf("removed pending upload for branch %s from spool directory %s", branchName, dir)
+	}
+}
+
+// Resume sends the code references saved to the spool directory dir by failed scans, connecting to LaunchDarkly
+// with the same options as Scan. Uploads are removed from the directory once they are sent, or if LaunchDarkly
+// already has newer code references for the branch. It returns the number of uploads which are still pending.
+func Resume(dir string) (int, error) {
+	return resumeUploads(dir, newApiOptions(projKeys(o.ProjKey.Value())))
+}
+
+// resumeUploads sends the uploads in the spool directory dir with apiOptions, and the base URI each upload was
+// spooled for.
+func resumeUploads(dir string, apiOptions ld.ApiOptions) (int, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+
+	pending := 0
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp") {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return pending, err
+		}
+		var upload spooledUpload
+		err = json.Unmarshal(data, &upload)
+		if err != nil || upload.RepoName == "" || upload.BaseUri == "" {
+			log.Warning.Printf("skipping invalid spooled upload %s", path)
+			pending++
+			continue
+		}
+
+		apiOptions.BaseUri = upload.BaseUri
+		ldApi := ld.InitApiClient(apiOptions)
+		err = ldApi.PutCodeReferenceBranch(upload.Branch, upload.RepoName)
+		switch {
+		case err == ld.BranchUpdateSequenceIdConflictErr:
+			log.Warning.Printf("discarding code references for branch %s of repository %s spooled at %s: newer code references have already been sent", upload.Branch.Name, upload.RepoName, upload.SpooledAt.Format(time.RFC3339))
+		case err != nil:
+			log.Error.Printf("could not send code references for branch %s of repository %s: %s", upload.Branch.Name, upload.RepoName, err)
+			pending++
+			continue
+		default:
+			log.Info.Printf("sent %d code references for branch %s of repository %s, spooled at %s", upload.Branch.TotalReferenceCount(), upload.Branch.Name, upload.RepoName, upload.SpooledAt.Format(time.RFC3339))
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return pending, fmt.Errorf("could not remove sent upload from spool directory: %s", err)
+		}
+	}
+	return pending, nil
+}
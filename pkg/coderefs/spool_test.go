@@ -0,0 +1,54 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func TestResume(t *testing.T) {
+	received := []string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		require.Equal(t, "api-x", req.Header.Get("Authorization"))
+		require.Equal(t, "1", req.Header.Get("X-Tenant"))
+		branch := path.Base(req.URL.Path)
+		received = append(received, branch)
+		switch branch {
+		case "conflict":
+			res.WriteHeader(http.StatusConflict)
+			res.Write([]byte(`{"code": "updateSequenceId_conflict", "message": "conflict"}`))
+		case "unauthorized":
+			res.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer testServer.Close()
+
+	dir, err := ioutil.TempDir("", "spool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, branch := range []string{"sent", "conflict", "unauthorized"} {
+		data, err := json.Marshal(spooledUpload{BaseUri: testServer.URL, RepoName: "repo", Branch: ld.BranchRep{Name: branch}})
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, spoolKey(testServer.URL, "repo", branch)), data, 0600))
+	}
+
+	pending, err := resumeUploads(dir, ld.ApiOptions{ApiKey: "api-x", Headers: http.Header{"X-Tenant": {"1"}}})
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+	require.ElementsMatch(t, []string{"sent", "conflict", "unauthorized"}, received)
+
+	// Only the upload which failed is kept
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, spoolKey(testServer.URL, "repo", "unauthorized"), infos[0].Name())
+}
@@ -0,0 +1,135 @@
+package coderefs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// flagReport joins a flag's code references with its status in each environment, so stale flags can be identified.
+type flagReport struct {
+	FlagKey        string `json:"flagKey"`
+	ReferenceCount int    `json:"referenceCount"`
+	// TestReferenceCount is the number of references in ReferenceCount found in test files.
+	TestReferenceCount int           `json:"testReferenceCount,omitempty"`
+	Environments       ld.FlagStatus `json:"environments"`
+	// Summary describes the flag's references and status, e.g. "referenced 40 times, off in all environments for 120 days"
+	Summary string `json:"summary"`
+	// Maintainer is the email of the LaunchDarkly member responsible for the flag.
+	Maintainer string `json:"maintainer,omitempty"`
+	// Owners are the emails of LaunchDarkly members who authored the flag's references, most references first.
+	Owners []string `json:"owners,omitempty"`
+}
+
+// makeFlagReports returns a report for each flag with a status, sorted by the number of references outside of
+// test files, descending, since references in production code matter more when deciding whether to remove a flag.
+func makeFlagReports(refs []ld.ReferenceHunksRep, statuses map[string]ld.FlagStatus, now time.Time) []flagReport {
+	refCounts := map[string]int{}
+	testRefCounts := map[string]int{}
+	for _, ref := range refs {
+		for _, hunk := range ref.Hunks {
+			refCounts[hunk.FlagKey] += hunk.ReferenceCount()
+			if hunk.TestCode {
+				testRefCounts[hunk.FlagKey] += hunk.ReferenceCount()
+			}
+		}
+	}
+
+	reports := make([]flagReport, 0, len(statuses))
+	for flagKey, status := range statuses {
+		reports = append(reports, flagReport{
+			FlagKey:            flagKey,
+			ReferenceCount:     refCounts[flagKey],
+			TestReferenceCount: testRefCounts[flagKey],
+			Environments:       status,
+			Summary:            flagSummary(refCounts[flagKey], testRefCounts[flagKey], status, now),
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		iProd, jProd := reports[i].ReferenceCount-reports[i].TestReferenceCount, reports[j].ReferenceCount-reports[j].TestReferenceCount
+		if iProd != jProd {
+			return iProd > jProd
+		}
+		if reports[i].ReferenceCount != reports[j].ReferenceCount {
+			return reports[i].ReferenceCount > reports[j].ReferenceCount
+		}
+		return reports[i].FlagKey < reports[j].FlagKey
+	})
+	return reports
+}
+
+func flagSummary(refCount, testRefCount int, status ld.FlagStatus, now time.Time) string {
+	parts := []string{}
+	switch refCount {
+	case 0:
+		parts = append(parts, "not referenced")
+	case 1:
+		parts = append(parts, "referenced 1 time")
+	default:
+		parts = append(parts, fmt.Sprintf("referenced %d times", refCount))
+	}
+	switch {
+	case testRefCount == 0:
+	case testRefCount == refCount:
+		parts[0] += " (only in tests)"
+	default:
+		parts[0] += fmt.Sprintf(" (%d in tests)", testRefCount)
+	}
+
+	onCount := status.OnCount()
+	switch {
+	case len(status) == 0:
+	case onCount == 0:
+		s := "off in all environments"
+		if lastModified := status.LastModified(); lastModified != nil {
+			s += " for " + formatDays(now.Sub(*lastModified))
+		}
+		parts = append(parts, s)
+	case onCount == len(status):
+		parts = append(parts, "on in all environments")
+	default:
+		parts = append(parts, fmt.Sprintf("on in %d of %d environments", onCount, len(status)))
+	}
+
+	if lastRequested := status.LastRequested(); lastRequested != nil {
+		parts = append(parts, "last requested "+formatDays(now.Sub(*lastRequested))+" ago")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatDays(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	switch {
+	case days < 1:
+		return "less than a day"
+	case days == 1:
+		return "1 day"
+	default:
+		return fmt.Sprintf("%d days", days)
+	}
+}
+
+// printFlagReports writes a table of flag reports.
+func printFlagReports(w io.Writer, reports []flagReport) {
+	data := [][]string{}
+	for _, r := range reports {
+		data = append(data, []string{r.FlagKey, strconv.Itoa(r.ReferenceCount), r.Summary})
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Flag", "# References", "Status"})
+	table.SetBorder(false)
+	if log.Colors(w) {
+		table.SetHeaderColor(tablewriter.Colors{tablewriter.Bold}, tablewriter.Colors{tablewriter.Bold}, tablewriter.Colors{tablewriter.Bold})
+	}
+	table.SetAutoWrapText(false)
+	table.AppendBulk(data)
+	table.Render()
+}
@@ -0,0 +1,52 @@
+package coderefs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_makeFlagReports(t *testing.T) {
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) *time.Time {
+		t := now.Add(-time.Duration(days) * 24 * time.Hour)
+		return &t
+	}
+
+	refs := []ld.ReferenceHunksRep{
+		{Path: "a", Hunks: []ld.HunkRep{
+			{FlagKey: "stale", Offsets: []ld.OffsetRep{{LineNumber: 1, StartColumn: 0, EndColumn: 5}, {LineNumber: 1, StartColumn: 6, EndColumn: 11}}},
+			{FlagKey: "partial"},
+		}},
+		{Path: "a_test", Hunks: []ld.HunkRep{
+			{FlagKey: "stale", TestCode: true},
+			{FlagKey: "tested", TestCode: true},
+		}},
+	}
+	statuses := map[string]ld.FlagStatus{
+		"stale": {
+			"production": {On: false, LastModified: daysAgo(120)},
+			"test":       {On: false, LastModified: daysAgo(200), LastRequested: daysAgo(130)},
+		},
+		"partial": {
+			"production": {On: true, LastRequested: daysAgo(0)},
+			"test":       {On: false},
+		},
+		"tested": {
+			"production": {On: true},
+		},
+		"unused": {
+			"production": {On: true},
+		},
+	}
+
+	require.Equal(t, []flagReport{
+		{FlagKey: "stale", ReferenceCount: 3, TestReferenceCount: 1, Environments: statuses["stale"], Summary: "referenced 3 times (1 in tests), off in all environments for 120 days, last requested 130 days ago"},
+		{FlagKey: "partial", ReferenceCount: 1, Environments: statuses["partial"], Summary: "referenced 1 time, on in 1 of 2 environments, last requested less than a day ago"},
+		{FlagKey: "tested", ReferenceCount: 1, TestReferenceCount: 1, Environments: statuses["tested"], Summary: "referenced 1 time (only in tests), on in all environments"},
+		{FlagKey: "unused", ReferenceCount: 0, Environments: statuses["unused"], Summary: "not referenced, on in all environments"},
+	}, makeFlagReports(refs, statuses, now))
+}
@@ -0,0 +1,59 @@
+package coderefs
+
+import (
+	"fmt"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+)
+
+// streamFlags retrieves the flag keys of projKey page by page, and adds each page to a matcher as it's retrieved, for
+// the streamFlags option. The flags and excludeFlags options, and the minimum flag key length, are applied to each
+// page, so the keys are never collected into a list. Flag keys cached by previous scans aren't used.
+func streamFlags(ldApi ld.ApiClient, projKey string) *command.FlagMatcher {
+	filter, err := flagFilterOptions()
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	matcher := command.NewFlagMatcher(nil)
+	stream := ldApi.StreamFlagKeys
+	if explicitFlags, ok := filter.explicitFlags(); ok {
+		log.Info.Printf("searching for %d flags provided by the flags option, without retrieving flags from LaunchDarkly", len(explicitFlags))
+		stream = func(fn func(keys []string)) error {
+			fn(explicitFlags)
+			return nil
+		}
+	}
+	omitted, err := addFlagPages(matcher, stream, filter)
+	if _, ok := err.(*ld.TruncatedFlagListError); ok {
+		log.Error.Fatalf("could not retrieve every flag key from LaunchDarkly: %s", err)
+	} else if err != nil {
+		log.Error.Fatalf("could not retrieve flag keys from LaunchDarkly: %s", err)
+	}
+	if matcher.Len() == 0 {
+		if omitted > 0 {
+			exitIfNoFlags(fmt.Sprintf("no flag keys longer than the minimum flag key length (%v) were found for project: %s", minFlagKeyLen, projKey))
+		} else {
+			exitIfNoFlags(fmt.Sprintf("no flag keys found for project: %s", projKey))
+		}
+	} else if omitted > 0 {
+		log.Warning.Printf("omitting %d flags with keys less than minimum (%d)", omitted, minFlagKeyLen)
+	}
+	return matcher
+}
+
+// addFlagPages adds the keys in each page passed to fn by stream to matcher, if they pass filter and aren't shorter
+// than the minimum flag key length, and returns the number of keys omitted for their length.
+func addFlagPages(matcher *command.FlagMatcher, stream func(fn func(keys []string)) error, filter flagFilter) (int, error) {
+	omitted := 0
+	pages := 0
+	err := stream(func(keys []string) {
+		filtered, short := filterShortFlagKeys(filter.apply(keys))
+		omitted += len(short)
+		matcher.Add(filtered)
+		pages++
+		log.Debug.Printf("added page %d of flag keys to the matcher, which now matches %d keys", pages, matcher.Len())
+	})
+	return omitted, err
+}
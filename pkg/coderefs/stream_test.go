@@ -0,0 +1,52 @@
+package coderefs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+)
+
+func Test_addFlagPages(t *testing.T) {
+	pages := [][]string{
+		{"flag-1", "ab", "old-flag"},
+		{"flag-2", "flag-1"},
+	}
+	stream := func(fn func(keys []string)) error {
+		for _, page := range pages {
+			fn(page)
+		}
+		return nil
+	}
+	matcher := command.NewFlagMatcher(nil)
+	omitted, err := addFlagPages(matcher, stream, flagFilter{exclude: []string{"old-*"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, omitted)
+	// flag-1 is only matched once
+	require.Equal(t, 2, matcher.Len())
+}
+
+func Test_generateReferencesFromGrep_flagMatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	contents := "beta := beta-ui\nbetamax := 1\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte(contents), 0644))
+	require.NoError(t, exec.Command("git", "-C", dir, "init", "-q").Run())
+
+	// Keys are added in separate pages, and no list of keys is passed to the search or attribution
+	cmd := command.Client{Workspace: dir, FlagMatcher: command.NewFlagMatcher([]string{"beta-ui"})}
+	cmd.FlagMatcher.Add([]string{"beta"})
+	// betamax isn't a reference to beta
+	b := &branch{}
+	refs, err := b.findReferences(cmd, nil, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, grepResultLines{
+		{Path: "a.go", LineNum: 1, LineText: "beta := beta-ui", FlagKeys: []string{"beta-ui", "beta"}, FlagColumns: map[string][]columnRange{"beta": {{0, 4}}, "beta-ui": {{8, 15}}}},
+	}, refs)
+}
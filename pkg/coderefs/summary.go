@@ -0,0 +1,107 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// Results of a scan, reported in the result of its summary
+const (
+	summaryUploaded = "uploaded"
+	summaryDryRun   = "dryRun"
+	summarySkipped  = "skipped"
+	summaryError    = "error"
+)
+
+// Reasons a scan was skipped, reported in the reason of its summary
+const (
+	skippedRepositoryDisabled = "repositoryDisabled"
+	skippedByConfiguration    = "repositoryConfiguration"
+	skippedNoFlags            = "noFlags"
+	skippedNoReferences       = "noReferences"
+	skippedPullRequest        = "pullRequest"
+	skippedStaleHead          = "staleHead"
+	skippedSequenceConflict   = "updateSequenceIdConflict"
+)
+
+// runSummary is printed to stdout when a scan ends, if the emitJsonSummary option is enabled, so scripts can act on
+// the result of a scan without parsing logs. Nothing is printed if the scan fails, unless the softFail option is
+// enabled.
+type runSummary struct {
+	Result string `json:"result"`
+	// Reason is only set when Result is skipped.
+	Reason string `json:"reason,omitempty"`
+	// Error is only set when Result is error.
+	Error    string `json:"error,omitempty"`
+	ProjKey  string `json:"projKey"`
+	RepoName string `json:"repoName,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Head     string `json:"head,omitempty"`
+	// Incomplete is true if maxFiles or maxScanSeconds was exceeded.
+	Incomplete     bool   `json:"incomplete,omitempty"`
+	FlagCount      int    `json:"flagCount"`
+	FileCount      int    `json:"fileCount"`
+	HunkCount      int    `json:"hunkCount"`
+	ReferenceCount int    `json:"referenceCount"`
+	OutFile        string `json:"outFile,omitempty"`
+	DurationMs     int64  `json:"durationMs"`
+	// FileErrors are the files which couldn't be searched, so references in them are missing.
+	FileErrors []command.FileError `json:"fileErrors,omitempty"`
+}
+
+var (
+	scanSummary runSummary
+	scanStart   = time.Now()
+)
+
+// summarizeBranch records the code references found in the summary of the scan.
+func summarizeBranch(branchRep ld.BranchRep, flagCount int) {
+	scanSummary.Branch = branchRep.Name
+	scanSummary.Head = branchRep.Head
+	scanSummary.FlagCount = flagCount
+	scanSummary.FileCount = len(branchRep.References)
+	scanSummary.HunkCount = branchRep.TotalHunkCount()
+	scanSummary.ReferenceCount = branchRep.TotalReferenceCount()
+	scanSummary.OutFile = o.OutFile.Value()
+}
+
+// emitSummary prints the summary of the scan to stdout, if the emitJsonSummary option is enabled. reason is only
+// provided for skipped scans.
+func emitSummary(result, reason string) {
+	if !o.EmitJsonSummary.Value() {
+		return
+	}
+	err := writeSummary(os.Stdout, scanSummary, result, reason, time.Since(scanStart))
+	if err != nil {
+		log.Warning.Printf("could not write summary: %s", err)
+	}
+}
+
+// softFail reports a fatal error in the summary of the scan, and returns the exit code of the process, so scans with
+// the softFail option enabled exit successfully. It's installed as the fatal error hook of the logger.
+func softFail(msg string) int {
+	log.Error.Printf("the scan failed, but exits successfully because softFail is enabled")
+	if scanSummary.ProjKey == "" {
+		scanSummary.ProjKey = o.ProjKey.Value()
+	}
+	if scanSummary.RepoName == "" {
+		scanSummary.RepoName = o.RepoName.Value()
+	}
+	scanSummary.Error = msg
+	emitSummary(summaryError, "")
+	return 0
+}
+
+func writeSummary(w io.Writer, s runSummary, result, reason string, duration time.Duration) error {
+	s.Result = result
+	s.Reason = reason
+	s.DurationMs = int64(duration / time.Millisecond)
+	return json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,32 @@
+package coderefs
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/command"
+)
+
+func Test_writeSummary(t *testing.T) {
+	var buf bytes.Buffer
+	s := runSummary{ProjKey: "default", RepoName: "repo", Branch: "main", FlagCount: 2, FileCount: 1, HunkCount: 3, ReferenceCount: 4}
+	require.NoError(t, writeSummary(&buf, s, summarySkipped, skippedPullRequest, 1500*time.Millisecond))
+	require.Equal(t, `{"result":"skipped","reason":"pullRequest","projKey":"default","repoName":"repo","branch":"main","flagCount":2,"fileCount":1,"hunkCount":3,"referenceCount":4,"durationMs":1500}`+"\n", buf.String())
+}
+
+func Test_writeSummary_error(t *testing.T) {
+	var buf bytes.Buffer
+	s := runSummary{ProjKey: "default", Error: "could not list files"}
+	require.NoError(t, writeSummary(&buf, s, summaryError, "", 0))
+	require.Equal(t, `{"result":"error","error":"could not list files","projKey":"default","flagCount":0,"fileCount":0,"hunkCount":0,"referenceCount":0,"durationMs":0}`+"\n", buf.String())
+}
+
+func Test_writeSummary_fileErrors(t *testing.T) {
+	var buf bytes.Buffer
+	s := runSummary{ProjKey: "default", FileErrors: []command.FileError{{Path: "a.go", Reason: "permission denied"}}}
+	require.NoError(t, writeSummary(&buf, s, summaryUploaded, "", 0))
+	require.Equal(t, `{"result":"uploaded","projKey":"default","flagCount":0,"fileCount":0,"hunkCount":0,"referenceCount":0,"durationMs":0,"fileErrors":[{"path":"a.go","reason":"permission denied"}]}`+"\n", buf.String())
+}
@@ -0,0 +1,62 @@
+package coderefs
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// testDirs are directory names conventionally containing only tests.
+var testDirs = map[string]bool{
+	"__tests__": true,
+	"__mocks__": true,
+	"spec":      true,
+	"specs":     true,
+	"test":      true,
+	"tests":     true,
+	"testdata":  true,
+}
+
+// testFileRegex matches the names of test files in common languages, e.g. foo_test.go, foo.test.ts, foo.spec.js,
+// test_foo.py, foo_spec.rb, FooTest.java, and FooTests.cs.
+var testFileRegex = regexp.MustCompile(`(_test\.go|\.(test|spec)\.[jt]sx?|^test_.*\.py|_test\.py|_(spec|test)\.rb|Tests?\.(java|kt|scala|cs))$`)
+
+// isTestPath returns true if the path, relative to the repository root, is conventionally used for test code.
+func isTestPath(p string) bool {
+	dir, name := path.Split(p)
+	for _, d := range strings.Split(strings.TrimSuffix(dir, "/"), "/") {
+		if testDirs[d] {
+			return true
+		}
+	}
+	return testFileRegex.MatchString(name)
+}
+
+// classifyTestCode marks the hunks of references in test files as test code. If excluded, references in test
+// files are removed instead.
+func classifyTestCode(refs []ld.ReferenceHunksRep, exclude bool) []ld.ReferenceHunksRep {
+	ret := make([]ld.ReferenceHunksRep, 0, len(refs))
+	for _, ref := range refs {
+		if !isTestPath(ref.Path) {
+			ret = append(ret, ref)
+			continue
+		}
+		if exclude {
+			continue
+		}
+		hunks := make([]ld.HunkRep, len(ref.Hunks))
+		for i, hunk := range ref.Hunks {
+			hunk.TestCode = true
+			hunks[i] = hunk
+		}
+		ret = append(ret, ld.ReferenceHunksRep{Path: ref.Path, Hunks: hunks})
+	}
+	return ret
+}
+
+func excludeTestReferences() bool {
+	return o.TestReferences.Value() == o.TestReferencesExclude
+}
@@ -0,0 +1,50 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_isTestPath(t *testing.T) {
+	specs := []struct {
+		path     string
+		expected bool
+	}{
+		{"pkg/coderefs/coderefs_test.go", true},
+		{"pkg/coderefs/coderefs.go", false},
+		{"src/components/__tests__/Checkout.js", true},
+		{"src/components/Checkout.test.tsx", true},
+		{"src/components/Checkout.spec.js", true},
+		{"src/components/Checkout.js", false},
+		{"spec/models/user_spec.rb", true},
+		{"app/models/user.rb", false},
+		{"tests/test_flags.py", true},
+		{"app/test_flags.py", true},
+		{"app/contest.py", false},
+		{"src/main/java/com/example/CheckoutTest.java", true},
+		{"src/main/java/com/example/Checkout.java", false},
+		{"latest/index.js", false},
+	}
+	for _, tt := range specs {
+		t.Run(tt.path, func(t *testing.T) {
+			require.Equal(t, tt.expected, isTestPath(tt.path))
+		})
+	}
+}
+
+func Test_classifyTestCode(t *testing.T) {
+	refs := []ld.ReferenceHunksRep{
+		{Path: "main.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+		{Path: "main_test.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+	}
+	require.Equal(t, []ld.ReferenceHunksRep{
+		{Path: "main.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}}},
+		{Path: "main_test.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", TestCode: true}}},
+	}, classifyTestCode(refs, false))
+	require.False(t, refs[1].Hunks[0].TestCode, "references should not be modified")
+
+	require.Equal(t, refs[:1], classifyTestCode(refs, true))
+}
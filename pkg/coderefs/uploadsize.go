@@ -0,0 +1,110 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// maxSizeContributors is the number of directories, files, and flags logged as the largest contributors to an
+// upload's size.
+const maxSizeContributors = 5
+
+// sizeContributor is the number of bytes a directory, file, or flag contributes to an upload.
+type sizeContributor struct {
+	Name  string
+	Bytes int
+}
+
+// uploadSize returns the size of the code references sent for a branch, serialized as they're sent to LaunchDarkly.
+func uploadSize(branchRep ld.BranchRep) int {
+	body, err := json.Marshal(branchRep)
+	if err != nil {
+		return 0
+	}
+	return len(body)
+}
+
+// sizeContributors returns the directories, files, and flags contributing the most bytes to the code references sent
+// for a branch, largest first. Directories include only the files directly inside them.
+func sizeContributors(branchRep ld.BranchRep) (dirs []sizeContributor, files []sizeContributor, flags []sizeContributor) {
+	dirBytes := map[string]int{}
+	flagBytes := map[string]int{}
+	for _, ref := range branchRep.References {
+		refBytes, _ := json.Marshal(ref)
+		files = append(files, sizeContributor{Name: ref.Path, Bytes: len(refBytes)})
+		dirBytes[path.Dir(ref.Path)] += len(refBytes)
+		for _, hunk := range ref.Hunks {
+			hunkBytes, _ := json.Marshal(hunk)
+			flagBytes[hunk.FlagKey] += len(hunkBytes)
+		}
+	}
+	for dir, n := range dirBytes {
+		dirs = append(dirs, sizeContributor{Name: dir, Bytes: n})
+	}
+	for flagKey, n := range flagBytes {
+		flags = append(flags, sizeContributor{Name: flagKey, Bytes: n})
+	}
+	return largestContributors(dirs), largestContributors(files), largestContributors(flags)
+}
+
+func largestContributors(contributors []sizeContributor) []sizeContributor {
+	sort.Slice(contributors, func(i, j int) bool {
+		if contributors[i].Bytes != contributors[j].Bytes {
+			return contributors[i].Bytes > contributors[j].Bytes
+		}
+		return contributors[i].Name < contributors[j].Name
+	})
+	if len(contributors) > maxSizeContributors {
+		contributors = contributors[:maxSizeContributors]
+	}
+	return contributors
+}
+
+// checkUploadSize estimates the size of the code references to be sent, and if it exceeds maxUploadBytes, logs the
+// directories, files, and flags contributing the most to it, and applies the onBudgetExceeded option.
+func checkUploadSize(branchRep ld.BranchRep) {
+	maxBytes := o.MaxUploadBytes.Value()
+	if maxBytes <= 0 {
+		return
+	}
+	total := uploadSize(branchRep)
+	log.Debug.Printf("code references for branch %s are %d bytes", branchRep.Name, total)
+	if total <= maxBytes {
+		return
+	}
+	log.Warning.Printf("code references are %d bytes, which exceeds maxUploadBytes (%d)", total, maxBytes)
+	logSizeContributors(branchRep)
+	if o.OnBudgetExceeded.Value() == o.OnBudgetExceededFail {
+		log.Error.Fatalf("code references are too large to send. Exclude the largest files or directories with exclude, or reduce contextLines or maxHunkBytes")
+	}
+}
+
+func logSizeContributors(branchRep ld.BranchRep) {
+	dirs, files, flags := sizeContributors(branchRep)
+	for _, c := range describeSizeContributors("directories", dirs) {
+		log.Warning.Printf("%s", c)
+	}
+	for _, c := range describeSizeContributors("files", files) {
+		log.Warning.Printf("%s", c)
+	}
+	for _, c := range describeSizeContributors("flags", flags) {
+		log.Warning.Printf("%s", c)
+	}
+}
+
+func describeSizeContributors(kind string, contributors []sizeContributor) []string {
+	if len(contributors) == 0 {
+		return nil
+	}
+	lines := []string{fmt.Sprintf("largest %s:", kind)}
+	for _, c := range contributors {
+		lines = append(lines, fmt.Sprintf("  %s: %d bytes", c.Name, c.Bytes))
+	}
+	return lines
+}
@@ -0,0 +1,57 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_sizeContributors(t *testing.T) {
+	branchRep := ld.BranchRep{Name: "main", References: []ld.ReferenceHunksRep{
+		{Path: "small.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Lines: "flag-1\n"}}},
+		{Path: "pkg/a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Lines: strings.Repeat("x", 700)}}},
+		{Path: "pkg/b.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Lines: strings.Repeat("x", 700)}}},
+		{Path: "large.go", Hunks: []ld.HunkRep{
+			{FlagKey: "flag-1", Lines: strings.Repeat("x", 100)},
+			{FlagKey: "flag-2", Lines: strings.Repeat("x", 1000)},
+		}},
+	}}
+	body, err := json.Marshal(branchRep)
+	require.NoError(t, err)
+
+	require.Equal(t, len(body), uploadSize(branchRep))
+
+	dirs, files, flags := sizeContributors(branchRep)
+	// pkg's files are each smaller than large.go, but larger together
+	require.Equal(t, []string{"pkg", "."}, contributorNames(dirs))
+	require.Equal(t, []string{"large.go", "pkg/a.go", "pkg/b.go", "small.go"}, contributorNames(files))
+	require.Equal(t, []string{"flag-1", "flag-2"}, contributorNames(flags))
+	require.True(t, files[0].Bytes > 1100)
+}
+
+func Test_largestContributors(t *testing.T) {
+	contributors := []sizeContributor{}
+	for i := 0; i < maxSizeContributors+2; i++ {
+		contributors = append(contributors, sizeContributor{Name: fmt.Sprintf("f%d", i), Bytes: i % 3})
+	}
+	largest := largestContributors(contributors)
+	require.Equal(t, []string{"f2", "f5", "f1", "f4", "f0"}, contributorNames(largest))
+}
+
+func Test_describeSizeContributors(t *testing.T) {
+	require.Nil(t, describeSizeContributors("files", nil))
+	require.Equal(t, []string{"largest files:", "  a.go: 10 bytes"}, describeSizeContributors("files", []sizeContributor{{Name: "a.go", Bytes: 10}}))
+}
+
+func contributorNames(contributors []sizeContributor) []string {
+	names := []string{}
+	for _, c := range contributors {
+		names = append(names, c.Name)
+	}
+	return names
+}
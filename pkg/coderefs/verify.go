@@ -0,0 +1,114 @@
+package coderefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// maxVerificationDifferences is the maximum number of differences logged when verifying an upload.
+const maxVerificationDifferences = 10
+
+// verifyUpload retrieves the code references sent for a branch from LaunchDarkly, if the verifyUpload option is
+// enabled, and logs whether they match what was sent.
+func verifyUpload(ldApi ld.ApiClient, sent ld.BranchRep, repoName string) {
+	if !o.VerifyUpload.Value() {
+		return
+	}
+	received, err := ldApi.GetCodeReferenceBranch(repoName, sent.Name)
+	if err != nil {
+		log.Warning.Printf("could not retrieve code references for branch %s to verify the upload: %s", sent.Name, err)
+		return
+	}
+	if received.Head != sent.Head {
+		log.Warning.Printf("could not verify the upload for branch %s: LaunchDarkly has code references for commit %s, which may have been sent by a concurrent scan", sent.Name, received.Head)
+		return
+	}
+	differences := uploadDifferences(sent, *received)
+	if len(differences) == 0 {
+		log.Info.Printf("verified %d code references in %d hunks across %d files sent for branch %s", sent.TotalReferenceCount(), sent.TotalHunkCount(), len(sent.References), sent.Name)
+		return
+	}
+	log.Warning.Printf("code references retrieved for branch %s don't match the code references sent, they may have been trimmed by LaunchDarkly: %d differences", sent.Name, len(differences))
+	for i, d := range differences {
+		if i == maxVerificationDifferences {
+			log.Warning.Printf("... and %d more differences", len(differences)-i)
+			break
+		}
+		log.Warning.Printf("  %s", d)
+	}
+}
+
+// uploadDifferences compares code references sent to LaunchDarkly with the code references retrieved for the same
+// branch, and describes each difference in the number of files, and in the number of hunks and lines for each flag.
+func uploadDifferences(sent, received ld.BranchRep) []string {
+	differences := []string{}
+	if len(sent.References) != len(received.References) {
+		differences = append(differences, fmt.Sprintf("sent %d files, retrieved %d", len(sent.References), len(received.References)))
+	}
+	sentHunks, sentLines := countHunksByFlag(sent)
+	receivedHunks, receivedLines := countHunksByFlag(received)
+	keys := []string{}
+	for key := range sentHunks {
+		keys = append(keys, key)
+	}
+	for key := range receivedHunks {
+		if _, ok := sentHunks[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if sentHunks[key] != receivedHunks[key] {
+			differences = append(differences, fmt.Sprintf("flag %s: sent %d hunks, retrieved %d", key, sentHunks[key], receivedHunks[key]))
+		} else if sentLines[key] != receivedLines[key] {
+			differences = append(differences, fmt.Sprintf("flag %s: sent %d bytes of source code, retrieved %d", key, sentLines[key], receivedLines[key]))
+		}
+	}
+	return differences
+}
+
+// countHunksByFlag returns the number of hunks, and the length of their lines, for each flag.
+func countHunksByFlag(b ld.BranchRep) (hunks map[string]int, lines map[string]int) {
+	hunks, lines = map[string]int{}, map[string]int{}
+	for _, ref := range b.References {
+		for _, hunk := range ref.Hunks {
+			hunks[hunk.FlagKey]++
+			lines[hunk.FlagKey] += len(hunk.Lines)
+		}
+	}
+	return hunks, lines
+}
+
+// verifyDeterminism compares the payloads of code references built twice from the same search results, and returns
+// an error describing the first difference if they aren't identical.
+func verifyDeterminism(first, second ld.BranchRep) error {
+	firstJson, err := json.MarshalIndent(first, "", "  ")
+	if err != nil {
+		return err
+	}
+	secondJson, err := json.MarshalIndent(second, "", "  ")
+	if err != nil {
+		return err
+	}
+	firstLines := strings.Split(string(firstJson), "\n")
+	secondLines := strings.Split(string(secondJson), "\n")
+	for i := 0; i < len(firstLines) || i < len(secondLines); i++ {
+		var a, b string
+		if i < len(firstLines) {
+			a = firstLines[i]
+		}
+		if i < len(secondLines) {
+			b = secondLines[i]
+		}
+		if a != b {
+			return fmt.Errorf("code references built twice from the same search results differ at line %d of the payload: %q, then %q", i+1, strings.TrimSpace(a), strings.TrimSpace(b))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package coderefs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/ld"
+)
+
+func Test_uploadDifferences(t *testing.T) {
+	sent := ld.BranchRep{References: []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Lines: "flag-1\n"}, {FlagKey: "flag-2", Lines: "flag-2\n"}}},
+		{Path: "b.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Lines: "flag-1\n"}}},
+	}}
+	require.Empty(t, uploadDifferences(sent, sent))
+
+	received := ld.BranchRep{References: []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1", Lines: "flag-1\n"}, {FlagKey: "flag-2", Lines: "fla"}, {FlagKey: "flag-3"}}},
+	}}
+	require.Equal(t, []string{
+		"sent 2 files, retrieved 1",
+		"flag flag-1: sent 2 hunks, retrieved 1",
+		"flag flag-2: sent 7 bytes of source code, retrieved 3",
+		"flag flag-3: sent 0 hunks, retrieved 1",
+	}, uploadDifferences(sent, received))
+}
+
+func Test_verifyDeterminism(t *testing.T) {
+	first := ld.BranchRep{Name: "main", References: []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-1"}, {FlagKey: "flag-2"}}},
+	}}
+	require.NoError(t, verifyDeterminism(first, first))
+
+	second := ld.BranchRep{Name: "main", References: []ld.ReferenceHunksRep{
+		{Path: "a.go", Hunks: []ld.HunkRep{{FlagKey: "flag-2"}, {FlagKey: "flag-1"}}},
+	}}
+	err := verifyDeterminism(first, second)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"\"flagKey\": \"flag-1\"", then "\"flagKey\": \"flag-2\""`)
+
+	third := ld.BranchRep{Name: "main", References: first.References[:0]}
+	require.Error(t, verifyDeterminism(first, third))
+}
+
+func Test_makeReferenceHunksReps_deterministic(t *testing.T) {
+	flags := []string{"flag-e", "flag-d", "flag-c", "flag-b", "flag-a"}
+	g := grepResultLines{{Path: "a.go", LineNum: 1, LineText: "flag-e flag-d flag-c flag-b flag-a", FlagKeys: flags}}
+	first := g.makeReferenceHunksReps("proj", 0, nil)
+	for i := 0; i < 20; i++ {
+		require.Equal(t, first, g.makeReferenceHunksReps("proj", 0, nil))
+	}
+	require.Equal(t, "flag-a", first[0].Hunks[0].FlagKey)
+}
@@ -0,0 +1,51 @@
+package coderefs
+
+import (
+	"fmt"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+// exitIfNoFlags applies the onZeroReferences option when there are no flag keys to search for, described by msg. If
+// code references should be sent, it returns, and the search is skipped. Otherwise, the scan ends.
+func exitIfNoFlags(msg string) {
+	send, err := noFlagsFound(msg)
+	if err != nil {
+		log.Error.Fatalf("%s", err)
+	}
+	if !send {
+		emitSummary(summarySkipped, skippedNoFlags)
+		log.Exit(0)
+	}
+}
+
+// noFlagsFound applies the onZeroReferences option when there are no flag keys to search for, described by msg. It
+// returns false if code references shouldn't be sent, which is the default, and an error if the scan should fail.
+func noFlagsFound(msg string) (bool, error) {
+	switch o.OnZeroReferences.Value() {
+	case o.OnZeroReferencesFail:
+		return false, fmt.Errorf("%s", msg)
+	case o.OnZeroReferencesUpload:
+		log.Info.Printf("%s, skipping the search", msg)
+		return true, nil
+	}
+	log.Info.Printf("%s, exiting early", msg)
+	return false, nil
+}
+
+// noReferencesFound applies the onZeroReferences option when no code references were found for flagCount flags. It
+// returns false if code references shouldn't be sent, and an error if the scan should fail. By default, an empty set of
+// code references is sent.
+func noReferencesFound(flagCount int) (bool, error) {
+	msg := fmt.Sprintf("no code references found for %d flags", flagCount)
+	switch o.OnZeroReferences.Value() {
+	case o.OnZeroReferencesFail:
+		return false, fmt.Errorf("%s. Check that dir, exclude, minScore, and the flags searched for are correct, or set onZeroReferences to %q", msg, o.OnZeroReferencesUpload)
+	case o.OnZeroReferencesSkip:
+		log.Info.Printf("%s, code references will not be sent to LaunchDarkly, and those previously sent are unchanged", msg)
+		return false, nil
+	}
+	log.Info.Printf("%s, any code references previously sent for the branch will be cleared", msg)
+	return true, nil
+}
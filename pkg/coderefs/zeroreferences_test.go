@@ -0,0 +1,45 @@
+package coderefs
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/launchdarkly/ld-find-code-refs/internal/log"
+	o "github.com/launchdarkly/ld-find-code-refs/internal/options"
+)
+
+func Test_zeroReferences(t *testing.T) {
+	log.Init(false)
+	populateOptions()
+	defer flag.Set("onZeroReferences", "")
+
+	specs := []struct {
+		name                    string
+		mode                    string
+		sendNoFlags, sendNoRefs bool
+		failNoFlags, failNoRefs bool
+	}{
+		{name: "default", mode: "", sendNoFlags: false, sendNoRefs: true},
+		{name: "upload", mode: o.OnZeroReferencesUpload, sendNoFlags: true, sendNoRefs: true},
+		{name: "skip", mode: o.OnZeroReferencesSkip, sendNoFlags: false, sendNoRefs: false},
+		{name: "fail", mode: o.OnZeroReferencesFail, failNoFlags: true, failNoRefs: true},
+	}
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, flag.Set("onZeroReferences", tt.mode))
+
+			send, err := noFlagsFound("no flag keys found for project: default")
+			require.Equal(t, tt.sendNoFlags, send)
+			require.Equal(t, tt.failNoFlags, err != nil)
+
+			send, err = noReferencesFound(3)
+			require.Equal(t, tt.sendNoRefs, send)
+			require.Equal(t, tt.failNoRefs, err != nil)
+			if err != nil {
+				require.Contains(t, err.Error(), "no code references found for 3 flags")
+			}
+		})
+	}
+}